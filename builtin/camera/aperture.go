@@ -0,0 +1,169 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package camera
+
+import (
+	"fmt"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/sample"
+	"github.com/jamiec7919/vermeer/texture"
+	"sort"
+)
+
+// maxCatEyeOffset limits the offset of the cat-eye circle so some of the aperture is always
+// open.
+const maxCatEyeOffset = 1.8
+
+// apertureMap samples an aperture shape given by an image, proportional to its luminance.
+type apertureMap struct {
+	w, h int
+	cdfY []float32 // Marginal CDF of the rows
+	cdfX []float32 // CDF of each row, w*h
+}
+
+// loadApertureMap reads the image and builds the CDFs.
+func loadApertureMap(filename string) (*apertureMap, error) {
+	w, h, data, err := texture.ReadImage(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ap := &apertureMap{w: w, h: h, cdfY: make([]float32, h), cdfX: make([]float32, w*h)}
+
+	total := float32(0)
+
+	for y := 0; y < h; y++ {
+		row := ap.cdfX[y*w : (y+1)*w]
+		sum := float32(0)
+
+		for x := range row {
+			c := data[(x+y*w)*3 : (x+y*w)*3+3]
+			sum += m.Max(0, 0.2126*c[0]+0.7152*c[1]+0.0722*c[2])
+			row[x] = sum
+		}
+
+		if sum > 0 {
+			for x := range row {
+				row[x] /= sum
+			}
+		}
+
+		total += sum
+		ap.cdfY[y] = total
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("Camera: aperture map %v is black", filename)
+	}
+
+	for y := range ap.cdfY {
+		ap.cdfY[y] /= total
+	}
+
+	return ap, nil
+}
+
+// sampleCDF returns the index of the first entry of cdf greater than u and the position of u
+// within that entry.
+func sampleCDF(cdf []float32, u float32) (int, float32) {
+	i := sort.Search(len(cdf), func(i int) bool { return cdf[i] > u })
+
+	if i == len(cdf) {
+		i = len(cdf) - 1
+	}
+
+	lo := float32(0)
+
+	if i > 0 {
+		lo = cdf[i-1]
+	}
+
+	if cdf[i] <= lo {
+		return i, 0.5
+	}
+
+	return i, m.Clamp((u-lo)/(cdf[i]-lo), 0, 1)
+}
+
+// sample returns a point in [-1,1]x[-1,1], the longer side of the image spans the square.
+func (ap *apertureMap) sample(u, v float32) (x, y float32) {
+	j, dv := sampleCDF(ap.cdfY, v)
+	i, du := sampleCDF(ap.cdfX[j*ap.w:(j+1)*ap.w], u)
+
+	scale := 2 / float32(ap.w)
+
+	if ap.h > ap.w {
+		scale = 2 / float32(ap.h)
+	}
+
+	x = (float32(i) + du - float32(ap.w)/2) * scale
+	y = (float32(j) + dv - float32(ap.h)/2) * scale
+
+	return
+}
+
+// samplePolygon returns a point on a regular polygon with n sides inscribed in the unit circle,
+// with a vertex on the X axis.  u picks a triangle of the fan from the centre.
+func samplePolygon(n int, u, v float32) (x, y float32) {
+	k := m.Min(m.Floor(u*float32(n)), float32(n-1))
+	u = u*float32(n) - k
+
+	sinA, cosA := m.Sincos(2 * m.Pi * k / float32(n))
+	sinB, cosB := m.Sincos(2 * m.Pi * (k + 1) / float32(n))
+
+	s := m.Sqrt(u)
+
+	x = s * ((1-v)*cosA + v*cosB)
+	y = s * ((1-v)*sinA + v*sinB)
+
+	return
+}
+
+// sampleAperture returns a point on the lens in camera space for the ray through the screen
+// point (sx,sy).  The shape is a circle, polygon or image scaled by Radius and the anamorphic
+// squeeze.  Returns false if the point is blocked by cat-eye vignetting, the point is still
+// returned so the ray can be traced for coverage.
+func (c *Camera) sampleAperture(lensU, lensV, sx, sy float32) (x, y float32, ok bool) {
+	ok = true
+
+	switch {
+	case c.apertureMap != nil:
+		x, y = c.apertureMap.sample(lensU, lensV)
+
+	case c.Blades >= 3:
+		x, y = samplePolygon(c.Blades, lensU, lensV)
+
+	default:
+		x, y = sample.UniformDisk2D(1, lensU, lensV)
+	}
+
+	if c.BladeRotation != 0 {
+		sinR, cosR := m.Sincos(degToRad(c.BladeRotation))
+		x, y = cosR*x-sinR*y, sinR*x+cosR*y
+	}
+
+	if c.CatEye > 0 {
+		// The exit pupil seen from off axis is clipped by the rear of the lens, modelled as a
+		// second unit circle moving outwards with the screen position.
+		ox := c.CatEye * sx
+		oy := c.CatEye * sy / c.Aspect
+
+		if d := m.Sqrt(ox*ox + oy*oy); d > maxCatEyeOffset {
+			ox *= maxCatEyeOffset / d
+			oy *= maxCatEyeOffset / d
+		}
+
+		ok = (x-ox)*(x-ox)+(y-oy)*(y-oy) <= 1
+	}
+
+	squeeze := c.Squeeze
+
+	if squeeze <= 0 {
+		squeeze = 1
+	}
+
+	return c.Radius * x / squeeze, c.Radius * y, ok
+}
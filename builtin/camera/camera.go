@@ -6,8 +6,8 @@ package camera
 
 import (
 	"fmt"
+	"github.com/jamiec7919/vermeer/colour"
 	m "github.com/jamiec7919/vermeer/math"
-	"github.com/jamiec7919/vermeer/math/sample"
 	//"math/rand"
 	"github.com/jamiec7919/vermeer/core"
 	param "github.com/jamiec7919/vermeer/core/param"
@@ -70,9 +70,53 @@ type Camera struct {
 
 	L, R, T, B float32 `node:",opt"`
 	Radius     float32 `node:",opt"`
+
+	Blades        int     `node:",opt"` // Number of aperture blades, 0 is a circular aperture
+	BladeRotation float32 `node:",opt"` // Rotation of the aperture in degrees
+	ApertureMap   string  `node:",opt"` // Image of the aperture shape, replaces the blades
+	Squeeze       float32 `node:",opt"` // Anamorphic squeeze, bokeh are Squeeze times taller than wide
+	CatEye        float32 `node:",opt"` // Cat-eye vignetting towards the frame edges, 0 is none
+
+	ShutterOpen    float32            `node:",opt"` // Shutter open time within the frame, default 0
+	ShutterClose   float32            `node:",opt"` // Shutter close time within the frame, default 1
+	ShutterCurve   param.Float32Array `node:",opt"` // Shutter efficiency evenly spaced from open to close
+	RollingShutter float32            `node:",opt"` // Readout time of a rolling shutter, 0 is global
+
+	Eye         string  `node:",opt"` // Stereo eye, Left or Right, "" for a mono camera
+	Stereo      string  `node:",opt"` // Parallel (default), Converged or OffAxis
+	Interocular float32 `node:",opt"` // Distance between the eyes, default 0.065
+	Convergence float32 `node:",opt"` // Distance to the zero parallax plane, default Focal
+
+	FocalLength          float32 `node:",opt"` // Lens focal length in mm, sets Fov
+	SensorWidth          float32 `node:",opt"` // Sensor width in mm, default 36
+	FStop                float32 `node:",opt"` // Aperture f-number, sets Radius
+	ShutterSpeed         float32 `node:",opt"` // Exposure time in seconds, sets ShutterClose
+	FPS                  float32 `node:",opt"` // Frame rate, default 24
+	ISO                  float32 `node:",opt"` // Sensitivity, default 100
+	ExposureCompensation float32 `node:",opt"` // Exposure adjustment in stops
+
+	K1, K2, K3          float32 `node:",opt"` // Brown-Conrady radial distortion
+	P1, P2              float32 `node:",opt"` // Brown-Conrady tangential distortion
+	STMap               string  `node:",opt"` // Redistort ST-map image, replaces the coefficients
+	Vignetting          float32 `node:",opt"` // Optical vignetting, 1 is the cos^4 law
+	ChromaticAberration float32 `node:",opt"` // Relative size of the image at 720nm to 585nm, minus 1
+
+	Projection string  `node:",opt"` // Perspective (default), Orthographic, FisheyeEquidistant, FisheyeEquisolid, Equirectangular or Cylindrical
+	OrthoWidth float32 `node:",opt"` // Width of the orthographic view in world units
+
+	projection  int
+	apertureMap *apertureMap
+	shutterCDF  []float32
+
+	eyeOffset   float32   // Signed offset of the eye along X
+	eyeMatrix   m.Matrix4 // Eye to camera
+	screenShift float32   // Off-axis shift of Sx
+	exposure    float32
+	stMap       *stMap
 }
 
 var _ core.Node = (*Camera)(nil)
+var _ core.CameraProjector = (*Camera)(nil)
 
 func degToRad(deg float32) float32 { return deg * m.Pi / 180.0 }
 
@@ -86,8 +130,46 @@ func (c *Camera) PreRender() error {
 	//	c.calcBasisLookat(c.From.Elems[0], c.Target.Elems[0], c.Up, 0)
 	//}
 
+	c.initPhysical()
+
 	c.TanThetaFocal = m.Tan(degToRad(c.Fov/2)) * c.Focal
 
+	proj, err := parseProjection(c.Projection)
+
+	if err != nil {
+		return err
+	}
+
+	c.projection = proj
+
+	if err := c.initStereo(); err != nil {
+		return err
+	}
+
+	if err := c.initShutter(); err != nil {
+		return err
+	}
+
+	if c.STMap != "" {
+		st, err := loadSTMap(c.STMap)
+
+		if err != nil {
+			return err
+		}
+
+		c.stMap = st
+	}
+
+	if c.ApertureMap != "" {
+		ap, err := loadApertureMap(c.ApertureMap)
+
+		if err != nil {
+			return err
+		}
+
+		c.apertureMap = ap
+	}
+
 	if c.Type == "LookAt" {
 		c.calcLookatMatrices()
 	} else {
@@ -216,33 +298,74 @@ func (c *Camera) matrixCalc() {
 
 }
 
-// ComputeRay calculates a position and direction for a sampled ray.
-// x,y are the raster position, lensU,lensV are in [0,1)x[0,1)
-func (c *Camera) ComputeRay(sc *core.ShaderContext, lensU, lensV float64, ray *core.Ray) {
+// localToWorld returns the camera to world matrix at the given time.
+func (c *Camera) localToWorld(time float32) m.Matrix4 {
+	if c.decomp == nil {
+		if c.eyeOffset != 0 {
+			return c.eyeMatrix
+		}
 
-	M := m.Matrix4Identity
+		return m.Matrix4Identity
+	}
+
+	k := time * float32(len(c.decomp)-1)
+
+	t := k - m.Floor(k)
+
+	key := int(m.Floor(k))
+	key2 := int(m.Ceil(k))
+
+	trn := m.TransformDecompLerp(c.decomp[key], c.decomp[key2], t)
+
+	if c.eyeOffset != 0 {
+		return m.Matrix4Mul(m.TransformDecompToMatrix4(trn), c.eyeMatrix)
+	}
 
-	if c.decomp != nil {
-		k := sc.Time * float32(len(c.decomp)-1)
+	return m.TransformDecompToMatrix4(trn)
+}
 
-		t := k - m.Floor(k)
+// Project is a core.CameraProjector method, it is the inverse of ComputeRay for a pinhole.
+// Points outside the view of angular projections aren't ok.
+func (c *Camera) Project(P m.Vec3, time float32) (sx, sy float32, ok bool) {
+	M, _ := m.Matrix4Inverse(c.localToWorld(time))
 
-		key := int(m.Floor(k))
-		key2 := int(m.Ceil(k))
+	Pl := m.Matrix4MulPoint(M, P)
 
-		trn := m.TransformDecompLerp(c.decomp[key], c.decomp[key2], t)
+	if c.projection != projPerspective {
+		return c.projectLocal(Pl)
+	}
 
-		M = m.TransformDecompToMatrix4(trn)
+	// Camera looks down -Z
+	if Pl[2] >= 0 {
+		return 0, 0, false
 	}
 
+	s := c.Focal / (-Pl[2] * c.TanThetaFocal)
+
+	return Pl[0]*s - c.screenShift, Pl[1] * s * c.Aspect, true
+}
+
+// ComputeRay calculates a position and direction for a sampled ray.
+// x,y are the raster position, lensU,lensV are in [0,1)x[0,1)
+func (c *Camera) ComputeRay(sc *core.ShaderContext, lensU, lensV float64, ray *core.Ray) {
+	if c.projection != projPerspective {
+		c.computeRayProjected(sc, ray)
+		return
+	}
+
+	M := c.localToWorld(sc.Time)
+
 	// D = || u*U + v*V - d*W  ||
 	ray.X = sc.X
 	ray.Y = sc.Y
 	ray.Sx = sc.Sx
 	ray.Sy = sc.Sy
 
-	camu := float32(ray.Sx) * float32(c.TanThetaFocal)
-	camv := float32(ray.Sy) * float32(c.TanThetaFocal/c.Aspect)
+	// Undistorted screen position
+	ux, uy := c.lensPosition(ray.Sx, ray.Sy, sc.Lambda)
+
+	camu := float32(ux+c.screenShift) * float32(c.TanThetaFocal)
+	camv := float32(uy) * float32(c.TanThetaFocal/c.Aspect)
 
 	U := m.Vec3{1, 0, 0}
 	V := m.Vec3{0, 1, 0}
@@ -259,15 +382,19 @@ func (c *Camera) ComputeRay(sc *core.ShaderContext, lensU, lensV float64, ray *c
 	var d m.Vec3
 	w, h := core.FrameMetrics()
 
+	visible := true
+
 	if c.Radius > 0.0 {
+		var x, y float32
+
+		x, y, visible = c.sampleAperture(float32(lensU), float32(lensV), ray.Sx, ray.Sy)
 
-		x, y := sample.UniformDisk2D(c.Radius, float32(lensU), float32(lensV))
 		e := m.Vec3Add(m.Vec3Scale(x, U), m.Vec3Scale(y, V))
 		//D = m.Matrix4MulVec(M, m.Vec3Normalize(m.Vec3Sub(s, e)))
 		d = m.Matrix4MulVec(M, m.Vec3Sub(s, e))
 
-		camu2 := float32(ray.Sx+(2/float32(w))) * float32(c.TanThetaFocal)
-		camv2 := float32(ray.Sy+(2/float32(h))) * float32(c.TanThetaFocal/c.Aspect)
+		camu2 := float32(ux+c.screenShift+(2/float32(w))) * float32(c.TanThetaFocal)
+		camv2 := float32(uy+(2/float32(h))) * float32(c.TanThetaFocal/c.Aspect)
 		sx := m.Vec3Sub(m.Vec3Add(m.Vec3Scale(camu2, U), m.Vec3Scale(camv, V)), m.Vec3Scale(c.Focal, W))
 		sy := m.Vec3Sub(m.Vec3Add(m.Vec3Scale(camu, U), m.Vec3Scale(camv2, V)), m.Vec3Scale(c.Focal, W))
 		d2x := m.Matrix4MulVec(M, m.Vec3Sub(sx, e))
@@ -283,8 +410,8 @@ func (c *Camera) ComputeRay(sc *core.ShaderContext, lensU, lensV float64, ray *c
 		D = m.Vec3Normalize(d)
 		P = m.Matrix4MulPoint(M, m.Vec3{})
 
-		camu2 := float32(ray.Sx+(2/float32(w))) * float32(c.TanThetaFocal)
-		camv2 := float32(ray.Sy+(2/float32(h))) * float32(c.TanThetaFocal/c.Aspect)
+		camu2 := float32(ux+c.screenShift+(2/float32(w))) * float32(c.TanThetaFocal)
+		camv2 := float32(uy+(2/float32(h))) * float32(c.TanThetaFocal/c.Aspect)
 		sx := m.Vec3Sub(m.Vec3Add(m.Vec3Scale(camu2, U), m.Vec3Scale(camv, V)), m.Vec3Scale(c.Focal, W))
 		sy := m.Vec3Sub(m.Vec3Add(m.Vec3Scale(camu, U), m.Vec3Scale(camv2, V)), m.Vec3Scale(c.Focal, W))
 		d2x := m.Matrix4MulVec(M, m.Vec3Sub(sx, m.Vec3{}))
@@ -317,6 +444,13 @@ func (c *Camera) ComputeRay(sc *core.ShaderContext, lensU, lensV float64, ray *c
 	sc.Image.PixelDelta[1] = 2 * c.TanThetaFocal / (c.Aspect * float32(h))
 
 	ray.Init(core.RayTypeCamera, P, D, m.Inf(1), 0, sc)
+	c.weightRay(ray, c.Focal/m.Vec3Length(s))
+
+	if !visible {
+		// Blocked by cat-eye vignetting, the ray is still traced so the pixel coverage isn't
+		// reduced but carries no light.
+		ray.Weight = colour.RGB{}
+	}
 
 	//	log.Printf("%v %v %v %v", D, u, v, vm.Vec3Add(vm.Vec3Scale(u, c.U), vm.Vec3Scale(v, c.V)))
 	return
@@ -326,7 +460,7 @@ var cameraCount = 0
 
 func init() {
 	nodes.Register("Camera", func() (core.Node, error) {
-		cam := Camera{Focal: 12, Fov: 90, NodeName: fmt.Sprintf("camera<%v>", cameraCount)}
+		cam := Camera{Focal: 12, Fov: 90, ShutterClose: 1, Interocular: 0.065, NodeName: fmt.Sprintf("camera<%v>", cameraCount)}
 
 		cameraCount++
 
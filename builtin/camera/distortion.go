@@ -0,0 +1,181 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package camera
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/texture"
+)
+
+// Wavelengths of the chromatic aberration, the centre wavelength is undistorted.
+const (
+	caLambdaCentre = 585
+	caLambdaRange  = 135
+)
+
+// undistortIter is the number of iterations to invert the Brown-Conrady model.
+const undistortIter = 20
+
+// stMap is a redistort ST-map, each pixel holds the undistorted image position in [0,1]x[0,1]
+// of the output pixel in red and green.  data is bottom row first as returned by
+// texture.ReadImage, so row y is at v = (y+0.5)/h with v up like t.
+type stMap struct {
+	w, h int
+	data []float32
+}
+
+// loadSTMap reads the ST-map image.
+func loadSTMap(filename string) (*stMap, error) {
+	w, h, data, err := texture.ReadImage(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &stMap{w, h, data}, nil
+}
+
+// texel returns the st value of the pixel at x,y clamped to the image.
+func (st *stMap) texel(x, y int) (s, t float32) {
+	if x < 0 {
+		x = 0
+	}
+
+	if x >= st.w {
+		x = st.w - 1
+	}
+
+	if y < 0 {
+		y = 0
+	}
+
+	if y >= st.h {
+		y = st.h - 1
+	}
+
+	i := (x + y*st.w) * 3
+
+	return st.data[i], st.data[i+1]
+}
+
+// lookup bilinearly interpolates the map at u,v in [0,1]x[0,1], v is up (the rows are already
+// bottom first so no flip is needed).
+func (st *stMap) lookup(u, v float32) (s, t float32) {
+	x := u*float32(st.w) - 0.5
+	y := v*float32(st.h) - 0.5
+
+	x0, y0 := m.Floor(x), m.Floor(y)
+	fx, fy := x-x0, y-y0
+	i, j := int(x0), int(y0)
+
+	s00, t00 := st.texel(i, j)
+	s10, t10 := st.texel(i+1, j)
+	s01, t01 := st.texel(i, j+1)
+	s11, t11 := st.texel(i+1, j+1)
+
+	s = (1-fy)*((1-fx)*s00+fx*s10) + fy*((1-fx)*s01+fx*s11)
+	t = (1-fy)*((1-fx)*t00+fx*t10) + fy*((1-fx)*t01+fx*t11)
+
+	return
+}
+
+// hasDistortion returns true if any lens distortion is given.
+func (c *Camera) hasDistortion() bool {
+	return c.stMap != nil || c.K1 != 0 || c.K2 != 0 || c.K3 != 0 || c.P1 != 0 || c.P2 != 0 ||
+		c.ChromaticAberration != 0
+}
+
+// distortionScale returns the scale from screen space to the normalised coordinates of the
+// distortion model.  For perspective these are the usual x/z, y/z so calibrated coefficients can
+// be used directly.
+func (c *Camera) distortionScale() float32 {
+	if c.projection == projPerspective {
+		return m.Tan(degToRad(c.Fov / 2))
+	}
+
+	return 1
+}
+
+// distort applies the Brown-Conrady model to the undistorted normalised position x,y.
+func (c *Camera) distort(x, y float32) (xd, yd float32) {
+	r2 := x*x + y*y
+	radial := 1 + r2*(c.K1+r2*(c.K2+r2*c.K3))
+
+	xd = x*radial + 2*c.P1*x*y + c.P2*(r2+2*x*x)
+	yd = y*radial + c.P1*(r2+2*y*y) + 2*c.P2*x*y
+
+	return
+}
+
+// undistort inverts distort by fixed point iteration.
+func (c *Camera) undistort(xd, yd float32) (x, y float32) {
+	x, y = xd, yd
+
+	for i := 0; i < undistortIter; i++ {
+		r2 := x*x + y*y
+		radial := 1 + r2*(c.K1+r2*(c.K2+r2*c.K3))
+
+		if radial <= 0 {
+			// Past the fold of the model, no sensible inverse.
+			break
+		}
+
+		dx := 2*c.P1*x*y + c.P2*(r2+2*x*x)
+		dy := c.P1*(r2+2*y*y) + 2*c.P2*x*y
+
+		x = (xd - dx) / radial
+		y = (yd - dy) / radial
+	}
+
+	return
+}
+
+// lensPosition returns the undistorted screen position for the ray through the image at
+// (sx,sy).  The image is distorted to match a plate, chromatic aberration scales the image with
+// the wavelength.
+func (c *Camera) lensPosition(sx, sy, lambda float32) (float32, float32) {
+	if !c.hasDistortion() {
+		return sx, sy
+	}
+
+	if c.stMap != nil {
+		s, t := c.stMap.lookup((sx+1)/2, (sy+1)/2)
+		sx, sy = 2*s-1, 2*t-1
+	} else if c.K1 != 0 || c.K2 != 0 || c.K3 != 0 || c.P1 != 0 || c.P2 != 0 {
+		scale := c.distortionScale()
+
+		x, y := c.undistort(sx*scale, sy/c.Aspect*scale)
+		sx, sy = x/scale, y*c.Aspect/scale
+	}
+
+	if c.ChromaticAberration != 0 && lambda > 0 {
+		mag := 1 + c.ChromaticAberration*(lambda-caLambdaCentre)/caLambdaRange
+
+		sx /= mag
+		sy /= mag
+	}
+
+	return sx, sy
+}
+
+// vignette returns the weight of a ray at angle theta to the view axis for optical vignetting,
+// a blend towards the cos^4 law.
+func (c *Camera) vignette(cosTheta float32) float32 {
+	if c.Vignetting == 0 {
+		return 1
+	}
+
+	cos2 := cosTheta * cosTheta
+
+	return m.Max(0, 1-c.Vignetting*(1-cos2*cos2))
+}
+
+// weightRay sets the weight of a camera ray for vignetting.
+func (c *Camera) weightRay(ray *core.Ray, cosTheta float32) {
+	w := c.vignette(cosTheta)
+	ray.Weight = colour.RGB{w, w, w}
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package camera
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeIdentitySTMap writes a 16 bit ST-map which maps each pixel to itself, t is up so the top
+// row of the file has t near 1.
+func writeIdentitySTMap(t *testing.T, w, h int) string {
+	img := image.NewNRGBA64(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			s := (float64(x) + 0.5) / float64(w)
+			tt := 1 - (float64(y)+0.5)/float64(h)
+			img.Set(x, y, color.NRGBA64{uint16(s*65535 + 0.5), uint16(tt*65535 + 0.5), 0, 65535})
+		}
+	}
+
+	filename := filepath.Join(t.TempDir(), "stmap.png")
+	f, err := os.Create(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func TestSTMapIdentity(t *testing.T) {
+	st, err := loadSTMap(writeIdentitySTMap(t, 64, 32))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Camera{stMap: st}
+
+	for _, p := range [][2]float32{{0, 0}, {0.5, 0.75}, {-0.6, 0.3}, {0.9, -0.9}, {-0.25, -0.5}} {
+		sx, sy := c.lensPosition(p[0], p[1], 0)
+
+		if d := abs(sx-p[0]) + abs(sy-p[1]); d > 1e-3 {
+			t.Errorf("lensPosition(%v, %v) = %v, %v", p[0], p[1], sx, sy)
+		}
+	}
+}
+
+func TestBrownConradyRoundTrip(t *testing.T) {
+	cameras := []*Camera{
+		{K1: -0.12, K2: 0.03},
+		{K1: 0.08, K2: -0.01, K3: 0.002},
+		{P1: 0.004, P2: -0.003},
+		{K1: -0.1, K2: 0.02, P1: 0.003, P2: 0.002},
+	}
+
+	for _, c := range cameras {
+		for y := float32(-0.8); y <= 0.8; y += 0.2 {
+			for x := float32(-0.8); x <= 0.8; x += 0.2 {
+				xd, yd := c.distort(x, y)
+				ux, uy := c.undistort(xd, yd)
+
+				if d := abs(ux-x) + abs(uy-y); d > 1e-4 {
+					t.Errorf("K %v,%v,%v P %v,%v: undistort(distort(%v, %v)) = %v, %v", c.K1, c.K2, c.K3, c.P1, c.P2, x, y, ux, uy)
+				}
+			}
+		}
+
+		// Distortion moves points off the centre.
+		if xd, yd := c.distort(0.5, 0.5); xd == 0.5 && yd == 0.5 {
+			t.Errorf("K %v,%v,%v P %v,%v: no distortion", c.K1, c.K2, c.K3, c.P1, c.P2)
+		}
+	}
+}
+
+func abs(x float32) float32 {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package camera
+
+import (
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// Defaults for physical exposure when only some of the values are given.
+const (
+	defaultFStop        = 8
+	defaultShutterSpeed = 1.0 / 50
+	defaultISO          = 100
+	defaultSensorWidth  = 36 // Full frame 35mm
+	defaultFPS          = 24
+)
+
+var _ core.CameraExposure = (*Camera)(nil)
+
+// initPhysical derives the field of view, aperture radius, shutter and exposure from the
+// physical camera values which are given.
+func (c *Camera) initPhysical() {
+	sensorWidth := c.SensorWidth
+
+	if sensorWidth <= 0 {
+		sensorWidth = defaultSensorWidth
+	}
+
+	if c.FocalLength > 0 {
+		c.Fov = 2 * m.Atan(sensorWidth/(2*c.FocalLength)) * 180 / m.Pi
+	}
+
+	if c.FStop > 0 {
+		// Focal length from the field of view so it applies to either.
+		focalLength := sensorWidth / (2 * m.Tan(degToRad(c.Fov/2)))
+		c.Radius = focalLength / 1000 / (2 * c.FStop) / core.MetresPerUnit()
+	}
+
+	if c.ShutterSpeed > 0 {
+		fps := c.FPS
+
+		if fps <= 0 {
+			fps = defaultFPS
+		}
+
+		c.ShutterClose = m.Min(1, c.ShutterOpen+c.ShutterSpeed*fps)
+	}
+
+	c.exposure = m.Pow(2, c.ExposureCompensation)
+
+	if c.FStop > 0 || c.ShutterSpeed > 0 || c.ISO > 0 {
+		N, t, S := c.FStop, c.ShutterSpeed, c.ISO
+
+		if N <= 0 {
+			N = defaultFStop
+		}
+
+		if t <= 0 {
+			t = defaultShutterSpeed
+		}
+
+		if S <= 0 {
+			S = defaultISO
+		}
+
+		// Saturation based exposure, a luminance of 1 nit gives t*S/(1.2*100*N^2) (ISO 12232).
+		c.exposure *= t * S / (120 * N * N)
+	}
+}
+
+// Exposure is a core.CameraExposure method.  Without physical values it is just the exposure
+// compensation.
+func (c *Camera) Exposure() float32 { return c.exposure }
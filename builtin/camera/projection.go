@@ -0,0 +1,235 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package camera
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// Camera projections.
+const (
+	projPerspective = iota
+	projOrthographic
+	projFisheyeEquidistant
+	projFisheyeEquisolid
+	projEquirectangular
+	projCylindrical
+)
+
+var projections = map[string]int{
+	"Perspective":        projPerspective,
+	"Orthographic":       projOrthographic,
+	"FisheyeEquidistant": projFisheyeEquidistant,
+	"FisheyeEquisolid":   projFisheyeEquisolid,
+	"Equirectangular":    projEquirectangular,
+	"Cylindrical":        projCylindrical,
+}
+
+// parseProjection returns the projection for the given name, "" is Perspective.
+func parseProjection(name string) (int, error) {
+	if name == "" {
+		return projPerspective, nil
+	}
+
+	proj, ok := projections[name]
+
+	if !ok {
+		return 0, fmt.Errorf("Camera: unknown projection %v", name)
+	}
+
+	return proj, nil
+}
+
+// direction returns the camera space direction through the screen point (sx,sy) for the
+// angular projections.  Returns false outside the image circle of a fisheye.
+//
+// The camera looks down -Z with X right and Y up.  Pixels are square so y is sy/Aspect.
+func (c *Camera) direction(sx, sy float32) (m.Vec3, bool) {
+	halfFov := degToRad(c.Fov / 2)
+	y := sy / c.Aspect
+
+	switch c.projection {
+	case projFisheyeEquidistant, projFisheyeEquisolid:
+		// The image circle spans the width of the image.
+		r := m.Sqrt(sx*sx + y*y)
+
+		if r > 1 {
+			return m.Vec3{}, false
+		}
+
+		theta := r * halfFov
+
+		if c.projection == projFisheyeEquisolid {
+			theta = 2 * m.Asin(m.Min(1, r*m.Sin(halfFov/2)))
+		}
+
+		sinTheta, cosTheta := m.Sincos(theta)
+
+		if r == 0 {
+			return m.Vec3{0, 0, -1}, true
+		}
+
+		return m.Vec3{sinTheta * sx / r, sinTheta * y / r, -cosTheta}, true
+
+	case projEquirectangular:
+		sinPhi, cosPhi := m.Sincos(sx * halfFov)
+		sinLat, cosLat := m.Sincos(m.Clamp(y*halfFov, -m.Pi/2, m.Pi/2))
+
+		return m.Vec3{cosLat * sinPhi, sinLat, -cosLat * cosPhi}, true
+
+	case projCylindrical:
+		sinPhi, cosPhi := m.Sincos(sx * halfFov)
+
+		return m.Vec3Normalize(m.Vec3{sinPhi, y * halfFov, -cosPhi}), true
+	}
+
+	return m.Vec3{}, false
+}
+
+// projectLocal is the inverse of direction (and the orthographic projection), Pl is in camera
+// space.
+func (c *Camera) projectLocal(Pl m.Vec3) (sx, sy float32, ok bool) {
+	halfFov := degToRad(c.Fov / 2)
+
+	switch c.projection {
+	case projOrthographic:
+		if Pl[2] >= 0 {
+			return 0, 0, false
+		}
+
+		s := 2 / c.orthoWidth()
+
+		return Pl[0] * s, Pl[1] * s * c.Aspect, true
+
+	case projFisheyeEquidistant, projFisheyeEquisolid:
+		D := m.Vec3Normalize(Pl)
+		theta := m.Acos(m.Clamp(-D[2], -1, 1))
+
+		if theta > halfFov {
+			return 0, 0, false
+		}
+
+		r := theta / halfFov
+
+		if c.projection == projFisheyeEquisolid {
+			r = m.Sin(theta/2) / m.Sin(halfFov/2)
+		}
+
+		rxy := m.Sqrt(D[0]*D[0] + D[1]*D[1])
+
+		if rxy == 0 {
+			return 0, 0, true
+		}
+
+		return r * D[0] / rxy, r * D[1] / rxy * c.Aspect, true
+
+	case projEquirectangular:
+		D := m.Vec3Normalize(Pl)
+		phi := m.Atan2(D[0], -D[2])
+		lat := m.Asin(m.Clamp(D[1], -1, 1))
+
+		return phi / halfFov, lat / halfFov * c.Aspect, m.Abs(phi) <= halfFov
+
+	case projCylindrical:
+		rxz := m.Sqrt(Pl[0]*Pl[0] + Pl[2]*Pl[2])
+
+		if rxz == 0 {
+			return 0, 0, false
+		}
+
+		phi := m.Atan2(Pl[0], -Pl[2])
+
+		return phi / halfFov, Pl[1] / rxz / halfFov * c.Aspect, m.Abs(phi) <= halfFov
+	}
+
+	return 0, 0, false
+}
+
+// orthoWidth returns the width of the orthographic view in world units.
+func (c *Camera) orthoWidth() float32 {
+	if c.OrthoWidth <= 0 {
+		return 2
+	}
+
+	return c.OrthoWidth
+}
+
+// computeRayProjected calculates the ray for the non-perspective projections.  Differentials
+// are with respect to Sx and Sy, so the pixel deltas are the size of a pixel in screen space.
+// Depth of field is not supported, lens distortion and vignetting are.
+func (c *Camera) computeRayProjected(sc *core.ShaderContext, ray *core.Ray) {
+	M := c.localToWorld(sc.Time)
+
+	ray.X = sc.X
+	ray.Y = sc.Y
+	ray.Sx = sc.Sx
+	ray.Sy = sc.Sy
+
+	sx, sy := c.lensPosition(ray.Sx, ray.Sy, sc.Lambda)
+
+	w, h := core.FrameMetrics()
+
+	dsx := 2 / float32(w)
+	dsy := 2 / float32(h)
+
+	sc.Image.PixelDelta[0] = dsx
+	sc.Image.PixelDelta[1] = dsy
+
+	if c.projection == projOrthographic {
+		halfWidth := c.orthoWidth() / 2
+
+		right := m.Matrix4MulVec(M, m.Vec3{halfWidth, 0, 0})
+		up := m.Matrix4MulVec(M, m.Vec3{0, halfWidth / c.Aspect, 0})
+
+		P := m.Matrix4MulPoint(M, m.Vec3{sx * halfWidth, sy * halfWidth / c.Aspect, 0})
+		D := m.Vec3Normalize(m.Matrix4MulVec(M, m.Vec3{0, 0, -1}))
+
+		ray.DdPdx = right
+		ray.DdPdy = up
+		ray.DdDdx = m.Vec3{}
+		ray.DdDdy = m.Vec3{}
+
+		ray.Init(core.RayTypeCamera, P, D, m.Inf(1), 0, sc)
+		return
+	}
+
+	P := m.Matrix4MulPoint(M, c.eyeOrigin(sx))
+
+	d, ok := c.direction(sx, sy)
+
+	if !ok {
+		// Outside the image circle, a zero length ray hits nothing.
+		ray.DdPdx, ray.DdPdy, ray.DdDdx, ray.DdDdy = m.Vec3{}, m.Vec3{}, m.Vec3{}, m.Vec3{}
+		ray.Init(core.RayTypeCamera, P, m.Matrix4MulVec(M, m.Vec3{0, 0, -1}), 0, 0, sc)
+		return
+	}
+
+	// Differentials by differencing the direction a pixel away, back towards the centre so
+	// they stay inside a fisheye circle.
+	stepX, stepY := dsx, dsy
+
+	if sx > 0 {
+		stepX = -dsx
+	}
+
+	if sy > 0 {
+		stepY = -dsy
+	}
+
+	dx, _ := c.direction(sx+stepX, sy)
+	dy, _ := c.direction(sx, sy+stepY)
+
+	D := m.Vec3Normalize(m.Matrix4MulVec(M, d))
+
+	ray.DdPdx = m.Vec3{}
+	ray.DdPdy = m.Vec3{}
+	ray.DdDdx = m.Matrix4MulVec(M, m.Vec3Scale(1/stepX, m.Vec3Sub(dx, d)))
+	ray.DdDdy = m.Matrix4MulVec(M, m.Vec3Scale(1/stepY, m.Vec3Sub(dy, d)))
+
+	ray.Init(core.RayTypeCamera, P, D, m.Inf(1), 0, sc)
+	c.weightRay(ray, -d[2]/m.Vec3Length(d))
+}
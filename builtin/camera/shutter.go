@@ -0,0 +1,99 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package camera
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+var _ core.CameraShutter = (*Camera)(nil)
+
+// initShutter checks the shutter parameters and builds the CDF of the shutter curve.
+func (c *Camera) initShutter() error {
+	if c.ShutterOpen < 0 || c.ShutterClose > 1 || c.ShutterOpen > c.ShutterClose {
+		return fmt.Errorf("Camera %v: need 0 <= ShutterOpen <= ShutterClose <= 1", c.NodeName)
+	}
+
+	if c.RollingShutter < 0 || c.RollingShutter > 1 {
+		return fmt.Errorf("Camera %v: RollingShutter must be in [0,1]", c.NodeName)
+	}
+
+	c.shutterCDF = nil
+
+	curve := c.ShutterCurve.Elems
+
+	if len(curve) == 0 {
+		return nil
+	}
+
+	if len(curve) == 1 {
+		return fmt.Errorf("Camera %v: ShutterCurve needs at least 2 values", c.NodeName)
+	}
+
+	// Each segment of the piecewise linear curve has the area of a trapezoid.
+	cdf := make([]float32, len(curve)-1)
+	total := float32(0)
+
+	for i := range cdf {
+		if curve[i] < 0 || curve[i+1] < 0 {
+			return fmt.Errorf("Camera %v: ShutterCurve values must not be negative", c.NodeName)
+		}
+
+		total += (curve[i] + curve[i+1]) / 2
+		cdf[i] = total
+	}
+
+	if total == 0 {
+		return fmt.Errorf("Camera %v: ShutterCurve is zero", c.NodeName)
+	}
+
+	for i := range cdf {
+		cdf[i] /= total
+	}
+
+	c.shutterCDF = cdf
+
+	return nil
+}
+
+// sampleShutterCurve returns a position in [0,1] across the exposure distributed by the shutter
+// curve.
+func (c *Camera) sampleShutterCurve(u float32) float32 {
+	if c.shutterCDF == nil {
+		return u
+	}
+
+	i, t := sampleCDF(c.shutterCDF, u)
+
+	// Invert the CDF of the linear density from a to b over the segment, i.e. solve
+	// a*x + (b-a)*x^2/2 = t*(a+b)/2 for x in [0,1].
+	a, b := c.ShutterCurve.Elems[i], c.ShutterCurve.Elems[i+1]
+	x := t
+
+	if d := b - a; m.Abs(d) > 1e-6*(a+b) {
+		x = (m.Sqrt(m.Max(0, a*a+t*(b*b-a*a))) - a) / d
+	}
+
+	return (float32(i) + m.Clamp(x, 0, 1)) / float32(len(c.shutterCDF))
+}
+
+// ShutterTime is a core.CameraShutter method.  The exposure of each scanline lasts from
+// ShutterOpen to ShutterClose, with a rolling shutter the scanlines start RollingShutter apart
+// from top to bottom.
+func (c *Camera) ShutterTime(u float64, sx, sy float32) float32 {
+	open := c.ShutterOpen
+	length := c.ShutterClose - c.ShutterOpen
+
+	if c.RollingShutter > 0 {
+		// The readout takes RollingShutter of the frame, the exposure is shortened so the
+		// last line closes by ShutterClose.
+		length = m.Max(0, length-c.RollingShutter)
+		open += c.RollingShutter * m.Clamp((1-sy)/2, 0, 1)
+	}
+
+	return m.Clamp(open+length*c.sampleShutterCurve(float32(u)), 0, 1)
+}
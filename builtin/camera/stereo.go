@@ -0,0 +1,81 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package camera
+
+import (
+	"fmt"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// isPanorama returns true for the projections which use omni-directional stereo.
+func (c *Camera) isPanorama() bool {
+	return c.projection == projEquirectangular || c.projection == projCylindrical
+}
+
+// initStereo calculates the eye offset and matrix from the stereo parameters.  The eyes are
+// either side of the camera position along its X axis.
+func (c *Camera) initStereo() error {
+	c.eyeOffset = 0
+	c.eyeMatrix = m.Matrix4Identity
+	c.screenShift = 0
+
+	switch c.Eye {
+	case "":
+		return nil
+	case "Left":
+		c.eyeOffset = -c.Interocular / 2
+	case "Right":
+		c.eyeOffset = c.Interocular / 2
+	default:
+		return fmt.Errorf("Camera %v: unknown eye %v", c.NodeName, c.Eye)
+	}
+
+	convergence := c.Convergence
+
+	if convergence <= 0 {
+		convergence = c.Focal
+	}
+
+	switch c.Stereo {
+	case "", "Parallel", "Converged", "OffAxis":
+	default:
+		return fmt.Errorf("Camera %v: unknown stereo mode %v", c.NodeName, c.Stereo)
+	}
+
+	// Panoramas offset the eye per ray (see eyeOrigin).
+	if c.isPanorama() {
+		return nil
+	}
+
+	c.eyeMatrix = m.Matrix4Translate(c.eyeOffset, 0, 0)
+
+	switch c.Stereo {
+	case "Converged":
+		// Toe in to look at the centre of the convergence plane.
+		toe := m.Matrix4Rotate(m.Atan2(c.eyeOffset, convergence), 0, 1, 0)
+		c.eyeMatrix = m.Matrix4Mul(c.eyeMatrix, toe)
+
+	case "OffAxis":
+		// Shift the image so the frustums of both eyes meet at the convergence plane.
+		if c.projection == projPerspective {
+			c.screenShift = -c.eyeOffset / (convergence * m.Tan(degToRad(c.Fov/2)))
+		}
+	}
+
+	return nil
+}
+
+// eyeOrigin returns the camera space ray origin for the screen position sx.  Panoramas are
+// omni-directional stereo, the eye is offset perpendicular to the horizontal view direction so
+// each column of the image has the correct parallax.
+func (c *Camera) eyeOrigin(sx float32) m.Vec3 {
+	if c.eyeOffset == 0 || !c.isPanorama() {
+		return m.Vec3{}
+	}
+
+	sinPhi, cosPhi := m.Sincos(sx * degToRad(c.Fov/2))
+
+	return m.Vec3{c.eyeOffset * cosPhi, 0, c.eyeOffset * sinPhi}
+}
@@ -0,0 +1,128 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/builtin/tonemap"
+	"github.com/jamiec7919/vermeer/core"
+	"image"
+	"os"
+)
+
+// ldrImage applies the view transform to the framebuffer and quantises it with dithering to
+// 8 or 16 bits.  With alpha the colours are unpremultiplied and the image is NRGBA, otherwise the
+// premultiplied colours are written (i.e. over black).
+func ldrImage(fb *core.Framebuffer, view tonemap.View, dither string, bits int, alpha bool) (image.Image, error) {
+	if bits != 8 && bits != 16 {
+		return nil, fmt.Errorf("Output: Bits must be 8 or 16, not %v", bits)
+	}
+
+	w, h := fb.Width, fb.Height
+	rgb := make([]float32, w*h*3)
+	copy(rgb, fb.Buf)
+
+	if alpha {
+		for i, a := range fb.Alpha {
+			if a > 0 {
+				for k := 0; k < 3; k++ {
+					rgb[i*3+k] /= a
+				}
+			}
+		}
+	}
+
+	if err := view.Apply(w, h, rgb); err != nil {
+		return nil, err
+	}
+
+	nc := 3
+
+	if alpha {
+		nc = 4
+		rgba := make([]float32, w*h*4)
+
+		for i, a := range fb.Alpha {
+			copy(rgba[i*4:i*4+3], rgb[i*3:i*3+3])
+			rgba[i*4+3] = a
+		}
+
+		rgb = rgba
+	}
+
+	q, err := tonemap.Quantise(w, h, nc, rgb, 1<<uint(bits)-1, dither)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Without alpha the image is opaque.
+	pixel := func(i int) (r, g, b, a uint16) {
+		a = 1<<uint(bits) - 1
+
+		if alpha {
+			a = q[i*4+3]
+		}
+
+		return q[i*nc], q[i*nc+1], q[i*nc+2], a
+	}
+
+	rect := image.Rect(0, 0, w, h)
+
+	if bits == 8 {
+		img := image.NewNRGBA(rect)
+
+		for i := 0; i < w*h; i++ {
+			r, g, b, a := pixel(i)
+			copy(img.Pix[i*4:], []uint8{uint8(r), uint8(g), uint8(b), uint8(a)})
+		}
+
+		return img, nil
+	}
+
+	img := image.NewNRGBA64(rect)
+
+	for i := 0; i < w*h; i++ {
+		r, g, b, a := pixel(i)
+		copy(img.Pix[i*8:], []uint8{uint8(r >> 8), uint8(r), uint8(g >> 8), uint8(g), uint8(b >> 8), uint8(b), uint8(a >> 8), uint8(a)})
+	}
+
+	return img, nil
+}
+
+// writeLDR writes the views to their filenames with encode.
+func writeLDR(camera, filename string, view tonemap.View, dither string, bits int, alpha bool,
+	encode func(f *os.File, img image.Image) error) error {
+	views, err := outputViews(camera, filename)
+
+	if err != nil {
+		return err
+	}
+
+	for _, v := range views {
+		img, err := ldrImage(core.ViewFramebuffer(v), view, dither, bits, alpha)
+
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Create(viewFilename(filename, v))
+
+		if err != nil {
+			return err
+		}
+
+		if err := encode(f, img); err != nil {
+			f.Close()
+			return err
+		}
+
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
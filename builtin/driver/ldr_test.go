@@ -0,0 +1,67 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"github.com/jamiec7919/vermeer/builtin/tonemap"
+	"github.com/jamiec7919/vermeer/core"
+	"image"
+	"testing"
+)
+
+func TestLDRImage(t *testing.T) {
+	// Premultiplied: half covered orange, an uncovered pixel with some colour (e.g. from
+	// emission) and an empty pixel.
+	fb := &core.Framebuffer{
+		Width:  3,
+		Height: 1,
+		Buf:    []float32{0.5, 0.25, 0, 0.2, 0, 0, 0, 0, 0},
+		Alpha:  []float32{0.5, 0, 0},
+	}
+
+	view := tonemap.View{Transfer: tonemap.Linear}
+
+	tests := []struct {
+		bits     int
+		alpha    bool
+		expected [3][4]uint32
+	}{
+		{8, true, [3][4]uint32{{255, 128, 0, 128}, {51, 0, 0, 0}, {0, 0, 0, 0}}},
+		{8, false, [3][4]uint32{{128, 64, 0, 255}, {51, 0, 0, 255}, {0, 0, 0, 255}}},
+		{16, true, [3][4]uint32{{65535, 32768, 0, 32768}, {13107, 0, 0, 0}, {0, 0, 0, 0}}},
+		{16, false, [3][4]uint32{{32768, 16384, 0, 65535}, {13107, 0, 0, 65535}, {0, 0, 0, 65535}}},
+	}
+
+	for _, test := range tests {
+		img, err := ldrImage(fb, view, tonemap.DitherNone, test.bits, test.alpha)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for x, expected := range test.expected {
+			var c [4]uint32
+
+			switch img := img.(type) {
+			case *image.NRGBA:
+				p := img.NRGBAAt(x, 0)
+				c = [4]uint32{uint32(p.R), uint32(p.G), uint32(p.B), uint32(p.A)}
+			case *image.NRGBA64:
+				p := img.NRGBA64At(x, 0)
+				c = [4]uint32{uint32(p.R), uint32(p.G), uint32(p.B), uint32(p.A)}
+			default:
+				t.Fatalf("%v bits: image is %T", test.bits, img)
+			}
+
+			if c != expected {
+				t.Errorf("%v bits alpha %v: pixel %v = %v, expected %v", test.bits, test.alpha, x, c, expected)
+			}
+		}
+	}
+
+	if _, err := ldrImage(fb, view, tonemap.DitherNone, 12, false); err == nil {
+		t.Errorf("expected an error for 12 bits")
+	}
+}
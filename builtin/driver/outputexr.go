@@ -0,0 +1,126 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/image"
+	_ "github.com/jamiec7919/vermeer/image/exr"
+	"github.com/jamiec7919/vermeer/nodes"
+	"strings"
+)
+
+// OutputEXR is a node which saves the rendered image into an OpenEXR file with premultiplied
+// RGBA channels.  With several views and no <camera> in the filename every view is written to
+// the one file as a multi-view EXR, the first view is the default.
+type OutputEXR struct {
+	NodeDef     core.NodeDef `node:"-"`
+	Filename    string
+	Half        bool   `node:",opt"` // Write half floats
+	Compression string `node:",opt"` // none, zip, zips or piz
+	TileSize    int    `node:",opt"` // Write a tiled image if > 0
+	Camera      string `node:",opt"` // View to write, see outputViews
+}
+
+// Name is a core.Node method.
+func (n *OutputEXR) Name() string { return "OutputEXR<>" }
+
+// Def is a core.Node method.
+func (n *OutputEXR) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *OutputEXR) PreRender() error { return nil }
+
+// PostRender is a core.Node method.
+func (n *OutputEXR) PostRender() error {
+	if n.Camera == "" && !strings.Contains(n.Filename, viewToken) && len(core.Views()) > 1 {
+		return n.write(n.Filename, core.Views())
+	}
+
+	views, err := outputViews(n.Camera, n.Filename)
+
+	if err != nil {
+		return err
+	}
+
+	for _, view := range views {
+		if err := n.write(viewFilename(n.Filename, view), []string{view}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write saves the views to filename, views after the first are prefixed by their name.
+func (n *OutputEXR) write(filename string, views []string) error {
+	i, err := image.NewWriter(filename)
+
+	if err != nil {
+		return err
+	}
+
+	w, h := core.FrameMetrics()
+
+	spec := image.Spec{
+		Width:        w,
+		Height:       h,
+		NChannels:    4 * len(views),
+		TileWidth:    n.TileSize,
+		TileHeight:   n.TileSize,
+		AlphaChannel: 3,
+		ExtraAttribs: map[string]interface{}{},
+	}
+
+	if n.Half {
+		spec.Format = []image.TypeDesc{{BaseType: image.HALF}}
+	}
+
+	if n.Compression != "" {
+		spec.ExtraAttribs["compression"] = n.Compression
+	}
+
+	if len(views) > 1 {
+		spec.ExtraAttribs["multiView"] = views
+	}
+
+	buf := make([]float32, w*h*spec.NChannels)
+
+	for k, view := range views {
+		prefix := ""
+
+		if k > 0 {
+			prefix = view + "."
+		}
+
+		for _, c := range []string{"R", "G", "B", "A"} {
+			spec.ChannelNames = append(spec.ChannelNames, prefix+c)
+		}
+
+		fb := core.ViewFramebuffer(view)
+
+		for p := range fb.Alpha {
+			rgba := buf[p*spec.NChannels+k*4:]
+			copy(rgba[0:3], fb.Buf[p*3:p*3+3])
+			rgba[3] = fb.Alpha[p]
+		}
+	}
+
+	if err := i.Open(filename, &spec); err != nil {
+		return err
+	}
+
+	defer i.Close()
+
+	return i.WriteImage(image.TypeDesc{BaseType: image.FLOAT}, buf)
+}
+
+func init() {
+	nodes.Register("OutputEXR", func() (core.Node, error) {
+		out := OutputEXR{Filename: "out.exr"}
+
+		return &out, nil
+	})
+}
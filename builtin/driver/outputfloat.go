@@ -11,10 +11,13 @@ import (
 	"os"
 )
 
-// OutputFloat is a node which saves the rendered image into a Float file.
+// OutputFloat is a node which saves the rendered image into a Float file.  The file is the raw
+// little-endian float32 pixels, RGB or RGBA if Alpha is set.
 type OutputFloat struct {
 	NodeDef  core.NodeDef `node:"-"`
 	Filename string
+	Alpha    bool   `node:",opt"` // Write premultiplied RGBA
+	Camera   string `node:",opt"` // View to write, see outputViews
 }
 
 // Name is a core.Node method.
@@ -28,17 +31,43 @@ func (n *OutputFloat) PreRender() error { return nil }
 
 // PostRender is a core.Node method.
 func (n *OutputFloat) PostRender() error {
-	//w, h := core.FrameMetrics()
+	views, err := outputViews(n.Camera, n.Filename)
 
-	fp, err := os.Create(n.Filename)
+	if err != nil {
+		return err
+	}
+
+	for _, view := range views {
+		if err := n.write(viewFilename(n.Filename, view), core.ViewFramebuffer(view)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write saves the framebuffer to filename.
+func (n *OutputFloat) write(filename string, fb *core.Framebuffer) error {
+	fp, err := os.Create(filename)
 
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(fp, binary.LittleEndian, core.FrameBuf())
+	defer fp.Close()
+
+	if !n.Alpha {
+		return binary.Write(fp, binary.LittleEndian, fb.Buf)
+	}
+
+	rgba := make([]float32, len(fb.Alpha)*4)
+
+	for i := range fb.Alpha {
+		copy(rgba[i*4:i*4+3], fb.Buf[i*3:i*3+3])
+		rgba[i*4+3] = fb.Alpha[i]
+	}
 
-	return err
+	return binary.Write(fp, binary.LittleEndian, rgba)
 }
 
 func init() {
@@ -11,10 +11,13 @@ import (
 	"github.com/jamiec7919/vermeer/nodes"
 )
 
-// OutputHDR is a node which saves the rendered image intoa Radiance HDR file.
+// OutputHDR is a node which saves the rendered image intoa Radiance HDR file.  Radiance HDR has
+// no alpha channel so the alpha is written as a grey image to AlphaFilename, if given.
 type OutputHDR struct {
-	NodeDef  core.NodeDef `node:"-"`
-	Filename string
+	NodeDef       core.NodeDef `node:"-"`
+	Filename      string
+	AlphaFilename string `node:",opt"`
+	Camera        string `node:",opt"` // View to write, see outputViews
 }
 
 // Name is a core.Node method.
@@ -28,7 +31,42 @@ func (n *OutputHDR) PreRender() error { return nil }
 
 // PostRender is a core.Node method.
 func (n *OutputHDR) PostRender() error {
-	i, err := image.NewWriter(n.Filename)
+	views, err := outputViews(n.Camera, n.Filename)
+
+	if err != nil {
+		return err
+	}
+
+	for _, view := range views {
+		fb := core.ViewFramebuffer(view)
+
+		if err := writeHDR(viewFilename(n.Filename, view), fb.Buf); err != nil {
+			return err
+		}
+
+		if n.AlphaFilename == "" {
+			continue
+		}
+
+		grey := make([]float32, len(fb.Alpha)*3)
+
+		for i, a := range fb.Alpha {
+			grey[i*3+0] = a
+			grey[i*3+1] = a
+			grey[i*3+2] = a
+		}
+
+		if err := writeHDR(viewFilename(n.AlphaFilename, view), grey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeHDR writes the RGB pixels to filename.
+func writeHDR(filename string, buf []float32) error {
+	i, err := image.NewWriter(filename)
 
 	if err != nil {
 		return err
@@ -41,13 +79,13 @@ func (n *OutputHDR) PostRender() error {
 		Height: h,
 	}
 
-	if err := i.Open(n.Filename, &spec); err != nil {
+	if err := i.Open(filename, &spec); err != nil {
 		return err
 	}
 
 	ty := image.TypeDesc{BaseType: image.FLOAT}
 
-	if err := i.WriteImage(ty, core.FrameBuf()); err != nil {
+	if err := i.WriteImage(ty, buf); err != nil {
 		return err
 	}
 
@@ -0,0 +1,61 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"github.com/jamiec7919/vermeer/builtin/tonemap"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/nodes"
+	"image"
+	"image/jpeg"
+	"os"
+)
+
+// OutputJPEG is a node which saves the rendered image into a JPEG file after the view
+// transform.
+type OutputJPEG struct {
+	NodeDef  core.NodeDef `node:"-"`
+	Filename string
+	Quality  int     `node:",opt"` // 1 to 100
+	Exposure float32 `node:",opt"` // Stops
+	Tonemap  string  `node:",opt"` // Linear, Reinhard, Filmic or DuanQiu
+	White    float32 `node:",opt"` // Reinhard white point
+	Equalise float32 `node:",opt"` // DuanQiu histogram equalisation
+	Transfer string  `node:",opt"` // sRGB, Rec709 or Linear
+	Dither   string  `node:",opt"` // None, Ordered or BlueNoise
+	Camera   string  `node:",opt"` // View to write, see outputViews
+}
+
+// Name is a core.Node method.
+func (n *OutputJPEG) Name() string { return "OutputJPEG<>" }
+
+// Def is a core.Node method.
+func (n *OutputJPEG) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *OutputJPEG) PreRender() error { return nil }
+
+// PostRender is a core.Node method.
+func (n *OutputJPEG) PostRender() error {
+	view := tonemap.View{
+		Exposure: n.Exposure,
+		Operator: n.Tonemap,
+		White:    n.White,
+		Equalise: n.Equalise,
+		Transfer: n.Transfer,
+	}
+
+	return writeLDR(n.Camera, n.Filename, view, n.Dither, 8, false, func(f *os.File, img image.Image) error {
+		return jpeg.Encode(f, img, &jpeg.Options{Quality: n.Quality})
+	})
+}
+
+func init() {
+	nodes.Register("OutputJPEG", func() (core.Node, error) {
+		out := OutputJPEG{Filename: "out.jpg", Quality: 90, Equalise: 0.5, Dither: tonemap.DitherBlueNoise}
+
+		return &out, nil
+	})
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"github.com/jamiec7919/vermeer/builtin/tonemap"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/nodes"
+	"image"
+	"image/png"
+	"os"
+)
+
+// OutputPNG is a node which saves the rendered image into an 8 or 16-bit PNG file after the
+// view transform.
+type OutputPNG struct {
+	NodeDef  core.NodeDef `node:"-"`
+	Filename string
+	Bits     int     `node:",opt"` // 8 or 16
+	Alpha    bool    `node:",opt"` // Write RGBA
+	Exposure float32 `node:",opt"` // Stops
+	Tonemap  string  `node:",opt"` // Linear, Reinhard, Filmic or DuanQiu
+	White    float32 `node:",opt"` // Reinhard white point
+	Equalise float32 `node:",opt"` // DuanQiu histogram equalisation
+	Transfer string  `node:",opt"` // sRGB, Rec709 or Linear
+	Dither   string  `node:",opt"` // None, Ordered or BlueNoise
+	Camera   string  `node:",opt"` // View to write, see outputViews
+}
+
+// Name is a core.Node method.
+func (n *OutputPNG) Name() string { return "OutputPNG<>" }
+
+// Def is a core.Node method.
+func (n *OutputPNG) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *OutputPNG) PreRender() error { return nil }
+
+// PostRender is a core.Node method.
+func (n *OutputPNG) PostRender() error {
+	view := tonemap.View{
+		Exposure: n.Exposure,
+		Operator: n.Tonemap,
+		White:    n.White,
+		Equalise: n.Equalise,
+		Transfer: n.Transfer,
+	}
+
+	return writeLDR(n.Camera, n.Filename, view, n.Dither, n.Bits, n.Alpha, func(f *os.File, img image.Image) error {
+		return png.Encode(f, img)
+	})
+}
+
+func init() {
+	nodes.Register("OutputPNG", func() (core.Node, error) {
+		out := OutputPNG{Filename: "out.png", Bits: 8, Equalise: 0.5, Dither: tonemap.DitherBlueNoise}
+
+		return &out, nil
+	})
+}
@@ -0,0 +1,43 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/core"
+	"strings"
+)
+
+// viewToken is replaced by the camera name in output filenames.
+const viewToken = "<camera>"
+
+// outputViews returns the cameras written by an output node.  This is the named camera or if
+// none is given, every view when the filename contains <camera> and the first view otherwise.
+func outputViews(camera, filename string) ([]string, error) {
+	if camera != "" {
+		if core.ViewFramebuffer(camera) == nil {
+			return nil, fmt.Errorf("Output %v: camera %v wasn't rendered", filename, camera)
+		}
+
+		return []string{camera}, nil
+	}
+
+	views := core.Views()
+
+	if len(views) == 0 {
+		return nil, fmt.Errorf("Output %v: nothing rendered", filename)
+	}
+
+	if !strings.Contains(filename, viewToken) {
+		return views[:1], nil
+	}
+
+	return views, nil
+}
+
+// viewFilename substitutes the camera name for <camera> in filename.
+func viewFilename(filename, camera string) string {
+	return strings.Replace(filename, viewToken, camera, -1)
+}
@@ -126,6 +126,17 @@ func (mesh *PolyMesh) initAccel() error {
 		mesh.normalidx = normalidx
 	}
 
+	if mesh.tangentidx != nil {
+		tangentidx := make([]uint32, len(mesh.tangentidx))
+
+		for i := range idxs {
+			tangentidx[i*3+0] = mesh.tangentidx[idxs[i]*3+0]
+			tangentidx[i*3+1] = mesh.tangentidx[idxs[i]*3+1]
+			tangentidx[i*3+2] = mesh.tangentidx[idxs[i]*3+2]
+		}
+		mesh.tangentidx = tangentidx
+	}
+
 	mesh.idxp = idxp
 
 	/*
@@ -0,0 +1,171 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package polymesh
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/builtin/maps"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// calcNormals calculates smooth (area weighted) vertex normals from the polygons, one set for
+// each motion key of Verts.
+func (mesh *PolyMesh) calcNormals() {
+	keys := mesh.Verts.MotionKeys
+
+	if keys < 1 {
+		keys = 1
+	}
+
+	nverts := len(mesh.Verts.Elems) / keys
+	N := make([]m.Vec3, nverts*keys)
+
+	for k := 0; k < keys; k++ {
+		P := mesh.Verts.Elems[k*nverts : (k+1)*nverts]
+		Nk := N[k*nverts : (k+1)*nverts]
+
+		mesh.forEachPoly(func(face []int32) {
+			// Newell's method gives the area weighted normal of a (possibly non-planar) polygon.
+			var Nf m.Vec3
+
+			for i := range face {
+				a := P[face[i]]
+				b := P[face[(i+1)%len(face)]]
+
+				Nf[0] += (a[1] - b[1]) * (a[2] + b[2])
+				Nf[1] += (a[2] - b[2]) * (a[0] + b[0])
+				Nf[2] += (a[0] - b[0]) * (a[1] + b[1])
+			}
+
+			for _, v := range face {
+				Nk[v] = m.Vec3Add(Nk[v], Nf)
+			}
+		})
+	}
+
+	for i := range N {
+		if m.Vec3Length2(N[i]) > 0 {
+			N[i] = m.Vec3Normalize(N[i])
+		}
+	}
+
+	mesh.Normals.Elems = N
+	mesh.Normals.ElemsPerKey = nverts
+	mesh.Normals.MotionKeys = mesh.Verts.MotionKeys
+	mesh.NormalIdx = nil // Same as FaceIdx
+}
+
+// forEachPoly calls fn with the vertex indexes of each polygon.
+func (mesh *PolyMesh) forEachPoly(fn func(face []int32)) {
+	faceIdx := mesh.FaceIdx
+
+	if faceIdx == nil {
+		for i := 0; i < mesh.Verts.ElemsPerKey; i++ {
+			faceIdx = append(faceIdx, int32(i))
+		}
+	}
+
+	if mesh.PolyCount == nil {
+		for i := 0; i+2 < len(faceIdx); i += 3 {
+			fn(faceIdx[i : i+3])
+		}
+		return
+	}
+
+	base := int32(0)
+
+	for _, n := range mesh.PolyCount {
+		fn(faceIdx[base : base+n])
+		base += n
+	}
+}
+
+// initDisplacement moves each vertex along its normal by the Displacement map.  The map is
+// evaluated once per vertex (at the UV and position of the first key, with the first face using
+// it) so shared vertices can't crack apart.  Vertex normals are recalculated afterwards.
+// Displacement only moves vertices so the mesh must be subdivided first.
+func (mesh *PolyMesh) initDisplacement() error {
+	if mesh.Displacement == nil {
+		return nil
+	}
+
+	if mesh.Subdiv == SubdivNone || mesh.SubdivLevel <= 0 {
+		return fmt.Errorf("PolyMesh %v: Displacement needs Subdiv and SubdivLevel to tessellate the mesh", mesh.NodeName)
+	}
+
+	// Map nodes may come after the mesh, this PreRenders them first.
+	if err := maps.Resolve(mesh.Displacement); err != nil {
+		return err
+	}
+
+	keys := mesh.Verts.MotionKeys
+
+	if keys < 1 {
+		keys = 1
+	}
+
+	nverts := len(mesh.Verts.Elems) / keys
+
+	if mesh.Normals.Elems == nil || mesh.NormalIdx != nil || len(mesh.Normals.Elems) != nverts*keys {
+		mesh.calcNormals()
+	}
+
+	uv := make([]m.Vec2, nverts)
+
+	if mesh.UV.Elems != nil {
+		seen := make([]bool, nverts)
+		corner := 0
+
+		mesh.forEachPoly(func(face []int32) {
+			for _, v := range face {
+				if !seen[v] {
+					seen[v] = true
+
+					if mesh.UVIdx != nil {
+						uv[v] = mesh.UV.Elems[mesh.UVIdx[corner]]
+					} else {
+						uv[v] = mesh.UV.Elems[v]
+					}
+				}
+				corner++
+			}
+		})
+	}
+
+	// Maps may use the task (e.g. to trace rays) so the context comes from a task's pool as in
+	// core.Trace.  The image isn't set up until Render.
+	task := new(core.RenderTask)
+	sg := task.NewShaderContext()
+	defer task.ReleaseShaderContext(sg)
+
+	sg.Image = &core.Image{}
+	sg.Transform = m.Matrix4Identity
+	sg.InvTransform = m.Matrix4Identity
+
+	for v := 0; v < nverts; v++ {
+		N := mesh.Normals.Elems[v]
+
+		sg.P = mesh.Verts.Elems[v]
+		sg.Po = sg.P
+		sg.N = N
+		sg.Ng = N
+		sg.Ns = N
+		sg.U = uv[v][0]
+		sg.V = uv[v][1]
+
+		d := mesh.DisplacementScale * mesh.Displacement.Float32(sg)
+
+		// Each key moves along its own normal.
+		for k := 0; k < keys; k++ {
+			i := k*nverts + v
+			mesh.Verts.Elems[i] = m.Vec3Mad(mesh.Verts.Elems[i], mesh.Normals.Elems[i], d)
+		}
+	}
+
+	mesh.calcNormals()
+
+	return nil
+}
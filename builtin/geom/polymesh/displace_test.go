@@ -0,0 +1,76 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package polymesh
+
+import (
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"testing"
+)
+
+// rampMap is a displacement of U, it uses the shader context pool like maps which trace rays.
+type rampMap struct{}
+
+func (rampMap) Float32(sg *core.ShaderContext) float32 {
+	sc := sg.NewShaderContext()
+	sg.ReleaseShaderContext(sc)
+
+	return sg.U
+}
+
+func TestDisplacement(t *testing.T) {
+	mesh := &PolyMesh{
+		NodeName:          "plane",
+		PolyCount:         []int32{4},
+		FaceIdx:           []int32{0, 1, 2, 3},
+		Subdiv:            SubdivLinear,
+		SubdivLevel:       2,
+		Displacement:      rampMap{},
+		DisplacementScale: 0.5,
+	}
+
+	mesh.Verts.Elems = []m.Vec3{{0, 0, 0}, {2, 0, 0}, {2, 2, 0}, {0, 2, 0}}
+	mesh.Verts.ElemsPerKey = 4
+	mesh.Verts.MotionKeys = 1
+	mesh.UV.Elems = []m.Vec2{{0, 0}, {1, 0}, {1, 1}, {0, 1}}
+	mesh.UV.ElemsPerKey = 4
+	mesh.UV.MotionKeys = 1
+
+	if err := mesh.initSubdiv(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mesh.initDisplacement(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mesh.Verts.Elems) != 25 {
+		t.Fatalf("%v verts, expected 25", len(mesh.Verts.Elems))
+	}
+
+	// The plane faces +z and U is x/2.
+	for i, P := range mesh.Verts.Elems {
+		if expected := 0.5 * P[0] / 2; m.Abs(P[2]-expected) > 1e-5 {
+			t.Errorf("vertex %v = %v, expected z = %v", i, P, expected)
+		}
+	}
+
+	// Normals are recalculated and tilt back along -x.
+	for i, N := range mesh.Normals.Elems {
+		expected := m.Vec3Normalize(m.Vec3{-0.25, 0, 1})
+
+		if m.Vec3Length(m.Vec3Sub(N, expected)) > 1e-5 {
+			t.Errorf("normal %v = %v, expected %v", i, N, expected)
+		}
+	}
+}
+
+func TestDisplacementNeedsSubdiv(t *testing.T) {
+	mesh := &PolyMesh{NodeName: "plane", Displacement: rampMap{}, DisplacementScale: 1}
+
+	if err := mesh.initDisplacement(); err == nil {
+		t.Errorf("expected an error displacing without Subdiv")
+	}
+}
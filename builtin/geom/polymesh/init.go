@@ -124,6 +124,8 @@ func (mesh *PolyMesh) init() error {
 
 	}
 
+	mesh.initTangents()
+
 	mesh.FaceIdx = nil
 	mesh.PolyCount = nil
 	mesh.UVIdx = nil
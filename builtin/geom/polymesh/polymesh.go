@@ -29,6 +29,14 @@ type PolyMesh struct {
 	CalcNormals bool `node:",opt"`
 	IsVisible   bool `node:",opt"`
 
+	Subdiv          string             `node:",opt"` // Subdivision scheme, "CatmullClark", "Loop" or "Linear"
+	SubdivLevel     int                `node:",opt"`
+	CreaseIdx       []int32            `node:",opt"` // Pairs of vertex indexes
+	CreaseSharpness param.Float32Array `node:",opt"` // One per CreaseIdx pair
+
+	Displacement      param.Float32Uniform `node:",opt"`
+	DisplacementScale float32              `node:",opt"`
+
 	Transform    param.MatrixArray `node:",opt"`
 	transformSRT []m.TransformDecomp
 	//	invTransformSRT []m.TransformDecomp
@@ -46,13 +54,18 @@ type PolyMesh struct {
 	normalidx     []uint32
 	shaderidx     []uint8
 
+	tangentidx  []uint32 // triangulated tangent indexes
+	tangents    []m.Vec3 // Generated from the UVs, see initTangents
+	tangentSign []float32
+
 	accel struct {
 		mqbvh qbvh.MotionQBVH
 		qbvh  []qbvh.Node
 		idx   []int32 // Face indexes
 	}
 
-	shader []core.Shader
+	shader  []core.Shader
+	opacity []core.OpacityShader // Per shader, nil if none of the shaders have opacity
 
 	bounds          m.BoundingBox
 	motionBounds    []m.BoundingBox
@@ -71,6 +84,18 @@ func (mesh *PolyMesh) Def() core.NodeDef { return mesh.NodeDef }
 
 // PreRender is a core.Node method.
 func (mesh *PolyMesh) PreRender() error {
+	if err := mesh.initSubdiv(); err != nil {
+		return err
+	}
+
+	if mesh.CalcNormals && mesh.Normals.Elems == nil {
+		mesh.calcNormals()
+	}
+
+	if err := mesh.initDisplacement(); err != nil {
+		return err
+	}
+
 	if err := mesh.init(); err != nil {
 		return err
 	}
@@ -93,6 +118,18 @@ func (mesh *PolyMesh) PreRender() error {
 		}
 	}
 
+	mesh.opacity = nil
+
+	for i, shader := range mesh.shader {
+		if op, ok := shader.(core.OpacityShader); ok && op.HasOpacity() {
+			if mesh.opacity == nil {
+				mesh.opacity = make([]core.OpacityShader, len(mesh.shader))
+			}
+
+			mesh.opacity[i] = op
+		}
+	}
+
 	return mesh.initAccel()
 }
 
@@ -112,7 +149,7 @@ func (mesh *PolyMesh) MotionKeys() int {
 }
 
 func create() (core.Node, error) {
-	mfile := PolyMesh{IsVisible: true}
+	mfile := PolyMesh{IsVisible: true, DisplacementScale: 1}
 
 	return &mfile, nil
 }
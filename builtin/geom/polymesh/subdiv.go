@@ -0,0 +1,538 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package polymesh
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// Subdivision schemes.
+const (
+	SubdivNone         = ""
+	SubdivCatmullClark = "CatmullClark"
+	SubdivLoop         = "Loop"
+	SubdivLinear       = "Linear" // Catmull-Clark topology with no smoothing, useful before displacement
+)
+
+type edgeKey [2]int32
+
+func makeEdgeKey(a, b int32) edgeKey {
+	if a > b {
+		return edgeKey{b, a}
+	}
+	return edgeKey{a, b}
+}
+
+type subdivEdge struct {
+	v     [2]int32
+	faces []int32
+	opp   []int32 // Loop only: vertex opposite the edge in each face
+}
+
+// subdivMesh is the polygon mesh being refined.  Positions are kept per motion key, UVs are
+// face-varying (one per face corner) and are interpolated linearly.
+type subdivMesh struct {
+	keys      [][]m.Vec3
+	polyCount []int32
+	faceIdx   []int32
+	faceStart []int32
+	uv        []m.Vec2
+	shaderIdx []int32
+	crease    map[edgeKey]float32
+
+	edges     []subdivEdge
+	edgeIdx   map[edgeKey]int32
+	vertEdges [][]int32
+	vertFaces [][]int32
+}
+
+// initSubdiv refines the polygon mesh SubdivLevel times with the selected scheme and replaces
+// the mesh arrays with the result.  Must be called before init triangulates the mesh.
+func (mesh *PolyMesh) initSubdiv() error {
+	if mesh.Subdiv == SubdivNone || mesh.SubdivLevel <= 0 {
+		return nil
+	}
+
+	switch mesh.Subdiv {
+	case SubdivCatmullClark, SubdivLoop, SubdivLinear:
+	default:
+		return fmt.Errorf("PolyMesh %v: unknown subdivision scheme \"%v\"", mesh.NodeName, mesh.Subdiv)
+	}
+
+	if len(mesh.CreaseIdx) != 2*len(mesh.CreaseSharpness.Elems) {
+		return fmt.Errorf("PolyMesh %v: CreaseIdx should have two vertices for each CreaseSharpness", mesh.NodeName)
+	}
+
+	s := mesh.newSubdivMesh()
+
+	if mesh.Subdiv == SubdivLoop {
+		s.triangulate()
+	}
+
+	for l := 0; l < mesh.SubdivLevel; l++ {
+		s.initTopology()
+
+		switch mesh.Subdiv {
+		case SubdivCatmullClark:
+			s = s.catmullClark(false)
+		case SubdivLinear:
+			s = s.catmullClark(true)
+		case SubdivLoop:
+			s = s.loop()
+		}
+	}
+
+	mesh.fromSubdivMesh(s)
+
+	return nil
+}
+
+// newSubdivMesh converts the mesh arrays into a subdivMesh, filling in implied index arrays.
+func (mesh *PolyMesh) newSubdivMesh() *subdivMesh {
+	s := &subdivMesh{crease: map[edgeKey]float32{}}
+
+	keys := mesh.Verts.MotionKeys
+
+	if keys < 1 {
+		keys = 1
+	}
+
+	nverts := len(mesh.Verts.Elems) / keys
+
+	for k := 0; k < keys; k++ {
+		s.keys = append(s.keys, mesh.Verts.Elems[k*nverts:(k+1)*nverts])
+	}
+
+	s.faceIdx = mesh.FaceIdx
+
+	if s.faceIdx == nil {
+		for i := 0; i < nverts; i++ {
+			s.faceIdx = append(s.faceIdx, int32(i))
+		}
+	}
+
+	s.polyCount = mesh.PolyCount
+
+	if s.polyCount == nil {
+		for i := 0; i < len(s.faceIdx)/3; i++ {
+			s.polyCount = append(s.polyCount, 3)
+		}
+	}
+
+	if mesh.UV.Elems != nil {
+		for i, v := range s.faceIdx {
+			if mesh.UVIdx != nil {
+				s.uv = append(s.uv, mesh.UV.Elems[mesh.UVIdx[i]])
+			} else {
+				s.uv = append(s.uv, mesh.UV.Elems[v])
+			}
+		}
+	}
+
+	if mesh.ShaderIdx != nil {
+		s.shaderIdx = mesh.ShaderIdx
+	}
+
+	for i, sharpness := range mesh.CreaseSharpness.Elems {
+		s.crease[makeEdgeKey(mesh.CreaseIdx[i*2], mesh.CreaseIdx[i*2+1])] = sharpness
+	}
+
+	return s
+}
+
+// fromSubdivMesh replaces the mesh arrays with the refined mesh.  Normals are discarded as
+// they no longer match, smooth normals are calculated later.
+func (mesh *PolyMesh) fromSubdivMesh(s *subdivMesh) {
+	mesh.Verts.Elems = nil
+
+	for _, key := range s.keys {
+		mesh.Verts.Elems = append(mesh.Verts.Elems, key...)
+	}
+
+	mesh.Verts.ElemsPerKey = len(s.keys[0])
+
+	mesh.PolyCount = s.polyCount
+	mesh.FaceIdx = s.faceIdx
+	mesh.ShaderIdx = s.shaderIdx
+
+	if s.uv != nil {
+		mesh.UV.Elems = s.uv
+		mesh.UV.ElemsPerKey = len(s.uv)
+		mesh.UV.MotionKeys = 1
+		mesh.UVIdx = make([]int32, len(s.uv))
+
+		for i := range mesh.UVIdx {
+			mesh.UVIdx[i] = int32(i)
+		}
+	}
+
+	mesh.Normals = param.Vec3Array{}
+	mesh.NormalIdx = nil
+	mesh.CalcNormals = true
+}
+
+// triangulate fan triangulates any non-triangle faces (Loop only works on triangles).
+func (s *subdivMesh) triangulate() {
+	var polyCount, faceIdx, shaderIdx []int32
+	var uv []m.Vec2
+
+	base := 0
+
+	for f, n := range s.polyCount {
+		for j := 1; j < int(n)-1; j++ {
+			polyCount = append(polyCount, 3)
+			faceIdx = append(faceIdx, s.faceIdx[base], s.faceIdx[base+j], s.faceIdx[base+j+1])
+
+			if s.uv != nil {
+				uv = append(uv, s.uv[base], s.uv[base+j], s.uv[base+j+1])
+			}
+
+			if s.shaderIdx != nil {
+				shaderIdx = append(shaderIdx, s.shaderIdx[f])
+			}
+		}
+		base += int(n)
+	}
+
+	s.polyCount, s.faceIdx, s.uv = polyCount, faceIdx, uv
+
+	if s.shaderIdx != nil {
+		s.shaderIdx = shaderIdx
+	}
+}
+
+// initTopology builds the edge and vertex adjacency for the current level.
+func (s *subdivMesh) initTopology() {
+	nverts := len(s.keys[0])
+
+	s.edges = nil
+	s.edgeIdx = map[edgeKey]int32{}
+	s.vertEdges = make([][]int32, nverts)
+	s.vertFaces = make([][]int32, nverts)
+	s.faceStart = make([]int32, len(s.polyCount))
+
+	base := int32(0)
+
+	for f, n := range s.polyCount {
+		s.faceStart[f] = base
+
+		for i := int32(0); i < n; i++ {
+			v0 := s.faceIdx[base+i]
+			v1 := s.faceIdx[base+(i+1)%n]
+
+			s.vertFaces[v0] = append(s.vertFaces[v0], int32(f))
+
+			key := makeEdgeKey(v0, v1)
+			e, ok := s.edgeIdx[key]
+
+			if !ok {
+				e = int32(len(s.edges))
+				s.edgeIdx[key] = e
+				s.edges = append(s.edges, subdivEdge{v: key})
+				s.vertEdges[key[0]] = append(s.vertEdges[key[0]], e)
+				s.vertEdges[key[1]] = append(s.vertEdges[key[1]], e)
+			}
+
+			s.edges[e].faces = append(s.edges[e].faces, int32(f))
+
+			if n == 3 {
+				s.edges[e].opp = append(s.edges[e].opp, s.faceIdx[base+(i+2)%n])
+			}
+		}
+
+		base += n
+	}
+}
+
+func (s *subdivMesh) edge(a, b int32) int32 { return s.edgeIdx[makeEdgeKey(a, b)] }
+
+// sharpness returns the edge sharpness, boundary (and non-manifold) edges are infinitely sharp.
+func (s *subdivMesh) sharpness(e int32) float32 {
+	if len(s.edges[e].faces) != 2 {
+		return m.Inf(1)
+	}
+
+	return s.crease[s.edges[e].v]
+}
+
+func (s *subdivMesh) other(e, v int32) int32 {
+	if s.edges[e].v[0] == v {
+		return s.edges[e].v[1]
+	}
+	return s.edges[e].v[0]
+}
+
+// edgePoint applies the crease rule to the smooth edge point, semi-sharp edges blend between
+// the two.
+func (s *subdivMesh) edgePoint(P []m.Vec3, e int32, smooth m.Vec3) m.Vec3 {
+	sharpness := s.sharpness(e)
+
+	if sharpness <= 0 {
+		return smooth
+	}
+
+	mid := m.Vec3Scale(0.5, m.Vec3Add(P[s.edges[e].v[0]], P[s.edges[e].v[1]]))
+
+	if sharpness >= 1 {
+		return mid
+	}
+
+	return m.Vec3Lerp(smooth, mid, sharpness)
+}
+
+// vertexPoint applies the crease and corner rules to the smooth vertex point.
+func (s *subdivMesh) vertexPoint(P []m.Vec3, v int32, smooth m.Vec3) m.Vec3 {
+	var sharp []int32
+	var sum float32
+
+	for _, e := range s.vertEdges[v] {
+		if sharpness := s.sharpness(e); sharpness > 0 {
+			sharp = append(sharp, e)
+			sum += m.Min(sharpness, 1)
+		}
+	}
+
+	if len(sharp) < 2 {
+		return smooth
+	}
+
+	var rule m.Vec3
+
+	if len(sharp) == 2 {
+		a := P[s.other(sharp[0], v)]
+		b := P[s.other(sharp[1], v)]
+
+		rule = m.Vec3Scale(1.0/8, m.Vec3Add3(a, m.Vec3Scale(6, P[v]), b))
+	} else {
+		rule = P[v]
+	}
+
+	sharpness := sum / float32(len(sharp))
+
+	if sharpness >= 1 {
+		return rule
+	}
+
+	return m.Vec3Lerp(smooth, rule, sharpness)
+}
+
+// childCreases returns the crease map for the next level, each half of a crease edge is one
+// less sharp.  newEdgeVert gives the new vertex index of the edge point.
+func (s *subdivMesh) childCreases(newEdgeVert func(e int32) int32) map[edgeKey]float32 {
+	crease := map[edgeKey]float32{}
+
+	for key, sharpness := range s.crease {
+		if sharpness <= 1 {
+			continue
+		}
+
+		e, ok := s.edgeIdx[key]
+
+		if !ok {
+			continue
+		}
+
+		ev := newEdgeVert(e)
+		crease[makeEdgeKey(key[0], ev)] = sharpness - 1
+		crease[makeEdgeKey(ev, key[1])] = sharpness - 1
+	}
+
+	return crease
+}
+
+// catmullClark returns the mesh refined once.  New vertices are ordered vertex points, edge
+// points then face points.  If linear is true the positions are not smoothed.
+func (s *subdivMesh) catmullClark(linear bool) *subdivMesh {
+	nverts := int32(len(s.keys[0]))
+	nedges := int32(len(s.edges))
+
+	out := &subdivMesh{}
+
+	for _, P := range s.keys {
+		Q := make([]m.Vec3, int(nverts+nedges)+len(s.polyCount))
+
+		// Face points
+		fp := Q[nverts+nedges:]
+
+		for f, n := range s.polyCount {
+			for i := int32(0); i < n; i++ {
+				fp[f] = m.Vec3Add(fp[f], P[s.faceIdx[s.faceStart[f]+i]])
+			}
+			fp[f] = m.Vec3Scale(1/float32(n), fp[f])
+		}
+
+		// Edge points
+		for e := range s.edges {
+			edge := &s.edges[e]
+			mid := m.Vec3Scale(0.5, m.Vec3Add(P[edge.v[0]], P[edge.v[1]]))
+
+			if linear || len(edge.faces) != 2 {
+				Q[nverts+int32(e)] = mid
+				continue
+			}
+
+			smooth := m.Vec3Scale(0.5, m.Vec3Add(mid, m.Vec3Scale(0.5, m.Vec3Add(fp[edge.faces[0]], fp[edge.faces[1]]))))
+			Q[nverts+int32(e)] = s.edgePoint(P, int32(e), smooth)
+		}
+
+		// Vertex points
+		for v := int32(0); v < nverts; v++ {
+			if linear {
+				Q[v] = P[v]
+				continue
+			}
+
+			n := float32(len(s.vertEdges[v]))
+			smooth := P[v]
+
+			if n > 0 && len(s.vertFaces[v]) == len(s.vertEdges[v]) {
+				var F, R m.Vec3
+
+				for _, f := range s.vertFaces[v] {
+					F = m.Vec3Add(F, fp[f])
+				}
+
+				for _, e := range s.vertEdges[v] {
+					R = m.Vec3Add(R, m.Vec3Scale(0.5, m.Vec3Add(P[s.edges[e].v[0]], P[s.edges[e].v[1]])))
+				}
+
+				F = m.Vec3Scale(1/n, F)
+				R = m.Vec3Scale(1/n, R)
+
+				smooth = m.Vec3Scale(1/n, m.Vec3Add3(F, m.Vec3Scale(2, R), m.Vec3Scale(n-3, P[v])))
+			}
+
+			Q[v] = s.vertexPoint(P, v, smooth)
+		}
+
+		out.keys = append(out.keys, Q)
+	}
+
+	// Topology: each n-gon becomes n quads.
+	for f, n := range s.polyCount {
+		base := s.faceStart[f]
+		facePoint := nverts + nedges + int32(f)
+
+		var centre m.Vec2
+
+		if s.uv != nil {
+			for i := int32(0); i < n; i++ {
+				centre = m.Vec2Add(centre, s.uv[base+i])
+			}
+			centre = m.Vec2Scale(1/float32(n), centre)
+		}
+
+		for i := int32(0); i < n; i++ {
+			prev := (i + n - 1) % n
+			next := (i + 1) % n
+
+			v := s.faceIdx[base+i]
+			vPrev := s.faceIdx[base+prev]
+			vNext := s.faceIdx[base+next]
+
+			out.polyCount = append(out.polyCount, 4)
+			out.faceIdx = append(out.faceIdx, v, nverts+s.edge(v, vNext), facePoint, nverts+s.edge(vPrev, v))
+
+			if s.uv != nil {
+				out.uv = append(out.uv, s.uv[base+i],
+					m.Vec2Scale(0.5, m.Vec2Add(s.uv[base+i], s.uv[base+next])),
+					centre,
+					m.Vec2Scale(0.5, m.Vec2Add(s.uv[base+prev], s.uv[base+i])))
+			}
+
+			if s.shaderIdx != nil {
+				out.shaderIdx = append(out.shaderIdx, s.shaderIdx[f])
+			}
+		}
+	}
+
+	out.crease = s.childCreases(func(e int32) int32 { return nverts + e })
+
+	return out
+}
+
+// loop returns the (triangle) mesh refined once.  New vertices are ordered vertex points then
+// edge points.
+func (s *subdivMesh) loop() *subdivMesh {
+	nverts := int32(len(s.keys[0]))
+	nedges := int32(len(s.edges))
+
+	out := &subdivMesh{}
+
+	for _, P := range s.keys {
+		Q := make([]m.Vec3, nverts+nedges)
+
+		// Edge points
+		for e := range s.edges {
+			edge := &s.edges[e]
+
+			if len(edge.faces) != 2 {
+				Q[nverts+int32(e)] = m.Vec3Scale(0.5, m.Vec3Add(P[edge.v[0]], P[edge.v[1]]))
+				continue
+			}
+
+			smooth := m.Vec3Add(m.Vec3Scale(3.0/8, m.Vec3Add(P[edge.v[0]], P[edge.v[1]])),
+				m.Vec3Scale(1.0/8, m.Vec3Add(P[edge.opp[0]], P[edge.opp[1]])))
+
+			Q[nverts+int32(e)] = s.edgePoint(P, int32(e), smooth)
+		}
+
+		// Vertex points
+		for v := int32(0); v < nverts; v++ {
+			n := len(s.vertEdges[v])
+			smooth := P[v]
+
+			if n > 0 && len(s.vertFaces[v]) == n {
+				beta := float32(3.0 / 16)
+
+				if n > 3 {
+					beta = 3 / (8 * float32(n))
+				}
+
+				smooth = m.Vec3Scale(1-float32(n)*beta, P[v])
+
+				for _, e := range s.vertEdges[v] {
+					smooth = m.Vec3Add(smooth, m.Vec3Scale(beta, P[s.other(e, v)]))
+				}
+			}
+
+			Q[v] = s.vertexPoint(P, v, smooth)
+		}
+
+		out.keys = append(out.keys, Q)
+	}
+
+	// Topology: each triangle becomes 4.
+	for f := range s.polyCount {
+		base := s.faceStart[f]
+
+		v0, v1, v2 := s.faceIdx[base], s.faceIdx[base+1], s.faceIdx[base+2]
+		e0 := nverts + s.edge(v0, v1)
+		e1 := nverts + s.edge(v1, v2)
+		e2 := nverts + s.edge(v2, v0)
+
+		out.polyCount = append(out.polyCount, 3, 3, 3, 3)
+		out.faceIdx = append(out.faceIdx, v0, e0, e2, v1, e1, e0, v2, e2, e1, e0, e1, e2)
+
+		if s.uv != nil {
+			u0, u1, u2 := s.uv[base], s.uv[base+1], s.uv[base+2]
+			m0 := m.Vec2Scale(0.5, m.Vec2Add(u0, u1))
+			m1 := m.Vec2Scale(0.5, m.Vec2Add(u1, u2))
+			m2 := m.Vec2Scale(0.5, m.Vec2Add(u2, u0))
+
+			out.uv = append(out.uv, u0, m0, m2, u1, m1, m0, u2, m2, m1, m0, m1, m2)
+		}
+
+		if s.shaderIdx != nil {
+			out.shaderIdx = append(out.shaderIdx, s.shaderIdx[f], s.shaderIdx[f], s.shaderIdx[f], s.shaderIdx[f])
+		}
+	}
+
+	out.crease = s.childCreases(func(e int32) int32 { return nverts + e })
+
+	return out
+}
@@ -0,0 +1,216 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package polymesh
+
+import (
+	m "github.com/jamiec7919/vermeer/math"
+	"testing"
+)
+
+// newCube returns the cube [-1,1]^3 as six quads, vertex i has x,y,z = +1 for bits 0,1,2 of i.
+func newCube() *PolyMesh {
+	mesh := &PolyMesh{
+		NodeName:  "cube",
+		PolyCount: []int32{4, 4, 4, 4, 4, 4},
+		FaceIdx: []int32{
+			0, 2, 3, 1, // -z
+			4, 5, 7, 6, // +z
+			0, 1, 5, 4, // -y
+			2, 6, 7, 3, // +y
+			0, 4, 6, 2, // -x
+			1, 3, 7, 5, // +x
+		},
+	}
+
+	for i := 0; i < 8; i++ {
+		P := m.Vec3{-1, -1, -1}
+
+		for k := range P {
+			if i&(1<<uint(k)) != 0 {
+				P[k] = 1
+			}
+		}
+
+		mesh.Verts.Elems = append(mesh.Verts.Elems, P)
+	}
+
+	mesh.Verts.ElemsPerKey = 8
+	mesh.Verts.MotionKeys = 1
+
+	return mesh
+}
+
+// cubeEdges returns the 12 edges of newCube as CreaseIdx pairs.
+func cubeEdges() (idx []int32) {
+	for i := int32(0); i < 8; i++ {
+		for k := uint(0); k < 3; k++ {
+			if j := i | 1<<k; j != i {
+				idx = append(idx, i, j)
+			}
+		}
+	}
+
+	return
+}
+
+func TestSubdivCounts(t *testing.T) {
+	var tests = []struct {
+		scheme       string
+		level        int
+		verts, faces int
+		sides        int32
+	}{
+		{SubdivCatmullClark, 1, 26, 24, 4},
+		{SubdivCatmullClark, 2, 98, 96, 4},
+		{SubdivCatmullClark, 3, 386, 384, 4},
+		{SubdivLinear, 2, 98, 96, 4},
+		{SubdivLoop, 1, 26, 48, 3},
+		{SubdivLoop, 2, 98, 192, 3},
+	}
+
+	for _, test := range tests {
+		mesh := newCube()
+		mesh.Subdiv = test.scheme
+		mesh.SubdivLevel = test.level
+
+		if err := mesh.initSubdiv(); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(mesh.Verts.Elems) != test.verts || mesh.Verts.ElemsPerKey != test.verts {
+			t.Errorf("%v level %v: %v verts, expected %v", test.scheme, test.level, len(mesh.Verts.Elems), test.verts)
+		}
+
+		if len(mesh.PolyCount) != test.faces {
+			t.Errorf("%v level %v: %v faces, expected %v", test.scheme, test.level, len(mesh.PolyCount), test.faces)
+		}
+
+		for _, n := range mesh.PolyCount {
+			if n != test.sides {
+				t.Errorf("%v level %v: %v sided face, expected %v", test.scheme, test.level, n, test.sides)
+				break
+			}
+		}
+
+		if len(mesh.FaceIdx) != test.faces*int(test.sides) {
+			t.Errorf("%v level %v: %v face indexes, expected %v", test.scheme, test.level, len(mesh.FaceIdx), test.faces*int(test.sides))
+		}
+	}
+}
+
+// onCube returns true if P is on the surface of the cube [-1,1]^3.
+func onCube(P m.Vec3) bool {
+	max := m.Max(m.Abs(P[0]), m.Max(m.Abs(P[1]), m.Abs(P[2])))
+	return m.Abs(max-1) < 1e-5
+}
+
+func TestSubdivCubeLimit(t *testing.T) {
+	// Smooth: the limit of a cube corner (valence 3) is (±0.5,±0.5,±0.5), vertex points keep
+	// their index.
+	mesh := newCube()
+	mesh.Subdiv = SubdivCatmullClark
+	mesh.SubdivLevel = 6
+
+	if err := mesh.initSubdiv(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, P := range newCube().Verts.Elems {
+		expected := m.Vec3Scale(0.5, P)
+
+		if m.Vec3Length(m.Vec3Sub(mesh.Verts.Elems[i], expected)) > 1e-3 {
+			t.Errorf("corner %v = %v, expected %v", i, mesh.Verts.Elems[i], expected)
+		}
+	}
+
+	// Infinitely sharp creases on all edges keep the cube.
+	for _, scheme := range []string{SubdivCatmullClark, SubdivLoop, SubdivLinear} {
+		mesh := newCube()
+		mesh.Subdiv = scheme
+		mesh.SubdivLevel = 3
+
+		if scheme != SubdivLinear {
+			mesh.CreaseIdx = cubeEdges()
+			mesh.CreaseSharpness.Elems = make([]float32, 12)
+
+			for i := range mesh.CreaseSharpness.Elems {
+				mesh.CreaseSharpness.Elems[i] = m.Inf(1)
+			}
+		}
+
+		if err := mesh.initSubdiv(); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, P := range newCube().Verts.Elems {
+			if mesh.Verts.Elems[i] != P {
+				t.Errorf("%v: corner %v = %v, expected %v", scheme, i, mesh.Verts.Elems[i], P)
+			}
+		}
+
+		for i, P := range mesh.Verts.Elems {
+			if !onCube(P) {
+				t.Errorf("%v: vertex %v = %v is not on the cube", scheme, i, P)
+				break
+			}
+		}
+	}
+}
+
+func TestSubdivCreaseSharpness(t *testing.T) {
+	for _, scheme := range []string{SubdivCatmullClark, SubdivLoop} {
+		mesh := newCube()
+		mesh.CreaseIdx = []int32{0, 1}
+		mesh.CreaseSharpness.Elems = []float32{2.5}
+
+		s := mesh.newSubdivMesh()
+
+		if scheme == SubdivLoop {
+			s.triangulate()
+		}
+
+		// Each level halves the crease edges and takes one off the sharpness, the edge is
+		// dropped once it is smooth.
+		for level, expected := range []struct {
+			edges     int
+			sharpness float32
+		}{{2, 1.5}, {4, 0.5}, {0, 0}} {
+			s.initTopology()
+
+			if scheme == SubdivLoop {
+				s = s.loop()
+			} else {
+				s = s.catmullClark(false)
+			}
+
+			if len(s.crease) != expected.edges {
+				t.Errorf("%v level %v: %v crease edges, expected %v", scheme, level+1, len(s.crease), expected.edges)
+			}
+
+			for key, sharpness := range s.crease {
+				if sharpness != expected.sharpness {
+					t.Errorf("%v level %v: edge %v sharpness %v, expected %v", scheme, level+1, key, sharpness, expected.sharpness)
+				}
+			}
+		}
+
+		// The crease pulls vertices 0 and 1 further out than without it.
+		mesh.fromSubdivMesh(s)
+
+		smooth := newCube()
+		smooth.Subdiv = scheme
+		smooth.SubdivLevel = 3
+
+		if err := smooth.initSubdiv(); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if m.Vec3Length(mesh.Verts.Elems[i]) <= m.Vec3Length(smooth.Verts.Elems[i]) {
+				t.Errorf("%v: creased corner %v = %v, smooth %v", scheme, i, mesh.Verts.Elems[i], smooth.Verts.Elems[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package polymesh
+
+import (
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// tangentKey identifies a tangent space vertex.  As in MikkTSpace corners are only welded if
+// they share position, normal and UV and the UV mapping has the same orientation.
+type tangentKey struct {
+	p, n, uv int64
+	flip     bool
+}
+
+// initTangents generates per-vertex tangents from the UVs, following the MikkTSpace approach
+// so that normal maps baked by other tools are reproduced: face tangents are projected into
+// the plane of each vertex normal and accumulated weighted by the corner angle.  The
+// bitangent is reconstructed as sign * N x T at shading time.
+//
+// Must be called after triangulation (idxp, uvtriidx, normalidx) and before initAccel.
+func (mesh *PolyMesh) initTangents() {
+	if mesh.UV.Elems == nil || mesh.uvtriidx == nil {
+		return
+	}
+
+	ntris := len(mesh.idxp) / 3
+
+	keys := map[tangentKey]uint32{}
+	var sum []m.Vec3
+
+	mesh.tangentidx = make([]uint32, len(mesh.idxp))
+
+	for t := 0; t < ntris; t++ {
+		var P [3]m.Vec3
+		var UV [3]m.Vec2
+
+		for k := range P {
+			P[k] = mesh.Verts.Elems[mesh.idxp[t*3+k]]
+			UV[k] = mesh.UV.Elems[mesh.uvtriidx[t*3+k]]
+		}
+
+		e1 := m.Vec3Sub(P[1], P[0])
+		e2 := m.Vec3Sub(P[2], P[0])
+		s1, t1 := UV[1][0]-UV[0][0], UV[1][1]-UV[0][1]
+		s2, t2 := UV[2][0]-UV[0][0], UV[2][1]-UV[0][1]
+
+		signedAreaSTx2 := s1*t2 - s2*t1
+		flip := signedAreaSTx2 < 0
+
+		// Unnormalized tangent, scaled by |signedAreaSTx2| so degenerate UVs contribute nothing.
+		Os := m.Vec3Sub(m.Vec3Scale(t2, e1), m.Vec3Scale(t1, e2))
+
+		if flip {
+			Os = m.Vec3Neg(Os)
+		}
+
+		Ng := m.Vec3Normalize(m.Vec3Cross(e1, e2))
+
+		for k := 0; k < 3; k++ {
+			key := tangentKey{p: int64(mesh.idxp[t*3+k]), uv: int64(mesh.uvtriidx[t*3+k]), flip: flip}
+
+			N := Ng
+
+			if mesh.normalidx != nil {
+				key.n = int64(mesh.normalidx[t*3+k])
+				N = m.Vec3Normalize(mesh.Normals.Elems[mesh.normalidx[t*3+k]])
+			} else {
+				// Faceted, don't share tangents between faces.
+				key.n = -1 - int64(t)
+			}
+
+			idx, ok := keys[key]
+
+			if !ok {
+				idx = uint32(len(sum))
+				keys[key] = idx
+				sum = append(sum, m.Vec3{})
+			}
+
+			mesh.tangentidx[t*3+k] = idx
+
+			// Corner angle measured in the tangent plane of the vertex normal.
+			a := m.Vec3Sub(P[(k+1)%3], P[k])
+			b := m.Vec3Sub(P[(k+2)%3], P[k])
+			a = m.Vec3Sub(a, m.Vec3Scale(m.Vec3Dot(N, a), N))
+			b = m.Vec3Sub(b, m.Vec3Scale(m.Vec3Dot(N, b), N))
+
+			if m.Vec3Length2(a) == 0 || m.Vec3Length2(b) == 0 {
+				continue
+			}
+
+			angle := m.Acos(m.Clamp(m.Vec3Dot(m.Vec3Normalize(a), m.Vec3Normalize(b)), -1, 1))
+
+			T := m.Vec3Sub(Os, m.Vec3Scale(m.Vec3Dot(N, Os), N))
+
+			if m.Vec3Length2(T) == 0 {
+				continue
+			}
+
+			sum[idx] = m.Vec3Add(sum[idx], m.Vec3Scale(angle, m.Vec3Normalize(T)))
+		}
+	}
+
+	mesh.tangents = make([]m.Vec3, len(sum))
+	mesh.tangentSign = make([]float32, len(sum))
+
+	for key, idx := range keys {
+		T := sum[idx]
+
+		if m.Vec3Length2(T) == 0 {
+			// No usable UVs around this vertex, any tangent will do.
+			N := mesh.tangentNormal(key)
+
+			T = m.Vec3Cross(N, m.Vec3{1, 0, 0})
+
+			if m.Vec3Length2(T) < 0.1 {
+				T = m.Vec3Cross(N, m.Vec3{0, 1, 0})
+			}
+		}
+
+		mesh.tangents[idx] = m.Vec3Normalize(T)
+		mesh.tangentSign[idx] = 1
+
+		if key.flip {
+			mesh.tangentSign[idx] = -1
+		}
+	}
+}
+
+// tangentNormal returns the normal for a tangent space vertex.
+func (mesh *PolyMesh) tangentNormal(key tangentKey) m.Vec3 {
+	if key.n >= 0 {
+		return m.Vec3Normalize(mesh.Normals.Elems[key.n])
+	}
+
+	t := -1 - key.n
+
+	e1 := m.Vec3Sub(mesh.Verts.Elems[mesh.idxp[t*3+1]], mesh.Verts.Elems[mesh.idxp[t*3+0]])
+	e2 := m.Vec3Sub(mesh.Verts.Elems[mesh.idxp[t*3+2]], mesh.Verts.Elems[mesh.idxp[t*3+0]])
+
+	return m.Vec3Normalize(m.Vec3Cross(e1, e2))
+}
+
+// interpTangent returns the interpolated tangent and bitangent for face idx.  Neither is
+// normalized, per-pixel MikkTSpace uses the unnormalized interpolated vectors.
+func (mesh *PolyMesh) interpTangent(idx int32, U, V, W float32, N m.Vec3) (T, B m.Vec3) {
+	t0 := mesh.tangentidx[idx*3+0]
+	t1 := mesh.tangentidx[idx*3+1]
+	t2 := mesh.tangentidx[idx*3+2]
+
+	for k := range T {
+		T[k] = U*mesh.tangents[t0][k] + V*mesh.tangents[t1][k] + W*mesh.tangents[t2][k]
+	}
+
+	B = m.Vec3Scale(mesh.tangentSign[t0], m.Vec3Cross(N, T))
+
+	return
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package polymesh
+
+import (
+	m "github.com/jamiec7919/vermeer/math"
+	"testing"
+)
+
+func TestTangentsFlatQuad(t *testing.T) {
+	var tests = []struct {
+		name string
+		uv   []m.Vec2
+		T, B m.Vec3
+	}{
+		{"uv", []m.Vec2{{0, 0}, {1, 0}, {1, 1}, {0, 1}}, m.Vec3{1, 0, 0}, m.Vec3{0, 1, 0}},
+		// U runs along -x so T flips, B still follows V.
+		{"mirrored", []m.Vec2{{1, 0}, {0, 0}, {0, 1}, {1, 1}}, m.Vec3{-1, 0, 0}, m.Vec3{0, 1, 0}},
+		// u = -y/2, v = x/2.
+		{"rotated", []m.Vec2{{0, 0}, {0, 1}, {-1, 1}, {-1, 0}}, m.Vec3{0, -1, 0}, m.Vec3{1, 0, 0}},
+	}
+
+	for _, test := range tests {
+		// Quad in the xy plane facing +z.
+		mesh := &PolyMesh{
+			PolyCount: []int32{4},
+			FaceIdx:   []int32{0, 1, 2, 3},
+		}
+		mesh.Verts.Elems = []m.Vec3{{0, 0, 0}, {2, 0, 0}, {2, 2, 0}, {0, 2, 0}}
+		mesh.Verts.ElemsPerKey = 4
+		mesh.Verts.MotionKeys = 1
+		mesh.UV.Elems = test.uv
+		mesh.UV.ElemsPerKey = 4
+		mesh.UV.MotionKeys = 1
+
+		if err := mesh.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		N := m.Vec3{0, 0, 1}
+
+		for idx := int32(0); idx < int32(len(mesh.idxp)/3); idx++ {
+			for _, b := range [][3]float32{{1, 0, 0}, {0.2, 0.3, 0.5}, {0, 0, 1}} {
+				T, B := mesh.interpTangent(idx, b[0], b[1], b[2], N)
+
+				if m.Vec3Length(m.Vec3Sub(T, test.T)) > 1e-5 || m.Vec3Length(m.Vec3Sub(B, test.B)) > 1e-5 {
+					t.Errorf("%v: face %v at %v: T %v, B %v, expected %v, %v", test.name, idx, b, T, B, test.T, test.B)
+				}
+			}
+		}
+	}
+}
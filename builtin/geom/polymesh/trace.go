@@ -18,6 +18,7 @@ func (mesh *PolyMesh) Trace(ray *core.Ray, sg *core.ShaderContext) bool {
 	var S [3]float32
 	var Kx, Ky, Kz int32
 	var transform, invTransform m.Matrix4
+	var prevTransform, prevInvTransform m.Matrix4
 
 	if mesh.Transform.Elems != nil {
 		Rp = ray.P
@@ -36,7 +37,7 @@ func (mesh *PolyMesh) Trace(ray *core.Ray, sg *core.ShaderContext) bool {
 			key := int(m.Floor(k))
 			key2 := int(m.Ceil(k))
 
-			if key > len(mesh.Transform.Elems)-1 || key > len(mesh.Transform.Elems)-1 {
+			if key > len(mesh.Transform.Elems)-1 || key2 > len(mesh.Transform.Elems)-1 {
 				panic(fmt.Sprintf("%v %v %v", ray.Time, key, key2))
 			}
 			//fmt.Printf("%v %v %v %v %v %v %v", ray.Time, len(mesh.Transform.Elems), time, key, key2, len(mesh.transformSRT), mesh.transformSRT)
@@ -52,6 +53,10 @@ func (mesh *PolyMesh) Trace(ray *core.Ray, sg *core.ShaderContext) bool {
 		ray.P = m.Matrix4MulPoint(invTransform, Rp)
 		ray.D = m.Matrix4MulVec(invTransform, Rd)
 		ray.Setup()
+
+		// anyHit needs the transform during traversal, restored below if nothing is hit.
+		prevTransform, prevInvTransform = sg.Transform, sg.InvTransform
+		sg.Transform, sg.InvTransform = transform, invTransform
 	}
 
 	if mesh.accel.qbvh != nil {
@@ -67,8 +72,12 @@ func (mesh *PolyMesh) Trace(ray *core.Ray, sg *core.ShaderContext) bool {
 			ray.Kx = Kx
 			ray.Ky = Ky
 			ray.Kz = Kz
-			sg.Transform = transform
-			sg.InvTransform = invTransform
+
+			if !hit {
+				sg.Transform, sg.InvTransform = prevTransform, prevInvTransform
+				return false
+			}
+
 			sg.P = m.Matrix4MulPoint(transform, sg.Po)
 			sg.N = m.Matrix4MulVec(m.Matrix4Transpose(invTransform), sg.N)
 		}
@@ -93,8 +102,12 @@ func (mesh *PolyMesh) Trace(ray *core.Ray, sg *core.ShaderContext) bool {
 		ray.Kx = Kx
 		ray.Ky = Ky
 		ray.Kz = Kz
-		sg.Transform = transform
-		sg.InvTransform = invTransform
+
+		if !hit {
+			sg.Transform, sg.InvTransform = prevTransform, prevInvTransform
+			return false
+		}
+
 		sg.P = m.Matrix4MulPoint(transform, sg.Po)
 		sg.N = m.Matrix4MulVec(m.Matrix4Transpose(invTransform), sg.N)
 	}
@@ -103,6 +116,61 @@ func (mesh *PolyMesh) Trace(ray *core.Ray, sg *core.ShaderContext) bool {
 
 func sqr(x float32) float32 { return x * x }
 
+// anyHit returns true if the candidate hit on face idx with vertices V0,V1,V2, barycentrics
+// U,V,W and distance T should be accepted, see core.AnyHit.  key, key2 and time select the
+// normals of motion meshes.
+func (mesh *PolyMesh) anyHit(ray *core.Ray, sg *core.ShaderContext, idx int32, V0, V1, V2 m.Vec3, key, key2 int, time, U, V, W, T float32) bool {
+	shaderIdx := uint8(0)
+
+	if mesh.shaderidx != nil {
+		shaderIdx = mesh.shaderidx[idx]
+	}
+
+	shader := mesh.opacity[shaderIdx]
+
+	if shader == nil {
+		return true
+	}
+
+	hit := core.Candidate{ElemID: uint32(idx), U: U, V: V, T: T}
+
+	if mesh.UV.Elems != nil {
+		hit.U = U*mesh.UV.Elems[mesh.uvtriidx[(idx*3)+0]][0] + V*mesh.UV.Elems[mesh.uvtriidx[(idx*3)+1]][0] + W*mesh.UV.Elems[mesh.uvtriidx[(idx*3)+2]][0]
+		hit.V = U*mesh.UV.Elems[mesh.uvtriidx[(idx*3)+0]][1] + V*mesh.UV.Elems[mesh.uvtriidx[(idx*3)+1]][1] + W*mesh.UV.Elems[mesh.uvtriidx[(idx*3)+2]][1]
+	}
+
+	for k := range hit.Po {
+		hit.Po[k] = U*V0[k] + V*V1[k] + W*V2[k]
+	}
+
+	hit.Ng = m.Vec3Normalize(m.Vec3Cross(m.Vec3Sub(V1, V0), m.Vec3Sub(V2, V0)))
+	hit.N = hit.Ng
+
+	// Static meshes always use the first key of the normals, see TraceMotionElems.
+	if mesh.Normals.Elems != nil && (mesh.accel.qbvh != nil || mesh.Normals.MotionKeys == mesh.Verts.MotionKeys) {
+		var N m.Vec3
+
+		for k, b := range [3]float32{U, V, W} {
+			i := int(mesh.normalidx[(idx*3)+int32(k)])
+			Nk := m.Vec3Lerp(mesh.Normals.Elems[i+mesh.Normals.ElemsPerKey*key], mesh.Normals.Elems[i+mesh.Normals.ElemsPerKey*key2], time)
+			N = m.Vec3Add(N, m.Vec3Scale(b, Nk))
+		}
+
+		hit.N = m.Vec3Normalize(N)
+	}
+
+	hit.P = hit.Po
+
+	// Trace sets the transform in sg before traversal.
+	if mesh.Transform.Elems != nil {
+		hit.P = m.Matrix4MulPoint(sg.Transform, hit.Po)
+		hit.N = m.Vec3Normalize(m.Matrix4MulVec(m.Matrix4Transpose(sg.InvTransform), hit.N))
+		hit.Ng = m.Vec3Normalize(m.Matrix4MulVec(m.Matrix4Transpose(sg.InvTransform), hit.Ng))
+	}
+
+	return core.AnyHit(ray, sg, shader, &hit)
+}
+
 // TraceElems implements qbvh.Primitive.
 ///go:nosplit
 func (mesh *PolyMesh) TraceElems(ray *core.Ray, sg *core.ShaderContext, base, count int) bool {
@@ -185,6 +253,10 @@ func (mesh *PolyMesh) TraceElems(ray *core.Ray, sg *core.ShaderContext, base, co
 
 			rcpDet := 1.0 / det
 
+			if mesh.opacity != nil && !mesh.anyHit(ray, sg, int32(i), mesh.Verts.Elems[i0], mesh.Verts.Elems[i1], mesh.Verts.Elems[i2], 0, 0, 0, fU*rcpDet, fV*rcpDet, fW*rcpDet, T*rcpDet) {
+				continue
+			}
+
 			U = fU * rcpDet
 			V = fV * rcpDet
 			W = fW * rcpDet
@@ -261,6 +333,10 @@ func (mesh *PolyMesh) TraceElems(ray *core.Ray, sg *core.ShaderContext, base, co
 
 			rcpDet := 1.0 / det
 
+			if mesh.opacity != nil && !mesh.anyHit(ray, sg, faceidx, mesh.Verts.Elems[i0], mesh.Verts.Elems[i1], mesh.Verts.Elems[i2], 0, 0, 0, fU*rcpDet, fV*rcpDet, fW*rcpDet, T*rcpDet) {
+				continue
+			}
+
 			U = fU * rcpDet
 			V = fV * rcpDet
 			W = fW * rcpDet
@@ -501,14 +577,18 @@ func (mesh *PolyMesh) TraceElems(ray *core.Ray, sg *core.ShaderContext, base, co
 		sg.Dduvdy[1] = alphay*0 + betay*0 + gammay*1
 	}
 
-	axisu := m.Vec3Sub(m.Vec3{1, 0, 0}, m.Vec3Scale(m.Vec3Dot(m.Vec3{1, 0, 0}, sg.Ng), sg.Ng))
+	if mesh.tangents != nil {
+		sg.DdPdu, sg.DdPdv = mesh.interpTangent(idx, U, V, W, N)
+	} else {
+		axisu := m.Vec3Sub(m.Vec3{1, 0, 0}, m.Vec3Scale(m.Vec3Dot(m.Vec3{1, 0, 0}, sg.Ng), sg.Ng))
 
-	if m.Vec3Length2(axisu) < 0.1 || m.Abs(m.Vec3Dot(axisu, sg.Ng)) > 0.3 {
-		axisu = m.Vec3Sub(m.Vec3{0, 0, 1}, m.Vec3Scale(m.Vec3Dot(m.Vec3{0, 0, 1}, sg.Ng), sg.Ng))
-	}
+		if m.Vec3Length2(axisu) < 0.1 || m.Abs(m.Vec3Dot(axisu, sg.Ng)) > 0.3 {
+			axisu = m.Vec3Sub(m.Vec3{0, 0, 1}, m.Vec3Scale(m.Vec3Dot(m.Vec3{0, 0, 1}, sg.Ng), sg.Ng))
+		}
 
-	sg.DdPdu = m.Vec3Normalize(axisu)
-	sg.DdPdv = m.Vec3Cross(sg.Ng, sg.DdPdu)
+		sg.DdPdu = m.Vec3Normalize(axisu)
+		sg.DdPdv = m.Vec3Cross(sg.Ng, sg.DdPdu)
+	}
 
 	sg.ElemID = uint32(idx)
 
@@ -615,6 +695,10 @@ func (mesh *PolyMesh) TraceMotionElems(time float32, key, key2 int, ray *core.Ra
 
 		rcpDet := 1.0 / det
 
+		if mesh.opacity != nil && !mesh.anyHit(ray, sg, faceidx, V0, V1, V2, key, key2, time, fU*rcpDet, fV*rcpDet, fW*rcpDet, T*rcpDet) {
+			continue
+		}
+
 		U = fU * rcpDet
 		V = fV * rcpDet
 		W = fW * rcpDet
@@ -692,14 +776,14 @@ func (mesh *PolyMesh) TraceMotionElems(time float32, key, key2 int, ray *core.Ra
 	}
 
 	if mesh.Normals.Elems != nil && mesh.Normals.MotionKeys == mesh.Verts.MotionKeys {
-		N0 := m.Vec3Lerp(mesh.Normals.Elems[int((idx*3)+0)+mesh.Normals.ElemsPerKey*key],
-			mesh.Normals.Elems[int((idx*3)+0)+mesh.Normals.ElemsPerKey*key2], time)
+		N0 := m.Vec3Lerp(mesh.Normals.Elems[int(mesh.normalidx[(idx*3)+0])+mesh.Normals.ElemsPerKey*key],
+			mesh.Normals.Elems[int(mesh.normalidx[(idx*3)+0])+mesh.Normals.ElemsPerKey*key2], time)
 
-		N1 := m.Vec3Lerp(mesh.Normals.Elems[int((idx*3)+1)+mesh.Normals.ElemsPerKey*key],
-			mesh.Normals.Elems[int((idx*3)+1)+mesh.Normals.ElemsPerKey*key2], time)
+		N1 := m.Vec3Lerp(mesh.Normals.Elems[int(mesh.normalidx[(idx*3)+1])+mesh.Normals.ElemsPerKey*key],
+			mesh.Normals.Elems[int(mesh.normalidx[(idx*3)+1])+mesh.Normals.ElemsPerKey*key2], time)
 
-		N2 := m.Vec3Lerp(mesh.Normals.Elems[int((idx*3)+2)+mesh.Normals.ElemsPerKey*key],
-			mesh.Normals.Elems[int((idx*3)+2)+mesh.Normals.ElemsPerKey*key2], time)
+		N2 := m.Vec3Lerp(mesh.Normals.Elems[int(mesh.normalidx[(idx*3)+2])+mesh.Normals.ElemsPerKey*key],
+			mesh.Normals.Elems[int(mesh.normalidx[(idx*3)+2])+mesh.Normals.ElemsPerKey*key2], time)
 
 		for k := range sg.N {
 			sg.N[k] = U*N0[k] +
@@ -712,6 +796,10 @@ func (mesh *PolyMesh) TraceMotionElems(time float32, key, key2 int, ray *core.Ra
 		sg.N = sg.Ng
 	}
 
+	if mesh.tangents != nil {
+		sg.DdPdu, sg.DdPdv = mesh.interpTangent(idx, U, V, W, sg.N)
+	}
+
 	sg.ElemID = uint32(idx)
 
 	return true
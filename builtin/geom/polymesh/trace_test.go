@@ -0,0 +1,126 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package polymesh
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+	"testing"
+)
+
+// cutoutShader is cut out for world x < 0, partially opaque for x in [0,1) and opaque beyond.
+type cutoutShader struct {
+	normals []m.Vec3 // N at each EvalOpacity
+}
+
+func (sh *cutoutShader) Name() string                { return "cutout" }
+func (sh *cutoutShader) Def() core.NodeDef           { return core.NodeDef{} }
+func (sh *cutoutShader) PreRender() error            { return nil }
+func (sh *cutoutShader) PostRender() error           { return nil }
+func (sh *cutoutShader) Eval(sc *core.ShaderContext) {}
+func (sh *cutoutShader) HasOpacity() bool            { return true }
+func (sh *cutoutShader) EvalEmission(sc *core.ShaderContext, omegaO m.Vec3) colour.RGB {
+	return colour.RGB{}
+}
+
+func (sh *cutoutShader) EvalOpacity(sc *core.ShaderContext) colour.RGB {
+	sh.normals = append(sh.normals, sc.N)
+
+	switch {
+	case sc.P[0] < 0:
+		return colour.RGB{}
+	case sc.P[0] < 1:
+		return colour.RGB{0.5, 0.5, 0.75}
+	}
+
+	return colour.RGB{1, 1, 1}
+}
+
+// newCutoutMesh returns two quads facing -z at z=1 and z=2.  The mesh is offset by Transform so
+// opacity must be evaluated at the world position.
+func newCutoutMesh(t *testing.T) (*PolyMesh, *cutoutShader) {
+	shader := &cutoutShader{}
+
+	core.Init(nil)
+	core.AddNode(shader)
+
+	mesh := &PolyMesh{
+		NodeName:  "mesh",
+		PolyCount: []int32{4, 4},
+		FaceIdx:   []int32{0, 3, 2, 1, 4, 7, 6, 5},
+		Shader:    []string{"cutout"},
+	}
+
+	mesh.Verts.Elems = []m.Vec3{
+		{-3, -1, 1}, {1, -1, 1}, {1, 1, 1}, {-3, 1, 1},
+		{-3, -1, 2}, {1, -1, 2}, {1, 1, 2}, {-3, 1, 2},
+	}
+	mesh.Verts.ElemsPerKey = len(mesh.Verts.Elems)
+	mesh.Verts.MotionKeys = 1
+	mesh.Transform = param.MatrixArray{Elems: []m.Matrix4{m.Matrix4Translate(1, 0, 0)}, MotionKeys: 1}
+
+	if err := mesh.PreRender(); err != nil {
+		t.Fatal(err)
+	}
+
+	return mesh, shader
+}
+
+func TestAnyHit(t *testing.T) {
+	mesh, shader := newCutoutMesh(t)
+
+	var tests = []struct {
+		ty           uint32
+		x            float32
+		hit          bool
+		tclosest     float32
+		transmission colour.RGB
+	}{
+		// Object x is negative so this is only partially opaque if the world position is used.
+		{core.RayTypeShadow, 0.5, false, 10, colour.RGB{0.25, 0.25, 0.0625}},
+		{core.RayTypeShadow, -0.5, false, 10, colour.RGB{1, 1, 1}},
+		{core.RayTypeShadow, 1.5, true, 1, colour.RGB{}},
+		{core.RayTypeCamera, -0.5, false, 10, colour.RGB{1, 1, 1}},
+		{core.RayTypeCamera, 1.5, true, 1, colour.RGB{1, 1, 1}},
+	}
+
+	// The faces are traced directly as qbvh.Trace needs a 16 byte aligned RenderTask, sc has
+	// the mesh transform as set up by Trace.
+	transform := mesh.Transform.Elems[0]
+	invTransform, _ := m.Matrix4Inverse(transform)
+
+	for _, test := range tests {
+		ray := &core.Ray{}
+		sc := &core.ShaderContext{Transform: transform, InvTransform: invTransform}
+
+		ray.Init(test.ty, m.Matrix4MulPoint(invTransform, m.Vec3{test.x, 0, 0}), m.Vec3{0, 0, 1}, 10, 0, sc)
+		shader.normals = nil
+
+		hit := mesh.TraceElems(ray, sc, 0, mesh.facecount)
+
+		if hit != test.hit || m.Abs(ray.Tclosest-test.tclosest) > 1e-5 {
+			t.Errorf("%v at %v: hit %v at %v, expected %v at %v", test.ty, test.x, hit, ray.Tclosest, test.hit, test.tclosest)
+		}
+
+		for k := range ray.Transmission {
+			if m.Abs(ray.Transmission[k]-test.transmission[k]) > 1e-5 {
+				t.Errorf("%v at %v: transmission %v, expected %v", test.ty, test.x, ray.Transmission, test.transmission)
+				break
+			}
+		}
+
+		if len(shader.normals) == 0 {
+			t.Errorf("%v at %v: opacity not evaluated", test.ty, test.x)
+		}
+
+		for _, N := range shader.normals {
+			if m.Vec3Length(m.Vec3Sub(N, m.Vec3{0, 0, -1})) > 1e-5 {
+				t.Errorf("%v at %v: N %v, expected (0,0,-1)", test.ty, test.x, N)
+			}
+		}
+	}
+}
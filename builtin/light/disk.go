@@ -27,8 +27,14 @@ type Disk struct {
 	Segments      int `node:",opt"`
 	Samples       int `node:",opt"`
 
-	shader core.Shader
-	geom   core.Geom
+	Lumens  float32 `node:",opt"` // Luminous flux
+	Candela float32 `node:",opt"` // Luminous intensity along the axis
+	Lux     float32 `node:",opt"` // Illuminance 1m along the axis
+	Kelvin  float32 `node:",opt"` // Colour temperature
+
+	shader     core.Shader
+	shaderName string // Shader of the geometry, wraps shader for photometric units
+	geom       core.Geom
 }
 
 var _ core.Node = (*Disk)(nil)
@@ -84,12 +90,19 @@ func (d *Disk) PreRender() error {
 			return fmt.Errorf("Unable to find shader %v", d.Shader)
 		}
 
-		d.shader = shader
-
 		d.N = m.Vec3Normalize(m.Vec3Sub(d.LookAt, d.P))
 		d.T = m.Vec3Normalize(m.Vec3Cross(d.N, d.Up))
 		d.B = m.Vec3Cross(d.N, d.T)
 
+		area := m.Pi * d.Radius * d.Radius
+		p := photometric{d.Lumens, d.Candela, d.Lux, d.Kelvin}
+
+		var err error
+
+		if d.shader, d.shaderName, err = p.shader(d.NodeName, d.Shader, shader, area, area); err != nil {
+			return err
+		}
+
 		mesh := d.createMesh()
 		core.AddNode(mesh)
 		d.geom = mesh
@@ -229,7 +242,7 @@ func (d *Disk) createMesh() *polymesh.PolyMesh {
 
 	msh := polymesh.PolyMesh{NodeDef: d.NodeDef, NodeName: d.NodeName + ":<mesh>",
 		IsVisible: true,
-		Shader:    []string{d.Shader}}
+		Shader:    []string{d.shaderName}}
 
 	//msh.ModelToWorld.Elems = []m.Matrix4{m.Matrix4Identity}
 	//msh.ModelToWorld.MotionKeys = 1
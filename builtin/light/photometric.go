@@ -0,0 +1,120 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package light
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// photometric are the photometric units of a light, radiance values of 1 are taken as 1 nit
+// (cd/m^2).
+type photometric struct {
+	lumens, candela, lux, kelvin float32
+}
+
+// radiance returns the luminance of a diffuse emitter with the given area and area projected
+// along its axis (in world units) which gives the flux or intensity.  Lux is the illuminance
+// at 1m treating the light as a point.  Returns false if no units were given.
+func (p photometric) radiance(area, projArea float32) (float32, bool) {
+	s := core.MetresPerUnit() * core.MetresPerUnit()
+
+	area *= s
+	projArea *= s
+
+	switch {
+	case p.lumens > 0:
+		return p.lumens / (m.Pi * area), true
+	case p.candela > 0:
+		return p.candela / projArea, true
+	case p.lux > 0:
+		return p.lux / projArea, true
+	}
+
+	return 1, false
+}
+
+// shader returns the light's shader, wrapped to scale the emission if photometric units are
+// given, and its name for the light geometry.
+func (p photometric) shader(light, name string, shader core.Shader, area, projArea float32) (core.Shader, string, error) {
+	if p.lumens < 0 || p.candela < 0 || p.lux < 0 || p.kelvin < 0 {
+		return nil, "", fmt.Errorf("Light %v: photometric units must not be negative", light)
+	}
+
+	L, ok := p.radiance(area, projArea)
+
+	if !ok && p.kelvin == 0 {
+		return shader, name, nil
+	}
+
+	scale := colour.RGB{L, L, L}
+
+	if p.kelvin > 0 {
+		scale.Mul(colour.Blackbody(p.kelvin))
+	}
+
+	sh := &emissionShader{name: light + ":<shader>", shader: shader, scale: scale}
+	core.AddNode(sh)
+
+	return sh, sh.name, nil
+}
+
+// emissionShader scales the emission of a light's shader, the shader's emission colour acts as
+// a filter on the photometric value.
+type emissionShader struct {
+	name   string
+	shader core.Shader
+	scale  colour.RGB
+}
+
+var _ core.Node = (*emissionShader)(nil)
+var _ core.Shader = (*emissionShader)(nil)
+var _ core.OpacityShader = (*emissionShader)(nil)
+
+// Name implements core.Node.
+func (sh *emissionShader) Name() string { return sh.name }
+
+// Def implements core.Node.
+func (sh *emissionShader) Def() core.NodeDef { return core.NodeDef{} }
+
+// PreRender implements core.Node.
+func (sh *emissionShader) PreRender() error { return nil }
+
+// PostRender implements core.Node.
+func (sh *emissionShader) PostRender() error { return nil }
+
+// Eval implements core.Shader.  The shader has added its own emission so only the difference
+// is added.
+func (sh *emissionShader) Eval(sg *core.ShaderContext) {
+	sh.shader.Eval(sg)
+
+	e := sh.shader.EvalEmission(sg, m.Vec3Neg(sg.Rd))
+
+	for k := range e {
+		sg.OutRGB[k] += e[k] * (sh.scale[k] - 1)
+	}
+}
+
+// EvalEmission implements core.Shader.
+func (sh *emissionShader) EvalEmission(sg *core.ShaderContext, omegaO m.Vec3) colour.RGB {
+	e := sh.shader.EvalEmission(sg, omegaO)
+	e.Mul(sh.scale)
+
+	return e
+}
+
+// HasOpacity implements core.OpacityShader.
+func (sh *emissionShader) HasOpacity() bool {
+	op, ok := sh.shader.(core.OpacityShader)
+
+	return ok && op.HasOpacity()
+}
+
+// EvalOpacity implements core.OpacityShader.
+func (sh *emissionShader) EvalOpacity(sg *core.ShaderContext) colour.RGB {
+	return sh.shader.(core.OpacityShader).EvalOpacity(sg)
+}
@@ -24,8 +24,14 @@ type Quad struct {
 
 	Samples int `node:",opt"`
 
-	shader core.Shader
-	geom   core.Geom
+	Lumens  float32 `node:",opt"` // Luminous flux
+	Candela float32 `node:",opt"` // Luminous intensity along the axis
+	Lux     float32 `node:",opt"` // Illuminance 1m along the axis
+	Kelvin  float32 `node:",opt"` // Colour temperature
+
+	shader     core.Shader
+	shaderName string // Shader of the geometry, wraps shader for photometric units
+	geom       core.Geom
 }
 
 var _ core.Node = (*Quad)(nil)
@@ -59,6 +65,15 @@ func (d *Quad) PreRender() error {
 	d.p[2] = m.Vec3Add3(d.P, d.U, d.V)
 	d.p[3] = m.Vec3Add(d.P, d.V)
 
+	area := m.Vec3Length(m.Vec3Cross(d.U, d.V))
+	p := photometric{d.Lumens, d.Candela, d.Lux, d.Kelvin}
+
+	var err error
+
+	if d.shader, d.shaderName, err = p.shader(d.NodeName, d.Shader, d.shader, area, area); err != nil {
+		return err
+	}
+
 	geom := d.createMesh()
 	core.AddNode(geom)
 	d.geom = geom
@@ -175,7 +190,7 @@ func (d *Quad) createMesh() *polymesh.PolyMesh {
 
 	msh := polymesh.PolyMesh{NodeDef: d.NodeDef, NodeName: d.NodeName + ":<mesh>",
 		IsVisible: true,
-		Shader:    []string{d.Shader}}
+		Shader:    []string{d.shaderName}}
 
 	//msh.ModelToWorld.Elems = []m.Matrix4{m.Matrix4Identity}
 	//msh.ModelToWorld.MotionKeys = 1
@@ -23,8 +23,14 @@ type Sphere struct {
 
 	Samples int `node:",opt"`
 
-	shader core.Shader
-	geom   core.Geom
+	Lumens  float32 `node:",opt"` // Luminous flux
+	Candela float32 `node:",opt"` // Luminous intensity along the axis
+	Lux     float32 `node:",opt"` // Illuminance 1m along the axis
+	Kelvin  float32 `node:",opt"` // Colour temperature
+
+	shader     core.Shader
+	shaderName string // Shader of the geometry, wraps shader for photometric units
+	geom       core.Geom
 }
 
 var _ core.Node = (*Sphere)(nil)
@@ -46,9 +52,17 @@ func (d *Sphere) PreRender() error {
 			return fmt.Errorf("Unable to find shader %v", d.Shader)
 		}
 
-		d.shader = shader
+		// Flux is from the whole surface, intensity from the disk seen in any direction.
+		area := 4 * m.Pi * d.Radius * d.Radius
+		p := photometric{d.Lumens, d.Candela, d.Lux, d.Kelvin}
 
-		geom := &sphere.Sphere{P: d.P, Radius: d.Radius, Shader: d.Shader}
+		var err error
+
+		if d.shader, d.shaderName, err = p.shader(d.NodeName, d.Shader, shader, area, area/4); err != nil {
+			return err
+		}
+
+		geom := &sphere.Sphere{P: d.P, Radius: d.Radius, Shader: d.shaderName}
 		core.AddNode(geom)
 		d.geom = geom
 
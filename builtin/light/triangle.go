@@ -23,8 +23,14 @@ type Tri struct {
 
 	Samples int
 
-	shader core.Shader
-	geom   core.Geom
+	Lumens  float32 `node:",opt"` // Luminous flux
+	Candela float32 `node:",opt"` // Luminous intensity along the axis
+	Lux     float32 `node:",opt"` // Illuminance 1m along the axis
+	Kelvin  float32 `node:",opt"` // Colour temperature
+
+	shader     core.Shader
+	shaderName string // Shader of the geometry, wraps shader for photometric units
+	geom       core.Geom
 }
 
 // Name implements core.Node.
@@ -43,7 +49,14 @@ func (d *Tri) PreRender() error {
 			return fmt.Errorf("Unable to find shader %v", d.Shader)
 		}
 
-		d.shader = shader
+		area := m.Vec3Length(m.Vec3Cross(m.Vec3Sub(d.P1, d.P0), m.Vec3Sub(d.P2, d.P0))) / 2
+		p := photometric{d.Lumens, d.Candela, d.Lux, d.Kelvin}
+
+		var err error
+
+		if d.shader, d.shaderName, err = p.shader(d.NodeName, d.Shader, shader, area, area); err != nil {
+			return err
+		}
 
 		geom := d.createMesh()
 		core.AddNode(geom)
@@ -538,7 +551,7 @@ func (d *Tri) createMesh() *polymesh.PolyMesh {
 
 	msh := polymesh.PolyMesh{NodeDef: d.NodeDef, NodeName: d.NodeName + ":<mesh>",
 		IsVisible: true,
-		Shader:    []string{d.Shader}}
+		Shader:    []string{d.shaderName}}
 
 	//msh.ModelToWorld.Elems = []m.Matrix4{m.Matrix4Identity}
 	//msh.ModelToWorld.MotionKeys = 1
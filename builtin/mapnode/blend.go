@@ -0,0 +1,132 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapnode
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/builtin/maps"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/nodes"
+)
+
+// Mix blends B over A using one of the usual layer blend modes.
+type Mix struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	A, B param.RGBUniform
+	Mix  param.Float32Uniform `node:",opt"` // Amount of blended result, default 0.5
+	Mode string               `node:",opt"` // Mix (default), Add, Subtract, Multiply, Screen, Overlay, Difference
+
+	blend func(a, b float32) float32
+}
+
+var _ core.Node = (*Mix)(nil)
+var _ param.RGBUniform = (*Mix)(nil)
+var _ param.Float32Uniform = (*Mix)(nil)
+
+var blendModes = map[string]func(a, b float32) float32{
+	"Mix":      func(a, b float32) float32 { return b },
+	"Add":      func(a, b float32) float32 { return a + b },
+	"Subtract": func(a, b float32) float32 { return a - b },
+	"Multiply": func(a, b float32) float32 { return a * b },
+	"Screen":   func(a, b float32) float32 { return 1 - (1-a)*(1-b) },
+	"Overlay": func(a, b float32) float32 {
+		if a < 0.5 {
+			return 2 * a * b
+		}
+		return 1 - 2*(1-a)*(1-b)
+	},
+	"Difference": func(a, b float32) float32 { return m.Abs(a - b) },
+}
+
+// Name is a core.Node method.
+func (n *Mix) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *Mix) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *Mix) PreRender() error {
+	if n.Mode == "" {
+		n.Mode = "Mix"
+	}
+
+	blend, ok := blendModes[n.Mode]
+
+	if !ok {
+		return fmt.Errorf("MixMap %v: unknown mode %v", n.NodeName, n.Mode)
+	}
+
+	n.blend = blend
+
+	return maps.ResolveFields(n)
+}
+
+// PostRender is a core.Node method.
+func (n *Mix) PostRender() error { return nil }
+
+// RGB implements param.RGBUniform.
+func (n *Mix) RGB(sg *core.ShaderContext) (c colour.RGB) {
+	a := n.A.RGB(sg)
+	b := n.B.RGB(sg)
+
+	for k := range c {
+		c[k] = n.blend(a[k], b[k])
+	}
+
+	return lerp(a, c, float32Param(n.Mix, sg, 0.5))
+}
+
+// Float32 implements param.Float32Uniform.
+func (n *Mix) Float32(sg *core.ShaderContext) float32 { return n.RGB(sg).Luminance() }
+
+// Multiply multiplies A and B, typically used to tint or mask a map.
+type Multiply struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	A, B param.RGBUniform
+}
+
+var _ core.Node = (*Multiply)(nil)
+var _ param.RGBUniform = (*Multiply)(nil)
+var _ param.Float32Uniform = (*Multiply)(nil)
+
+// Name is a core.Node method.
+func (n *Multiply) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *Multiply) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *Multiply) PreRender() error { return maps.ResolveFields(n) }
+
+// PostRender is a core.Node method.
+func (n *Multiply) PostRender() error { return nil }
+
+// RGB implements param.RGBUniform.
+func (n *Multiply) RGB(sg *core.ShaderContext) colour.RGB {
+	c := n.A.RGB(sg)
+	c.Mul(n.B.RGB(sg))
+
+	return c
+}
+
+// Float32 implements param.Float32Uniform.
+func (n *Multiply) Float32(sg *core.ShaderContext) float32 { return n.RGB(sg).Luminance() }
+
+func init() {
+	nodes.Register("MixMap", func() (core.Node, error) {
+		return &Mix{}, nil
+	})
+
+	nodes.Register("MultiplyMap", func() (core.Node, error) {
+		return &Multiply{}, nil
+	})
+}
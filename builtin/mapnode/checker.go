@@ -0,0 +1,82 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapnode
+
+import (
+	"github.com/jamiec7919/vermeer/builtin/maps"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/nodes"
+)
+
+// Checker is a checkerboard in UV space, box filtered over the pixel footprint so it
+// doesn't alias in the distance.
+type Checker struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	Colour1, Colour2 param.RGBUniform `node:",opt"`
+	Repeat           float32          `node:",opt"` // Number of squares per unit UV
+}
+
+var _ core.Node = (*Checker)(nil)
+var _ param.RGBUniform = (*Checker)(nil)
+var _ param.Float32Uniform = (*Checker)(nil)
+
+// Name is a core.Node method.
+func (n *Checker) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *Checker) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *Checker) PreRender() error { return maps.ResolveFields(n) }
+
+// PostRender is a core.Node method.
+func (n *Checker) PostRender() error { return nil }
+
+// Integral of the 1D square wave (period 2) from 0 to x.
+func bumpInt(x float32) float32 {
+	return m.Floor(x/2) + 2*m.Max(x/2-m.Floor(x/2)-0.5, 0)
+}
+
+// RGB implements param.RGBUniform.
+func (n *Checker) RGB(sg *core.ShaderContext) colour.RGB {
+	s := sg.U * n.Repeat
+	t := sg.V * n.Repeat
+
+	var ds, dt float32
+
+	if sg.Image != nil {
+		ds = n.Repeat * m.Max(m.Abs(sg.Image.PixelDelta[0]*sg.Dduvdx[0]), m.Abs(sg.Image.PixelDelta[1]*sg.Dduvdy[0]))
+		dt = n.Repeat * m.Max(m.Abs(sg.Image.PixelDelta[0]*sg.Dduvdx[1]), m.Abs(sg.Image.PixelDelta[1]*sg.Dduvdy[1]))
+	}
+
+	var area2 float32 // Fraction of the footprint covered by Colour2
+
+	if ds == 0 || dt == 0 {
+		if (int(m.Floor(s))+int(m.Floor(t)))%2 != 0 {
+			area2 = 1
+		}
+	} else {
+		// Closed form box filter, see PBRT 3rd ed. 10.5.3
+		sint := (bumpInt(s+ds) - bumpInt(s-ds)) / (2 * ds)
+		tint := (bumpInt(t+dt) - bumpInt(t-dt)) / (2 * dt)
+		area2 = sint + tint - 2*sint*tint
+	}
+
+	return lerp(rgbParam(n.Colour1, sg, colour.RGB{1, 1, 1}), rgbParam(n.Colour2, sg, colour.RGB{}), area2)
+}
+
+// Float32 implements param.Float32Uniform.
+func (n *Checker) Float32(sg *core.ShaderContext) float32 { return n.RGB(sg).Luminance() }
+
+func init() {
+	nodes.Register("CheckerMap", func() (core.Node, error) {
+		return &Checker{Repeat: 8}, nil
+	})
+}
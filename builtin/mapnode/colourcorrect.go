@@ -0,0 +1,101 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapnode
+
+import (
+	"github.com/jamiec7919/vermeer/builtin/maps"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/nodes"
+)
+
+// ColourCorrect applies a simple grade to its input.  Operations are applied in the order
+// exposure, gain, lift, contrast, saturation and then gamma.
+type ColourCorrect struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	Input      param.RGBUniform
+	Exposure   param.Float32Uniform `node:",opt"` // Stops
+	Gain       param.RGBUniform     `node:",opt"` // Multiplier
+	Lift       param.RGBUniform     `node:",opt"` // Raises the blacks
+	Contrast   param.Float32Uniform `node:",opt"` // Contrast around ContrastPivot
+	Saturation param.Float32Uniform `node:",opt"`
+	Gamma      param.Float32Uniform `node:",opt"`
+
+	ContrastPivot float32 `node:",opt"`
+}
+
+var _ core.Node = (*ColourCorrect)(nil)
+var _ param.RGBUniform = (*ColourCorrect)(nil)
+var _ param.Float32Uniform = (*ColourCorrect)(nil)
+
+// Name is a core.Node method.
+func (n *ColourCorrect) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *ColourCorrect) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *ColourCorrect) PreRender() error {
+	return maps.ResolveFields(n)
+}
+
+// PostRender is a core.Node method.
+func (n *ColourCorrect) PostRender() error { return nil }
+
+// RGB implements param.RGBUniform.
+func (n *ColourCorrect) RGB(sg *core.ShaderContext) colour.RGB {
+	c := n.Input.RGB(sg)
+
+	if n.Exposure != nil {
+		c.Scale(m.Pow(2, n.Exposure.Float32(sg)))
+	}
+
+	if n.Gain != nil {
+		c.Mul(n.Gain.RGB(sg))
+	}
+
+	if n.Lift != nil {
+		lift := n.Lift.RGB(sg)
+
+		for k := range c {
+			c[k] = lift[k] + c[k]*(1-lift[k])
+		}
+	}
+
+	if n.Contrast != nil {
+		contrast := n.Contrast.Float32(sg)
+
+		for k := range c {
+			c[k] = n.ContrastPivot + (c[k]-n.ContrastPivot)*contrast
+		}
+	}
+
+	if n.Saturation != nil {
+		c = lerp(colour.RGB{c.Luminance(), c.Luminance(), c.Luminance()}, c, n.Saturation.Float32(sg))
+	}
+
+	if n.Gamma != nil {
+		if gamma := n.Gamma.Float32(sg); gamma > 0 {
+			for k := range c {
+				c[k] = m.Pow(m.Max(0, c[k]), 1/gamma)
+			}
+		}
+	}
+
+	return c
+}
+
+// Float32 implements param.Float32Uniform.
+func (n *ColourCorrect) Float32(sg *core.ShaderContext) float32 { return n.RGB(sg).Luminance() }
+
+func init() {
+	nodes.Register("ColourCorrectMap", func() (core.Node, error) {
+		return &ColourCorrect{ContrastPivot: 0.18}, nil
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapnode
+
+import (
+	"github.com/jamiec7919/vermeer/builtin/maps"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	"github.com/jamiec7919/vermeer/nodes"
+)
+
+// HSV adjusts the hue, saturation and value of its input.
+type HSV struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	Input      param.RGBUniform
+	Hue        param.Float32Uniform `node:",opt"` // Hue shift, 1 is a full turn
+	Saturation param.Float32Uniform `node:",opt"` // Saturation multiplier
+	Value      param.Float32Uniform `node:",opt"` // Value multiplier
+}
+
+var _ core.Node = (*HSV)(nil)
+var _ param.RGBUniform = (*HSV)(nil)
+var _ param.Float32Uniform = (*HSV)(nil)
+
+// Name is a core.Node method.
+func (n *HSV) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *HSV) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *HSV) PreRender() error {
+	return maps.ResolveFields(n)
+}
+
+// PostRender is a core.Node method.
+func (n *HSV) PostRender() error { return nil }
+
+// RGB implements param.RGBUniform.
+func (n *HSV) RGB(sg *core.ShaderContext) colour.RGB {
+	h, s, v := colour.RGBToHSV(n.Input.RGB(sg))
+
+	h += float32Param(n.Hue, sg, 0)
+	s *= float32Param(n.Saturation, sg, 1)
+	v *= float32Param(n.Value, sg, 1)
+
+	if s > 1 {
+		s = 1
+	}
+
+	return colour.HSVToRGB(h, s, v)
+}
+
+// Float32 implements param.Float32Uniform.
+func (n *HSV) Float32(sg *core.ShaderContext) float32 { return n.RGB(sg).Luminance() }
+
+func init() {
+	nodes.Register("HSVMap", func() (core.Node, error) {
+		return &HSV{}, nil
+	})
+}
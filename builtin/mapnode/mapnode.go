@@ -0,0 +1,62 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package mapnode provides nodes for building shading networks out of maps.
+
+Each node implements param.RGBUniform and param.Float32Uniform (returning the luminance) so can be
+used for any shader parameter.  Nodes are referred to by name with the map keyword:
+
+	CheckerMap {
+		Name "check"
+		Colour1 rgb 1 1 1
+		Colour2 rgb 0.1 0.1 0.1
+	}
+
+	MixMap {
+		Name "dirty"
+		A map "check"
+		B rgbtex "dirt.png"
+		Mix float 0.3
+	}
+
+	ShaderStd {
+		Name "mtl"
+		DiffuseColour map "dirty"
+		...
+	}
+*/
+package mapnode
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+)
+
+// float32Param evaluates p or returns def if the parameter wasn't given.
+func float32Param(p param.Float32Uniform, sg *core.ShaderContext, def float32) float32 {
+	if p == nil {
+		return def
+	}
+
+	return p.Float32(sg)
+}
+
+// rgbParam evaluates p or returns def if the parameter wasn't given.
+func rgbParam(p param.RGBUniform, sg *core.ShaderContext, def colour.RGB) colour.RGB {
+	if p == nil {
+		return def
+	}
+
+	return p.RGB(sg)
+}
+
+func lerp(a, b colour.RGB, t float32) (c colour.RGB) {
+	for k := range c {
+		c[k] = (1-t)*a[k] + t*b[k]
+	}
+
+	return
+}
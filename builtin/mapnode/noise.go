@@ -0,0 +1,335 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapnode
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/builtin/maps"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/nodes"
+)
+
+// checkSpace returns an error for unknown lookup spaces, "" is treated as Object.
+func checkSpace(name, space string) error {
+	switch space {
+	case "", "Object", "World", "UV":
+		return nil
+	}
+
+	return fmt.Errorf("%v: unknown space %v", name, space)
+}
+
+// spacePoint returns the point in the given space and its screen space derivatives, which like
+// DdPdx/DdPdy are scaled by the image PixelDelta to give the pixel footprint.
+func spacePoint(sg *core.ShaderContext, space string) (p, dpdx, dpdy m.Vec3) {
+	switch space {
+	case "World":
+		return sg.P, sg.DdPdx, sg.DdPdy
+	case "UV":
+		return m.Vec3{sg.U, sg.V, 0}, m.Vec3{sg.Dduvdx[0], sg.Dduvdx[1], 0}, m.Vec3{sg.Dduvdy[0], sg.Dduvdy[1], 0}
+	}
+
+	return sg.Po, m.Matrix4MulVec(sg.InvTransform, sg.DdPdx), m.Matrix4MulVec(sg.InvTransform, sg.DdPdy)
+}
+
+// lookupPoint returns the point in the given space scaled by frequency and the width of the
+// pixel footprint at that point, used to anti-alias procedurals.
+func lookupPoint(sg *core.ShaderContext, space string, frequency float32) (p m.Vec3, width float32) {
+	var pixelDelta [2]float32
+
+	if sg.Image != nil {
+		pixelDelta = sg.Image.PixelDelta
+	}
+
+	p, dpdx, dpdy := spacePoint(sg, space)
+
+	dpdx = m.Vec3Scale(pixelDelta[0], dpdx)
+	dpdy = m.Vec3Scale(pixelDelta[1], dpdy)
+
+	width = frequency * m.Max(m.Vec3Length(dpdx), m.Vec3Length(dpdy))
+
+	return m.Vec3Scale(frequency, p), width
+}
+
+// Noise is Perlin or simplex noise, summed over octaves for fBm or turbulence.
+type Noise struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	Type       string  `node:",opt"` // Perlin (default) or Simplex
+	Space      string  `node:",opt"` // Object (default), World or UV
+	Frequency  float32 `node:",opt"`
+	Octaves    int     `node:",opt"`
+	Lacunarity float32 `node:",opt"` // Frequency multiplier between octaves
+	Gain       float32 `node:",opt"` // Amplitude multiplier between octaves
+	Turbulence bool    `node:",opt"`
+
+	Colour1, Colour2 param.RGBUniform `node:",opt"`
+
+	noise maps.NoiseFunc
+	norm  float32
+}
+
+var _ core.Node = (*Noise)(nil)
+var _ param.RGBUniform = (*Noise)(nil)
+var _ param.Float32Uniform = (*Noise)(nil)
+
+// Name is a core.Node method.
+func (n *Noise) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *Noise) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *Noise) PreRender() error {
+	switch n.Type {
+	case "", "Perlin":
+		n.noise = maps.Perlin
+	case "Simplex":
+		n.noise = maps.Simplex
+	default:
+		return fmt.Errorf("NoiseMap %v: unknown type %v", n.NodeName, n.Type)
+	}
+
+	if n.Octaves < 1 {
+		n.Octaves = 1
+	}
+
+	// Normalise by the total amplitude of all octaves.
+	amplitude := float32(1)
+	n.norm = 0
+
+	for i := 0; i < n.Octaves; i++ {
+		n.norm += amplitude
+		amplitude *= n.Gain
+	}
+
+	if err := checkSpace("NoiseMap "+n.NodeName, n.Space); err != nil {
+		return err
+	}
+
+	return maps.ResolveFields(n)
+}
+
+// PostRender is a core.Node method.
+func (n *Noise) PostRender() error { return nil }
+
+// Float32 implements param.Float32Uniform, returns the noise in [0,1].
+func (n *Noise) Float32(sg *core.ShaderContext) float32 {
+	p, width := lookupPoint(sg, n.Space, n.Frequency)
+
+	v := maps.FBm(n.noise, p, width, n.Octaves, n.Lacunarity, n.Gain, n.Turbulence) / n.norm
+
+	if !n.Turbulence {
+		v = 0.5 + 0.5*v
+	}
+
+	return m.Clamp(v, 0, 1)
+}
+
+// RGB implements param.RGBUniform.
+func (n *Noise) RGB(sg *core.ShaderContext) colour.RGB {
+	return lerp(rgbParam(n.Colour1, sg, colour.RGB{}), rgbParam(n.Colour2, sg, colour.RGB{1, 1, 1}), n.Float32(sg))
+}
+
+// Cellular is Worley (cellular) noise.
+type Cellular struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	Space     string  `node:",opt"` // Object (default), World or UV
+	Frequency float32 `node:",opt"`
+	Jitter    float32 `node:",opt"` // 0 is a regular grid, 1 (default) fully random
+	Output    string  `node:",opt"` // F1 (default), F2 or F2-F1
+
+	Colour1, Colour2 param.RGBUniform `node:",opt"`
+}
+
+var _ core.Node = (*Cellular)(nil)
+var _ param.RGBUniform = (*Cellular)(nil)
+var _ param.Float32Uniform = (*Cellular)(nil)
+
+// Name is a core.Node method.
+func (n *Cellular) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *Cellular) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *Cellular) PreRender() error {
+	switch n.Output {
+	case "":
+		n.Output = "F1"
+	case "F1", "F2", "F2-F1":
+	default:
+		return fmt.Errorf("CellularMap %v: unknown output %v", n.NodeName, n.Output)
+	}
+
+	if err := checkSpace("CellularMap "+n.NodeName, n.Space); err != nil {
+		return err
+	}
+
+	return maps.ResolveFields(n)
+}
+
+// PostRender is a core.Node method.
+func (n *Cellular) PostRender() error { return nil }
+
+// Float32 implements param.Float32Uniform.
+func (n *Cellular) Float32(sg *core.ShaderContext) float32 {
+	p, width := lookupPoint(sg, n.Space, n.Frequency)
+
+	f1, f2 := maps.Worley(p, n.Jitter)
+
+	var v float32
+
+	switch n.Output {
+	case "F2":
+		v = f2
+	case "F2-F1":
+		v = f2 - f1
+	default:
+		v = f1
+	}
+
+	// Cells smaller than the footprint average out, 0.5 is roughly the mean of all the outputs.
+	if width > 0.5 {
+		v = lerpf(v, 0.5, m.Clamp(2*width-1, 0, 1))
+	}
+
+	return m.Clamp(v, 0, 1)
+}
+
+// RGB implements param.RGBUniform.
+func (n *Cellular) RGB(sg *core.ShaderContext) colour.RGB {
+	return lerp(rgbParam(n.Colour1, sg, colour.RGB{}), rgbParam(n.Colour2, sg, colour.RGB{1, 1, 1}), n.Float32(sg))
+}
+
+// Wood is a pattern of concentric rings around the Z axis of the lookup space, distorted
+// by noise.
+type Wood struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	Space      string  `node:",opt"` // Object (default), World or UV
+	Frequency  float32 `node:",opt"` // Rings per unit
+	Distortion float32 `node:",opt"`
+	Octaves    int     `node:",opt"` // Octaves of distortion noise
+
+	Colour1, Colour2 param.RGBUniform `node:",opt"`
+}
+
+var _ core.Node = (*Wood)(nil)
+var _ param.RGBUniform = (*Wood)(nil)
+var _ param.Float32Uniform = (*Wood)(nil)
+
+// Name is a core.Node method.
+func (n *Wood) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *Wood) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *Wood) PreRender() error {
+	if err := checkSpace("WoodMap "+n.NodeName, n.Space); err != nil {
+		return err
+	}
+
+	return maps.ResolveFields(n)
+}
+
+// PostRender is a core.Node method.
+func (n *Wood) PostRender() error { return nil }
+
+// Float32 implements param.Float32Uniform.
+func (n *Wood) Float32(sg *core.ShaderContext) float32 {
+	p, width := lookupPoint(sg, n.Space, n.Frequency)
+
+	r := m.Sqrt(p[0]*p[0]+p[1]*p[1]) + n.Distortion*maps.FBm(maps.Perlin, p, width, n.Octaves, 2, 0.5, false)
+
+	// Rings narrower than the footprint blend to their average.
+	v := r - m.Floor(r)
+
+	return lerpf(v*v*(3-2*v), 0.5, m.Clamp(2*width-1, 0, 1))
+}
+
+// RGB implements param.RGBUniform.
+func (n *Wood) RGB(sg *core.ShaderContext) colour.RGB {
+	return lerp(rgbParam(n.Colour1, sg, colour.RGB{0.3, 0.15, 0.05}), rgbParam(n.Colour2, sg, colour.RGB{0.6, 0.4, 0.2}), n.Float32(sg))
+}
+
+// Marble is a sine wave along the X axis of the lookup space perturbed by turbulence.
+type Marble struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	Space      string  `node:",opt"` // Object (default), World or UV
+	Frequency  float32 `node:",opt"`
+	Distortion float32 `node:",opt"`
+	Octaves    int     `node:",opt"` // Octaves of turbulence
+
+	Colour1, Colour2 param.RGBUniform `node:",opt"`
+}
+
+var _ core.Node = (*Marble)(nil)
+var _ param.RGBUniform = (*Marble)(nil)
+var _ param.Float32Uniform = (*Marble)(nil)
+
+// Name is a core.Node method.
+func (n *Marble) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *Marble) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *Marble) PreRender() error {
+	if err := checkSpace("MarbleMap "+n.NodeName, n.Space); err != nil {
+		return err
+	}
+
+	return maps.ResolveFields(n)
+}
+
+// PostRender is a core.Node method.
+func (n *Marble) PostRender() error { return nil }
+
+// Float32 implements param.Float32Uniform.
+func (n *Marble) Float32(sg *core.ShaderContext) float32 {
+	p, width := lookupPoint(sg, n.Space, n.Frequency)
+
+	t := m.Pi * (p[0] + n.Distortion*maps.FBm(maps.Perlin, p, width, n.Octaves, 2, 0.5, true))
+
+	v := 0.5 + 0.5*m.Sin(t)
+
+	return lerpf(v, 0.5, m.Clamp(2*width-1, 0, 1))
+}
+
+// RGB implements param.RGBUniform.
+func (n *Marble) RGB(sg *core.ShaderContext) colour.RGB {
+	return lerp(rgbParam(n.Colour1, sg, colour.RGB{0.1, 0.1, 0.12}), rgbParam(n.Colour2, sg, colour.RGB{0.9, 0.9, 0.88}), n.Float32(sg))
+}
+
+func lerpf(a, b, t float32) float32 { return (1-t)*a + t*b }
+
+func init() {
+	nodes.Register("NoiseMap", func() (core.Node, error) {
+		return &Noise{Frequency: 1, Octaves: 1, Lacunarity: 2, Gain: 0.5}, nil
+	})
+
+	nodes.Register("CellularMap", func() (core.Node, error) {
+		return &Cellular{Frequency: 1, Jitter: 1}, nil
+	})
+
+	nodes.Register("WoodMap", func() (core.Node, error) {
+		return &Wood{Frequency: 4, Distortion: 0.5, Octaves: 4}, nil
+	})
+
+	nodes.Register("MarbleMap", func() (core.Node, error) {
+		return &Marble{Frequency: 1, Distortion: 4, Octaves: 6}, nil
+	})
+}
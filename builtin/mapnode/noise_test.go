@@ -0,0 +1,95 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapnode
+
+import (
+	"github.com/jamiec7919/vermeer/builtin/maps"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"testing"
+)
+
+// samplePoints returns a grid of object space lookup points with no pixel footprint.
+func samplePoints() (sgs []*core.ShaderContext) {
+	for i := 0; i < 4000; i++ {
+		p := m.Vec3{float32(i%20) * 0.37, float32(i/20%20) * 0.41, float32(i/400) * 0.43}
+		sgs = append(sgs, &core.ShaderContext{Po: p})
+	}
+
+	return
+}
+
+// checkRange checks that f is in [0,1] for all the sample points and covers at least [lo,hi].
+func checkRange(t *testing.T, name string, f func(sg *core.ShaderContext) float32, lo, hi float32) {
+	min, max := float32(1), float32(0)
+
+	for _, sg := range samplePoints() {
+		v := f(sg)
+
+		if v < 0 || v > 1 {
+			t.Errorf("%v: %v at %v", name, v, sg.Po)
+			return
+		}
+
+		min, max = m.Min(min, v), m.Max(max, v)
+	}
+
+	if min > lo || max < hi {
+		t.Errorf("%v: range [%v,%v], expected at least [%v,%v]", name, min, max, lo, hi)
+	}
+}
+
+func TestNoiseRange(t *testing.T) {
+	for _, typ := range []string{"Perlin", "Simplex"} {
+		for _, octaves := range []int{1, 4} {
+			for _, turbulence := range []bool{false, true} {
+				n := &Noise{NodeName: typ, Type: typ, Frequency: 1, Octaves: octaves, Lacunarity: 2, Gain: 0.5, Turbulence: turbulence}
+
+				// PreRender twice, the normalisation mustn't accumulate.
+				for i := 0; i < 2; i++ {
+					if err := n.PreRender(); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				// The summed octaves before clamping are within the total amplitude.
+				for _, sg := range samplePoints() {
+					if v := maps.FBm(n.noise, sg.Po, 0, n.Octaves, n.Lacunarity, n.Gain, n.Turbulence) / n.norm; v < -1 || v > 1 {
+						t.Errorf("%v %v octaves turbulence %v: fBm %v at %v", typ, octaves, turbulence, v, sg.Po)
+						break
+					}
+				}
+
+				lo, hi := float32(0.3), float32(0.7)
+
+				if turbulence {
+					lo, hi = 0.05, 0.5
+				}
+
+				checkRange(t, typ, n.Float32, lo, hi)
+			}
+		}
+	}
+}
+
+func TestCellularRange(t *testing.T) {
+	for _, output := range []string{"F1", "F2", "F2-F1"} {
+		n := &Cellular{NodeName: output, Frequency: 1, Jitter: 1, Output: output}
+
+		if err := n.PreRender(); err != nil {
+			t.Fatal(err)
+		}
+
+		checkRange(t, output, n.Float32, 0.3, 0.7)
+	}
+
+	// F2 is never nearer than F1.
+	for _, sg := range samplePoints() {
+		if f1, f2 := maps.Worley(sg.Po, 1); f1 < 0 || f2 < f1 {
+			t.Errorf("Worley at %v: F1 %v, F2 %v", sg.Po, f1, f2)
+			break
+		}
+	}
+}
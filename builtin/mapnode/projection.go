@@ -0,0 +1,321 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapnode
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/builtin/maps"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/nodes"
+)
+
+// checkProjectionSpace returns an error for unknown projection spaces, "" is treated as Object.
+func checkProjectionSpace(name, space string) error {
+	switch space {
+	case "", "Object", "World":
+		return nil
+	}
+
+	return fmt.Errorf("%v: unknown space %v", name, space)
+}
+
+// spaceNormal returns the shading normal in the given space.
+func spaceNormal(sg *core.ShaderContext, space string) m.Vec3 {
+	if space == "World" {
+		return sg.N
+	}
+
+	return m.Vec3Normalize(m.Matrix4MulVec(m.Matrix4Transpose(sg.Transform), sg.N))
+}
+
+// evalUV evaluates input with the surface coordinates replaced by uv and derivatives duvdx,
+// duvdy.  Texture inputs then filter over the projected footprint.
+func evalUV(input param.RGBUniform, sg *core.ShaderContext, uv, duvdx, duvdy m.Vec2) colour.RGB {
+	U, V, Dduvdx, Dduvdy := sg.U, sg.V, sg.Dduvdx, sg.Dduvdy
+
+	sg.U, sg.V, sg.Dduvdx, sg.Dduvdy = uv[0], uv[1], duvdx, duvdy
+
+	c := input.RGB(sg)
+
+	sg.U, sg.V, sg.Dduvdx, sg.Dduvdy = U, V, Dduvdx, Dduvdy
+
+	return c
+}
+
+// Triplanar projects its input along the three axes and blends the results by the normal, for
+// surfaces without UVs.
+type Triplanar struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	Input param.RGBUniform
+
+	Space     string  `node:",opt"` // Object (default) or World
+	Scale     float32 `node:",opt"` // Texture repeats per unit
+	Sharpness float32 `node:",opt"` // Higher values narrow the blend between projections
+}
+
+var _ core.Node = (*Triplanar)(nil)
+var _ param.RGBUniform = (*Triplanar)(nil)
+var _ param.Float32Uniform = (*Triplanar)(nil)
+
+// Name is a core.Node method.
+func (n *Triplanar) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *Triplanar) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *Triplanar) PreRender() error {
+	if err := checkProjectionSpace("TriplanarMap "+n.NodeName, n.Space); err != nil {
+		return err
+	}
+
+	return maps.ResolveFields(n)
+}
+
+// PostRender is a core.Node method.
+func (n *Triplanar) PostRender() error { return nil }
+
+// RGB implements param.RGBUniform.
+func (n *Triplanar) RGB(sg *core.ShaderContext) (c colour.RGB) {
+	p, dpdx, dpdy := spacePoint(sg, n.Space)
+	N := spaceNormal(sg, n.Space)
+
+	p = m.Vec3Scale(n.Scale, p)
+	dpdx = m.Vec3Scale(n.Scale, dpdx)
+	dpdy = m.Vec3Scale(n.Scale, dpdy)
+
+	var w [3]float32
+	var total float32
+
+	for k := range w {
+		w[k] = m.Pow(m.Abs(N[k]), n.Sharpness)
+		total += w[k]
+	}
+
+	if total == 0 {
+		return
+	}
+
+	// Axes for each projection, flipped on the negative side so the texture isn't mirrored.
+	axes := [3][2]int{{2, 1}, {0, 2}, {0, 1}}
+	flip := [3][2]float32{{-sign(N[0]), 1}, {1, -sign(N[1])}, {sign(N[2]), 1}}
+
+	for k := range w {
+		w[k] /= total
+
+		// Don't bother sampling projections which contribute nothing visible.
+		if w[k] < 1e-3 {
+			continue
+		}
+
+		a, b := axes[k][0], axes[k][1]
+		su, sv := flip[k][0], flip[k][1]
+
+		uv := m.Vec2{su * p[a], sv * p[b]}
+		duvdx := m.Vec2{su * dpdx[a], sv * dpdx[b]}
+		duvdy := m.Vec2{su * dpdy[a], sv * dpdy[b]}
+
+		ck := evalUV(n.Input, sg, uv, duvdx, duvdy)
+
+		for j := range c {
+			c[j] += w[k] * ck[j]
+		}
+	}
+
+	return
+}
+
+// Float32 implements param.Float32Uniform.
+func (n *Triplanar) Float32(sg *core.ShaderContext) float32 { return n.RGB(sg).Luminance() }
+
+// Projection evaluates its input with surface coordinates from a planar, spherical, cylindrical
+// or camera projection.  The projection is placed with Transform, which maps projection space
+// into object (or world) space:
+//
+// Planar projects along Z so the unit square [0,1]x[0,1] on the XY plane covers the texture.
+// Spherical wraps U around the Y axis and V from the +Y pole to the -Y pole.
+// Cylindrical wraps U around the Y axis with V running along it.
+// Camera projects through the named camera, Transform and Space are ignored.
+type Projection struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	Input param.RGBUniform
+
+	Type      string            `node:",opt"` // Planar (default), Spherical, Cylindrical or Camera
+	Space     string            `node:",opt"` // Object (default) or World
+	Transform param.MatrixArray `node:",opt"`
+	Camera    string            `node:",opt"` // Name of camera for Camera projection
+
+	invTransform m.Matrix4
+	camera       core.CameraProjector
+	project      func(n *Projection, sg *core.ShaderContext, p m.Vec3) (m.Vec2, bool)
+	periodic     bool
+}
+
+var _ core.Node = (*Projection)(nil)
+var _ param.RGBUniform = (*Projection)(nil)
+var _ param.Float32Uniform = (*Projection)(nil)
+
+// Name is a core.Node method.
+func (n *Projection) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *Projection) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *Projection) PreRender() error {
+	switch n.Type {
+	case "", "Planar":
+		n.project = projectPlanar
+	case "Spherical":
+		n.project = projectSpherical
+		n.periodic = true
+	case "Cylindrical":
+		n.project = projectCylindrical
+		n.periodic = true
+	case "Camera":
+		node := core.FindNode(n.Camera)
+
+		if node == nil {
+			return fmt.Errorf("Unable to find node (camera %v)", n.Camera)
+		}
+
+		camera, ok := node.(core.CameraProjector)
+
+		if !ok {
+			return fmt.Errorf("ProjectionMap %v: camera %v doesn't support projection", n.NodeName, n.Camera)
+		}
+
+		n.camera = camera
+		n.project = projectCamera
+		n.Space = "World"
+	default:
+		return fmt.Errorf("ProjectionMap %v: unknown type %v", n.NodeName, n.Type)
+	}
+
+	if err := checkProjectionSpace("ProjectionMap "+n.NodeName, n.Space); err != nil {
+		return err
+	}
+
+	n.invTransform = m.Matrix4Identity
+
+	if n.Type != "Camera" && n.Transform.Elems != nil {
+		inv, ok := m.Matrix4Inverse(n.Transform.Elems[0])
+
+		if !ok {
+			return fmt.Errorf("ProjectionMap %v: Transform not invertible", n.NodeName)
+		}
+
+		n.invTransform = inv
+	}
+
+	return maps.ResolveFields(n)
+}
+
+// PostRender is a core.Node method.
+func (n *Projection) PostRender() error { return nil }
+
+func projectPlanar(n *Projection, sg *core.ShaderContext, p m.Vec3) (m.Vec2, bool) {
+	return m.Vec2{p[0], p[1]}, true
+}
+
+func projectSpherical(n *Projection, sg *core.ShaderContext, p m.Vec3) (m.Vec2, bool) {
+	r := m.Vec3Length(p)
+
+	if r == 0 {
+		return m.Vec2{}, true
+	}
+
+	return m.Vec2{0.5 + m.Atan2(p[2], p[0])/(2*m.Pi), 0.5 - m.Asin(m.Clamp(p[1]/r, -1, 1))/m.Pi}, true
+}
+
+func projectCylindrical(n *Projection, sg *core.ShaderContext, p m.Vec3) (m.Vec2, bool) {
+	return m.Vec2{0.5 + m.Atan2(p[2], p[0])/(2*m.Pi), p[1]}, true
+}
+
+func projectCamera(n *Projection, sg *core.ShaderContext, p m.Vec3) (m.Vec2, bool) {
+	sx, sy, ok := n.camera.Project(p, sg.Time)
+
+	// Screen y is up, texture V is down.
+	return m.Vec2{0.5 + 0.5*sx, 0.5 - 0.5*sy}, ok
+}
+
+// differential returns the change in projected coordinates for the differential dp.  The
+// projection is differenced over the actual pixel footprint (dp scaled by delta) and rescaled
+// so the result matches the units of Dduvdx/Dduvdy.
+func (n *Projection) differential(sg *core.ShaderContext, p, dp m.Vec3, uv m.Vec2, delta float32) m.Vec2 {
+	if delta == 0 {
+		return m.Vec2{}
+	}
+
+	uv2, ok := n.project(n, sg, m.Vec3Add(p, m.Vec3Scale(delta, dp)))
+
+	if !ok {
+		return m.Vec2{}
+	}
+
+	d := m.Vec2Sub(uv2, uv)
+
+	// Take the short way round the seam.
+	if n.periodic {
+		d[0] -= m.Floor(d[0] + 0.5)
+	}
+
+	return m.Vec2Scale(1/delta, d)
+}
+
+// RGB implements param.RGBUniform.  Points the projection doesn't reach (behind a camera) are
+// black.
+func (n *Projection) RGB(sg *core.ShaderContext) colour.RGB {
+	p, dpdx, dpdy := spacePoint(sg, n.Space)
+
+	p = m.Matrix4MulPoint(n.invTransform, p)
+	dpdx = m.Matrix4MulVec(n.invTransform, dpdx)
+	dpdy = m.Matrix4MulVec(n.invTransform, dpdy)
+
+	uv, ok := n.project(n, sg, p)
+
+	if !ok {
+		return colour.RGB{}
+	}
+
+	var pixelDelta [2]float32
+
+	if sg.Image != nil {
+		pixelDelta = sg.Image.PixelDelta
+	}
+
+	duvdx := n.differential(sg, p, dpdx, uv, pixelDelta[0])
+	duvdy := n.differential(sg, p, dpdy, uv, pixelDelta[1])
+
+	return evalUV(n.Input, sg, uv, duvdx, duvdy)
+}
+
+// Float32 implements param.Float32Uniform.
+func (n *Projection) Float32(sg *core.ShaderContext) float32 { return n.RGB(sg).Luminance() }
+
+func sign(v float32) float32 {
+	if v < 0 {
+		return -1
+	}
+
+	return 1
+}
+
+func init() {
+	nodes.Register("TriplanarMap", func() (core.Node, error) {
+		return &Triplanar{Scale: 1, Sharpness: 4}, nil
+	})
+
+	nodes.Register("ProjectionMap", func() (core.Node, error) {
+		return &Projection{}, nil
+	})
+}
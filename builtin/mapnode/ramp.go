@@ -0,0 +1,113 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapnode
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/builtin/maps"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/nodes"
+	"sort"
+)
+
+// Ramp maps a float input onto a colour gradient.
+type Ramp struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	Input     param.Float32Uniform `node:",opt"` // Defaults to the V surface coordinate
+	Positions param.Float32Array
+	Colours   param.Vec3Array
+	Interp    string `node:",opt"` // Linear (default), Constant or Smooth
+
+	keys []rampKey
+}
+
+type rampKey struct {
+	pos float32
+	c   colour.RGB
+}
+
+var _ core.Node = (*Ramp)(nil)
+var _ param.RGBUniform = (*Ramp)(nil)
+var _ param.Float32Uniform = (*Ramp)(nil)
+
+// Name is a core.Node method.
+func (n *Ramp) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *Ramp) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *Ramp) PreRender() error {
+	if len(n.Positions.Elems) == 0 || len(n.Positions.Elems) != len(n.Colours.Elems) {
+		return fmt.Errorf("RampMap %v: need the same (non-zero) number of Positions and Colours", n.NodeName)
+	}
+
+	switch n.Interp {
+	case "":
+		n.Interp = "Linear"
+	case "Linear", "Constant", "Smooth":
+	default:
+		return fmt.Errorf("RampMap %v: unknown interpolation %v", n.NodeName, n.Interp)
+	}
+
+	n.keys = nil
+
+	for i := range n.Positions.Elems {
+		n.keys = append(n.keys, rampKey{n.Positions.Elems[i], colour.RGB(n.Colours.Elems[i])})
+	}
+
+	sort.SliceStable(n.keys, func(i, j int) bool { return n.keys[i].pos < n.keys[j].pos })
+
+	return maps.ResolveFields(n)
+}
+
+// PostRender is a core.Node method.
+func (n *Ramp) PostRender() error { return nil }
+
+// RGB implements param.RGBUniform.
+func (n *Ramp) RGB(sg *core.ShaderContext) colour.RGB {
+	t := sg.V
+
+	if n.Input != nil {
+		t = n.Input.Float32(sg)
+	}
+
+	// First key after t
+	i := sort.Search(len(n.keys), func(i int) bool { return n.keys[i].pos > t })
+
+	if i == 0 {
+		return n.keys[0].c
+	}
+
+	if i == len(n.keys) {
+		return n.keys[len(n.keys)-1].c
+	}
+
+	k0, k1 := n.keys[i-1], n.keys[i]
+
+	switch n.Interp {
+	case "Constant":
+		return k0.c
+	case "Smooth":
+		x := m.Clamp((t-k0.pos)/(k1.pos-k0.pos), 0, 1)
+		return lerp(k0.c, k1.c, x*x*(3-2*x))
+	}
+
+	return lerp(k0.c, k1.c, (t-k0.pos)/(k1.pos-k0.pos))
+}
+
+// Float32 implements param.Float32Uniform.
+func (n *Ramp) Float32(sg *core.ShaderContext) float32 { return n.RGB(sg).Luminance() }
+
+func init() {
+	nodes.Register("RampMap", func() (core.Node, error) {
+		return &Ramp{}, nil
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mapnode
+
+import (
+	"github.com/jamiec7919/vermeer/builtin/maps"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/nodes"
+)
+
+// UVTransform evaluates its input with transformed surface coordinates.  The coordinates are
+// scaled and rotated about the pivot and then offset.
+type UVTransform struct {
+	NodeDef  core.NodeDef `node:"-"`
+	NodeName string       `node:"Name"`
+
+	Input param.RGBUniform
+
+	ScaleU, ScaleV   param.Float32Uniform `node:",opt"`
+	OffsetU, OffsetV param.Float32Uniform `node:",opt"`
+	Rotate           param.Float32Uniform `node:",opt"` // Degrees
+	PivotU, PivotV   float32              `node:",opt"`
+}
+
+var _ core.Node = (*UVTransform)(nil)
+var _ param.RGBUniform = (*UVTransform)(nil)
+var _ param.Float32Uniform = (*UVTransform)(nil)
+
+// Name is a core.Node method.
+func (n *UVTransform) Name() string { return n.NodeName }
+
+// Def is a core.Node method.
+func (n *UVTransform) Def() core.NodeDef { return n.NodeDef }
+
+// PreRender is a core.Node method.
+func (n *UVTransform) PreRender() error {
+	return maps.ResolveFields(n)
+}
+
+// PostRender is a core.Node method.
+func (n *UVTransform) PostRender() error { return nil }
+
+// transform applies the transform to a point (or a vector if point is false).
+func (n *UVTransform) transform(sg *core.ShaderContext, uv m.Vec2, point bool) m.Vec2 {
+	scaleU := float32Param(n.ScaleU, sg, 1)
+	scaleV := float32Param(n.ScaleV, sg, 1)
+	theta := float32Param(n.Rotate, sg, 0) * m.Pi / 180
+	cosTheta, sinTheta := m.Cos(theta), m.Sin(theta)
+
+	if point {
+		uv[0] -= n.PivotU
+		uv[1] -= n.PivotV
+	}
+
+	u := scaleU * uv[0]
+	v := scaleV * uv[1]
+
+	uv = m.Vec2{cosTheta*u - sinTheta*v, sinTheta*u + cosTheta*v}
+
+	if point {
+		uv[0] += n.PivotU + float32Param(n.OffsetU, sg, 0)
+		uv[1] += n.PivotV + float32Param(n.OffsetV, sg, 0)
+	}
+
+	return uv
+}
+
+// RGB implements param.RGBUniform.
+func (n *UVTransform) RGB(sg *core.ShaderContext) colour.RGB {
+	U, V, Dduvdx, Dduvdy := sg.U, sg.V, sg.Dduvdx, sg.Dduvdy
+
+	uv := n.transform(sg, m.Vec2{U, V}, true)
+	sg.Dduvdx = n.transform(sg, Dduvdx, false)
+	sg.Dduvdy = n.transform(sg, Dduvdy, false)
+	sg.U, sg.V = uv[0], uv[1]
+
+	c := n.Input.RGB(sg)
+
+	sg.U, sg.V, sg.Dduvdx, sg.Dduvdy = U, V, Dduvdx, Dduvdy
+
+	return c
+}
+
+// Float32 implements param.Float32Uniform.
+func (n *UVTransform) Float32(sg *core.ShaderContext) float32 { return n.RGB(sg).Luminance() }
+
+func init() {
+	nodes.Register("UVTransformMap", func() (core.Node, error) {
+		return &UVTransform{}, nil
+	})
+}
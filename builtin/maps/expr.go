@@ -0,0 +1,83 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package maps
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"sync"
+)
+
+// Expr is a parameter computed by an expression, e.g.
+//
+//	mix(0.2, 0.8, step(0.5, fract(U*10)))
+//
+// Expressions have float and vector (also used for colours) values.  The variables P, Po, N,
+// Ng, Rd (vectors) and U, V, Time, Lambda, ElemID (floats) are taken from the ShaderContext and
+// map('name') evaluates a map node.  Floats are promoted to vectors where needed, components
+// are selected with .x/.y/.z or .r/.g/.b.
+//
+// The expression is parsed and type checked when created and compiled into closures when
+// resolved, any map nodes must exist by then.
+type Expr struct {
+	Source string
+
+	root  *exprNode
+	once  sync.Once
+	err   error
+	value exprValue
+}
+
+// CreateExpr parses the expression source.
+func CreateExpr(source string) (*Expr, error) {
+	root, err := parseExpr(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Expr{Source: source, root: root}, nil
+}
+
+func (e *Expr) compile() {
+	e.value, e.err = compileExpr(e.root)
+}
+
+// Resolve compiles the expression and returns an error if a referenced map node is missing.
+// Called from PreRender by maps.Resolve, otherwise on first use.
+func (e *Expr) Resolve() error {
+	e.once.Do(e.compile)
+
+	return e.err
+}
+
+// Float32 implements param.Float32Uniform.  Vector expressions return the first component,
+// an expression that failed to resolve (the error is returned to PreRender) is zero.
+func (e *Expr) Float32(sg *core.ShaderContext) float32 {
+	if err := e.Resolve(); err != nil {
+		return 0
+	}
+
+	if e.value.typ == exprFloat {
+		return e.value.f(sg)
+	}
+
+	return e.value.v(sg)[0]
+}
+
+// RGB implements param.RGBUniform.  Float expressions are grey, an expression that failed to
+// resolve is black.
+func (e *Expr) RGB(sg *core.ShaderContext) colour.RGB {
+	if err := e.Resolve(); err != nil {
+		return colour.RGB{}
+	}
+
+	if e.value.typ == exprFloat {
+		v := e.value.f(sg)
+		return colour.RGB{v, v, v}
+	}
+
+	return colour.RGB(e.value.v(sg))
+}
@@ -0,0 +1,225 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package maps
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	core.Init(nil)
+	core.AddNode(&testMap{"grey", &Constant{C: colour.RGB{0.2, 0.4, 0.6}}})
+
+	sg := &core.ShaderContext{U: 0.25, V: 0.75, P: m.Vec3{1, 2, 3}, N: m.Vec3{0, 0, 1}}
+
+	tests := []struct {
+		src string
+		typ int
+		v   m.Vec3 // Floats in v[0]
+	}{
+		// Precedence and associativity.
+		{"1 + 2 * 3", exprFloat, m.Vec3{7}},
+		{"(1 + 2) * 3", exprFloat, m.Vec3{9}},
+		{"10 - 4 - 3", exprFloat, m.Vec3{3}},
+		{"12 / 3 / 2", exprFloat, m.Vec3{2}},
+		{"2 * 7 % 4", exprFloat, m.Vec3{2}},
+		{"1 + 1 < 3 && 2 > 1", exprFloat, m.Vec3{1}},
+		{"0 && 1 || 1", exprFloat, m.Vec3{1}},
+		{"1 == 1 != 0", exprFloat, m.Vec3{1}},
+		{"-7 % 3", exprFloat, m.Vec3{2}},
+
+		// Unary minus and not.
+		{"-2 * 3", exprFloat, m.Vec3{-6}},
+		{"- -2", exprFloat, m.Vec3{2}},
+		{"-U + 1", exprFloat, m.Vec3{0.75}},
+		{"-P", exprVec, m.Vec3{-1, -2, -3}},
+		{"!0 + !2", exprFloat, m.Vec3{1}},
+
+		// Ternary, right associative and lower precedence than ||.
+		{"U < 0.5 ? 1 : 2", exprFloat, m.Vec3{1}},
+		{"0 ? 1 : 1 ? 2 : 3", exprFloat, m.Vec3{2}},
+		{"0 || 0 ? 1 : 2", exprFloat, m.Vec3{2}},
+		{"V > 0.5 ? P : 0", exprVec, m.Vec3{1, 2, 3}},
+		{"V < 0.5 ? P : 0", exprVec, m.Vec3{0, 0, 0}},
+
+		// Float to vector promotion.
+		{"P + 1", exprVec, m.Vec3{2, 3, 4}},
+		{"2 * P", exprVec, m.Vec3{2, 4, 6}},
+		{"mix(0, P, 0.5)", exprVec, m.Vec3{0.5, 1, 1.5}},
+		{"max(P, 2)", exprVec, m.Vec3{2, 2, 3}},
+		{"rgb(U, V, 1) * 2", exprVec, m.Vec3{0.5, 1.5, 2}},
+
+		// Components.
+		{"P.x", exprFloat, m.Vec3{1}},
+		{"P.b", exprFloat, m.Vec3{3}},
+		{"(P * 2).y + N.z", exprFloat, m.Vec3{5}},
+		{"cross(N, vec3(1, 0, 0)).g", exprFloat, m.Vec3{1}},
+
+		// Functions and constants.
+		{"floor(2.5) + fract(2.25)", exprFloat, m.Vec3{2.25}},
+		{"clamp(5, 0, 1) + step(0.5, U)", exprFloat, m.Vec3{1}},
+		{"length(vec3(3, 4, 0))", exprFloat, m.Vec3{5}},
+		{"cos(pi)", exprFloat, m.Vec3{-1}},
+		{"1.5e1 + .5", exprFloat, m.Vec3{15.5}},
+
+		// Maps.
+		{"map('grey')", exprVec, m.Vec3{0.2, 0.4, 0.6}},
+		{"map('grey').g * 2", exprFloat, m.Vec3{0.8}},
+	}
+
+	for _, test := range tests {
+		n, err := parseExpr(test.src)
+
+		if err != nil {
+			t.Errorf("%v: %v", test.src, err)
+			continue
+		}
+
+		val, err := compileExpr(n)
+
+		if err != nil {
+			t.Errorf("%v: %v", test.src, err)
+			continue
+		}
+
+		if val.typ != test.typ {
+			t.Errorf("%v: type %v, expected %v", test.src, val.typ, test.typ)
+			continue
+		}
+
+		var v m.Vec3
+
+		if val.typ == exprFloat {
+			v[0] = val.f(sg)
+		} else {
+			v = val.v(sg)
+		}
+
+		for k := range v {
+			if m.Abs(v[k]-test.v[k]) > 1e-5 {
+				t.Errorf("%v = %v, expected %v", test.src, v, test.v)
+				break
+			}
+		}
+	}
+}
+
+func TestExprConstantFolding(t *testing.T) {
+	core.Init(nil)
+	core.AddNode(&testMap{"grey", &Constant{C: colour.RGB{0.2, 0.4, 0.6}}})
+
+	tests := []struct {
+		src      string
+		constant bool
+	}{
+		{"1 + 2", true},
+		{"sin(0.5) * 2 + pi", true},
+		{"vec3(1, 2, 3).y", true},
+		{"U < 0.5 ? 1 : 2", false},
+		{"1 + U * 0", false},
+		{"noise(P)", false},
+
+		// Variables and maps are never folded, even when the result can't change.
+		{"U", false},
+		{"P.x * 0", false},
+		{"vec3(U, 1, 1).y", false},
+		{"Time - Time", false},
+		{"map('grey')", false},
+		{"map('grey').r + 1", false},
+	}
+
+	for _, test := range tests {
+		n, err := parseExpr(test.src)
+
+		if err != nil {
+			t.Fatalf("%v: %v", test.src, err)
+		}
+
+		val, err := compileExpr(n)
+
+		if err != nil {
+			t.Fatalf("%v: %v", test.src, err)
+		}
+
+		if val.constant != test.constant {
+			t.Errorf("%v: constant %v, expected %v", test.src, val.constant, test.constant)
+		}
+
+		// Folded expressions don't need a shading point.
+		if val.constant {
+			val.f(nil)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	core.Init(nil)
+
+	tests := []struct {
+		src, err string
+	}{
+		{"1.2.3", `col 1: unexpected "1.2.3"`},
+		{"1 + 1.2.3", `col 5: unexpected "1.2.3"`},
+		{"map('grey", `col 5: map expects a node name in single quotes`},
+		{"'grey", `col 1: unexpected "unterminated string"`},
+		{"1 + foo(2)", `col 5: unknown function "foo"`},
+		{"pow(2)", `col 1: pow expects 2 arguments`},
+		{"U + clamp(1, 2)", `col 5: clamp expects 3 arguments`},
+		{"sin(1, 2)", `col 1: sin expects 1 arguments`},
+		{"1 +", `col 4: unexpected "end of expression"`},
+		{"(1 + 2", `col 7: expected ")", found "end of expression"`},
+		{"1 2", `col 3: unexpected "2"`},
+		{"U.x", `col 3: component of a float`},
+		{"P.w", `col 3: unknown component "w"`},
+		{"P ? 1 : 0", `col 3: condition must be a float`},
+		{"P < 1", `col 3: "<" needs float operands`},
+		{"W + 1", `col 1: unknown variable "W"`},
+		{"dot(P, 1)", `col 1: argument 2 of dot must be a vector`},
+		{"map('missing')", `Unable to find node (map missing)`},
+	}
+
+	for _, test := range tests {
+		e, err := CreateExpr(test.src)
+
+		if err == nil {
+			// Parsed, so the error must come from resolving the maps.
+			err = e.Resolve()
+		}
+
+		if err == nil {
+			t.Errorf("%v: no error, expected %v", test.src, test.err)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: error %q, expected %q", test.src, err, test.err)
+		}
+	}
+}
+
+func TestExprMapCycle(t *testing.T) {
+	core.Init(nil)
+
+	e, err := CreateExpr("map('b') * 2")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &testMap{"a", e}
+	core.AddNode(a)
+	core.AddNode(&testMap{"b", &NodeRef{Name: "a"}})
+
+	expected := "Map cycle: b -> a -> b"
+
+	if err := a.PreRender(); err == nil || err.Error() != expected {
+		t.Errorf("error %v, expected %v", err, expected)
+	}
+
+	if c := a.RGB(&core.ShaderContext{}); c != (colour.RGB{}) {
+		t.Errorf("failed expression gave %v", c)
+	}
+}
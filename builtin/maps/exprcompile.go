@@ -0,0 +1,354 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package maps
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+)
+
+// exprAny is a function argument which may be a float or vector, componentwise functions return
+// vectors if any of their arguments are.
+const exprAny = -1
+
+type floatFn func(sg *core.ShaderContext) float32
+type vecFn func(sg *core.ShaderContext) m.Vec3
+
+// exprValue is a compiled expression, f is set for floats and v for vectors.
+type exprValue struct {
+	typ      int
+	f        floatFn
+	v        vecFn
+	constant bool // Doesn't depend on the shading point, may be evaluated with a nil context
+}
+
+// exprFunc describes a function callable from expressions.
+type exprFunc struct {
+	typ     int   // Result type
+	args    []int // Argument types
+	compile func(args []exprValue, typ int) exprValue
+}
+
+// exprFuncs are the functions available to expressions.  map('name') is handled by the parser.
+var exprFuncs = map[string]exprFunc{
+	"sin":   unaryFunc(m.Sin),
+	"cos":   unaryFunc(m.Cos),
+	"tan":   unaryFunc(m.Tan),
+	"asin":  unaryFunc(m.Asin),
+	"acos":  unaryFunc(m.Acos),
+	"atan":  unaryFunc(m.Atan),
+	"sqrt":  unaryFunc(m.Sqrt),
+	"abs":   unaryFunc(m.Abs),
+	"floor": unaryFunc(m.Floor),
+	"ceil":  unaryFunc(m.Ceil),
+	"fract": unaryFunc(func(x float32) float32 { return x - m.Floor(x) }),
+	"exp":   unaryFunc(m.Exp),
+	"log":   unaryFunc(func(x float32) float32 { return float32(math.Log(float64(x))) }),
+	"sign": unaryFunc(func(x float32) float32 {
+		switch {
+		case x > 0:
+			return 1
+		case x < 0:
+			return -1
+		}
+		return 0
+	}),
+
+	"atan2": binaryFunc(m.Atan2),
+	"pow":   binaryFunc(m.Pow),
+	"min":   binaryFunc(m.Min),
+	"max":   binaryFunc(m.Max),
+	"mod":   binaryFunc(fmod),
+	"step":  binaryFunc(func(edge, x float32) float32 { return b2f(x >= edge) }),
+
+	"clamp":      ternaryFunc(m.Clamp),
+	"mix":        ternaryFunc(func(a, b, t float32) float32 { return a + t*(b-a) }),
+	"smoothstep": ternaryFunc(smoothStep),
+
+	"vec3": {exprVec, []int{exprFloat, exprFloat, exprFloat}, compileVec3},
+	"rgb":  {exprVec, []int{exprFloat, exprFloat, exprFloat}, compileVec3},
+
+	"length":    vecToFloatFunc(m.Vec3Length),
+	"luminance": vecToFloatFunc(func(v m.Vec3) float32 { return colour.RGB(v).Luminance() }),
+	"noise":     vecToFloatFunc(Perlin),
+	"normalize": {exprVec, []int{exprVec}, func(args []exprValue, typ int) exprValue {
+		a := args[0].v
+		return exprValue{typ: exprVec, v: func(sg *core.ShaderContext) m.Vec3 { return m.Vec3Normalize(a(sg)) }}
+	}},
+
+	"dot": {exprFloat, []int{exprVec, exprVec}, func(args []exprValue, typ int) exprValue {
+		a, b := args[0].v, args[1].v
+		return exprValue{typ: exprFloat, f: func(sg *core.ShaderContext) float32 { return m.Vec3Dot(a(sg), b(sg)) }}
+	}},
+	"cross": {exprVec, []int{exprVec, exprVec}, func(args []exprValue, typ int) exprValue {
+		a, b := args[0].v, args[1].v
+		return exprValue{typ: exprVec, v: func(sg *core.ShaderContext) m.Vec3 { return m.Vec3Cross(a(sg), b(sg)) }}
+	}},
+}
+
+// exprBinaryFuncs implement the binary operators.
+var exprBinaryFuncs = map[string]func(a, b float32) float32{
+	"+":  func(a, b float32) float32 { return a + b },
+	"-":  func(a, b float32) float32 { return a - b },
+	"*":  func(a, b float32) float32 { return a * b },
+	"/":  func(a, b float32) float32 { return a / b },
+	"%":  fmod,
+	"<":  func(a, b float32) float32 { return b2f(a < b) },
+	"<=": func(a, b float32) float32 { return b2f(a <= b) },
+	">":  func(a, b float32) float32 { return b2f(a > b) },
+	">=": func(a, b float32) float32 { return b2f(a >= b) },
+	"==": func(a, b float32) float32 { return b2f(a == b) },
+	"!=": func(a, b float32) float32 { return b2f(a != b) },
+	"&&": func(a, b float32) float32 { return b2f(a != 0 && b != 0) },
+	"||": func(a, b float32) float32 { return b2f(a != 0 || b != 0) },
+}
+
+func b2f(b bool) float32 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// fmod returns x modulo y with the sign of y, so fract(x) == mod(x, 1).
+func fmod(x, y float32) float32 {
+	return x - y*m.Floor(x/y)
+}
+
+// vecOf returns a as a vector, floats are copied to all components.
+func vecOf(a exprValue) vecFn {
+	if a.typ == exprVec {
+		return a.v
+	}
+
+	f := a.f
+
+	return func(sg *core.ShaderContext) m.Vec3 {
+		x := f(sg)
+		return m.Vec3{x, x, x}
+	}
+}
+
+func unaryFunc(f func(x float32) float32) exprFunc {
+	return exprFunc{exprFloat, []int{exprAny}, func(args []exprValue, typ int) exprValue {
+		if typ == exprFloat {
+			a := args[0].f
+			return exprValue{typ: typ, f: func(sg *core.ShaderContext) float32 { return f(a(sg)) }}
+		}
+
+		a := args[0].v
+
+		return exprValue{typ: typ, v: func(sg *core.ShaderContext) m.Vec3 {
+			x := a(sg)
+			return m.Vec3{f(x[0]), f(x[1]), f(x[2])}
+		}}
+	}}
+}
+
+func binaryFunc(f func(a, b float32) float32) exprFunc {
+	return exprFunc{exprFloat, []int{exprAny, exprAny}, func(args []exprValue, typ int) exprValue {
+		if typ == exprFloat {
+			a, b := args[0].f, args[1].f
+			return exprValue{typ: typ, f: func(sg *core.ShaderContext) float32 { return f(a(sg), b(sg)) }}
+		}
+
+		a, b := vecOf(args[0]), vecOf(args[1])
+
+		return exprValue{typ: typ, v: func(sg *core.ShaderContext) m.Vec3 {
+			x, y := a(sg), b(sg)
+			return m.Vec3{f(x[0], y[0]), f(x[1], y[1]), f(x[2], y[2])}
+		}}
+	}}
+}
+
+func ternaryFunc(f func(a, b, c float32) float32) exprFunc {
+	return exprFunc{exprFloat, []int{exprAny, exprAny, exprAny}, func(args []exprValue, typ int) exprValue {
+		if typ == exprFloat {
+			a, b, c := args[0].f, args[1].f, args[2].f
+			return exprValue{typ: typ, f: func(sg *core.ShaderContext) float32 { return f(a(sg), b(sg), c(sg)) }}
+		}
+
+		a, b, c := vecOf(args[0]), vecOf(args[1]), vecOf(args[2])
+
+		return exprValue{typ: typ, v: func(sg *core.ShaderContext) m.Vec3 {
+			x, y, z := a(sg), b(sg), c(sg)
+			return m.Vec3{f(x[0], y[0], z[0]), f(x[1], y[1], z[1]), f(x[2], y[2], z[2])}
+		}}
+	}}
+}
+
+func vecToFloatFunc(f func(v m.Vec3) float32) exprFunc {
+	return exprFunc{exprFloat, []int{exprVec}, func(args []exprValue, typ int) exprValue {
+		a := args[0].v
+		return exprValue{typ: exprFloat, f: func(sg *core.ShaderContext) float32 { return f(a(sg)) }}
+	}}
+}
+
+func compileVec3(args []exprValue, typ int) exprValue {
+	x, y, z := args[0].f, args[1].f, args[2].f
+	return exprValue{typ: exprVec, v: func(sg *core.ShaderContext) m.Vec3 { return m.Vec3{x(sg), y(sg), z(sg)} }}
+}
+
+// compileVar returns the value of a ShaderContext variable.
+func compileVar(name string) exprValue {
+	var v vecFn
+	var f floatFn
+
+	switch name {
+	case "P":
+		v = func(sg *core.ShaderContext) m.Vec3 { return sg.P }
+	case "Po":
+		v = func(sg *core.ShaderContext) m.Vec3 { return sg.Po }
+	case "N":
+		v = func(sg *core.ShaderContext) m.Vec3 { return sg.N }
+	case "Ng":
+		v = func(sg *core.ShaderContext) m.Vec3 { return sg.Ng }
+	case "Rd":
+		v = func(sg *core.ShaderContext) m.Vec3 { return sg.Rd }
+	case "U":
+		f = func(sg *core.ShaderContext) float32 { return sg.U }
+	case "V":
+		f = func(sg *core.ShaderContext) float32 { return sg.V }
+	case "Time":
+		f = func(sg *core.ShaderContext) float32 { return sg.Time }
+	case "Lambda":
+		f = func(sg *core.ShaderContext) float32 { return sg.Lambda }
+	case "ElemID":
+		f = func(sg *core.ShaderContext) float32 { return float32(sg.ElemID) }
+	}
+
+	if v != nil {
+		return exprValue{typ: exprVec, v: v}
+	}
+
+	return exprValue{typ: exprFloat, f: f}
+}
+
+// compileExpr compiles the parsed expression n into closures.  Nodes that don't depend on the
+// shading point are evaluated once.
+func compileExpr(n *exprNode) (exprValue, error) {
+	args := make([]exprValue, len(n.args))
+	constant := true
+
+	for i := range n.args {
+		a, err := compileExpr(n.args[i])
+
+		if err != nil {
+			return exprValue{}, err
+		}
+
+		args[i] = a
+		constant = constant && a.constant
+	}
+
+	var val exprValue
+
+	switch n.kind {
+	case exprConst:
+		c := n.value
+		return exprValue{typ: exprFloat, f: func(sg *core.ShaderContext) float32 { return c }, constant: true}, nil
+
+	case exprVar:
+		return compileVar(n.op), nil
+
+	case exprMap:
+		ref := &NodeRef{Name: n.name}
+
+		if err := ref.Resolve(); err != nil {
+			return exprValue{}, err
+		}
+
+		return exprValue{typ: exprVec, v: func(sg *core.ShaderContext) m.Vec3 { return m.Vec3(ref.RGB(sg)) }}, nil
+
+	case exprUnary:
+		a := args[0]
+
+		switch {
+		case n.op == "!":
+			f := a.f
+			val = exprValue{typ: exprFloat, f: func(sg *core.ShaderContext) float32 { return b2f(f(sg) == 0) }}
+		case a.typ == exprFloat:
+			f := a.f
+			val = exprValue{typ: exprFloat, f: func(sg *core.ShaderContext) float32 { return -f(sg) }}
+		default:
+			v := a.v
+			val = exprValue{typ: exprVec, v: func(sg *core.ShaderContext) m.Vec3 { return m.Vec3Neg(v(sg)) }}
+		}
+
+	case exprBinary:
+		val = compileBinary(n.op, n.typ, args[0], args[1])
+
+	case exprCond:
+		cond := args[0].f
+
+		if n.typ == exprFloat {
+			a, b := args[1].f, args[2].f
+
+			val = exprValue{typ: exprFloat, f: func(sg *core.ShaderContext) float32 {
+				if cond(sg) != 0 {
+					return a(sg)
+				}
+				return b(sg)
+			}}
+		} else {
+			a, b := vecOf(args[1]), vecOf(args[2])
+
+			val = exprValue{typ: exprVec, v: func(sg *core.ShaderContext) m.Vec3 {
+				if cond(sg) != 0 {
+					return a(sg)
+				}
+				return b(sg)
+			}}
+		}
+
+	case exprCall:
+		val = exprFuncs[n.op].compile(args, n.typ)
+
+	case exprComponent:
+		v, k := args[0].v, n.comp
+		val = exprValue{typ: exprFloat, f: func(sg *core.ShaderContext) float32 { return v(sg)[k] }}
+	}
+
+	if constant {
+		return constantValue(val), nil
+	}
+
+	return val, nil
+}
+
+// compileBinary compiles a binary operator, float arithmetic avoids the indirect call.
+func compileBinary(op string, typ int, a, b exprValue) exprValue {
+	if typ == exprFloat {
+		x, y := a.f, b.f
+
+		switch op {
+		case "+":
+			return exprValue{typ: typ, f: func(sg *core.ShaderContext) float32 { return x(sg) + y(sg) }}
+		case "-":
+			return exprValue{typ: typ, f: func(sg *core.ShaderContext) float32 { return x(sg) - y(sg) }}
+		case "*":
+			return exprValue{typ: typ, f: func(sg *core.ShaderContext) float32 { return x(sg) * y(sg) }}
+		case "/":
+			return exprValue{typ: typ, f: func(sg *core.ShaderContext) float32 { return x(sg) / y(sg) }}
+		}
+	}
+
+	return binaryFunc(exprBinaryFuncs[op]).compile([]exprValue{a, b}, typ)
+}
+
+// constantValue evaluates val once.
+func constantValue(val exprValue) exprValue {
+	if val.typ == exprFloat {
+		c := val.f(nil)
+		return exprValue{typ: exprFloat, f: func(sg *core.ShaderContext) float32 { return c }, constant: true}
+	}
+
+	c := val.v(nil)
+
+	return exprValue{typ: exprVec, v: func(sg *core.ShaderContext) m.Vec3 { return c }, constant: true}
+}
@@ -0,0 +1,498 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package maps
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Types of expression values.
+const (
+	exprFloat = iota
+	exprVec
+)
+
+// Kinds of expression node.
+const (
+	exprConst = iota
+	exprVar
+	exprUnary
+	exprBinary
+	exprCond
+	exprCall
+	exprComponent
+	exprMap
+)
+
+// exprNode is a node of a parsed expression.  The type of every node is known when parsed.
+type exprNode struct {
+	kind  int
+	typ   int
+	op    string // Operator, variable or function name
+	value float32
+	comp  int    // Component for exprComponent
+	name  string // Map node name for exprMap
+	args  []*exprNode
+}
+
+// Kinds of expression token.
+const (
+	tokEOF = iota
+	tokNumber
+	tokIdent
+	tokString
+	tokOp
+)
+
+type exprToken struct {
+	kind  int
+	str   string
+	value float32
+	pos   int
+}
+
+// exprParser is a recursive descent parser for expressions.
+type exprParser struct {
+	src  string
+	pos  int
+	tok  exprToken
+	peek bool
+}
+
+// exprVars are the variables available to expressions and their types.
+var exprVars = map[string]int{
+	"P": exprVec, "Po": exprVec, "N": exprVec, "Ng": exprVec, "Rd": exprVec,
+	"U": exprFloat, "V": exprFloat, "Time": exprFloat, "Lambda": exprFloat, "ElemID": exprFloat,
+}
+
+// exprConsts are named constants.
+var exprConsts = map[string]float32{
+	"pi": 3.14159265358979323846,
+}
+
+// exprComponents are the names of vector components.
+var exprComponents = map[string]int{
+	"x": 0, "y": 1, "z": 2,
+	"r": 0, "g": 1, "b": 2,
+}
+
+// exprOps are the operators, longest first.
+var exprOps = []string{"<=", ">=", "==", "!=", "&&", "||", "+", "-", "*", "/", "%", "(", ")", ",", ".", "?", ":", "<", ">", "!"}
+
+// parseExpr parses the expression in src.
+func parseExpr(src string) (*exprNode, error) {
+	p := exprParser{src: src}
+
+	n, err := p.expr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.next(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %q", t.str)
+	}
+
+	return n, nil
+}
+
+func (p *exprParser) errorf(t exprToken, msg string, v ...interface{}) error {
+	return fmt.Errorf("expr %q: col %v: %v", p.src, t.pos+1, fmt.Sprintf(msg, v...))
+}
+
+// next returns the next token.
+func (p *exprParser) next() exprToken {
+	if p.peek {
+		p.peek = false
+		return p.tok
+	}
+
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+
+	start := p.pos
+	p.tok = exprToken{pos: start}
+
+	if p.pos >= len(p.src) {
+		p.tok.kind = tokEOF
+		p.tok.str = "end of expression"
+		return p.tok
+	}
+
+	c := p.src[p.pos]
+
+	switch {
+	case isDigit(c) || c == '.' && p.pos+1 < len(p.src) && isDigit(p.src[p.pos+1]):
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+
+		// Exponent
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			p.pos++
+
+			if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+				p.pos++
+			}
+
+			for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+				p.pos++
+			}
+		}
+
+		p.tok.kind = tokNumber
+		p.tok.str = p.src[start:p.pos]
+
+		v, err := strconv.ParseFloat(p.tok.str, 32)
+
+		if err != nil {
+			// Reported by the caller as an unexpected token.
+			p.tok.kind = tokOp
+		}
+
+		p.tok.value = float32(v)
+
+	case isIdentStart(c):
+		for p.pos < len(p.src) && (isIdentStart(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+
+		p.tok.kind = tokIdent
+		p.tok.str = p.src[start:p.pos]
+
+	case c == '\'':
+		end := strings.IndexByte(p.src[p.pos+1:], '\'')
+
+		if end < 0 {
+			p.pos = len(p.src)
+			p.tok.kind = tokOp
+			p.tok.str = "unterminated string"
+			return p.tok
+		}
+
+		p.tok.kind = tokString
+		p.tok.str = p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+
+	default:
+		p.tok.kind = tokOp
+		p.tok.str = string(c)
+
+		for _, op := range exprOps {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.tok.str = op
+				break
+			}
+		}
+
+		p.pos += len(p.tok.str)
+	}
+
+	return p.tok
+}
+
+// accept consumes the next token if it is the operator op.
+func (p *exprParser) accept(op string) bool {
+	t := p.next()
+
+	if t.kind == tokOp && t.str == op {
+		return true
+	}
+
+	p.peek = true
+	return false
+}
+
+// expect consumes the operator op or returns an error.
+func (p *exprParser) expect(op string) error {
+	if t := p.next(); t.kind != tokOp || t.str != op {
+		return p.errorf(t, "expected %q, found %q", op, t.str)
+	}
+
+	return nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool { return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+
+// expr parses a conditional expression:
+//
+//	or [ '?' expr ':' expr ]
+func (p *exprParser) expr() (*exprNode, error) {
+	cond, err := p.binary(0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.tok
+
+	if !p.accept("?") {
+		return cond, nil
+	}
+
+	if cond.typ != exprFloat {
+		return nil, p.errorf(t, "condition must be a float")
+	}
+
+	a, err := p.expr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	b, err := p.expr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &exprNode{kind: exprCond, typ: maxType(a, b), args: []*exprNode{cond, a, b}}, nil
+}
+
+// exprBinaryOps are the binary operators by increasing precedence.
+var exprBinaryOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"<", "<=", ">", ">=", "==", "!="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// binary parses binary operators of precedence level and above.
+func (p *exprParser) binary(level int) (*exprNode, error) {
+	if level == len(exprBinaryOps) {
+		return p.unary()
+	}
+
+	a, err := p.binary(level + 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+L:
+	for {
+		t := p.next()
+
+		if t.kind == tokOp {
+			for _, op := range exprBinaryOps[level] {
+				if t.str != op {
+					continue
+				}
+
+				b, err := p.binary(level + 1)
+
+				if err != nil {
+					return nil, err
+				}
+
+				typ := maxType(a, b)
+
+				// Comparisons and logic are only defined for floats.
+				if level < 3 {
+					if typ != exprFloat {
+						return nil, p.errorf(t, "%q needs float operands", op)
+					}
+				}
+
+				a = &exprNode{kind: exprBinary, typ: typ, op: op, args: []*exprNode{a, b}}
+				continue L
+			}
+		}
+
+		p.peek = true
+		return a, nil
+	}
+}
+
+// unary parses '-' and '!' prefixes.
+func (p *exprParser) unary() (*exprNode, error) {
+	t := p.next()
+
+	if t.kind == tokOp && (t.str == "-" || t.str == "!") {
+		a, err := p.unary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if t.str == "!" && a.typ != exprFloat {
+			return nil, p.errorf(t, "\"!\" needs a float operand")
+		}
+
+		return &exprNode{kind: exprUnary, typ: a.typ, op: t.str, args: []*exprNode{a}}, nil
+	}
+
+	p.peek = true
+
+	return p.postfix()
+}
+
+// postfix parses component selection, e.g. N.z or map('dirt').r.
+func (p *exprParser) postfix() (*exprNode, error) {
+	a, err := p.primary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept(".") {
+		t := p.next()
+		comp, ok := exprComponents[t.str]
+
+		if t.kind != tokIdent || !ok {
+			return nil, p.errorf(t, "unknown component %q", t.str)
+		}
+
+		if a.typ != exprVec {
+			return nil, p.errorf(t, "component of a float")
+		}
+
+		a = &exprNode{kind: exprComponent, typ: exprFloat, comp: comp, args: []*exprNode{a}}
+	}
+
+	return a, nil
+}
+
+// primary parses numbers, variables, function calls and parenthesised expressions.
+func (p *exprParser) primary() (*exprNode, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokNumber:
+		return &exprNode{kind: exprConst, typ: exprFloat, value: t.value}, nil
+
+	case tokIdent:
+		if p.accept("(") {
+			return p.call(t)
+		}
+
+		if typ, ok := exprVars[t.str]; ok {
+			return &exprNode{kind: exprVar, typ: typ, op: t.str}, nil
+		}
+
+		if v, ok := exprConsts[t.str]; ok {
+			return &exprNode{kind: exprConst, typ: exprFloat, value: v}, nil
+		}
+
+		return nil, p.errorf(t, "unknown variable %q", t.str)
+
+	case tokOp:
+		if t.str == "(" {
+			a, err := p.expr()
+
+			if err != nil {
+				return nil, err
+			}
+
+			return a, p.expect(")")
+		}
+	}
+
+	return nil, p.errorf(t, "unexpected %q", t.str)
+}
+
+// call parses the arguments of a function call, the name and '(' have been read.
+func (p *exprParser) call(name exprToken) (*exprNode, error) {
+	if name.str == "map" {
+		t := p.next()
+
+		if t.kind != tokString {
+			return nil, p.errorf(t, "map expects a node name in single quotes")
+		}
+
+		return &exprNode{kind: exprMap, typ: exprVec, name: t.str}, p.expect(")")
+	}
+
+	fn, ok := exprFuncs[name.str]
+
+	if !ok {
+		return nil, p.errorf(name, "unknown function %q", name.str)
+	}
+
+	var args []*exprNode
+
+	if !p.accept(")") {
+		for {
+			a, err := p.expr()
+
+			if err != nil {
+				return nil, err
+			}
+
+			args = append(args, a)
+
+			if p.accept(")") {
+				break
+			}
+
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(args) != len(fn.args) {
+		return nil, p.errorf(name, "%v expects %v arguments", name.str, len(fn.args))
+	}
+
+	typ := fn.typ
+
+	for i, a := range args {
+		switch fn.args[i] {
+		case exprVec:
+			if a.typ != exprVec {
+				return nil, p.errorf(name, "argument %v of %v must be a vector", i+1, name.str)
+			}
+		case exprFloat:
+			if a.typ != exprFloat {
+				return nil, p.errorf(name, "argument %v of %v must be a float", i+1, name.str)
+			}
+		case exprAny:
+			// Componentwise functions return vectors if any argument is.
+			if a.typ == exprVec {
+				typ = exprVec
+			}
+		}
+	}
+
+	return &exprNode{kind: exprCall, typ: typ, op: name.str, args: args}, nil
+}
+
+// maxType returns the type of an operation on a and b, floats are promoted to vectors.
+func maxType(a, b *exprNode) int {
+	if a.typ == exprVec || b.typ == exprVec {
+		return exprVec
+	}
+
+	return exprFloat
+}
+
+// mapRefs appends the names of the map nodes used by the expression to refs.
+func (n *exprNode) mapRefs(refs []string) []string {
+	if n == nil {
+		return refs
+	}
+
+	if n.kind == exprMap {
+		refs = append(refs, n.name)
+	}
+
+	for _, a := range n.args {
+		refs = a.mapRefs(refs)
+	}
+
+	return refs
+}
@@ -0,0 +1,186 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package maps
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// NodeRef is a parameter that refers to a map node by name.  The node is looked up on first
+// use (nodes may be declared after the reference or added by Include) and must implement
+// param.RGBUniform and/or param.Float32Uniform.  Resolving the reference PreRenders the node
+// and fails if the maps refer to each other in a cycle.
+type NodeRef struct {
+	Name string
+	Chan int // Used by Float32 if the node only provides RGB
+
+	once sync.Once
+	err  error
+	rgb  param.RGBUniform
+	f32  param.Float32Uniform
+}
+
+func (c *NodeRef) resolve() {
+	node := core.FindNode(c.Name)
+
+	if node == nil {
+		c.err = fmt.Errorf("Unable to find node (map %v)", c.Name)
+		return
+	}
+
+	c.rgb, _ = node.(param.RGBUniform)
+	c.f32, _ = node.(param.Float32Uniform)
+
+	if c.rgb == nil && c.f32 == nil {
+		c.err = fmt.Errorf("Node %v is not a map", c.Name)
+		return
+	}
+
+	if err := checkCycle(c.Name, node, nil, map[string]bool{}); err != nil {
+		c.err = err
+		c.rgb, c.f32 = nil, nil
+		return
+	}
+
+	// The node may be declared after the one using it, make sure it is ready to evaluate.
+	if err := core.PreRenderNode(node); err != nil {
+		c.err = err
+		c.rgb, c.f32 = nil, nil
+	}
+}
+
+// checkCycle follows the map references of node (named name) depth first and returns an error
+// if one leads back to a node on the current path, path holds the names leading to node and
+// done the nodes already checked.
+func checkCycle(name string, node core.Node, path []string, done map[string]bool) error {
+	for i := range path {
+		if path[i] == name {
+			return fmt.Errorf("Map cycle: %v", strings.Join(append(path[i:], name), " -> "))
+		}
+	}
+
+	if done[name] {
+		return nil
+	}
+
+	path = append(path, name)
+
+	for _, ref := range fieldRefs(node) {
+		if next := core.FindNode(ref); next != nil {
+			if err := checkCycle(ref, next, path, done); err != nil {
+				return err
+			}
+		}
+	}
+
+	done[name] = true
+
+	return nil
+}
+
+// fieldRefs returns the names of the map nodes referred to by the exported parameter fields of
+// the node struct pointed to by node, including map('name') in expressions.
+func fieldRefs(node interface{}) (refs []string) {
+	v := reflect.Indirect(reflect.ValueOf(node))
+
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+
+		if f.Kind() != reflect.Interface || !f.CanInterface() || f.IsNil() {
+			continue
+		}
+
+		switch t := f.Interface().(type) {
+		case *NodeRef:
+			refs = append(refs, t.Name)
+		case *Expr:
+			refs = t.root.mapRefs(refs)
+		}
+	}
+
+	return
+}
+
+// Resolve looks up the referenced node and returns an error if it isn't a valid map.
+func (c *NodeRef) Resolve() error {
+	c.once.Do(c.resolve)
+
+	return c.err
+}
+
+// Float32 implements param.Float32Uniform.  A reference that failed to resolve (the error is
+// returned to PreRender by Resolve) is zero.
+func (c *NodeRef) Float32(sg *core.ShaderContext) float32 {
+	if err := c.Resolve(); err != nil {
+		return 0
+	}
+
+	if c.f32 != nil {
+		return c.f32.Float32(sg)
+	}
+
+	return c.rgb.RGB(sg)[c.Chan]
+}
+
+// RGB implements param.RGBUniform.  A reference that failed to resolve is black.
+func (c *NodeRef) RGB(sg *core.ShaderContext) colour.RGB {
+	if err := c.Resolve(); err != nil {
+		return colour.RGB{}
+	}
+
+	if c.rgb != nil {
+		return c.rgb.RGB(sg)
+	}
+
+	v := c.f32.Float32(sg)
+
+	return colour.RGB{v, v, v}
+}
+
+// Resolve checks any map node references in params and compiles expressions, should be called
+// from PreRender so that missing nodes are reported before rendering.  Other parameter types are
+// ignored.
+func Resolve(params ...interface{}) error {
+	for _, p := range params {
+		switch t := p.(type) {
+		case *NodeRef:
+			if err := t.Resolve(); err != nil {
+				return err
+			}
+		case *Expr:
+			if err := t.Resolve(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ResolveFields calls Resolve on all of the exported parameter fields of the node struct
+// pointed to by node.
+func ResolveFields(node interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(node))
+
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Kind() == reflect.Interface && f.CanInterface() && !f.IsNil() {
+			if err := Resolve(f.Interface()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
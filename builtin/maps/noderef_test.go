@@ -0,0 +1,113 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package maps
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	"strings"
+	"testing"
+)
+
+// testMap is a map node which passes on its input, or white if there isn't one.
+type testMap struct {
+	NodeName string
+	Input    param.RGBUniform
+}
+
+func (n *testMap) Name() string                           { return n.NodeName }
+func (n *testMap) Def() core.NodeDef                      { return core.NodeDef{} }
+func (n *testMap) PreRender() error                       { return ResolveFields(n) }
+func (n *testMap) PostRender() error                      { return nil }
+func (n *testMap) Float32(sg *core.ShaderContext) float32 { return n.RGB(sg)[0] }
+
+func (n *testMap) RGB(sg *core.ShaderContext) colour.RGB {
+	if n.Input == nil {
+		return colour.RGB{1, 1, 1}
+	}
+
+	return n.Input.RGB(sg)
+}
+
+// addChain starts a new scene with a testMap for each "name:input" in links.  input names the
+// map used as the input, or is empty for none.  The scene also has a node named shader which
+// isn't a map.
+func addChain(links []string) (maps []*testMap) {
+	core.Init(nil)
+	core.AddNode(&notMap{"shader"})
+
+	for _, link := range links {
+		s := strings.SplitN(link, ":", 2)
+		n := &testMap{NodeName: s[0]}
+
+		if s[1] != "" {
+			n.Input = &NodeRef{Name: s[1]}
+		}
+
+		core.AddNode(n)
+		maps = append(maps, n)
+	}
+
+	return
+}
+
+func TestNodeRefResolve(t *testing.T) {
+	links := []string{"a:b", "b:c", "c:"}
+
+	for i, n := range addChain(links) {
+		if err := n.PreRender(); err != nil {
+			t.Fatalf("%v: %v", links[i], err)
+		}
+	}
+
+	maps := addChain(links)
+
+	// Resolving doesn't depend on the order of PreRender.
+	if err := maps[2].PreRender(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := maps[0].RGB(&core.ShaderContext{}); c != (colour.RGB{1, 1, 1}) {
+		t.Errorf("chain gave %v, expected white", c)
+	}
+}
+
+func TestNodeRefErrors(t *testing.T) {
+	// The first map of each chain is PreRendered.
+	chains := map[string][]string{
+		"Unable to find node (map x)": {"a:x"},
+		"Node shader is not a map":    {"a:shader"},
+		"Map cycle: a -> a":           {"a:a"},
+		"Map cycle: b -> c -> b":      {"a:b", "b:c", "c:b"},
+		"Map cycle: b -> c -> a -> b": {"a:b", "b:c", "c:a"},
+	}
+
+	for expected, links := range chains {
+		maps := addChain(links)
+
+		err := maps[0].PreRender()
+
+		if err == nil || err.Error() != expected {
+			t.Errorf("%v: error %v, expected %v", links, err, expected)
+			continue
+		}
+
+		// A failed reference is black when rendered instead of panicking or recursing.
+		if c := maps[0].RGB(&core.ShaderContext{}); c != (colour.RGB{}) {
+			t.Errorf("%v: failed reference gave %v", links, c)
+		}
+	}
+}
+
+// notMap is a node which isn't a map.
+type notMap struct {
+	NodeName string
+}
+
+func (n *notMap) Name() string      { return n.NodeName }
+func (n *notMap) Def() core.NodeDef { return core.NodeDef{} }
+func (n *notMap) PreRender() error  { return nil }
+func (n *notMap) PostRender() error { return nil }
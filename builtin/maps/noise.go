@@ -0,0 +1,272 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package maps
+
+import (
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// NoiseFunc is a 3D noise function with values in [-1,1].
+type NoiseFunc func(p m.Vec3) float32
+
+// Ken Perlin's reference permutation.
+var perm = [512]uint8{
+	151, 160, 137, 91, 90, 15, 131, 13, 201, 95, 96, 53, 194, 233, 7, 225, 140, 36, 103, 30, 69, 142,
+	8, 99, 37, 240, 21, 10, 23, 190, 6, 148, 247, 120, 234, 75, 0, 26, 197, 62, 94, 252, 219, 203, 117,
+	35, 11, 32, 57, 177, 33, 88, 237, 149, 56, 87, 174, 20, 125, 136, 171, 168, 68, 175, 74, 165, 71,
+	134, 139, 48, 27, 166, 77, 146, 158, 231, 83, 111, 229, 122, 60, 211, 133, 230, 220, 105, 92, 41,
+	55, 46, 245, 40, 244, 102, 143, 54, 65, 25, 63, 161, 1, 216, 80, 73, 209, 76, 132, 187, 208, 89,
+	18, 169, 200, 196, 135, 130, 116, 188, 159, 86, 164, 100, 109, 198, 173, 186, 3, 64, 52, 217, 226,
+	250, 124, 123, 5, 202, 38, 147, 118, 126, 255, 82, 85, 212, 207, 206, 59, 227, 47, 16, 58, 17, 182,
+	189, 28, 42, 223, 183, 170, 213, 119, 248, 152, 2, 44, 154, 163, 70, 221, 153, 101, 155, 167, 43,
+	172, 9, 129, 22, 39, 253, 19, 98, 108, 110, 79, 113, 224, 232, 178, 185, 112, 104, 218, 246, 97,
+	228, 251, 34, 242, 193, 238, 210, 144, 12, 191, 179, 162, 241, 81, 51, 145, 235, 249, 14, 239,
+	107, 49, 192, 214, 31, 181, 199, 106, 157, 184, 84, 204, 176, 115, 121, 50, 45, 127, 4, 150, 254,
+	138, 236, 205, 93, 222, 114, 67, 29, 24, 72, 243, 141, 128, 195, 78, 66, 215, 61, 156, 180,
+}
+
+func init() {
+	for i := 0; i < 256; i++ {
+		perm[256+i] = perm[i]
+	}
+}
+
+func fade(t float32) float32 { return t * t * t * (t*(t*6-15) + 10) }
+
+func lerp(t, a, b float32) float32 { return a + t*(b-a) }
+
+func grad(hash uint8, x, y, z float32) float32 {
+	h := hash & 15
+	u, v := y, z
+
+	if h < 8 {
+		u = x
+	}
+
+	if h < 4 {
+		v = y
+	} else if h == 12 || h == 14 {
+		v = x
+	}
+
+	if h&1 != 0 {
+		u = -u
+	}
+
+	if h&2 != 0 {
+		v = -v
+	}
+
+	return u + v
+}
+
+// Perlin returns Ken Perlin's improved gradient noise at p.
+func Perlin(p m.Vec3) float32 {
+	fx, fy, fz := m.Floor(p[0]), m.Floor(p[1]), m.Floor(p[2])
+	X, Y, Z := int(fx)&255, int(fy)&255, int(fz)&255
+	x, y, z := p[0]-fx, p[1]-fy, p[2]-fz
+
+	u, v, w := fade(x), fade(y), fade(z)
+
+	A := int(perm[X]) + Y
+	AA := int(perm[A]) + Z
+	AB := int(perm[A+1]) + Z
+	B := int(perm[X+1]) + Y
+	BA := int(perm[B]) + Z
+	BB := int(perm[B+1]) + Z
+
+	return lerp(w, lerp(v, lerp(u, grad(perm[AA], x, y, z), grad(perm[BA], x-1, y, z)),
+		lerp(u, grad(perm[AB], x, y-1, z), grad(perm[BB], x-1, y-1, z))),
+		lerp(v, lerp(u, grad(perm[AA+1], x, y, z-1), grad(perm[BA+1], x-1, y, z-1)),
+			lerp(u, grad(perm[AB+1], x, y-1, z-1), grad(perm[BB+1], x-1, y-1, z-1))))
+}
+
+// Simplex returns 3D simplex noise at p, cheaper than Perlin and without axis aligned artifacts.
+//
+// Gustavson, 'Simplex noise demystified', 2005.
+func Simplex(p m.Vec3) float32 {
+	const F3 = 1.0 / 3.0
+	const G3 = 1.0 / 6.0
+
+	// Skew to find the simplex cell
+	s := (p[0] + p[1] + p[2]) * F3
+	i := m.Floor(p[0] + s)
+	j := m.Floor(p[1] + s)
+	k := m.Floor(p[2] + s)
+
+	t := (i + j + k) * G3
+	x0 := p[0] - (i - t)
+	y0 := p[1] - (j - t)
+	z0 := p[2] - (k - t)
+
+	// Which of the six simplices are we in
+	var i1, j1, k1, i2, j2, k2 int
+
+	if x0 >= y0 {
+		if y0 >= z0 {
+			i1, j1, k1, i2, j2, k2 = 1, 0, 0, 1, 1, 0
+		} else if x0 >= z0 {
+			i1, j1, k1, i2, j2, k2 = 1, 0, 0, 1, 0, 1
+		} else {
+			i1, j1, k1, i2, j2, k2 = 0, 0, 1, 1, 0, 1
+		}
+	} else {
+		if y0 < z0 {
+			i1, j1, k1, i2, j2, k2 = 0, 0, 1, 0, 1, 1
+		} else if x0 < z0 {
+			i1, j1, k1, i2, j2, k2 = 0, 1, 0, 0, 1, 1
+		} else {
+			i1, j1, k1, i2, j2, k2 = 0, 1, 0, 1, 1, 0
+		}
+	}
+
+	x1 := x0 - float32(i1) + G3
+	y1 := y0 - float32(j1) + G3
+	z1 := z0 - float32(k1) + G3
+	x2 := x0 - float32(i2) + 2*G3
+	y2 := y0 - float32(j2) + 2*G3
+	z2 := z0 - float32(k2) + 2*G3
+	x3 := x0 - 1 + 3*G3
+	y3 := y0 - 1 + 3*G3
+	z3 := z0 - 1 + 3*G3
+
+	ii, jj, kk := int(i)&255, int(j)&255, int(k)&255
+
+	corner := func(x, y, z float32, di, dj, dk int) float32 {
+		t := 0.6 - x*x - y*y - z*z
+
+		if t < 0 {
+			return 0
+		}
+
+		h := perm[ii+di+int(perm[jj+dj+int(perm[kk+dk])])]
+		t *= t
+
+		return t * t * grad(h, x, y, z)
+	}
+
+	n := corner(x0, y0, z0, 0, 0, 0) +
+		corner(x1, y1, z1, i1, j1, k1) +
+		corner(x2, y2, z2, i2, j2, k2) +
+		corner(x3, y3, z3, 1, 1, 1)
+
+	// Scale to roughly [-1,1]
+	return 32 * n
+}
+
+func smoothStep(min, max, x float32) float32 {
+	t := m.Clamp((x-min)/(max-min), 0, 1)
+	return t * t * (3 - 2*t)
+}
+
+// FBm sums octaves of noise, each octave lacunarity times the frequency and gain times the
+// amplitude of the last.  width is the filter width at p, octaves finer than the filter are
+// faded out to avoid aliasing.  If turbulence is true the absolute value of each octave is
+// summed.
+//
+// Based on PBRT 3rd ed. 10.6.2.
+func FBm(noise NoiseFunc, p m.Vec3, width float32, octaves int, lacunarity, gain float32, turbulence bool) float32 {
+	foctaves := float32(octaves)
+
+	if width > 0 && lacunarity > 1 {
+		foctaves = m.Clamp((-1-m.Log2(width))/m.Log2(lacunarity), 0, foctaves)
+	}
+
+	n := int(foctaves)
+
+	var sum float32
+
+	amplitude := float32(1)
+	frequency := float32(1)
+
+	for i := 0; i < n; i++ {
+		v := noise(m.Vec3Scale(frequency, p))
+
+		if turbulence {
+			v = m.Abs(v)
+		}
+
+		sum += amplitude * v
+		amplitude *= gain
+		frequency *= lacunarity
+	}
+
+	// Fade in the partial octave, turbulence fades to the average value rather than 0.
+	partial := smoothStep(0.3, 0.7, foctaves-float32(n))
+
+	if partial > 0 {
+		v := noise(m.Vec3Scale(frequency, p))
+
+		if turbulence {
+			sum += amplitude * (partial*m.Abs(v) + (1-partial)*0.2)
+		} else {
+			sum += amplitude * partial * v
+		}
+	} else if turbulence {
+		for i := n; i < octaves; i++ {
+			sum += amplitude * 0.2
+			amplitude *= gain
+		}
+	}
+
+	return sum
+}
+
+// hash3 returns a pseudo-random point in [0,1)^3 for the integer cell (i,j,k).
+func hash3(i, j, k int) m.Vec3 {
+	h := uint32(i)*73856093 ^ uint32(j)*19349663 ^ uint32(k)*83492791
+
+	var p m.Vec3
+
+	for c := range p {
+		// xorshift/multiply mix
+		h ^= h >> 16
+		h *= 0x7feb352d
+		h ^= h >> 15
+		h *= 0x846ca68b
+		h ^= h >> 16
+		p[c] = float32(h&0xffffff) / (1 << 24)
+	}
+
+	return p
+}
+
+// Worley returns the distances to the closest (f1) and second closest (f2) feature points of
+// cellular noise, with one feature point per unit cell.  jitter in [0,1] controls how far
+// points stray from the cell centres.
+//
+// Worley, 'A Cellular Texture Basis Function', 1996.
+func Worley(p m.Vec3, jitter float32) (f1, f2 float32) {
+	fx, fy, fz := m.Floor(p[0]), m.Floor(p[1]), m.Floor(p[2])
+	ix, iy, iz := int(fx), int(fy), int(fz)
+
+	f1 = m.Inf(1)
+	f2 = m.Inf(1)
+
+	for k := -1; k <= 1; k++ {
+		for j := -1; j <= 1; j++ {
+			for i := -1; i <= 1; i++ {
+				h := hash3(ix+i, iy+j, iz+k)
+
+				q := m.Vec3{
+					fx + float32(i) + 0.5 + jitter*(h[0]-0.5),
+					fy + float32(j) + 0.5 + jitter*(h[1]-0.5),
+					fz + float32(k) + 0.5 + jitter*(h[2]-0.5),
+				}
+
+				d := m.Vec3Length(m.Vec3Sub(q, p))
+
+				if d < f1 {
+					f2 = f1
+					f1 = d
+				} else if d < f2 {
+					f2 = d
+				}
+			}
+		}
+	}
+
+	return
+}
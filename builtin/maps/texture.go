@@ -5,79 +5,188 @@
 package maps
 
 import (
-	//"fmt"
+	"fmt"
 	"github.com/jamiec7919/vermeer/colour"
 	"github.com/jamiec7919/vermeer/core"
 	"github.com/jamiec7919/vermeer/core/param"
 	"github.com/jamiec7919/vermeer/texture"
 	"net/url"
 	"strconv"
+	"strings"
 )
 
+// Texture samples a texture file.
 type Texture struct {
 	Filename string
 	Chan     int
+	Options  texture.Options
 }
 
+// Float32 implements param.Float32Uniform.
 func (c *Texture) Float32(sg *core.ShaderContext) float32 {
-	return texture.SampleFeline(c.Filename, sg)[c.Chan]
+	return texture.Sample(c.Filename, c.Options, sg)[c.Chan]
 }
 
+// RGB implements param.RGBUniform.
 func (c *Texture) RGB(sg *core.ShaderContext) colour.RGB {
-
-	return colour.RGB(texture.SampleFeline(c.Filename, sg))
-
+	return colour.RGB(texture.Sample(c.Filename, c.Options, sg))
 }
 
+// TextureTrilinear samples a texture file with trilinear filtering.  It is the same as Texture
+// with the FilterTrilinear option and is kept for existing callers.
 type TextureTrilinear struct {
 	Filename string
 	Chan     int
 }
 
+// Float32 implements param.Float32Uniform.
 func (c *TextureTrilinear) Float32(sg *core.ShaderContext) float32 {
 	return texture.SampleRGB(c.Filename, sg)[c.Chan]
 }
 
+// RGB implements param.RGBUniform.
 func (c *TextureTrilinear) RGB(sg *core.ShaderContext) colour.RGB {
 	return colour.RGB(texture.SampleRGB(c.Filename, sg))
+}
+
+// parseFloats parses a comma separated list of n floats.
+func parseFloats(s string, n int) ([]float32, error) {
+	fields := strings.Split(s, ",")
+
+	if len(fields) != n {
+		return nil, fmt.Errorf("expected %v values: %v", n, s)
+	}
+
+	v := make([]float32, n)
+
+	for i := range fields {
+		f, err := strconv.ParseFloat(strings.TrimSpace(fields[i]), 32)
+
+		if err != nil {
+			return nil, err
+		}
+
+		v[i] = float32(f)
+	}
 
+	return v, nil
 }
 
-func CreateFloat32TextureMap(filename string) (param.Float32Uniform, error) {
+// parseTextureURL parses the filename and query options of a texture url:
+//
+// ch=n selects the channel for float maps.
+// filter=feline|trilinear|ewa
+// colourspace=linear|srgb|raw
+// wrap=repeat|clamp|mirror|black
+// scale=s or scale=su,sv, offset=u,v, rotate=degrees transform the texture coordinates.
+func parseTextureURL(filename string) (*Texture, error) {
 	u, err := url.Parse(filename)
 
 	if err != nil {
 		return nil, err
 	}
 
-	chString := u.Query().Get("ch")
-
-	ch, _ := strconv.Atoi(chString)
+	q := u.Query()
+	tex := &Texture{Filename: u.Path}
 
-	filter := u.Query().Get("filter")
+	if ch := q.Get("ch"); ch != "" {
+		if tex.Chan, err = strconv.Atoi(ch); err != nil || tex.Chan < 0 || tex.Chan > 2 {
+			return nil, fmt.Errorf("texture %v: invalid channel %v", u.Path, ch)
+		}
+	}
 
-	switch filter {
+	switch filter := q.Get("filter"); filter {
+	case "", "feline":
+		tex.Options.Filter = texture.FilterFeline
 	case "trilinear":
-		return &TextureTrilinear{Filename: u.EscapedPath(), Chan: ch}, nil
+		tex.Options.Filter = texture.FilterTrilinear
+	case "ewa":
+		tex.Options.Filter = texture.FilterEWA
+	default:
+		return nil, fmt.Errorf("texture %v: unknown filter %v", u.Path, filter)
+	}
+
+	switch cs := q.Get("colourspace"); cs {
+	case "", "linear":
+		tex.Options.ColourSpace = texture.ColourSpaceLinear
+	case "srgb":
+		tex.Options.ColourSpace = texture.ColourSpaceSRGB
+	case "raw":
+		tex.Options.ColourSpace = texture.ColourSpaceRaw
+	default:
+		return nil, fmt.Errorf("texture %v: unknown colourspace %v", u.Path, cs)
+	}
+
+	switch wrap := q.Get("wrap"); wrap {
+	case "", "repeat":
+		tex.Options.Wrap = texture.WrapRepeat
+	case "clamp":
+		tex.Options.Wrap = texture.WrapClamp
+	case "mirror":
+		tex.Options.Wrap = texture.WrapMirror
+	case "black":
+		tex.Options.Wrap = texture.WrapBlack
+	default:
+		return nil, fmt.Errorf("texture %v: unknown wrap %v", u.Path, wrap)
+	}
+
+	if scale := q.Get("scale"); scale != "" {
+		n := 1
+
+		if strings.Contains(scale, ",") {
+			n = 2
+		}
+
+		v, err := parseFloats(scale, n)
+
+		if err != nil {
+			return nil, fmt.Errorf("texture %v: scale: %v", u.Path, err)
+		}
+
+		tex.Options.ScaleU, tex.Options.ScaleV = v[0], v[n-1]
+	}
+
+	if offset := q.Get("offset"); offset != "" {
+		v, err := parseFloats(offset, 2)
+
+		if err != nil {
+			return nil, fmt.Errorf("texture %v: offset: %v", u.Path, err)
+		}
+
+		tex.Options.OffsetU, tex.Options.OffsetV = v[0], v[1]
 	}
 
-	return &Texture{Filename: u.EscapedPath(), Chan: ch}, nil
+	if rotate := q.Get("rotate"); rotate != "" {
+		v, err := parseFloats(rotate, 1)
+
+		if err != nil {
+			return nil, fmt.Errorf("texture %v: rotate: %v", u.Path, err)
+		}
+
+		tex.Options.Rotate = v[0]
+	}
 
+	return tex, nil
 }
 
-func CreateRGBTextureMap(filename string) (param.RGBUniform, error) {
-	u, err := url.Parse(filename)
+// CreateFloat32TextureMap returns a float map for the texture url.
+func CreateFloat32TextureMap(filename string) (param.Float32Uniform, error) {
+	tex, err := parseTextureURL(filename)
 
 	if err != nil {
 		return nil, err
 	}
 
-	filter := u.Query().Get("filter")
+	return tex, nil
+}
+
+// CreateRGBTextureMap returns an RGB map for the texture url, the ch option is ignored.
+func CreateRGBTextureMap(filename string) (param.RGBUniform, error) {
+	tex, err := parseTextureURL(filename)
 
-	switch filter {
-	case "trilinear":
-		return &TextureTrilinear{Filename: u.EscapedPath(), Chan: 0}, nil
+	if err != nil {
+		return nil, err
 	}
 
-	return &Texture{Filename: u.EscapedPath(), Chan: 0}, nil
+	return tex, nil
 }
@@ -12,14 +12,15 @@ import (
 	"math"
 )
 
-// MicrofacetGGX implements the GGX specular microfacet model.
+// MicrofacetGGX implements the GGX specular microfacet model with anisotropic roughness.
+// The U tangent is the direction of AlphaU.  Sampling uses the distribution of visible normals.
 // Instanced for each point
 type MicrofacetGGX struct {
-	Lambda    float32
-	OmegaR    m.Vec3 // reflected (view or out) direction
-	Roughness float32
-	Fresnel   core.Fresnel
-	U, V, N   m.Vec3 // Tangent space
+	Lambda         float32
+	OmegaR         m.Vec3 // reflected (view or out) direction
+	AlphaU, AlphaV float32
+	Fresnel        core.Fresnel
+	U, V, N        m.Vec3 // Tangent space
 
 }
 
@@ -30,42 +31,45 @@ func chi(x float32) float32 {
 	return 0
 }
 
-func ggxSmithG1(omega, omegaM m.Vec3, alpha float32) float32 {
-
-	// 2 / (1+sqrt(1+alpha^2*tan^2 theta_v))
-	// tan^2(x) + 1 = sec^2(x)
-	// sec(x) = 1/cos(x)
-	// sec^2(x) = 1/cos_2(x)
-	// tan^2(x) = 1/cos_2(x) - 1
+// ggxAlpha maps the user roughness to the distribution alpha.  This is the same (rather steep)
+// remapping the isotropic model has always used.
+func ggxAlpha(roughness float32) float32 {
+	return sqr32(roughness * roughness)
+}
 
-	ODotN := m.Vec3Dot(omega, omegaM)
+// ggxLambda is the Smith Lambda function for the anisotropic distribution.
+func ggxLambda(omega m.Vec3, alphaU, alphaV float32) float32 {
+	cos2 := omega[2] * omega[2]
 
-	//thetaV := m.Acos(omega[2])
-	//tan := m.Tan(thetaV)
-	//log.Printf("%v %v", tan*tan, (1.0/(omega[2]*omega[2]))-1)
+	if cos2 == 0 {
+		return m.Inf(1)
+	}
 
-	denom := 1 + m.Sqrt(1+(alpha*alpha)*((1.0/(omega[2]*omega[2]))-1))
+	a2tan2 := (sqr32(alphaU*omega[0]) + sqr32(alphaV*omega[1])) / cos2
 
-	return chi(ODotN/omega[2]) * 2 / denom
+	return (-1 + m.Sqrt(1+a2tan2)) / 2
 }
 
-func ggxD(omegaM m.Vec3, alpha float32) float32 {
+func ggxSmithG1(omega, omegaM m.Vec3, alphaU, alphaV float32) float32 {
+	return chi(m.Vec3Dot(omega, omegaM)/omega[2]) / (1 + ggxLambda(omega, alphaU, alphaV))
+}
 
-	numer := alpha * alpha * chi(omegaM[2])
+func ggxD(omegaM m.Vec3, alphaU, alphaV float32) float32 {
+	if omegaM[2] <= 0 {
+		return 0
+	}
 
 	if omegaM[2] == 1.0 {
 		// if omegaM == {0,0,1} with alpha small there is a numerical problem
 		// calculating the weight. Since this mostly happens with omegaM being chosen as the
 		// perfect mirror direction (same as shade normal) we do the calculation directly here avoiding
 		// the extra squaring of alpha.
-		// denom = m.Pi * sqr32(alpha*alpha)
-		return 1.0 / (m.Pi * alpha * alpha)
+		return 1.0 / (m.Pi * alphaU * alphaV)
 	}
 
-	denom := m.Pi * sqr32(omegaM[2]*omegaM[2]) * sqr32(alpha*alpha+((1.0/(omegaM[2]*omegaM[2]))-1))
+	d := sqr32(omegaM[0]/alphaU) + sqr32(omegaM[1]/alphaV) + sqr32(omegaM[2])
 
-	//log.Printf("%v %v %v", omegaM, numer, denom)
-	return numer / denom
+	return 1.0 / (m.Pi * alphaU * alphaV * d * d)
 }
 
 func sign(v float32) float32 {
@@ -77,24 +81,58 @@ func sign(v float32) float32 {
 
 }
 
-// NewMicrofacetGGX returns a new instance of the model for the given parameters.
+// NewMicrofacetGGX returns a new instance of the isotropic model for the given parameters.
 func NewMicrofacetGGX(sg *core.ShaderContext, omegaI m.Vec3, fresnel core.Fresnel, roughness float32, U, V, N m.Vec3) *MicrofacetGGX {
-	return &MicrofacetGGX{sg.Lambda, m.Vec3BasisProject(U, V, N, omegaI), roughness * roughness, fresnel, U, V, N}
+	return NewMicrofacetGGXAniso(sg, omegaI, fresnel, roughness, roughness, U, V, N)
+}
+
+// NewMicrofacetGGXAniso returns a new instance of the model with roughnessU along the U
+// tangent and roughnessV along V.
+func NewMicrofacetGGXAniso(sg *core.ShaderContext, omegaI m.Vec3, fresnel core.Fresnel, roughnessU, roughnessV float32, U, V, N m.Vec3) *MicrofacetGGX {
+	return &MicrofacetGGX{sg.Lambda, m.Vec3BasisProject(U, V, N, omegaI), ggxAlpha(roughnessU), ggxAlpha(roughnessV), fresnel, U, V, N}
+}
+
+// sampleVNDF samples a microfacet normal from the distribution of normals visible from omega,
+// which must be in the upper hemisphere.
+//
+// Heitz, 'Sampling the GGX Distribution of Visible Normals', JCGT 2018.
+func sampleVNDF(omega m.Vec3, alphaU, alphaV float32, r0, r1 float64) m.Vec3 {
+	// Transform view direction to the hemisphere configuration
+	Vh := m.Vec3Normalize(m.Vec3{alphaU * omega[0], alphaV * omega[1], omega[2]})
+
+	// Orthonormal basis
+	T1 := m.Vec3{1, 0, 0}
+
+	if lensq := Vh[0]*Vh[0] + Vh[1]*Vh[1]; lensq > 0 {
+		T1 = m.Vec3Scale(1/m.Sqrt(lensq), m.Vec3{-Vh[1], Vh[0], 0})
+	}
+
+	T2 := m.Vec3Cross(Vh, T1)
+
+	// Parameterization of the projected area
+	r := m.Sqrt(float32(r0))
+	phi := 2.0 * m.Pi * float32(r1)
+	t1 := r * m.Cos(phi)
+	t2 := r * m.Sin(phi)
+	s := 0.5 * (1.0 + Vh[2])
+	t2 = (1.0-s)*m.Sqrt(1.0-t1*t1) + s*t2
+
+	// Reprojection onto hemisphere
+	Nh := m.Vec3Add3(m.Vec3Scale(t1, T1), m.Vec3Scale(t2, T2), m.Vec3Scale(m.Sqrt(m.Max(0, 1-t1*t1-t2*t2)), Vh))
+
+	// Transform the normal back to the ellipsoid configuration
+	return m.Vec3Normalize(m.Vec3{alphaU * Nh[0], alphaV * Nh[1], m.Max(0, Nh[2])})
 }
 
 // Sample implements core.BSDF.
 func (b *MicrofacetGGX) Sample(r0, r1 float64) (omegaO m.Vec3) {
 
-	alpha := sqr32(b.Roughness)
+	s := sign(b.OmegaR[2])
 
-	thetaM := math.Atan2(float64(alpha)*math.Sqrt(r0), math.Sqrt(1-r0))
-	phiM := 2.0 * math.Pi * r1
+	omegaM := sampleVNDF(m.Vec3Scale(s, b.OmegaR), b.AlphaU, b.AlphaV, r0, r1)
+	omegaM = m.Vec3Scale(s, omegaM)
 
-	omegaM := m.Vec3{m.Sin(float32(thetaM)) * m.Cos(float32(phiM)),
-		m.Sin(float32(thetaM)) * m.Sin(float32(phiM)),
-		m.Cos(float32(thetaM))}
-
-	omegaO = m.Vec3Sub(m.Vec3Scale(2.0*m.Vec3DotAbs(omegaM, b.OmegaR), omegaM), b.OmegaR)
+	omegaO = m.Vec3Sub(m.Vec3Scale(2.0*m.Vec3Dot(omegaM, b.OmegaR), omegaM), b.OmegaR)
 
 	return m.Vec3BasisExpand(b.U, b.V, b.N, m.Vec3Normalize(omegaO))
 }
@@ -103,16 +141,12 @@ func (b *MicrofacetGGX) Sample(r0, r1 float64) (omegaO m.Vec3) {
 func (b *MicrofacetGGX) PDF(_omegaO m.Vec3) float64 {
 	omegaO := m.Vec3BasisProject(b.U, b.V, b.N, _omegaO)
 
-	alpha := sqr32(b.Roughness)
-
-	var omegaM m.Vec3
+	omegaM := m.Vec3Scale(sign(b.OmegaR[2]), m.Vec3Normalize(m.Vec3Add(b.OmegaR, omegaO)))
 
-	omegaM = m.Vec3Scale(sign(b.OmegaR[2]), m.Vec3Normalize(m.Vec3Add(b.OmegaR, omegaO)))
+	// D_v(m) / (4 |o.m|) where D_v = G1(r) |r.m| D(m) / |r.n|, and |r.m| == |o.m| for reflection.
+	pdf := float64(ggxSmithG1(b.OmegaR, omegaM, b.AlphaU, b.AlphaV) * ggxD(omegaM, b.AlphaU, b.AlphaV) / (4 * m.Abs(b.OmegaR[2])))
 
-	//log.Printf("D: %v", ggxD(omegaM, alpha))
-	pdf := float64(ggxD(omegaM, alpha) * omegaM[2])
-
-	if math.IsNaN(pdf) {
+	if math.IsNaN(pdf) || math.IsInf(pdf, 0) {
 		return 0
 	}
 
@@ -123,13 +157,11 @@ func (b *MicrofacetGGX) PDF(_omegaO m.Vec3) float64 {
 func (b *MicrofacetGGX) Eval(_omegaO m.Vec3) (rho colour.Spectrum) {
 	omegaI := m.Vec3BasisProject(b.U, b.V, b.N, _omegaO)
 
-	alpha := sqr32(b.Roughness)
-
 	h := m.Vec3Scale(sign(b.OmegaR[2]), m.Vec3Normalize(m.Vec3Add(b.OmegaR, omegaI)))
 
 	fresnel := b.Fresnel.Kr(m.Vec3DotAbs(b.OmegaR, h))
 
-	numer := ggxSmithG1(b.OmegaR, h, alpha) * ggxSmithG1(omegaI, h, alpha) * ggxD(h, alpha)
+	numer := ggxSmithG1(b.OmegaR, h, b.AlphaU, b.AlphaV) * ggxSmithG1(omegaI, h, b.AlphaU, b.AlphaV) * ggxD(h, b.AlphaU, b.AlphaV)
 	denom := 4 * m.Abs(b.OmegaR[2]) * m.Abs(omegaI[2])
 
 	rho.Lambda = b.Lambda
@@ -147,7 +179,6 @@ func (b *MicrofacetGGX) Eval(_omegaO m.Vec3) (rho colour.Spectrum) {
 
 // This computes the weight as per the paper, but not sure it's useful for Vermeer.
 func (b *MicrofacetGGX) _weight(omegaI m.Vec3) (rho colour.Spectrum) {
-	alpha := sqr32(b.Roughness)
 
 	var omegaM m.Vec3
 
@@ -168,7 +199,7 @@ func (b *MicrofacetGGX) _weight(omegaI m.Vec3) (rho colour.Spectrum) {
 	omegaM = m.Vec3Scale(sign(m.Vec3Dot(b.OmegaR, omegaI)), m.Vec3Normalize(m.Vec3Add(b.OmegaR, omegaI)))
 	//	}
 
-	weight = m.Vec3DotAbs(omegaI, omegaM) * ggxSmithG1(omegaI, omegaM, alpha) * ggxSmithG1(b.OmegaR, omegaM, alpha)
+	weight = m.Vec3DotAbs(omegaI, omegaM) * ggxSmithG1(omegaI, omegaM, b.AlphaU, b.AlphaV) * ggxSmithG1(b.OmegaR, omegaM, b.AlphaU, b.AlphaV)
 	weight /= m.Abs(omegaM[2]) * m.Abs(omegaI[2])
 
 	rho.Lambda = b.Lambda
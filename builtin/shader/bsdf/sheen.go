@@ -0,0 +1,147 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bsdf
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/sample"
+	"math"
+	"sync"
+)
+
+// Sheen implements the 'Charlie' sheen model for cloth and velvet.
+// Instanced for each point
+//
+// Estevez & Kulla, 'Production Friendly Microfacet Sheen BRDF', 2017.
+type Sheen struct {
+	Lambda    float32
+	OmegaI    m.Vec3
+	Roughness float32
+	U, V, N   m.Vec3 // Tangent space
+}
+
+// NewSheen returns a new instance of the model for the given parameters.
+func NewSheen(lambda float32, omegaI m.Vec3, roughness float32, U, V, N m.Vec3) *Sheen {
+	return &Sheen{lambda, m.Vec3BasisProject(U, V, N, omegaI), sheenAlpha(roughness), U, V, N}
+}
+
+// The distribution is undefined at alpha == 0.
+func sheenAlpha(roughness float32) float32 {
+	return m.Clamp(roughness*roughness, 0.001, 1)
+}
+
+func sheenD(cosThetaH, alpha float32) float32 {
+	invAlpha := 1 / alpha
+	sin2 := m.Max(0, 1-cosThetaH*cosThetaH)
+
+	return (2 + invAlpha) * m.Pow(sin2, invAlpha*0.5) / (2 * m.Pi)
+}
+
+// Ashikhmin's visibility term, cheap and avoids the fitted curves of the full Charlie model.
+func sheenV(cosI, cosO float32) float32 {
+	return 1 / (4 * (cosI + cosO - cosI*cosO))
+}
+
+func sheenEval(omegaI, omegaO m.Vec3, alpha float32) float32 {
+	if omegaI[2] <= 0 || omegaO[2] <= 0 {
+		return 0
+	}
+
+	h := m.Vec3Normalize(m.Vec3Add(omegaI, omegaO))
+
+	return omegaO[2] * sheenD(h[2], alpha) * sheenV(omegaI[2], omegaO[2])
+}
+
+// Sample implements core.BSDF.
+func (b *Sheen) Sample(r0, r1 float64) m.Vec3 {
+	return m.Vec3BasisExpand(b.U, b.V, b.N, sample.CosineHemisphere(r0, r1))
+}
+
+// PDF implements core.BSDF.
+func (b *Sheen) PDF(_omegaO m.Vec3) float64 {
+	omegaO := m.Vec3BasisProject(b.U, b.V, b.N, _omegaO)
+	ODotN := float64(m.Max(0, omegaO[2]))
+
+	return ODotN / math.Pi
+}
+
+// Eval implements core.BSDF.
+func (b *Sheen) Eval(_omegaO m.Vec3) (rho colour.Spectrum) {
+	omegaO := m.Vec3BasisProject(b.U, b.V, b.N, _omegaO)
+
+	rho.Lambda = b.Lambda
+	rho.FromRGB(colour.RGB{1, 1, 1})
+	rho.Scale(sheenEval(b.OmegaI, omegaO, b.Roughness))
+
+	return
+}
+
+const sheenAlbedoRes = 16
+
+var sheenAlbedoOnce sync.Once
+var sheenAlbedoTable [sheenAlbedoRes * sheenAlbedoRes]float32
+
+// initSheenAlbedo integrates the directional albedo of the sheen lobe for a grid of
+// view angles and roughnesses.
+func initSheenAlbedo() {
+	const n = 32
+
+	for j := 0; j < sheenAlbedoRes; j++ {
+		roughness := float32(j) / (sheenAlbedoRes - 1)
+		alpha := sheenAlpha(roughness)
+
+		for i := 0; i < sheenAlbedoRes; i++ {
+			cosTheta := m.Max(float32(i)/(sheenAlbedoRes-1), 0.001)
+			omegaI := m.Vec3{m.Sqrt(1 - cosTheta*cosTheta), 0, cosTheta}
+
+			var sum float32
+
+			for y := 0; y < n; y++ {
+				for x := 0; x < n; x++ {
+					omegaO := sample.CosineHemisphere((float64(x)+0.5)/n, (float64(y)+0.5)/n)
+
+					if omegaO[2] <= 0 {
+						continue
+					}
+
+					sum += sheenEval(omegaI, omegaO, alpha) * m.Pi / omegaO[2]
+				}
+			}
+
+			sheenAlbedoTable[i+j*sheenAlbedoRes] = m.Min(1, sum/(n*n))
+		}
+	}
+}
+
+// SheenAlbedo returns the fraction of energy reflected by the sheen lobe for the given
+// view angle cosine and roughness.  Used to attenuate lobes underneath the sheen layer.
+func SheenAlbedo(cosTheta, roughness float32) float32 {
+	sheenAlbedoOnce.Do(initSheenAlbedo)
+
+	x := m.Clamp(cosTheta, 0, 1) * (sheenAlbedoRes - 1)
+	y := m.Clamp(roughness, 0, 1) * (sheenAlbedoRes - 1)
+
+	x0 := int(m.Floor(x))
+	y0 := int(m.Floor(y))
+	x1 := x0 + 1
+	y1 := y0 + 1
+
+	if x1 > sheenAlbedoRes-1 {
+		x1 = sheenAlbedoRes - 1
+	}
+
+	if y1 > sheenAlbedoRes-1 {
+		y1 = sheenAlbedoRes - 1
+	}
+
+	dx := x - float32(x0)
+	dy := y - float32(y0)
+
+	a0 := (1-dx)*sheenAlbedoTable[x0+y0*sheenAlbedoRes] + dx*sheenAlbedoTable[x1+y0*sheenAlbedoRes]
+	a1 := (1-dx)*sheenAlbedoTable[x0+y1*sheenAlbedoRes] + dx*sheenAlbedoTable[x1+y1*sheenAlbedoRes]
+
+	return (1-dy)*a0 + dy*a1
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shader
+
+import (
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// bumpNormal returns the shading normal perturbed by the height map.  The height derivatives
+// are found by finite differences over the pixel footprint (Dduvdx, Dduvdy) and applied with
+// the surface gradient so no tangents are needed.  Without ray differentials the normal is
+// returned unchanged.
+//
+// Mikkelsen, 'Bump Mapping Unparametrized Surfaces on the GPU', 2010.
+func bumpNormal(sg *core.ShaderContext, height param.Float32Uniform, scale float32) m.Vec3 {
+	dPdx := m.Vec3Scale(sg.Image.PixelDelta[0], sg.DdPdx)
+	dPdy := m.Vec3Scale(sg.Image.PixelDelta[1], sg.DdPdy)
+	duvdx := m.Vec2Scale(sg.Image.PixelDelta[0], sg.Dduvdx)
+	duvdy := m.Vec2Scale(sg.Image.PixelDelta[1], sg.Dduvdy)
+
+	R1 := m.Vec3Cross(dPdy, sg.N)
+	R2 := m.Vec3Cross(sg.N, dPdx)
+	det := m.Vec3Dot(dPdx, R1)
+
+	if det == 0 {
+		return sg.N
+	}
+
+	P, U, V := sg.P, sg.U, sg.V
+
+	h := height.Float32(sg)
+
+	sg.P = m.Vec3Add(P, dPdx)
+	sg.U = U + duvdx[0]
+	sg.V = V + duvdx[1]
+	dhdx := scale * (height.Float32(sg) - h)
+
+	sg.P = m.Vec3Add(P, dPdy)
+	sg.U = U + duvdy[0]
+	sg.V = V + duvdy[1]
+	dhdy := scale * (height.Float32(sg) - h)
+
+	sg.P, sg.U, sg.V = P, U, V
+
+	grad := m.Vec3Scale(sign(det), m.Vec3Add(m.Vec3Scale(dhdx, R1), m.Vec3Scale(dhdy, R2)))
+
+	return clampNormal(m.Vec3Normalize(m.Vec3Sub(m.Vec3Scale(m.Abs(det), sg.N), grad)), sg.N, sg.Ng)
+}
+
+// normalMapNormal returns the shading normal replaced by the tangent space normal map, with
+// DdPdu and DdPdv giving the tangent and bitangent.  The map is encoded in [0,1] with the
+// usual (OpenGL, +Y) convention.  strength blends between the surface and mapped normals.
+func normalMapNormal(sg *core.ShaderContext, normalMap param.RGBUniform, strength float32) m.Vec3 {
+	c := normalMap.RGB(sg)
+
+	tx := (2*c[0] - 1) * strength
+	ty := (2*c[1] - 1) * strength
+	tz := 1 + (2*c[2]-2)*strength
+
+	N := m.Vec3Add3(m.Vec3Scale(tx, sg.DdPdu), m.Vec3Scale(ty, sg.DdPdv), m.Vec3Scale(tz, sg.N))
+
+	if m.Vec3Length2(N) == 0 {
+		return sg.N
+	}
+
+	return clampNormal(m.Vec3Normalize(N), sg.N, sg.Ng)
+}
+
+// clampNormal bends the perturbed normal N back above the surface, on the side of Ng that the
+// unperturbed normal N0 is on.  Strong bumps can otherwise tilt N past the tangent plane so
+// lights and reflections come from inside the surface.
+func clampNormal(N, N0, Ng m.Vec3) m.Vec3 {
+	const minCos = 0.01
+
+	if m.Vec3Dot(N0, Ng) < 0 {
+		Ng = m.Vec3Neg(Ng)
+	}
+
+	if d := m.Vec3Dot(N, Ng); d < minCos {
+		N = m.Vec3Normalize(m.Vec3Add(N, m.Vec3Scale(minCos-d, Ng)))
+	}
+
+	return N
+}
+
+func sign(v float32) float32 {
+	if v < 0 {
+		return -1
+	}
+
+	return 1
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shader
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"testing"
+)
+
+// heightMap is a height of A + B*U.
+type heightMap struct{ A, B float32 }
+
+func (h heightMap) Float32(sg *core.ShaderContext) float32 { return h.A + h.B*sg.U }
+
+type rgbMap colour.RGB
+
+func (c rgbMap) RGB(sg *core.ShaderContext) colour.RGB { return colour.RGB(c) }
+
+// newFlatContext returns a context for the z=0 plane with U along x and V along y, N is the
+// shading normal.
+func newFlatContext(N m.Vec3) *core.ShaderContext {
+	return &core.ShaderContext{
+		N:      m.Vec3Normalize(N),
+		Ng:     m.Vec3{0, 0, 1},
+		DdPdx:  m.Vec3{1, 0, 0},
+		DdPdy:  m.Vec3{0, 1, 0},
+		Dduvdx: m.Vec2{1, 0},
+		Dduvdy: m.Vec2{0, 1},
+		Image:  &core.Image{PixelDelta: [2]float32{0.01, 0.01}},
+	}
+}
+
+func TestBumpNormal(t *testing.T) {
+	var tests = []struct {
+		name     string
+		N        m.Vec3
+		height   heightMap
+		expected m.Vec3
+	}{
+		{"constant", m.Vec3{0, 0, 1}, heightMap{0.3, 0}, m.Vec3{0, 0, 1}},
+		{"constant tilted", m.Vec3{1, 0, 1}, heightMap{0.3, 0}, m.Vec3Normalize(m.Vec3{1, 0, 1})},
+		// Height rising along +x tilts the normal back along -x.
+		{"ramp", m.Vec3{0, 0, 1}, heightMap{0, 1}, m.Vec3Normalize(m.Vec3{-1, 0, 1})},
+	}
+
+	for _, test := range tests {
+		sg := newFlatContext(test.N)
+
+		N := bumpNormal(sg, test.height, 1)
+
+		if m.Vec3Length(m.Vec3Sub(N, test.expected)) > 1e-4 {
+			t.Errorf("%v: N %v, expected %v", test.name, N, test.expected)
+		}
+	}
+}
+
+func TestPerturbedNormalAboveSurface(t *testing.T) {
+	var tests = []struct {
+		name     string
+		N, Ng, T m.Vec3
+		normal   rgbMap // Tangent space normal map
+	}{
+		// The map points along the tangent, which dips below the surface for a tilted N.
+		{"tangent", m.Vec3{1, 0, 1}, m.Vec3{0, 0, 1}, m.Vec3{1, 0, 0}, rgbMap{1, 0.5, 0.5}},
+		{"back face", m.Vec3{-1, 0, -1}, m.Vec3{0, 0, 1}, m.Vec3{-1, 0, 0}, rgbMap{1, 0.5, 0.5}},
+		{"into surface", m.Vec3{0, 0, 1}, m.Vec3{0, 0, 1}, m.Vec3{1, 0, 0}, rgbMap{0.5, 0.5, 0}},
+	}
+
+	for _, test := range tests {
+		sg := newFlatContext(test.N)
+		sg.Ng = test.Ng
+		sg.DdPdu = test.T
+		sg.DdPdu, sg.DdPdv = tangentFrame(sg, nil)
+
+		N := normalMapNormal(sg, test.normal, 1)
+
+		if m.Abs(m.Vec3Length(N)-1) > 1e-5 {
+			t.Errorf("%v: N %v is not normalized", test.name, N)
+		}
+
+		if m.Vec3Dot(N, test.Ng)*m.Vec3Dot(sg.N, test.Ng) <= 0 {
+			t.Errorf("%v: N %v is below the surface (Ng %v, unperturbed %v)", test.name, N, test.Ng, sg.N)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shader
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/ldseq"
+	"math"
+)
+
+// Kinds of lobe in a closure.
+const (
+	lobeDiffuse = iota // Skipped for lights with no diffuse contribution
+	lobeGlossy
+	lobeMirror // Traced rather than lit
+)
+
+// closure collects the weighted lobes of one or more shaders at a shading point so they can be
+// lit together.  All of the diffuse and glossy lobes are lit with one set of samples per light
+// using a core.MixBSDF.
+type closure struct {
+	diffuse []core.BSDFLobe
+	glossy  []core.BSDFLobe
+	mirror  []core.BSDFLobe
+
+	other colour.RGB // Emission and results of shaders which can't be layered
+	alpha float32
+
+	mix core.MixBSDF
+}
+
+// layerShader is implemented by shaders which can add their lobes to a closure, so that they
+// can be used in MixShader and LayerShader.
+type layerShader interface {
+	core.Shader
+
+	// closure adds the lobes at sg to cl scaled by weight.  May change sg.N.
+	closure(sg *core.ShaderContext, weight float32, cl *closure)
+}
+
+// addLobe adds the BSDF with the given tint, lobes with no weight are dropped.
+func (cl *closure) addLobe(bsdf core.BSDF, weight colour.RGB, kind int) {
+	if weight.Maxh() <= 0 {
+		return
+	}
+
+	lobe := core.BSDFLobe{BSDF: bsdf, Weight: weight}
+
+	switch kind {
+	case lobeDiffuse:
+		cl.diffuse = append(cl.diffuse, lobe)
+	case lobeGlossy:
+		cl.glossy = append(cl.glossy, lobe)
+	case lobeMirror:
+		cl.mirror = append(cl.mirror, lobe)
+	}
+}
+
+// addShader adds the shader at sg scaled by weight.  Shaders which can't be layered are
+// evaluated and their colour and alpha blended.  sg.N is restored afterwards.
+func (cl *closure) addShader(sg *core.ShaderContext, sh core.Shader, weight float32) {
+	if weight <= 0 {
+		return
+	}
+
+	N := sg.N
+
+	if l, ok := sh.(layerShader); ok {
+		l.closure(sg, weight, cl)
+	} else {
+		sg.OutRGB = colour.RGB{}
+		sg.OutAlpha = 1
+
+		sh.Eval(sg)
+
+		sg.OutRGB.Scale(weight)
+		cl.other.Add(sg.OutRGB)
+		cl.alpha += weight * sg.OutAlpha
+	}
+
+	sg.N = N
+}
+
+// eval lights and traces the lobes and sets sg.OutRGB and sg.OutAlpha.
+func (cl *closure) eval(sg *core.ShaderContext) {
+	contrib := cl.other
+
+	if len(cl.diffuse)+len(cl.glossy) > 0 {
+		sg.LightsPrepare()
+
+		for sg.NextLight() {
+			cl.mix.Lobes = append(cl.mix.Lobes[:0], cl.glossy...)
+
+			if sg.Lp.DiffuseShadeMult() > 0.0 {
+				cl.mix.Lobes = append(cl.mix.Lobes, cl.diffuse...)
+			}
+
+			if len(cl.mix.Lobes) == 0 {
+				continue
+			}
+
+			contrib.Add(sg.EvaluateLightSamples(&cl.mix))
+		}
+	}
+
+	for _, lobe := range cl.mirror {
+		c := traceSpecular(sg, lobe.BSDF)
+		c.Mul(lobe.Weight)
+		contrib.Add(c)
+	}
+
+	sg.OutRGB = contrib
+	sg.OutAlpha = cl.alpha
+}
+
+// traceSpecular returns the unweighted contribution of a mirror lobe by tracing a reflection
+// ray.
+func traceSpecular(sg *core.ShaderContext, specBRDF core.BSDF) (contrib colour.RGB) {
+	var samp core.TraceSample
+	ray := sg.NewRay()
+
+	specSamples := 1
+
+	for i := 0; i < specSamples; i++ {
+		idx := uint64(sg.I*specSamples /*+ sg.Sample*/ + i)
+		r0 := ldseq.VanDerCorput(idx, sg.Scramble[0])
+		r1 := ldseq.Sobol(idx, sg.Scramble[1])
+
+		specOmegaO := specBRDF.Sample(r0, r1)
+		pdf := specBRDF.PDF(specOmegaO)
+
+		if m.Vec3Dot(specOmegaO, sg.Ng) <= 0.0 {
+			continue
+
+		}
+
+		ray.Init(core.RayTypeReflected, sg.OffsetP(1), specOmegaO, m.Inf(1), sg.Level+1, sg)
+
+		if core.Trace(ray, &samp) {
+
+			rho := specBRDF.Eval(specOmegaO)
+
+			rho.Scale(1.0 / float32(pdf))
+
+			col := rho.ToRGB()
+
+			col.Mul(samp.Colour)
+
+			for k := range col {
+				if col[k] < 0 || math.IsNaN(float64(col[k])) {
+					col[k] = 0
+				}
+			}
+			contrib.Add(col)
+		}
+
+	}
+
+	sg.ReleaseRay(ray)
+
+	contrib.Scale(1.0 / float32(specSamples))
+
+	return
+}
@@ -5,6 +5,7 @@
 package shader
 
 import (
+	"github.com/jamiec7919/vermeer/builtin/maps"
 	"github.com/jamiec7919/vermeer/colour"
 	"github.com/jamiec7919/vermeer/core"
 	"github.com/jamiec7919/vermeer/core/param"
@@ -33,7 +34,7 @@ func (sh *Debug) Def() core.NodeDef { return sh.NodeDef }
 
 // PreRender is a core.Node method.
 func (sh *Debug) PreRender() error {
-	return nil
+	return maps.ResolveFields(sh)
 }
 
 // PostRender is a core.Node method.
@@ -0,0 +1,193 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shader
+
+import (
+	"github.com/jamiec7919/vermeer/builtin/maps"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/nodes"
+)
+
+// ShadowCatcher renders invisible except for the shadows and reflections of CG objects, for
+// compositing over a background plate.
+//
+// Shadows are output in alpha with black colour, so compositing "over" the plate darkens it by
+// the fraction of light blocked.  Reflections are added premultiplied with their coverage.
+// Seen by secondary rays the catcher is black.
+type ShadowCatcher struct {
+	NodeDef core.NodeDef `node:"-"`
+	MtlName string       `node:"Name"`
+
+	ShadowStrength     param.Float32Uniform `node:",opt"` // Scales the shadow alpha, default 1
+	ReflectionStrength param.Float32Uniform `node:",opt"` // Mirror reflection weight, default 0
+}
+
+// Assert that ShadowCatcher satisfies important interfaces.
+var _ core.Node = (*ShadowCatcher)(nil)
+var _ core.Shader = (*ShadowCatcher)(nil)
+
+// Name is a core.Node method.
+func (sh *ShadowCatcher) Name() string { return sh.MtlName }
+
+// Def is a core.Node method.
+func (sh *ShadowCatcher) Def() core.NodeDef { return sh.NodeDef }
+
+// PreRender is a core.Node method.
+func (sh *ShadowCatcher) PreRender() error { return maps.ResolveFields(sh) }
+
+// PostRender is a core.Node method.
+func (sh *ShadowCatcher) PostRender() error { return nil }
+
+// Eval implements core.Shader.
+func (sh *ShadowCatcher) Eval(sg *core.ShaderContext) {
+	sg.OutRGB = colour.RGB{}
+	sg.OutAlpha = 0
+
+	if sg.Level > 0 {
+		return
+	}
+
+	shadow := m.Clamp(float32Param(sh.ShadowStrength, sg, 1)*shadowFraction(sg), 0, 1)
+
+	var refl colour.RGB
+	var reflAlpha float32
+
+	if strength := float32Param(sh.ReflectionStrength, sg, 0); strength > 0 {
+		refl, reflAlpha = traceMirror(sg)
+		refl.Scale(strength)
+		reflAlpha *= strength
+	}
+
+	sg.OutRGB = refl
+	sg.OutAlpha = 1 - (1-shadow)*(1-reflAlpha)
+}
+
+// EvalEmission implements core.Shader.
+func (sh *ShadowCatcher) EvalEmission(sg *core.ShaderContext, omegaO m.Vec3) colour.RGB {
+	return colour.RGB{}
+}
+
+// shadowFraction returns the fraction of the (cosine weighted) direct light at sg which is
+// blocked by other objects.
+func shadowFraction(sg *core.ShaderContext) float32 {
+	var unshadowed, shadowed float32
+
+	ray := sg.NewRay()
+	chsc := sg.NewShaderContext()
+
+	sg.LightsPrepare()
+
+	for sg.NextLight() {
+		if sg.Lp.DiffuseShadeMult() <= 0.0 {
+			continue
+		}
+
+		sg.Lsamples = sg.Lsamples[:0]
+
+		if err := sg.Lp.SampleArea(sg, sg.NSamples); err != nil {
+			continue
+		}
+
+		for _, ls := range sg.Lsamples {
+			cosTheta := m.Vec3Dot(ls.Ld, sg.N)
+
+			if cosTheta <= 0 || ls.Pdf <= 0 {
+				continue
+			}
+
+			rgb := ls.Liu.ToRGB()
+			e := rgb.Luminance() * cosTheta / ls.Pdf
+
+			unshadowed += e
+
+			ray.Init(core.RayTypeShadow, sg.OffsetP(1), m.Vec3Scale(ls.Ldist*(1.0-core.ShadowRayEpsilon), ls.Ld), 1.0, 0, sg)
+
+			if !core.TraceProbe(ray, chsc) {
+				shadowed += e * ray.Transmission.Luminance()
+			}
+		}
+	}
+
+	sg.ReleaseRay(ray)
+	sg.ReleaseShaderContext(chsc)
+
+	if unshadowed <= 0 {
+		return 0
+	}
+
+	return 1 - shadowed/unshadowed
+}
+
+// traceMirror traces the mirror reflection at sg, returning the colour and alpha of whatever is
+// hit.
+func traceMirror(sg *core.ShaderContext) (colour.RGB, float32) {
+	D := m.Vec3Sub(sg.Rd, m.Vec3Scale(2*m.Vec3Dot(sg.Rd, sg.N), sg.N))
+
+	if m.Vec3Dot(D, sg.Ng) <= 0 {
+		return colour.RGB{}, 0
+	}
+
+	var samp core.TraceSample
+
+	ray := sg.NewRay()
+	ray.Init(core.RayTypeReflected, sg.OffsetP(1), D, m.Inf(1), sg.Level+1, sg)
+
+	hit := core.Trace(ray, &samp)
+
+	sg.ReleaseRay(ray)
+
+	if !hit {
+		return colour.RGB{}, 0
+	}
+
+	return samp.Colour, samp.Alpha
+}
+
+// Holdout cuts a hole with zero alpha wherever it is seen, for objects which are in the
+// background plate but must hide CG objects behind them.  Holdouts still cast shadows.
+type Holdout struct {
+	NodeDef core.NodeDef `node:"-"`
+	MtlName string       `node:"Name"`
+}
+
+// Assert that Holdout satisfies important interfaces.
+var _ core.Node = (*Holdout)(nil)
+var _ core.Shader = (*Holdout)(nil)
+
+// Name is a core.Node method.
+func (sh *Holdout) Name() string { return sh.MtlName }
+
+// Def is a core.Node method.
+func (sh *Holdout) Def() core.NodeDef { return sh.NodeDef }
+
+// PreRender is a core.Node method.
+func (sh *Holdout) PreRender() error { return nil }
+
+// PostRender is a core.Node method.
+func (sh *Holdout) PostRender() error { return nil }
+
+// Eval implements core.Shader.
+func (sh *Holdout) Eval(sg *core.ShaderContext) {
+	sg.OutRGB = colour.RGB{}
+	sg.OutAlpha = 0
+}
+
+// EvalEmission implements core.Shader.
+func (sh *Holdout) EvalEmission(sg *core.ShaderContext, omegaO m.Vec3) colour.RGB {
+	return colour.RGB{}
+}
+
+func init() {
+	nodes.Register("ShadowCatcher", func() (core.Node, error) {
+		return &ShadowCatcher{}, nil
+	})
+
+	nodes.Register("Holdout", func() (core.Node, error) {
+		return &Holdout{}, nil
+	})
+}
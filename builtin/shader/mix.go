@@ -0,0 +1,404 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shader
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/builtin/maps"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/nodes"
+	"strings"
+)
+
+// findShader returns the shader node with the given name.
+func findShader(name string) (core.Shader, error) {
+	node := core.FindNode(name)
+
+	if node == nil {
+		return nil, fmt.Errorf("Unable to find node (shader %v)", name)
+	}
+
+	shader, ok := node.(core.Shader)
+
+	if !ok {
+		return nil, fmt.Errorf("Unable to find shader %v", name)
+	}
+
+	return shader, nil
+}
+
+// shaderRefs returns the names of the shaders used by a MixShader or LayerShader node.
+func shaderRefs(node core.Node) []string {
+	switch t := node.(type) {
+	case *MixShader:
+		return []string{t.A, t.B}
+	case *LayerShader:
+		var refs []string
+
+		for _, name := range []string{t.Layer1, t.Layer2, t.Layer3, t.Layer4} {
+			if name != "" {
+				refs = append(refs, name)
+			}
+		}
+
+		return refs
+	}
+
+	return nil
+}
+
+// checkShaderCycle follows the shaders used by node (named name) depth first and returns an
+// error if one leads back to a shader on the current path, path holds the names leading to node
+// and done the shaders already checked.
+func checkShaderCycle(name string, node core.Node, path []string, done map[string]bool) error {
+	for i := range path {
+		if path[i] == name {
+			return fmt.Errorf("Shader cycle: %v", strings.Join(append(path[i:], name), " -> "))
+		}
+	}
+
+	if done[name] {
+		return nil
+	}
+
+	path = append(path, name)
+
+	for _, ref := range shaderRefs(node) {
+		if next := core.FindNode(ref); next != nil {
+			if err := checkShaderCycle(ref, next, path, done); err != nil {
+				return err
+			}
+		}
+	}
+
+	done[name] = true
+
+	return nil
+}
+
+// hasOpacity returns true if any of the shaders has opacity.
+func hasOpacity(shaders ...core.Shader) bool {
+	for _, shader := range shaders {
+		if op, ok := shader.(core.OpacityShader); ok && op.HasOpacity() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shaderOpacity returns the opacity of shader, 1 if it has none.
+func shaderOpacity(sg *core.ShaderContext, shader core.Shader) colour.RGB {
+	if op, ok := shader.(core.OpacityShader); ok && op.HasOpacity() {
+		return op.EvalOpacity(sg)
+	}
+
+	return colour.RGB{1, 1, 1}
+}
+
+// MixShader blends shader B over shader A.  The lobes of both are lit together so mixing
+// costs little more than a single shader.
+type MixShader struct {
+	NodeDef core.NodeDef `node:"-"`
+	MtlName string       `node:"Name"`
+
+	A, B string               // Shader names
+	Mix  param.Float32Uniform `node:",opt"` // Amount of B, default 0.5
+
+	a, b core.Shader
+}
+
+// Assert that MixShader satisfies important interfaces.
+var _ core.Node = (*MixShader)(nil)
+var _ core.Shader = (*MixShader)(nil)
+var _ core.OpacityShader = (*MixShader)(nil)
+var _ layerShader = (*MixShader)(nil)
+
+// Name is a core.Node method.
+func (sh *MixShader) Name() string { return sh.MtlName }
+
+// Def is a core.Node method.
+func (sh *MixShader) Def() core.NodeDef { return sh.NodeDef }
+
+// PreRender is a core.Node method.
+func (sh *MixShader) PreRender() error {
+	if err := sh.resolve(); err != nil {
+		return err
+	}
+
+	return maps.ResolveFields(sh)
+}
+
+// PostRender is a core.Node method.
+func (sh *MixShader) PostRender() error { return nil }
+
+// resolve looks up the shaders and checks they don't use this shader again.  Called by
+// HasOpacity as well as PreRender as geoms may be set up first.
+func (sh *MixShader) resolve() error {
+	if sh.a != nil && sh.b != nil {
+		return nil
+	}
+
+	if err := checkShaderCycle(sh.MtlName, sh, nil, map[string]bool{}); err != nil {
+		return err
+	}
+
+	a, err := findShader(sh.A)
+
+	if err != nil {
+		return err
+	}
+
+	b, err := findShader(sh.B)
+
+	if err != nil {
+		return err
+	}
+
+	sh.a, sh.b = a, b
+
+	return nil
+}
+
+// mix returns the amount of B at sg.
+func (sh *MixShader) mix(sg *core.ShaderContext) float32 {
+	return m.Clamp(float32Param(sh.Mix, sg, 0.5), 0, 1)
+}
+
+// HasOpacity implements core.OpacityShader.
+func (sh *MixShader) HasOpacity() bool {
+	if sh.resolve() != nil {
+		return false
+	}
+
+	return hasOpacity(sh.a, sh.b)
+}
+
+// EvalOpacity implements core.OpacityShader.
+func (sh *MixShader) EvalOpacity(sg *core.ShaderContext) colour.RGB {
+	mix := sh.mix(sg)
+
+	a := shaderOpacity(sg, sh.a)
+	b := shaderOpacity(sg, sh.b)
+
+	for k := range a {
+		a[k] = (1-mix)*a[k] + mix*b[k]
+	}
+
+	return a
+}
+
+// Eval implements core.Shader.
+func (sh *MixShader) Eval(sg *core.ShaderContext) {
+	var cl closure
+
+	sh.closure(sg, 1, &cl)
+	cl.eval(sg)
+}
+
+// closure implements layerShader.
+func (sh *MixShader) closure(sg *core.ShaderContext, weight float32, cl *closure) {
+	mix := sh.mix(sg)
+
+	cl.addShader(sg, sh.a, weight*(1-mix))
+	cl.addShader(sg, sh.b, weight*mix)
+}
+
+// EvalEmission implements core.Shader.
+func (sh *MixShader) EvalEmission(sg *core.ShaderContext, omegaO m.Vec3) colour.RGB {
+	mix := sh.mix(sg)
+
+	a := sh.a.EvalEmission(sg, omegaO)
+	b := sh.b.EvalEmission(sg, omegaO)
+
+	a.Scale(1 - mix)
+	b.Scale(mix)
+	a.Add(b)
+
+	return a
+}
+
+// LayerShader stacks up to four shaders, each layer covers those below it by its weight.  Layer1
+// is the bottom.  The lobes of all layers are lit together.
+type LayerShader struct {
+	NodeDef core.NodeDef `node:"-"`
+	MtlName string       `node:"Name"`
+
+	Layer1  string               `node:",opt"` // Shader names
+	Weight1 param.Float32Uniform `node:",opt"` // Coverage, default 1
+	Layer2  string               `node:",opt"`
+	Weight2 param.Float32Uniform `node:",opt"`
+	Layer3  string               `node:",opt"`
+	Weight3 param.Float32Uniform `node:",opt"`
+	Layer4  string               `node:",opt"`
+	Weight4 param.Float32Uniform `node:",opt"`
+
+	layers  []core.Shader
+	weights []param.Float32Uniform
+}
+
+// Assert that LayerShader satisfies important interfaces.
+var _ core.Node = (*LayerShader)(nil)
+var _ core.Shader = (*LayerShader)(nil)
+var _ core.OpacityShader = (*LayerShader)(nil)
+var _ layerShader = (*LayerShader)(nil)
+
+// Name is a core.Node method.
+func (sh *LayerShader) Name() string { return sh.MtlName }
+
+// Def is a core.Node method.
+func (sh *LayerShader) Def() core.NodeDef { return sh.NodeDef }
+
+// PreRender is a core.Node method.
+func (sh *LayerShader) PreRender() error {
+	if err := sh.resolve(); err != nil {
+		return err
+	}
+
+	return maps.ResolveFields(sh)
+}
+
+// PostRender is a core.Node method.
+func (sh *LayerShader) PostRender() error { return nil }
+
+// resolve looks up the shaders of the layers which are given and checks they don't use this
+// shader again.  Called by HasOpacity as well as PreRender as geoms may be set up first.
+func (sh *LayerShader) resolve() error {
+	if sh.layers != nil {
+		return nil
+	}
+
+	if err := checkShaderCycle(sh.MtlName, sh, nil, map[string]bool{}); err != nil {
+		return err
+	}
+
+	names := []string{sh.Layer1, sh.Layer2, sh.Layer3, sh.Layer4}
+	weights := []param.Float32Uniform{sh.Weight1, sh.Weight2, sh.Weight3, sh.Weight4}
+
+	var layers []core.Shader
+	var layerWeights []param.Float32Uniform
+
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+
+		shader, err := findShader(name)
+
+		if err != nil {
+			return err
+		}
+
+		layers = append(layers, shader)
+		layerWeights = append(layerWeights, weights[i])
+	}
+
+	if len(layers) == 0 {
+		return fmt.Errorf("LayerShader %v has no layers", sh.MtlName)
+	}
+
+	sh.layers, sh.weights = layers, layerWeights
+
+	return nil
+}
+
+// layerWeights returns the visible fraction of each layer at sg, working down from the top.
+func (sh *LayerShader) layerWeights(sg *core.ShaderContext, weight float32, w []float32) []float32 {
+	w = w[:0]
+
+	for range sh.layers {
+		w = append(w, 0)
+	}
+
+	cover := weight
+
+	for i := len(sh.layers) - 1; i >= 0 && cover > 0; i-- {
+		f := m.Clamp(float32Param(sh.weights[i], sg, 1), 0, 1)
+
+		w[i] = cover * f
+		cover *= 1 - f
+	}
+
+	return w
+}
+
+// HasOpacity implements core.OpacityShader.
+func (sh *LayerShader) HasOpacity() bool {
+	if sh.resolve() != nil {
+		return false
+	}
+
+	return hasOpacity(sh.layers...)
+}
+
+// EvalOpacity implements core.OpacityShader.  The weights only blend the layers so the
+// opacity is normalised by the total coverage, uncovered parts don't make the surface
+// transparent.
+func (sh *LayerShader) EvalOpacity(sg *core.ShaderContext) (opacity colour.RGB) {
+	var buf [4]float32
+	var total float32
+
+	for i, w := range sh.layerWeights(sg, 1, buf[:]) {
+		if w > 0 {
+			op := shaderOpacity(sg, sh.layers[i])
+			op.Scale(w)
+			opacity.Add(op)
+			total += w
+		}
+	}
+
+	if total <= 0 {
+		return colour.RGB{1, 1, 1}
+	}
+
+	opacity.Scale(1 / total)
+
+	return
+}
+
+// Eval implements core.Shader.
+func (sh *LayerShader) Eval(sg *core.ShaderContext) {
+	var cl closure
+
+	sh.closure(sg, 1, &cl)
+	cl.eval(sg)
+}
+
+// closure implements layerShader.
+func (sh *LayerShader) closure(sg *core.ShaderContext, weight float32, cl *closure) {
+	var buf [4]float32
+
+	for i, w := range sh.layerWeights(sg, weight, buf[:]) {
+		cl.addShader(sg, sh.layers[i], w)
+	}
+}
+
+// EvalEmission implements core.Shader.
+func (sh *LayerShader) EvalEmission(sg *core.ShaderContext, omegaO m.Vec3) (emission colour.RGB) {
+	var buf [4]float32
+
+	for i, w := range sh.layerWeights(sg, 1, buf[:]) {
+		if w > 0 {
+			e := sh.layers[i].EvalEmission(sg, omegaO)
+			e.Scale(w)
+			emission.Add(e)
+		}
+	}
+
+	return
+}
+
+func init() {
+	nodes.Register("MixShader", func() (core.Node, error) {
+		return &MixShader{}, nil
+	})
+
+	nodes.Register("LayerShader", func() (core.Node, error) {
+		return &LayerShader{}, nil
+	})
+}
@@ -0,0 +1,197 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shader
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"strings"
+	"testing"
+)
+
+// opaqueShader is a shader node with a constant opacity.
+type opaqueShader struct {
+	MtlName string
+	Opacity colour.RGB
+}
+
+func (sh *opaqueShader) Name() string                { return sh.MtlName }
+func (sh *opaqueShader) Def() core.NodeDef           { return core.NodeDef{} }
+func (sh *opaqueShader) PreRender() error            { return nil }
+func (sh *opaqueShader) PostRender() error           { return nil }
+func (sh *opaqueShader) Eval(sg *core.ShaderContext) {}
+func (sh *opaqueShader) HasOpacity() bool            { return true }
+
+func (sh *opaqueShader) EvalOpacity(sg *core.ShaderContext) colour.RGB { return sh.Opacity }
+
+func (sh *opaqueShader) EvalEmission(sg *core.ShaderContext, omegaO m.Vec3) colour.RGB {
+	return colour.RGB{}
+}
+
+// newScene starts a new scene with a ShaderStd named std and the given nodes.
+func newScene(nodes ...core.Node) {
+	core.Init(nil)
+	core.AddNode(&ShaderStd{MtlName: "std"})
+
+	for _, n := range nodes {
+		core.AddNode(n)
+	}
+}
+
+func TestMixShaderResolve(t *testing.T) {
+	mix := &MixShader{MtlName: "m1", A: "std", B: "l1"}
+	newScene(mix, &LayerShader{MtlName: "l1", Layer1: "std", Layer2: "std"})
+
+	if err := mix.PreRender(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shaders may be used more than once without being a cycle.
+	newScene(mix, &LayerShader{MtlName: "l1", Layer1: "m2", Layer2: "m2"}, &MixShader{MtlName: "m2", A: "std", B: "std"})
+	mix.a, mix.b = nil, nil
+
+	if err := mix.PreRender(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShaderCycle(t *testing.T) {
+	// The first node of each scene is on the cycle, or uses a shader which is.
+	scenes := []struct {
+		cycle string
+		nodes []core.Node
+	}{
+		{"m1 -> m1", []core.Node{
+			&MixShader{MtlName: "m1", A: "std", B: "m1"},
+		}},
+		{"m1 -> l1 -> m1", []core.Node{
+			&MixShader{MtlName: "m1", A: "std", B: "l1"},
+			&LayerShader{MtlName: "l1", Layer1: "std", Layer2: "m1"},
+		}},
+		{"m1 -> m2 -> m1", []core.Node{
+			&LayerShader{MtlName: "l1", Layer1: "m1"},
+			&MixShader{MtlName: "m1", A: "std", B: "m2"},
+			&MixShader{MtlName: "m2", A: "m1", B: "std"},
+		}},
+	}
+
+	for _, scene := range scenes {
+		newScene(scene.nodes...)
+
+		// Geoms call HasOpacity before PreRender, it must return rather than recurse.
+		if scene.nodes[0].(core.OpacityShader).HasOpacity() {
+			t.Errorf("%v: HasOpacity with a cycle", scene.cycle)
+		}
+
+		err := scene.nodes[0].PreRender()
+
+		if err == nil || !strings.Contains(err.Error(), "Shader cycle: "+scene.cycle) {
+			t.Errorf("%v: error %v", scene.cycle, err)
+		}
+	}
+}
+
+// constBSDF has a constant PDF, Sample returns the lobe's id in X and the rescaled r0 in Y.
+type constBSDF struct {
+	id  float32
+	pdf float64
+}
+
+func (b constBSDF) Sample(r0, r1 float64) m.Vec3       { return m.Vec3{b.id, float32(r0), 0} }
+func (b constBSDF) Eval(omegaO m.Vec3) colour.Spectrum { return colour.Spectrum{} }
+func (b constBSDF) PDF(omegaO m.Vec3) float64          { return b.pdf }
+
+func TestMixBSDFPDF(t *testing.T) {
+	// Lobes are picked by their average weight, 0.3 and 0.2 here.  Lobes with no weight are
+	// never picked so their PDF doesn't count.
+	mix := core.MixBSDF{Lobes: []core.BSDFLobe{
+		{BSDF: constBSDF{1, 2}, Weight: colour.RGB{0.3, 0.3, 0.3}},
+		{BSDF: constBSDF{2, 100}, Weight: colour.RGB{}},
+		{BSDF: constBSDF{3, 0.5}, Weight: colour.RGB{0.6, 0, 0}},
+		{BSDF: constBSDF{4, 100}, Weight: colour.RGB{-1, -1, -1}},
+	}}
+
+	if pdf, expected := mix.PDF(m.Vec3{0, 0, 1}), 0.6*2+0.4*0.5; m.Abs(float32(pdf-expected)) > 1e-6 {
+		t.Errorf("PDF %v, expected %v", pdf, expected)
+	}
+
+	samples := []struct {
+		r0       float64
+		lobe     float32
+		rescaled float64
+	}{
+		{0, 1, 0},
+		{0.3, 1, 0.5},
+		{0.65, 3, 0.125},
+		{0.8, 3, 0.5},
+		{0.999, 3, 0.9975},
+	}
+
+	for _, s := range samples {
+		d := mix.Sample(s.r0, 0)
+
+		if d[0] != s.lobe || m.Abs(d[1]-float32(s.rescaled)) > 1e-5 {
+			t.Errorf("Sample(%v) picked lobe %v with %v, expected %v with %v", s.r0, d[0], d[1], s.lobe, s.rescaled)
+		}
+	}
+
+	if pdf := (&core.MixBSDF{}).PDF(m.Vec3{0, 0, 1}); pdf != 0 {
+		t.Errorf("empty PDF %v", pdf)
+	}
+}
+
+func TestLayerShaderCoverage(t *testing.T) {
+	opaque := &opaqueShader{"opaque", colour.RGB{1, 1, 1}}
+	transparent := &opaqueShader{"clear", colour.RGB{}}
+
+	layers := []struct {
+		name    string
+		layer   LayerShader
+		weights []float32 // Visible fraction of each layer, bottom first
+		opacity float32
+	}{
+		{"full", LayerShader{Layer1: "opaque", Layer2: "clear", Weight2: heightMap{A: 0.25}},
+			[]float32{0.75, 0.25}, 0.75},
+		{"top covers", LayerShader{Layer1: "opaque", Layer2: "clear"},
+			[]float32{0, 1}, 0},
+		// The uncovered half is normalised away, it isn't see through.
+		{"partial", LayerShader{Layer1: "opaque", Weight1: heightMap{A: 0.5}, Layer3: "opaque", Weight3: heightMap{A: 0.5}},
+			[]float32{0.25, 0.5}, 1},
+		{"partial clear", LayerShader{Layer1: "clear", Weight1: heightMap{A: 0.5}, Layer2: "opaque", Weight2: heightMap{A: 0.5}},
+			[]float32{0.25, 0.5}, 2.0 / 3},
+		{"uncovered", LayerShader{Layer1: "clear", Weight1: heightMap{}},
+			[]float32{0}, 1},
+	}
+
+	for _, test := range layers {
+		sh := test.layer
+		sh.MtlName = test.name
+		newScene(opaque, transparent, &sh)
+
+		if err := sh.PreRender(); err != nil {
+			t.Fatal(err)
+		}
+
+		sg := &core.ShaderContext{}
+		w := sh.layerWeights(sg, 1, nil)
+
+		if len(w) != len(test.weights) {
+			t.Errorf("%v: weights %v, expected %v", test.name, w, test.weights)
+			continue
+		}
+
+		for i := range w {
+			if m.Abs(w[i]-test.weights[i]) > 1e-6 {
+				t.Errorf("%v: weights %v, expected %v", test.name, w, test.weights)
+				break
+			}
+		}
+
+		if op := sh.EvalOpacity(sg); m.Abs(op[0]-test.opacity) > 1e-6 || op[0] != op[1] || op[0] != op[2] {
+			t.Errorf("%v: opacity %v, expected %v", test.name, op, test.opacity)
+		}
+	}
+}
@@ -9,16 +9,14 @@ This package is in heavy development so documentation somewhat sketchy. */
 package shader
 
 import (
-	"fmt"
+	"github.com/jamiec7919/vermeer/builtin/maps"
 	"github.com/jamiec7919/vermeer/builtin/shader/bsdf"
 	fr "github.com/jamiec7919/vermeer/builtin/shader/fresnel"
 	"github.com/jamiec7919/vermeer/colour"
 	"github.com/jamiec7919/vermeer/core"
 	"github.com/jamiec7919/vermeer/core/param"
 	m "github.com/jamiec7919/vermeer/math"
-	"github.com/jamiec7919/vermeer/math/ldseq"
 	"github.com/jamiec7919/vermeer/nodes"
-	"math"
 )
 
 // ShaderStd is the default surface shader.
@@ -38,17 +36,49 @@ type ShaderStd struct {
 	Spec1Colour       param.RGBUniform     `node:",opt"` // Colour parameter
 	Spec1Strength     param.Float32Uniform `node:",opt"` // Weight parameter
 	Spec1Roughness    param.Float32Uniform `node:",opt"`
+	Spec1Anisotropy   param.Float32Uniform `node:",opt"` // 0 is isotropic, 1 stretches highlights along the tangent
+	Spec1Rotation     param.Float32Uniform `node:",opt"` // Rotation of the tangent, [0,1] is a full turn
 	Spec1FresnelModel string               `node:",opt"`
 	spec1FresnelModel fr.Model
 	Spec1FresnelRefl  param.RGBUniform `node:",opt"` // Colour parameter
 	Spec1FresnelEdge  param.RGBUniform `node:",opt"` // Colour parameter
 
+	Spec2Colour       param.RGBUniform     `node:",opt"` // Colour parameter
+	Spec2Strength     param.Float32Uniform `node:",opt"` // Weight parameter
+	Spec2Roughness    param.Float32Uniform `node:",opt"`
+	Spec2Anisotropy   param.Float32Uniform `node:",opt"` // 0 is isotropic, 1 stretches highlights along the tangent
+	Spec2Rotation     param.Float32Uniform `node:",opt"` // Rotation of the tangent, [0,1] is a full turn
+	Spec2FresnelModel string               `node:",opt"`
+	spec2FresnelModel fr.Model
+	Spec2FresnelRefl  param.RGBUniform `node:",opt"` // Colour parameter
+	Spec2FresnelEdge  param.RGBUniform `node:",opt"` // Colour parameter
+
+	SheenColour    param.RGBUniform     `node:",opt"` // Colour parameter
+	SheenStrength  param.Float32Uniform `node:",opt"` // Weight parameter
+	SheenRoughness param.Float32Uniform `node:",opt"`
+
+	CoatColour    param.RGBUniform     `node:",opt"` // Tint applied to all lobes under the coat
+	CoatStrength  param.Float32Uniform `node:",opt"` // Weight parameter
+	CoatRoughness param.Float32Uniform `node:",opt"`
+	CoatIOR       param.Float32Uniform `node:",opt"`
+
+	TangentMap param.RGBUniform `node:",opt"` // Tangent direction in UV space, encoded in [0,1]
+
+	BumpMap        param.Float32Uniform `node:",opt"` // Height map
+	BumpHeight     param.Float32Uniform `node:",opt"` // Scale applied to BumpMap
+	NormalMap      param.RGBUniform     `node:",opt"` // Tangent space normal map
+	NormalStrength param.Float32Uniform `node:",opt"`
+
 	IOR param.Float32Uniform `node:",opt"`
+
+	Opacity param.RGBUniform `node:",opt"` // Cutout/transparency, 1 is opaque
 }
 
 // Assert that ShaderStd satisfies important interfaces.
 var _ core.Node = (*ShaderStd)(nil)
 var _ core.Shader = (*ShaderStd)(nil)
+var _ core.OpacityShader = (*ShaderStd)(nil)
+var _ layerShader = (*ShaderStd)(nil)
 
 // Name is a core.Node method.
 func (sh *ShaderStd) Name() string { return sh.MtlName }
@@ -59,21 +89,36 @@ func (sh *ShaderStd) Def() core.NodeDef { return sh.NodeDef }
 // PreRender is a core.Node method.
 func (sh *ShaderStd) PreRender() error {
 
-	switch sh.Spec1FresnelModel {
-	case "Dielectric":
-		sh.spec1FresnelModel = fr.DielectricModel
-	case "Metal":
-		sh.spec1FresnelModel = fr.ConductorModel
+	sh.spec1FresnelModel = fresnelModel(sh.Spec1FresnelModel)
+	sh.spec2FresnelModel = fresnelModel(sh.Spec2FresnelModel)
+
+	return maps.ResolveFields(sh)
+}
 
+// fresnelModel returns the model for the given name, "Dielectric" (default) or "Metal".
+func fresnelModel(name string) fr.Model {
+	switch name {
+	case "Metal":
+		return fr.ConductorModel
 	}
-	return nil
+
+	return fr.DielectricModel
 }
 
 // PostRender is a core.Node method.
 func (sh *ShaderStd) PostRender() error { return nil }
 
+// HasOpacity implements core.OpacityShader.
+func (sh *ShaderStd) HasOpacity() bool { return sh.Opacity != nil }
+
+// EvalOpacity implements core.OpacityShader.
+func (sh *ShaderStd) EvalOpacity(sg *core.ShaderContext) colour.RGB { return sh.Opacity.RGB(sg) }
+
 // Eval implements core.Shader.  Performs all shading for the surface point in sg.  May trace
 // rays and shadow rays.
+//
+// Lobes are layered from the top down: clearcoat, sheen and then the diffuse and specular
+// lobes.  Each layer attenuates the layers below it by the energy it reflects.
 func (sh *ShaderStd) Eval(sg *core.ShaderContext) {
 
 	//fmt.Printf("%v %v %v %v\n", sg.DdDdx, sg.DdNdx, sg.DdDdy, sg.DdNdy)
@@ -96,203 +141,165 @@ func (sh *ShaderStd) Eval(sg *core.ShaderContext) {
 		return
 	}
 
-	// Construct a tangent space
-	V := m.Vec3Cross(sg.N, sg.DdPdu)
+	var cl closure
 
-	if m.Vec3Length2(V) < 0.1 {
-		V = m.Vec3Cross(sg.N, sg.DdPdv)
-	}
-	V = m.Vec3Normalize(V)
-	U := m.Vec3Normalize(m.Vec3Cross(sg.N, V))
-
-	diffRoughness := float32(0.5)
-
-	if sh.DiffuseRoughness != nil {
-		diffRoughness = sh.DiffuseRoughness.Float32(sg)
-	}
-
-	var _ = diffRoughness
-	diffBrdf := bsdf.NewOrenNayar(sg.Lambda, m.Vec3Neg(sg.Rd), diffRoughness, U, V, sg.N)
-	//diffBrdf := bsdf.NewLambert(sg.Lambda, m.Vec3Neg(sg.Rd), U, V, sg.N)
-
-	var diffContrib colour.RGB
-
-	var diffColour colour.RGB
-
-	if sh.DiffuseColour != nil {
-		diffColour = sh.DiffuseColour.RGB(sg)
-	}
+	sh.closure(sg, 1, &cl)
+	cl.eval(sg)
+}
 
-	diffWeight := float32(0)
-	spec1Weight := float32(0)
+// closure implements layerShader.
+func (sh *ShaderStd) closure(sg *core.ShaderContext, weight float32, cl *closure) {
+	cl.alpha += weight
 
-	if sh.DiffuseStrength != nil {
-		diffWeight = sh.DiffuseStrength.Float32(sg)
+	if sg.Level > 3 {
+		return
 	}
 
-	if sh.Spec1Strength != nil {
-		spec1Weight = sh.Spec1Strength.Float32(sg)
+	if sh.NormalMap != nil {
+		sg.N = normalMapNormal(sg, sh.NormalMap, float32Param(sh.NormalStrength, sg, 1))
 	}
 
-	totalWeight := diffWeight + spec1Weight
-	diffWeight /= totalWeight
-	spec1Weight /= totalWeight
-
-	if totalWeight == 0.0 {
-		panic(fmt.Sprintf("Shader %v has no weight", sh.Name()))
+	if sh.BumpMap != nil {
+		sg.N = bumpNormal(sg, sh.BumpMap, float32Param(sh.BumpHeight, sg, 1))
 	}
 
-	if diffWeight > 0.0 {
-
-		sg.LightsPrepare()
-
-		for sg.NextLight() {
+	U, V := tangentFrame(sg, sh.TangentMap)
 
-			if sg.Lp.DiffuseShadeMult() > 0.0 {
+	omegaI := m.Vec3Neg(sg.Rd)
+	cosThetaI := m.Vec3DotAbs(sg.N, omegaI)
 
-				// In this example the brdf passed is an interface
-				// allowing sampling, pdf and bsdf eval
-				col := sg.EvaluateLightSamples(diffBrdf)
-				col.Mul(diffColour)
-				diffContrib.Add(col)
-			}
+	coatWeight := float32Param(sh.CoatStrength, sg, 0)
+	sheenWeight := float32Param(sh.SheenStrength, sg, 0)
+	diffWeight := float32Param(sh.DiffuseStrength, sg, 0)
+	spec1Weight := float32Param(sh.Spec1Strength, sg, 0)
+	spec2Weight := float32Param(sh.Spec2Strength, sg, 0)
 
-		}
+	totalWeight := diffWeight + spec1Weight + spec2Weight
 
-		diffContrib.Scale(diffWeight)
+	// Nothing to reflect, the closure is left black.
+	if totalWeight+coatWeight+sheenWeight == 0.0 {
+		sg.OutRGB = colour.RGB{}
+		return
 	}
 
-	ior := float32(1.7)
-
-	if sh.IOR != nil {
-		ior = sh.IOR.Float32(sg)
+	// Diffuse and specular lobes share the base layer, only normalise if together they
+	// would reflect more energy than they receive.
+	if totalWeight > 1 {
+		diffWeight /= totalWeight
+		spec1Weight /= totalWeight
+		spec2Weight /= totalWeight
 	}
 
-	var fresnel core.Fresnel
+	// base is the fraction of energy (and tint) passed through to the layers below.
+	base := colour.RGB{weight, weight, weight}
 
-	switch sh.spec1FresnelModel {
-	case fr.DielectricModel:
-		fresnel = fr.NewDielectric(ior)
-	case fr.ConductorModel:
+	if coatWeight > 0.0 {
+		coatFresnel := fr.NewDielectric(float32Param(sh.CoatIOR, sg, 1.5))
+		coatRoughness := float32Param(sh.CoatRoughness, sg, 0)
 
-		refl := colour.RGB{0.5, 0.5, 0.5}
-		edge := colour.RGB{0.5, 0.5, 0.5}
+		cl.addLobe(specularBSDF(sg, coatFresnel, coatRoughness, 0, 0, U, V),
+			colour.RGB{weight * coatWeight, weight * coatWeight, weight * coatWeight}, specularKind(coatRoughness))
 
-		if sh.Spec1FresnelRefl != nil {
-			refl = sh.Spec1FresnelRefl.RGB(sg)
-		}
+		coatKr := coatFresnel.Kr(cosThetaI)
+		coatColour := rgbParam(sh.CoatColour, sg, colour.RGB{1, 1, 1})
 
-		if sh.Spec1FresnelEdge != nil {
-			refl = sh.Spec1FresnelEdge.RGB(sg)
+		for k := range base {
+			base[k] *= (1 - coatWeight*coatKr[k]) * (1 - coatWeight + coatWeight*coatColour[k])
 		}
-
-		fresnel = fr.NewConductor(0, refl, edge)
 	}
 
-	var spec1Contrib colour.RGB
-
-	if spec1Weight > 0.0 {
-		spec1Roughness := float32(0.5)
-
-		if sh.Spec1Roughness != nil {
-			spec1Roughness = sh.Spec1Roughness.Float32(sg)
-		}
-
-		var spec1Colour colour.RGB
-
-		if sh.Spec1Colour != nil {
-			spec1Colour = sh.Spec1Colour.RGB(sg)
-		}
-
-		var spec1BRDF core.BSDF
-
-		if spec1Roughness == 0.0 {
-			spec1BRDF = bsdf.NewSpecular(sg, m.Vec3Neg(sg.Rd), fresnel, U, V, sg.N)
-		} else {
-			spec1BRDF = bsdf.NewMicrofacetGGX(sg, m.Vec3Neg(sg.Rd), fresnel, spec1Roughness, U, V, sg.N)
-		}
-
-		//	Kr := fresnel.Kr(m.Vec3DotAbs(sg.N, m.Vec3Neg(sg.Rd)))
-		var samp core.TraceSample
-		ray := sg.NewRay()
+	if sheenWeight > 0.0 {
+		sheenRoughness := float32Param(sh.SheenRoughness, sg, 0.5)
+		sheenColour := rgbParam(sh.SheenColour, sg, colour.RGB{})
 
-		spec1Samples := 0
-
-		if spec1Roughness == 0.0 {
-			spec1Samples = 1
-		}
+		w := sheenColour
+		w.Mul(base)
+		w.Scale(sheenWeight)
 
-		for i := 0; i < spec1Samples; i++ {
-			idx := uint64(sg.I*spec1Samples /*+ sg.Sample*/ + i)
-			r0 := ldseq.VanDerCorput(idx, sg.Scramble[0])
-			r1 := ldseq.Sobol(idx, sg.Scramble[1])
+		cl.addLobe(bsdf.NewSheen(sg.Lambda, omegaI, sheenRoughness, U, V, sg.N), w, lobeDiffuse)
 
-			spec1OmegaO := spec1BRDF.Sample(r0, r1)
-			pdf := spec1BRDF.PDF(spec1OmegaO)
+		base.Scale(1 - sheenWeight*sheenColour.Maxh()*bsdf.SheenAlbedo(cosThetaI, sheenRoughness))
+	}
 
-			if m.Vec3Dot(spec1OmegaO, sg.Ng) <= 0.0 {
-				continue
+	if diffWeight > 0.0 {
+		diffRoughness := float32Param(sh.DiffuseRoughness, sg, 0.5)
 
-			}
-			//fmt.Printf("%v %v\n", spec1Omega, m.Vec3Length(spec1Omega))
+		w := rgbParam(sh.DiffuseColour, sg, colour.RGB{})
+		w.Mul(base)
+		w.Scale(diffWeight)
 
-			ray.Init(core.RayTypeReflected, sg.OffsetP(1), spec1OmegaO, m.Inf(1), sg.Level+1, sg)
+		cl.addLobe(bsdf.NewOrenNayar(sg.Lambda, omegaI, diffRoughness, U, V, sg.N), w, lobeDiffuse)
+	}
 
-			if core.Trace(ray, &samp) {
+	ior := float32Param(sh.IOR, sg, 1.7)
 
-				rho := spec1BRDF.Eval(spec1OmegaO)
+	if spec1Weight > 0.0 {
+		fresnel := newFresnel(sg, sh.spec1FresnelModel, ior, sh.Spec1FresnelRefl, sh.Spec1FresnelEdge)
+		roughness := float32Param(sh.Spec1Roughness, sg, 0.5)
 
-				rho.Scale(1.0 / float32(pdf))
+		w := rgbParam(sh.Spec1Colour, sg, colour.RGB{})
+		w.Mul(base)
+		w.Scale(spec1Weight)
 
-				col := rho.ToRGB()
+		cl.addLobe(specularBSDF(sg, fresnel, roughness, float32Param(sh.Spec1Anisotropy, sg, 0),
+			float32Param(sh.Spec1Rotation, sg, 0), U, V), w, specularKind(roughness))
+	}
 
-				//fmt.Printf("%v %v\n", col, samp.Colour)
-				col.Mul(spec1Colour)
-				col.Mul(samp.Colour)
+	if spec2Weight > 0.0 {
+		fresnel := newFresnel(sg, sh.spec2FresnelModel, ior, sh.Spec2FresnelRefl, sh.Spec2FresnelEdge)
+		roughness := float32Param(sh.Spec2Roughness, sg, 0.5)
 
-				for k := range col {
-					if col[k] < 0 || math.IsNaN(float64(col[k])) {
-						col[k] = 0
-					}
-				}
-				spec1Contrib.Add(col)
-			}
+		w := rgbParam(sh.Spec2Colour, sg, colour.RGB{})
+		w.Mul(base)
+		w.Scale(spec2Weight)
 
-		}
+		cl.addLobe(specularBSDF(sg, fresnel, roughness, float32Param(sh.Spec2Anisotropy, sg, 0),
+			float32Param(sh.Spec2Rotation, sg, 0), U, V), w, specularKind(roughness))
+	}
 
-		sg.ReleaseRay(ray)
+	emissContrib := sh.EvalEmission(sg, omegaI)
+	emissContrib.Scale(weight)
 
-		if spec1Samples > 0 {
-			spec1Contrib.Scale(spec1Weight / float32(spec1Samples))
-		}
+	cl.other.Add(emissContrib)
+}
 
-		if spec1Roughness > 0.0 { // No point doing direct lighting for mirror surfaces!
-			sg.LightsPrepare()
+// specularBSDF returns the BSDF for a specular lobe, roughness 0 is a mirror.  Anisotropic lobes
+// are stretched along U after rotating it about the normal.
+func specularBSDF(sg *core.ShaderContext, fresnel core.Fresnel, roughness, anisotropy, rotation float32, U, V m.Vec3) core.BSDF {
+	if roughness == 0.0 {
+		return bsdf.NewSpecular(sg, m.Vec3Neg(sg.Rd), fresnel, U, V, sg.N)
+	}
 
-			for sg.NextLight() {
+	if rotation != 0.0 {
+		U, V = rotateFrame(sg.N, U, V, rotation)
+	}
 
-				//			if sg.Lp.DiffuseShadeMult() > 0.0 {
+	roughnessU, roughnessV := anisotropicRoughness(roughness, anisotropy)
 
-				// In this example the brdf passed is an interface
-				// allowing sampling, pdf and bsdf eval
-				col := sg.EvaluateLightSamples(spec1BRDF)
-				col.Mul(spec1Colour)
-				spec1Contrib.Add(col)
-				//			}
+	return bsdf.NewMicrofacetGGXAniso(sg, m.Vec3Neg(sg.Rd), fresnel, roughnessU, roughnessV, U, V, sg.N)
+}
 
-			}
-		}
+// specularKind returns the kind of lobe for a specular BSDF.  No point doing direct lighting
+// for mirror surfaces!
+func specularKind(roughness float32) int {
+	if roughness == 0.0 {
+		return lobeMirror
 	}
 
-	contrib := colour.RGB{}
+	return lobeGlossy
+}
 
-	emissContrib := sh.EvalEmission(sg, m.Vec3Neg(sg.Rd))
+// newFresnel returns the Fresnel model for a specular lobe.
+func newFresnel(sg *core.ShaderContext, model fr.Model, ior float32, reflParam, edgeParam param.RGBUniform) core.Fresnel {
+	switch model {
+	case fr.ConductorModel:
+		refl := rgbParam(reflParam, sg, colour.RGB{0.5, 0.5, 0.5})
+		edge := rgbParam(edgeParam, sg, colour.RGB{0.5, 0.5, 0.5})
 
-	contrib.Add(emissContrib)
-	contrib.Add(diffContrib)
-	contrib.Add(spec1Contrib)
+		return fr.NewConductor(0, refl, edge)
+	}
 
-	sg.OutRGB = contrib
+	return fr.NewDielectric(ior)
 }
 
 // EvalEmission implements core.Shader.
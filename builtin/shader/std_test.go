@@ -0,0 +1,31 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shader
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"testing"
+)
+
+func TestShaderStdNoWeight(t *testing.T) {
+	sh := &ShaderStd{MtlName: "std"}
+
+	sg := &core.ShaderContext{
+		Rd:     m.Vec3{0, 0, -1},
+		N:      m.Vec3{0, 0, 1},
+		Ng:     m.Vec3{0, 0, 1},
+		DdPdu:  m.Vec3{1, 0, 0},
+		DdPdv:  m.Vec3{0, 1, 0},
+		OutRGB: colour.RGB{1, 1, 1},
+	}
+
+	sh.Eval(sg)
+
+	if sg.OutRGB != (colour.RGB{}) || sg.OutAlpha != 1 {
+		t.Errorf("OutRGB %v, OutAlpha %v, expected black with alpha 1", sg.OutRGB, sg.OutAlpha)
+	}
+}
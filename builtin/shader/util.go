@@ -0,0 +1,86 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package shader
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/core/param"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// float32Param evaluates p or returns def if the parameter wasn't given.
+func float32Param(p param.Float32Uniform, sg *core.ShaderContext, def float32) float32 {
+	if p == nil {
+		return def
+	}
+
+	return p.Float32(sg)
+}
+
+// rgbParam evaluates p or returns def if the parameter wasn't given.
+func rgbParam(p param.RGBUniform, sg *core.ShaderContext, def colour.RGB) colour.RGB {
+	if p == nil {
+		return def
+	}
+
+	return p.RGB(sg)
+}
+
+// tangentFrame returns an orthonormal tangent frame (U,V) around the shading normal.  U follows
+// DdPdu projected onto the tangent plane unless tangentMap is given, in which case the map
+// red/green channels give a direction in the (DdPdu,DdPdv) plane.
+func tangentFrame(sg *core.ShaderContext, tangentMap param.RGBUniform) (U, V m.Vec3) {
+	T := sg.DdPdu
+
+	if tangentMap != nil {
+		dir := tangentMap.RGB(sg)
+
+		T = m.Vec3Add(m.Vec3Scale(2*dir[0]-1, sg.DdPdu), m.Vec3Scale(2*dir[1]-1, sg.DdPdv))
+	}
+
+	// Gram-Schmidt against the normal, fall back on DdPdv and then any perpendicular
+	// vector if the derivatives are degenerate.
+	U = m.Vec3Sub(T, m.Vec3Scale(m.Vec3Dot(sg.N, T), sg.N))
+
+	if m.Vec3Length2(U) < 1e-12 {
+		U = m.Vec3Cross(sg.DdPdv, sg.N)
+	}
+
+	if m.Vec3Length2(U) < 1e-12 {
+		U = m.Vec3Cross(sg.N, m.Vec3{1, 0, 0})
+
+		if m.Vec3Length2(U) < 0.1 {
+			U = m.Vec3Cross(sg.N, m.Vec3{0, 1, 0})
+		}
+	}
+
+	U = m.Vec3Normalize(U)
+	V = m.Vec3Cross(sg.N, U)
+
+	return
+}
+
+// rotateFrame rotates the tangent frame about N, rotation is in turns.
+func rotateFrame(N, U, V m.Vec3, rotation float32) (m.Vec3, m.Vec3) {
+	phi := 2 * m.Pi * rotation
+	cosPhi, sinPhi := m.Cos(phi), m.Sin(phi)
+
+	Ur := m.Vec3Add(m.Vec3Scale(cosPhi, U), m.Vec3Scale(sinPhi, V))
+
+	return Ur, m.Vec3Cross(N, Ur)
+}
+
+// anisotropicRoughness splits roughness into tangent and bitangent roughness, anisotropy 0 is
+// isotropic and 1 is maximally stretched along the tangent.
+func anisotropicRoughness(roughness, anisotropy float32) (roughnessU, roughnessV float32) {
+	if anisotropy == 0 {
+		return roughness, roughness
+	}
+
+	aspect := m.Sqrt(1 - 0.9*m.Clamp(anisotropy, 0, 1))
+
+	return m.Min(1, roughness/aspect), roughness * aspect
+}
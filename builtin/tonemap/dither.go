@@ -0,0 +1,224 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tonemap
+
+import (
+	"fmt"
+	m "github.com/jamiec7919/vermeer/math"
+	"math/rand"
+	"sync"
+)
+
+// Dither methods.
+const (
+	DitherNone      = "None"
+	DitherOrdered   = "Ordered"
+	DitherBlueNoise = "BlueNoise"
+)
+
+const (
+	bayerSize     = 8
+	blueNoiseSize = 64
+	blueNoiseSeed = 1
+	// blueNoiseSigma is the width of the Gaussian filter of void-and-cluster.
+	blueNoiseSigma = 1.5
+)
+
+var bayer = makeBayer(bayerSize)
+
+var blueNoise []float32
+var blueNoiseOnce sync.Once
+
+// Quantise converts the display values in img to integers in [0,max], adding dither of one
+// quantisation step.  img is w by h with nc channels, the same threshold is used for each
+// channel of a pixel.
+func Quantise(w, h, nc int, img []float32, max int, dither string) ([]uint16, error) {
+	var threshold func(x, y int) float32
+
+	switch dither {
+	case "", DitherNone:
+		threshold = func(x, y int) float32 { return 0 }
+	case DitherOrdered:
+		threshold = func(x, y int) float32 { return bayer[x%bayerSize+(y%bayerSize)*bayerSize] }
+	case DitherBlueNoise:
+		blueNoiseOnce.Do(func() { blueNoise = makeBlueNoise(blueNoiseSize, blueNoiseSigma) })
+
+		threshold = func(x, y int) float32 {
+			return blueNoise[x%blueNoiseSize+(y%blueNoiseSize)*blueNoiseSize]
+		}
+	default:
+		return nil, fmt.Errorf("tonemap: unknown dither %v", dither)
+	}
+
+	if len(img) < w*h*nc {
+		return nil, fmt.Errorf("tonemap: image too small")
+	}
+
+	out := make([]uint16, w*h*nc)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			d := threshold(x, y)
+
+			for k := 0; k < nc; k++ {
+				i := (x+y*w)*nc + k
+				v := m.Clamp(img[i], 0, 1)*float32(max) + d
+				out[i] = uint16(m.Clamp(m.Floor(v+0.5), 0, float32(max)))
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// makeBayer returns the n by n (power of 2) Bayer matrix as thresholds in [-0.5,0.5).
+func makeBayer(n int) []float32 {
+	M := []int{0}
+
+	for s := 1; s < n; s *= 2 {
+		next := make([]int, 4*s*s)
+
+		for y := 0; y < s; y++ {
+			for x := 0; x < s; x++ {
+				v := 4 * M[x+y*s]
+				next[x+y*2*s] = v
+				next[x+s+y*2*s] = v + 2
+				next[x+(y+s)*2*s] = v + 3
+				next[x+s+(y+s)*2*s] = v + 1
+			}
+		}
+
+		M = next
+	}
+
+	t := make([]float32, n*n)
+
+	for i, v := range M {
+		t[i] = (float32(v)+0.5)/float32(n*n) - 0.5
+	}
+
+	return t
+}
+
+// makeBlueNoise returns an n by n tileable blue noise threshold matrix with values in
+// [-0.5,0.5), made by Ulichney's void-and-cluster method.
+func makeBlueNoise(n int, sigma float64) []float32 {
+	size := n * n
+
+	// Gaussian energy with toroidal distance so the matrix tiles.
+	gauss := make([]float32, size)
+
+	for dy := 0; dy < n; dy++ {
+		for dx := 0; dx < n; dx++ {
+			x, y := float32(minInt(dx, n-dx)), float32(minInt(dy, n-dy))
+			gauss[dx+dy*n] = m.Exp(-(x*x + y*y) / float32(2*sigma*sigma))
+		}
+	}
+
+	pattern := make([]bool, size)
+	energy := make([]float32, size)
+
+	toggle := func(p int, on bool) {
+		pattern[p] = on
+		px, py := p%n, p/n
+		s := float32(1)
+
+		if !on {
+			s = -1
+		}
+
+		for q := range energy {
+			dx, dy := (q%n-px+n)%n, (q/n-py+n)%n
+			energy[q] += s * gauss[dx+dy*n]
+		}
+	}
+
+	// tightestCluster is the set pixel with most energy, largestVoid the empty pixel with least.
+	tightestCluster := func() int {
+		best := -1
+
+		for p, on := range pattern {
+			if on && (best < 0 || energy[p] > energy[best]) {
+				best = p
+			}
+		}
+
+		return best
+	}
+
+	largestVoid := func() int {
+		best := -1
+
+		for p, on := range pattern {
+			if !on && (best < 0 || energy[p] < energy[best]) {
+				best = p
+			}
+		}
+
+		return best
+	}
+
+	// Initial pattern of random points made homogeneous by moving the tightest cluster into the
+	// largest void until stable (with a limit in case of a cycle).
+	r := rand.New(rand.NewSource(blueNoiseSeed))
+	ones := size / 10
+
+	for k := 0; k < ones; {
+		if p := r.Intn(size); !pattern[p] {
+			toggle(p, true)
+			k++
+		}
+	}
+
+	for i := 0; i < size; i++ {
+		c := tightestCluster()
+		toggle(c, false)
+		v := largestVoid()
+
+		if v == c {
+			toggle(c, true)
+			break
+		}
+
+		toggle(v, true)
+	}
+
+	initial := append([]bool(nil), pattern...)
+	initialEnergy := append([]float32(nil), energy...)
+	rank := make([]int, size)
+
+	// Rank the initial points by removing the tightest clusters.
+	for k := ones - 1; k >= 0; k-- {
+		c := tightestCluster()
+		toggle(c, false)
+		rank[c] = k
+	}
+
+	// Then the remaining pixels by filling the largest voids.
+	copy(pattern, initial)
+	copy(energy, initialEnergy)
+
+	for k := ones; k < size; k++ {
+		v := largestVoid()
+		toggle(v, true)
+		rank[v] = k
+	}
+
+	t := make([]float32, size)
+
+	for p, k := range rank {
+		t[p] = (float32(k)+0.5)/float32(size) - 0.5
+	}
+
+	return t
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tonemap
+
+import (
+	m "github.com/jamiec7919/vermeer/math"
+	"testing"
+)
+
+func TestDitherThresholds(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		t    []float32
+	}{
+		{"bayer", bayerSize, makeBayer(bayerSize)},
+		{"bayer 2", 2, makeBayer(2)},
+		{"blue noise", blueNoiseSize, makeBlueNoise(blueNoiseSize, blueNoiseSigma)},
+	}
+
+	for _, test := range tests {
+		size := test.n * test.n
+
+		if len(test.t) != size {
+			t.Errorf("%v: %v thresholds, expected %v", test.name, len(test.t), size)
+			continue
+		}
+
+		// Thresholds are (rank+0.5)/size - 0.5, each rank is used once.
+		used := make([]bool, size)
+
+		for i, v := range test.t {
+			if v < -0.5 || v >= 0.5 {
+				t.Errorf("%v: threshold %v = %v, expected [-0.5,0.5)", test.name, i, v)
+				continue
+			}
+
+			rank := int(m.Floor((v + 0.5) * float32(size)))
+
+			if used[rank] {
+				t.Errorf("%v: rank %v used twice", test.name, rank)
+			}
+
+			used[rank] = true
+		}
+	}
+}
+
+func TestQuantiseEndpoints(t *testing.T) {
+	for _, max := range []int{255, 65535} {
+		for _, dither := range []string{DitherNone, DitherOrdered, DitherBlueNoise} {
+			const w, h = 16, 16
+
+			tests := []struct {
+				v        float32
+				expected uint16
+			}{
+				{-1, 0},
+				{0, 0},
+				{1, uint16(max)},
+				{2, uint16(max)},
+			}
+
+			for _, test := range tests {
+				img := make([]float32, w*h)
+
+				for i := range img {
+					img[i] = test.v
+				}
+
+				q, err := Quantise(w, h, 1, img, max, dither)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for i, v := range q {
+					if v != test.expected {
+						t.Errorf("%v bits %v: %v at %v = %v, expected %v", max, dither, test.v, i, v, test.expected)
+						break
+					}
+				}
+			}
+		}
+	}
+
+	// Without dither values round to nearest.
+	q, _ := Quantise(3, 1, 1, []float32{0.5, 0.25 / 255, 0.75 / 255}, 255, DitherNone)
+
+	if q[0] != 128 || q[1] != 0 || q[2] != 1 {
+		t.Errorf("rounded %v, expected [128 0 1]", q)
+	}
+}
+
+func TestQuantiseDitherMean(t *testing.T) {
+	// A constant between two levels is dithered so the average is kept.
+	const w, h = bayerSize, bayerSize
+	const v = 100.3 / 255
+
+	img := make([]float32, w*h*3)
+
+	for i := range img {
+		img[i] = v
+	}
+
+	q, err := Quantise(w, h, 3, img, 255, DitherOrdered)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sum float32
+
+	for i, c := range q {
+		if c != 100 && c != 101 {
+			t.Fatalf("%v = %v, expected 100 or 101", i, c)
+		}
+
+		sum += float32(c)
+	}
+
+	if mean := sum / float32(len(q)); m.Abs(mean-100.3) > 1.0/(w*h) {
+		t.Errorf("mean %v, expected 100.3", mean)
+	}
+
+	if _, err := Quantise(w, h, 3, img, 255, "Random"); err == nil {
+		t.Errorf("expected an error for an unknown dither")
+	}
+}
@@ -1,60 +1,151 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
 package tonemap
 
 import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/colour"
 	m "github.com/jamiec7919/vermeer/math"
+	"sort"
 )
 
 // Tonemapping based on paper by Jiang Duan and Guoping Qiu
 // http://www.cs.nott.ac.uk/~pszqiu/webpages/Papers/1494_Qiu_G.pdf
 
-func Tonemap(w, h int, alpha float32, img []float32, buf []byte) error {
+const (
+	histogramBins = 1 << 16
+	displayLevels = 256 // Power of 2 for rangedivide
+)
 
-	H := make([]int32, 1000000)
+// Tonemap maps the log luminance of the w by h RGB image img to display levels in [0,1].  The
+// log luminance range is divided into intervals holding equal numbers of pixels (histogram
+// equalisation), alpha blends these with equal intervals (a linear mapping of log luminance).
+func Tonemap(w, h int, alpha float32, img []float32) ([]float32, error) {
+	if len(img) < w*h*3 {
+		return nil, fmt.Errorf("tonemap: image too small")
+	}
+
+	H := make([]int, histogramBins)
 	LI := make([]float32, w*h)
 
 	Lmin := m.Inf(1)
 	Lmax := m.Inf(-1)
 
-	// Compute log luminance
+	// Compute log luminance, black pixels are left at the bottom of the range.
 	for i := range LI {
-		LI[i] = m.Log(0.299*img[i*3+0] + 0.587*img[i*3+1] + 0.114*img[i*3+2])
+		Y := colour.RGB{img[i*3+0], img[i*3+1], img[i*3+2]}.Luminance()
+
+		if Y <= 0 {
+			LI[i] = m.Inf(-1)
+			continue
+		}
+
+		LI[i] = m.Log2(Y)
 
 		Lmin = m.Min(Lmin, LI[i])
 		Lmax = m.Max(Lmax, LI[i])
 	}
 
+	levels := make([]float32, w*h)
+
+	if Lmin > Lmax {
+		return levels, nil // All black
+	}
+
+	if Lmax-Lmin < 1e-6 {
+		for i := range levels {
+			if LI[i] >= Lmin {
+				levels[i] = 1
+			}
+		}
+
+		return levels, nil
+	}
+
 	// Compute histogram
-	for i := range LI {
-		l := (LI[i] - Lmin) / (Lmax - Lmin)
+	bin := func(L float32) int {
+		l := (L - Lmin) / (Lmax - Lmin)
 
 		i := int(l * float32(len(H)))
 
+		if i < 0 {
+			i = 0
+		}
+
 		if i > len(H)-1 {
 			i = len(H) - 1
 		}
 
-		H[i]++
+		return i
 	}
 
-	// Scan for beta0
-	beta0 := 0
-	sum := 0
-	for sum < w*h/2 && beta0 < len(H) {
-		sum += H[beta0]
-		beta0++
+	for _, L := range LI {
+		H[bin(L)]++
+	}
+
+	// Boundaries of the display levels, blend of equal pixel counts and equal widths.
+	bounds := make([]int, displayLevels+1)
+	bounds[displayLevels] = len(H)
+	rangedivide(H, 0, len(H), bounds[:displayLevels])
+
+	B := make([]float32, displayLevels+1)
+
+	for k := range B {
+		B[k] = alpha*float32(bounds[k])/float32(len(H)) + (1-alpha)*float32(k)/displayLevels
+	}
+
+	for i, L := range LI {
+		if L < Lmin {
+			continue
+		}
+
+		l := (L - Lmin) / (Lmax - Lmin)
+
+		// Find the level with B[k] <= l < B[k+1].
+		k := sort.Search(displayLevels, func(k int) bool { return B[k+1] > l })
+
+		if k >= displayLevels {
+			levels[i] = 1
+			continue
+		}
+
+		t := float32(0)
+
+		if d := B[k+1] - B[k]; d > 0 {
+			t = (l - B[k]) / d
+		}
+
+		levels[i] = (float32(k) + m.Clamp(t, 0, 1)) / displayLevels
 	}
 
-	dynrange := make([]float32, 256)
+	return levels, nil
 }
 
-func rangedivide(hist []int32, Npix int) {
-	beta := 0
+// rangedivide divides the histogram bins [lo,hi) at the median into len(bounds) intervals with
+// equal pixel counts, bounds receives the first bin of each interval.
+func rangedivide(hist []int, lo, hi int, bounds []int) {
+	bounds[0] = lo
+
+	if len(bounds) == 1 {
+		return
+	}
+
+	total := 0
+
+	for _, n := range hist[lo:hi] {
+		total += n
+	}
+
+	beta := lo
 	sum := 0
-	for sum < Npix/2 && beta < len(hist) {
+
+	for beta < hi && sum+hist[beta] <= total/2 {
 		sum += hist[beta]
 		beta++
 	}
 
-	rangedivide(hist[:beta], Npix/2)
-	rangedivide(hist[beta:], Npix/2)
+	rangedivide(hist, lo, beta, bounds[:len(bounds)/2])
+	rangedivide(hist, beta, hi, bounds[len(bounds)/2:])
 }
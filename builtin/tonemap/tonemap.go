@@ -0,0 +1,164 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package tonemap implements view transforms from the rendered (scene linear) colours to display
+values, and the dithering used when quantising them.
+
+A View applies an exposure in stops, a tonemap operator and then the display transfer function
+(OETF).  The operators are Linear (values above 1 are clipped), Reinhard, Filmic (a fit of the
+ACES reference rendering) and DuanQiu, a global histogram adjustment of the whole image (see
+Tonemap).
+*/
+package tonemap
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// Tonemap operators.
+const (
+	Linear   = "Linear"
+	Reinhard = "Reinhard"
+	Filmic   = "Filmic"
+	DuanQiu  = "DuanQiu"
+)
+
+// Transfer functions.
+const (
+	SRGB   = "sRGB"
+	Rec709 = "Rec709"
+	// Linear is also a transfer function, values are written unchanged.
+)
+
+// View is a view transform.
+type View struct {
+	Exposure float32 // Stops
+	Operator string  // Tonemap operator, default Linear
+	White    float32 // Reinhard: smallest value mapped to white, 0 for none
+	Equalise float32 // DuanQiu: blend of histogram equalisation (1) and log mapping (0)
+	Transfer string  // Display transfer function, default sRGB
+}
+
+// Apply transforms the w by h RGB image img in place to display values in [0,1].
+func (v *View) Apply(w, h int, img []float32) error {
+	if len(img) < w*h*3 {
+		return fmt.Errorf("tonemap: image too small")
+	}
+
+	switch v.Transfer {
+	case "", SRGB, Rec709, Linear:
+	default:
+		return fmt.Errorf("tonemap: unknown transfer function %v", v.Transfer)
+	}
+
+	scale := m.Pow(2, v.Exposure)
+
+	for i := range img[:w*h*3] {
+		img[i] *= scale
+	}
+
+	switch v.Operator {
+	case "", Linear:
+	case Reinhard:
+		for i := 0; i < w*h; i++ {
+			c := colour.RGB{img[i*3], img[i*3+1], img[i*3+2]}
+			setRGB(img[i*3:], c, reinhard(c.Luminance(), v.White))
+		}
+	case Filmic:
+		for i := range img[:w*h*3] {
+			img[i] = filmic(img[i])
+		}
+	case DuanQiu:
+		levels, err := Tonemap(w, h, v.Equalise, img)
+
+		if err != nil {
+			return err
+		}
+
+		// The levels are display values, the colour is scaled to give the same luminance
+		// after the transfer function.
+		for i := 0; i < w*h; i++ {
+			c := colour.RGB{img[i*3], img[i*3+1], img[i*3+2]}
+			setRGB(img[i*3:], c, v.decode(levels[i]))
+		}
+	default:
+		return fmt.Errorf("tonemap: unknown operator %v", v.Operator)
+	}
+
+	for i := range img[:w*h*3] {
+		img[i] = v.encode(m.Clamp(img[i], 0, 1))
+	}
+
+	return nil
+}
+
+// setRGB stores c scaled to luminance L.
+func setRGB(dst []float32, c colour.RGB, L float32) {
+	Y := c.Luminance()
+
+	if Y > 0 {
+		c.Scale(L / Y)
+	}
+
+	copy(dst[:3], c[:])
+}
+
+// reinhard is the global Reinhard operator on luminance, extended to map white to 1.
+func reinhard(L, white float32) float32 {
+	if L <= 0 {
+		return 0
+	}
+
+	if white > 0 {
+		return L * (1 + L/(white*white)) / (1 + L)
+	}
+
+	return L / (1 + L)
+}
+
+// filmic is Krzysztof Narkowicz's fit of the ACES filmic curve.
+func filmic(x float32) float32 {
+	x *= 0.6 // The fit includes the exposure of the reference rendering.
+
+	if x <= 0 {
+		return 0
+	}
+
+	return (x * (2.51*x + 0.03)) / (x*(2.43*x+0.59) + 0.14)
+}
+
+// encode applies the transfer function to the linear value.
+func (v *View) encode(x float32) float32 {
+	switch v.Transfer {
+	case Linear:
+		return x
+	case Rec709:
+		if x < 0.018 {
+			return 4.5 * x
+		}
+
+		return 1.099*m.Pow(x, 0.45) - 0.099
+	}
+
+	return colour.LinearToSRGB(x)
+}
+
+// decode inverts encode.
+func (v *View) decode(x float32) float32 {
+	switch v.Transfer {
+	case Linear:
+		return x
+	case Rec709:
+		if x < 0.081 {
+			return x / 4.5
+		}
+
+		return m.Pow((x+0.099)/1.099, 1/0.45)
+	}
+
+	return colour.SRGBToLinear(x)
+}
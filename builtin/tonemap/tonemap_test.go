@@ -0,0 +1,62 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tonemap
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+	"testing"
+)
+
+func TestViewApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		view    View
+		in, out [3]float32
+	}{
+		{"linear", View{Transfer: Linear}, [3]float32{0.25, 0.5, 2}, [3]float32{0.25, 0.5, 1}},
+		{"exposure", View{Exposure: 1, Transfer: Linear}, [3]float32{0.25, 0.5, -1}, [3]float32{0.5, 1, 0}},
+		{"srgb", View{}, [3]float32{0, 0.5, 1}, [3]float32{0, colour.LinearToSRGB(0.5), 1}},
+		{"rec709", View{Transfer: Rec709}, [3]float32{0, 0.01, 1}, [3]float32{0, 0.045, 1}},
+		// Grey keeps its hue, luminance 1 maps to 0.5.
+		{"reinhard", View{Operator: Reinhard, Transfer: Linear}, [3]float32{1, 1, 1}, [3]float32{0.5, 0.5, 0.5}},
+		{"reinhard white", View{Operator: Reinhard, White: 4, Transfer: Linear}, [3]float32{4, 4, 4}, [3]float32{1, 1, 1}},
+		{"filmic", View{Operator: Filmic, Transfer: Linear}, [3]float32{0, 0, 100}, [3]float32{0, 0, 1}},
+	}
+
+	for _, test := range tests {
+		img := test.in
+
+		if err := test.view.Apply(1, 1, img[:]); err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		for k := range img {
+			if m.Abs(img[k]-test.out[k]) > 2e-3 {
+				t.Errorf("%v: %v, expected %v", test.name, img, test.out)
+				break
+			}
+		}
+	}
+
+	for _, view := range []View{{Operator: "Unknown"}, {Transfer: "Unknown"}} {
+		if err := view.Apply(1, 1, make([]float32, 3)); err == nil {
+			t.Errorf("%+v: expected an error", view)
+		}
+	}
+}
+
+func TestTransferInverse(t *testing.T) {
+	for _, transfer := range []string{SRGB, Rec709, Linear} {
+		v := View{Transfer: transfer}
+
+		for x := float32(0); x <= 1; x += 1.0 / 64 {
+			if y := v.decode(v.encode(x)); m.Abs(y-x) > 1e-4 {
+				t.Errorf("%v: decode(encode(%v)) = %v", transfer, x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+mktex command converts images into tiled, mip-mapped texture files which Vermeer reads without
+decoding or filtering.  Each <file.png> is converted to <file.png.vtx> next to it, which the
+renderer then uses in place of the image as long as it is newer than the image and was made for
+the same colour space.
+
+Execute as:
+
+	mktex [-colourspace=linear|srgb|raw] [-half] [-o out.vtx] <file.png>...
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jamiec7919/vermeer/texture"
+	"os"
+)
+
+var output = flag.String("o", "", "Output filename (only with a single input, default adds .vtx to the image name)")
+var colourSpace = flag.String("colourspace", "linear", "Colour space of the images (linear, srgb or raw)")
+var half = flag.Bool("half", false, "Store texels as half floats")
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: mktex [flags] <image>...\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	if *output != "" && flag.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "mktex: -o can only be used with a single image\n")
+		os.Exit(2)
+	}
+
+	var cs int
+
+	switch *colourSpace {
+	case "linear":
+		cs = texture.ColourSpaceLinear
+	case "srgb":
+		cs = texture.ColourSpaceSRGB
+	case "raw":
+		cs = texture.ColourSpaceRaw
+	default:
+		fmt.Fprintf(os.Stderr, "mktex: unknown colour space %v\n", *colourSpace)
+		os.Exit(2)
+	}
+
+	failed := false
+
+	for _, filename := range flag.Args() {
+		out := *output
+
+		if out == "" {
+			out = texture.TiledFilename(filename)
+		}
+
+		if err := texture.MakeTiled(filename, out, cs, *half); err != nil {
+			fmt.Fprintf(os.Stderr, "mktex: %v: %v\n", filename, err)
+			failed = true
+			continue
+		}
+
+		fmt.Printf("%v -> %v\n", filename, out)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	_ "github.com/jamiec7919/vermeer/builtin/geom/proc/vnf"
 	_ "github.com/jamiec7919/vermeer/builtin/geom/proc/wfobj"
 	_ "github.com/jamiec7919/vermeer/builtin/light"
+	_ "github.com/jamiec7919/vermeer/builtin/mapnode"
 	_ "github.com/jamiec7919/vermeer/builtin/misc"
 	"github.com/jamiec7919/vermeer/builtin/scene"
 	_ "github.com/jamiec7919/vermeer/builtin/shader"
@@ -0,0 +1,47 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package colour
+
+import (
+	"math"
+)
+
+// planck returns the spectral radiance of a black body at temperature kelvin for wavelength
+// lambda in nm, up to a constant factor.
+func planck(lambda, kelvin float64) float64 {
+	const c2 = 1.4387769e7 // hc/k in nm K
+
+	l := lambda / 1000 // Micrometres keeps the values in range
+
+	return 1 / (l * l * l * l * l * (math.Exp(c2/(lambda*kelvin)) - 1))
+}
+
+// Blackbody returns the linear sRGB colour of a black body at the temperature kelvin, with a
+// luminance of 1.  e.g. 6500 is close to white, 3200 is tungsten.
+func Blackbody(kelvin float32) RGB {
+	var x, y, z float64
+
+	ob := &cie1931deg2
+
+	for lambda := ob.LambdaMin; lambda < ob.LambdaMax; lambda++ {
+		p := planck(float64(lambda)+0.5, float64(kelvin))
+
+		x += p * float64(ob.X(lambda+0.5))
+		y += p * float64(ob.Y(lambda+0.5))
+		z += p * float64(ob.Z(lambda+0.5))
+	}
+
+	rgb := sRGB.XYZToRGB(float32(x/y), 1, float32(z/y))
+
+	for k := range rgb {
+		if rgb[k] < 0 {
+			rgb[k] = 0
+		}
+	}
+
+	rgb.Scale(1 / rgb.Luminance())
+
+	return rgb
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package colour
+
+import (
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// Luminance returns the luminance of c (Rec. 709 primaries).
+func (c RGB) Luminance() float32 {
+	return 0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]
+}
+
+// RGBToHSV converts c to hue, saturation and value.  Hue is in [0,1).
+func RGBToHSV(c RGB) (h, s, v float32) {
+	max := c.Maxh()
+	min := c.Minh()
+	delta := max - min
+
+	v = max
+
+	if max <= 0 || delta <= 0 {
+		return 0, 0, v
+	}
+
+	s = delta / max
+
+	switch max {
+	case c[0]:
+		h = (c[1] - c[2]) / delta
+	case c[1]:
+		h = 2 + (c[2]-c[0])/delta
+	default:
+		h = 4 + (c[0]-c[1])/delta
+	}
+
+	h /= 6
+
+	if h < 0 {
+		h += 1
+	}
+
+	return
+}
+
+// HSVToRGB converts hue, saturation and value to RGB.  Hue wraps so any value is valid.
+func HSVToRGB(h, s, v float32) RGB {
+	if s <= 0 {
+		return RGB{v, v, v}
+	}
+
+	h = (h - m.Floor(h)) * 6
+
+	i := int(h) % 6
+	f := h - m.Floor(h)
+	p := v * (1 - s)
+	q := v * (1 - s*f)
+	t := v * (1 - s*(1-f))
+
+	switch i {
+	case 0:
+		return RGB{v, t, p}
+	case 1:
+		return RGB{q, v, p}
+	case 2:
+		return RGB{p, v, t}
+	case 3:
+		return RGB{p, q, v}
+	case 4:
+		return RGB{t, p, v}
+	}
+
+	return RGB{v, p, q}
+}
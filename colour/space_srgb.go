@@ -4,6 +4,10 @@
 
 package colour
 
+import (
+	"math"
+)
+
 // sRGB is a concrete ColourSpace for the sRGB space.
 var sRGB = Space{
 	m: [9]float32{
@@ -12,3 +16,21 @@ var sRGB = Space{
 		0.0556434, -0.2040259, 1.0572252,
 	},
 }
+
+// SRGBToLinear decodes an sRGB encoded value to linear, values above 1 follow the same curve.
+func SRGBToLinear(v float32) float32 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return float32(math.Pow((float64(v)+0.055)/1.055, 2.4))
+}
+
+// LinearToSRGB encodes a linear value in [0,1] with the sRGB transfer function.
+func LinearToSRGB(v float32) float32 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+
+	return float32(1.055*math.Pow(float64(v), 1/2.4) - 0.055)
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+)
+
+// Candidate is a candidate intersection passed to AnyHit.  Geoms interpolate the surface
+// parameters, position and normals at the candidate so opacity can depend on any of them.
+type Candidate struct {
+	ElemID uint32
+	U, V   float32 // Surface parameters
+	T      float32 // Distance along the ray
+	P, Po  m.Vec3  // World and object space position
+	N, Ng  m.Vec3  // World space shading and geometric normals
+}
+
+// AnyHit is called by geoms for each candidate intersection with a shader which has opacity,
+// before the hit is accepted.  Returns true if the hit should be accepted.
+//
+// Shadow rays accumulate the transmission (1-opacity) of the surfaces they pass through in
+// ray.Transmission and are only stopped once it reaches zero.  Other rays accept the hit with
+// probability equal to the average opacity so cutouts are ignored and partial opacity blends
+// over iterations.
+//
+// The candidate is copied into sc for EvalOpacity, sc is left unchanged.
+func AnyHit(ray *Ray, sc *ShaderContext, shader OpacityShader, hit *Candidate) bool {
+	ElemID, U, V, Dduvdx, Dduvdy := sc.ElemID, sc.U, sc.V, sc.Dduvdx, sc.Dduvdy
+	P, Po, N, Ng := sc.P, sc.Po, sc.N, sc.Ng
+
+	// Opacity is evaluated with the texture footprint of a point as the ray differentials
+	// aren't known until the hit is accepted.
+	sc.ElemID, sc.U, sc.V, sc.Dduvdx, sc.Dduvdy = hit.ElemID, hit.U, hit.V, m.Vec2{}, m.Vec2{}
+	sc.P, sc.Po, sc.N, sc.Ng = hit.P, hit.Po, hit.N, hit.Ng
+
+	opacity := shader.EvalOpacity(sc)
+
+	sc.ElemID, sc.U, sc.V, sc.Dduvdx, sc.Dduvdy = ElemID, U, V, Dduvdx, Dduvdy
+	sc.P, sc.Po, sc.N, sc.Ng = P, Po, N, Ng
+
+	if ray.Type&RayTypeShadow != 0 {
+		for k := range ray.Transmission {
+			ray.Transmission[k] *= 1 - m.Clamp(opacity[k], 0, 1)
+		}
+
+		return ray.Transmission.Maxh() <= 0
+	}
+
+	p := (opacity[0] + opacity[1] + opacity[2]) / 3
+
+	if p >= 1 {
+		return true
+	}
+
+	if p <= 0 {
+		return false
+	}
+
+	return anyHitRand(ray, hit.ElemID, hit.T) < p
+}
+
+// anyHitRand returns a random number in [0,1) for the candidate hit.  Hashes the ray and hit so
+// the same hit always gives the same answer within a sample.
+func anyHitRand(ray *Ray, elemID uint32, t float32) float32 {
+	h := ray.Scramble[0] ^ uint64(ray.I)<<32 ^ uint64(ray.Level)<<24 ^ uint64(elemID)*0x9e3779b97f4a7c15 ^ uint64(math.Float32bits(t))
+
+	// splitmix64 finalizer
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+
+	return float32(h>>40) / (1 << 24)
+}
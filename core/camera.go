@@ -4,8 +4,33 @@
 
 package core
 
+import (
+	m "github.com/jamiec7919/vermeer/math"
+)
+
 // Camera represents a 3D camera.
 type Camera interface {
 	// ComputeRay should return a world-space ray within the given pixel.
 	ComputeRay(sc *ShaderContext, lensU, lensV float64, ray *Ray)
 }
+
+// CameraProjector is implemented by cameras which can project world space points onto the
+// screen, used for camera projection mapping.
+type CameraProjector interface {
+	// Project returns the screen position of P in [-1,1]x[-1,1] at the given time (x right, y
+	// up, as ShaderContext.Sx,Sy), ok is false if P is behind the camera.
+	Project(P m.Vec3, time float32) (sx, sy float32, ok bool)
+}
+
+// CameraShutter is implemented by cameras which control when rays are sampled during the frame.
+type CameraShutter interface {
+	// ShutterTime returns the time in [0,1] of a ray through the screen position (sx,sy) for
+	// the sample u in [0,1).
+	ShutterTime(u float64, sx, sy float32) float32
+}
+
+// CameraExposure is implemented by cameras with an exposure, samples are scaled by it before
+// they are added to the framebuffer.
+type CameraExposure interface {
+	Exposure() float32
+}
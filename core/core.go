@@ -11,6 +11,7 @@ var stats RenderStats
 var scene Scene
 var nodes []Node
 var nodeMap map[string]Node
+var prerendered map[Node]bool
 var globals Globals
 var filter PixelFilter
 
@@ -20,6 +21,8 @@ var defaultGlobals = Globals{
 	YRes:          1024,
 	MaxGoRoutines: 5,
 	MaxIter:       16,
+
+	TextureCacheSize: 2048,
 }
 
 // Init initializes the core system with the given Scene.
@@ -28,6 +31,7 @@ func Init(s Scene) {
 	scene = s
 	nodes = nil
 	nodeMap = make(map[string]Node)
+	prerendered = make(map[Node]bool)
 	stats = RenderStats{}
 }
 
@@ -35,7 +39,12 @@ func Init(s Scene) {
 // Nodes may add new nodes so PreRender iterates until no new nodes are created.
 func PreRender() error {
 
-	framebuffer = &Framebuffer{globals.XRes, globals.YRes, make([]float32, globals.XRes*globals.YRes*3)}
+	framebuffer = &Framebuffer{
+		Width:  globals.XRes,
+		Height: globals.YRes,
+		Buf:    make([]float32, globals.XRes*globals.YRes*3),
+		Alpha:  make([]float32, globals.XRes*globals.YRes),
+	}
 
 	// pre and fixup nodes
 	// Note that nodes in PreRender may add new nodes, so we must backup and
@@ -50,7 +59,7 @@ func PreRender() error {
 		allnodes = append(allnodes, _nodes...)
 
 		for _, node := range _nodes {
-			if err := node.PreRender(); err != nil {
+			if err := PreRenderNode(node); err != nil {
 				return err
 			}
 		}
@@ -61,6 +70,24 @@ func PreRender() error {
 	return scene.PreRender()
 }
 
+// PreRenderNode calls PreRender on node unless it has already been called.  Nodes that use
+// another node during their own PreRender (e.g. evaluating a map) call this first, as the other
+// node may come later in the scene.  A node is marked before its PreRender is called so a
+// dependency cycle doesn't recurse, the caller must check for cycles itself.
+func PreRenderNode(node Node) error {
+	if prerendered == nil {
+		prerendered = make(map[Node]bool)
+	}
+
+	if prerendered[node] {
+		return nil
+	}
+
+	prerendered[node] = true
+
+	return node.PreRender()
+}
+
 // PostRender is called on all nodes once Render has returned.
 func PostRender() error {
 	// post process image
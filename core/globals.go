@@ -4,6 +4,11 @@
 
 package core
 
+import (
+	"os"
+	"path/filepath"
+)
+
 // Globals is a node representing the global render settings.
 type Globals struct {
 	NodeDef       NodeDef `node:",opt"`
@@ -13,6 +18,13 @@ type Globals struct {
 	Camera        string  `node:",opt"`
 	MaxIter       int     `node:",opt"`
 	Output        string  `node:",opt"`
+
+	TextureCacheSize int    `node:",opt"` // Megabytes, 0 for unlimited
+	TextureCacheDir  string `node:",opt"` // Where tiled files converted from images are kept
+
+	Cameras []string `node:",opt"` // Cameras rendered together as views, replaces Camera
+
+	MetresPerUnit float32 `node:",opt"` // Size of a world unit for physical units, default 1
 }
 
 var _ Node = (*Globals)(nil)
@@ -28,3 +40,34 @@ func (g *Globals) PreRender() error { return nil }
 
 // PostRender is a node method.
 func (g *Globals) PostRender() error { return nil }
+
+// TextureCacheSize returns the memory limit for the texture cache in bytes, 0 is unlimited.
+func TextureCacheSize() int64 {
+	return int64(globals.TextureCacheSize) << 20
+}
+
+// TextureCacheDir returns the directory for tiled files converted from images, the vermeer
+// directory in the user's cache directory by default.  Returns "" if there isn't one.
+func TextureCacheDir() string {
+	if globals.TextureCacheDir != "" {
+		return globals.TextureCacheDir
+	}
+
+	dir, err := os.UserCacheDir()
+
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "vermeer", "textures")
+}
+
+// MetresPerUnit returns the size of a world unit in metres, used to convert physical camera and
+// light units.
+func MetresPerUnit() float32 {
+	if globals.MetresPerUnit <= 0 {
+		return 1
+	}
+
+	return globals.MetresPerUnit
+}
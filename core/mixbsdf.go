@@ -0,0 +1,140 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+)
+
+// BSDFLobe is a BSDF tinted by an RGB weight.
+type BSDFLobe struct {
+	BSDF   BSDF
+	Weight colour.RGB
+}
+
+// MixBSDF is the weighted sum of several lobes.  It is sampled by picking a lobe in proportion to
+// its (average) weight so that EvaluateLightSamples does MIS against all of the lobes at once
+// rather than lighting each separately.
+//
+// EvaluateLightSamples applies the weights after converting each lobe to RGB, the same as
+// tinting the result of a lone BSDF.  Eval has to weight the spectrum instead.
+type MixBSDF struct {
+	Lobes []BSDFLobe
+}
+
+// Assert that MixBSDF satisfies important interfaces.
+var _ BSDF = (*MixBSDF)(nil)
+
+// selectWeight returns the probability weight of a lobe.
+func (l *BSDFLobe) selectWeight() float32 {
+	return m.Max(0, (l.Weight[0]+l.Weight[1]+l.Weight[2])/3)
+}
+
+// total returns the sum of the lobe selection weights.
+func (b *MixBSDF) total() (total float32) {
+	for i := range b.Lobes {
+		total += b.Lobes[i].selectWeight()
+	}
+
+	return
+}
+
+// Sample implements BSDF.  r0 picks the lobe and is then rescaled to sample it.
+func (b *MixBSDF) Sample(r0, r1 float64) m.Vec3 {
+	total := b.total()
+
+	if total <= 0 {
+		return m.Vec3{}
+	}
+
+	r := r0 * float64(total)
+	idx := -1
+	var p float64
+
+	for i := range b.Lobes {
+		p = float64(b.Lobes[i].selectWeight())
+
+		if p <= 0 {
+			continue
+		}
+
+		idx = i
+
+		if r < p {
+			break
+		}
+
+		r -= p
+	}
+
+	u := r / p
+
+	if u >= 1 {
+		u = math.Nextafter(1, 0)
+	}
+
+	return b.Lobes[idx].BSDF.Sample(u, r1)
+}
+
+// PDF implements BSDF.
+func (b *MixBSDF) PDF(omegaO m.Vec3) (pdf float64) {
+	total := b.total()
+
+	if total <= 0 {
+		return 0
+	}
+
+	for i := range b.Lobes {
+		if p := b.Lobes[i].selectWeight(); p > 0 {
+			pdf += float64(p/total) * b.Lobes[i].BSDF.PDF(omegaO)
+		}
+	}
+
+	return
+}
+
+// Eval implements BSDF.
+func (b *MixBSDF) Eval(omegaO m.Vec3) (rho colour.Spectrum) {
+	for i := range b.Lobes {
+		r := b.Lobes[i].BSDF.Eval(omegaO)
+
+		w := colour.Spectrum{Lambda: r.Lambda}
+		w.FromRGB(b.Lobes[i].Weight)
+
+		r.Mul(w)
+		rho.Add(r)
+		rho.Lambda = r.Lambda
+	}
+
+	return
+}
+
+// bsdfRGB returns the BSDF for omegaO lit by Liu as RGB.  The lobes of a MixBSDF are tinted
+// after converting to RGB.
+func bsdfRGB(bsdf BSDF, omegaO m.Vec3, Liu colour.Spectrum) colour.RGB {
+	mix, ok := bsdf.(*MixBSDF)
+
+	if !ok {
+		rho := bsdf.Eval(omegaO)
+		rho.Mul(Liu)
+
+		return rho.ToRGB()
+	}
+
+	var rgb colour.RGB
+
+	for i := range mix.Lobes {
+		rho := mix.Lobes[i].BSDF.Eval(omegaO)
+		rho.Mul(Liu)
+
+		c := rho.ToRGB()
+		c.Mul(mix.Lobes[i].Weight)
+		rgb.Add(c)
+	}
+
+	return rgb
+}
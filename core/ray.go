@@ -5,6 +5,7 @@
 package core
 
 import (
+	"github.com/jamiec7919/vermeer/colour"
 	m "github.com/jamiec7919/vermeer/math"
 	"math/rand"
 )
@@ -46,6 +47,9 @@ type Ray struct {
 
 	NodesT, LeafsT int
 
+	Transmission colour.RGB // Product of (1-opacity) of the surfaces a shadow ray passed through
+	Weight       colour.RGB // Weight of a camera ray's sample, e.g. vignetting
+
 	next *Ray // Pool list
 	Task *RenderTask
 }
@@ -65,6 +69,8 @@ func (r *Ray) Init(ty uint32, P, D m.Vec3, maxdist float32, level uint8, sc *Sha
 	r.Time = sc.Time
 	r.NodesT = 0
 	r.LeafsT = 0
+	r.Transmission = colour.RGB{1, 1, 1}
+	r.Weight = colour.RGB{1, 1, 1}
 
 	r.Scramble = sc.Scramble // ^ math.Float64bits(pdf)
 	r.I = sc.I
@@ -163,6 +169,8 @@ type RenderTask struct {
 	rand    *rand.Rand
 	rayPool *Ray
 	cxtPool *ShaderContext
+
+	textureLookups uint64 // Added to the totals when the task finishes
 }
 
 // NewRay allocates a ray from the pool.
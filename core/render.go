@@ -33,10 +33,12 @@ type Image struct {
 	PixelDelta [2]float32 // Size of pixel
 }
 
-// Framebuffer represents a buffer of pixels, RGB or deep.
+// Framebuffer represents a buffer of pixels, RGB or deep.  Colours are premultiplied by Alpha,
+// the pixel coverage.
 type Framebuffer struct {
 	Width, Height int
 	Buf           []float32
+	Alpha         []float32
 }
 
 // Aspect returns the aspect ratio of this framebuffer.
@@ -47,6 +49,76 @@ func (fb *Framebuffer) Aspect() float32 {
 var image *Image
 var framebuffer *Framebuffer
 
+// view is a camera rendered into its own framebuffer.
+type view struct {
+	name        string
+	camera      Camera
+	framebuffer *Framebuffer
+}
+
+// views are rendered in turn each iteration, the first uses framebuffer.
+var views []view
+
+// Views returns the names of the cameras rendered, in the order of Globals.Cameras.
+func Views() (names []string) {
+	for _, v := range views {
+		names = append(names, v.name)
+	}
+
+	return
+}
+
+// ViewFramebuffer returns the framebuffer of the named camera, nil if it wasn't rendered.
+func ViewFramebuffer(name string) *Framebuffer {
+	for _, v := range views {
+		if v.name == name {
+			return v.framebuffer
+		}
+	}
+
+	return nil
+}
+
+// findViews finds the cameras to render.  All views share the resolution of the first.
+func findViews() error {
+	names := globals.Cameras
+
+	if len(names) == 0 {
+		name := "camera"
+
+		if globals.Camera != "" {
+			name = globals.Camera
+		}
+
+		names = []string{name}
+	}
+
+	views = nil
+
+	for i, name := range names {
+		camera, ok := FindNode(name).(Camera)
+
+		if !ok {
+			return ErrNoCamera
+		}
+
+		fb := framebuffer
+
+		if i > 0 {
+			fb = &Framebuffer{
+				Width:  framebuffer.Width,
+				Height: framebuffer.Height,
+				Buf:    make([]float32, len(framebuffer.Buf)),
+				Alpha:  make([]float32, len(framebuffer.Alpha)),
+			}
+		}
+
+		views = append(views, view{name, camera, fb})
+	}
+
+	return nil
+}
+
 // FrameAspect returns the aspect ratio of the current framebuffer.
 func FrameAspect() float32 {
 	return framebuffer.Aspect()
@@ -62,6 +134,11 @@ func FrameBuf() []float32 {
 	return framebuffer.Buf
 }
 
+// FrameAlpha returns the []float32 slice of pixel alphas, one per pixel.
+func FrameAlpha() []float32 {
+	return framebuffer.Alpha
+}
+
 // render represents one goroutine.
 func render(iter int, camera Camera, framebuffer *Framebuffer, work chan workitem, wg *sync.WaitGroup) {
 	defer wg.Done()
@@ -72,6 +149,14 @@ func render(iter int, camera Camera, framebuffer *Framebuffer, work chan workite
 	sc := task.NewShaderContext()
 	sc.Image = image
 
+	shutter, _ := camera.(CameraShutter)
+
+	exposure := float32(1)
+
+	if e, ok := camera.(CameraExposure); ok {
+		exposure = e.Exposure()
+	}
+
 	for item := range work {
 		for j := 0; j < item.h; j++ {
 			for i := 0; i < item.w; i++ {
@@ -117,6 +202,10 @@ func render(iter int, camera Camera, framebuffer *Framebuffer, work chan workite
 				sc.Lambda = float32(lambda)
 				sc.Time = float32(time)
 
+				if shutter != nil {
+					sc.Time = shutter.ShutterTime(time, sc.Sx, sc.Sy)
+				}
+
 				camera.ComputeRay(sc, lensU, lensV, ray)
 
 				samp := TraceSample{}
@@ -124,16 +213,23 @@ func render(iter int, camera Camera, framebuffer *Framebuffer, work chan workite
 				ray.Scramble = framescramble[pixIdx].scramble
 				Trace(ray, &samp)
 
+				samp.Colour.Mul(ray.Weight)
+				samp.Colour.Scale(exposure)
+
 				for k := 0; k < 3; k++ {
 					framebuffer.Buf[(x+y*framebuffer.Width)*3+k] = (framebuffer.Buf[(x+y*framebuffer.Width)*3+k]*float32(iter) + samp.Colour[k]) / float32(iter+1)
 				}
 
+				framebuffer.Alpha[x+y*framebuffer.Width] = (framebuffer.Alpha[x+y*framebuffer.Width]*float32(iter) + samp.Alpha) / float32(iter+1)
+
 			}
 		}
 	}
 
 	task.ReleaseRay(ray)
 	task.ReleaseShaderContext(sc)
+
+	addTaskStats(task)
 }
 
 // Render is called to start the render process.
@@ -144,23 +240,9 @@ func Render(maxIter int, exit chan bool) (RenderStats, error) {
 		globals.MaxIter = maxIter
 	}
 
-	// 1. Find camera
-	camName := "camera"
-
-	if globals.Camera != "" {
-		camName = globals.Camera
-	}
-
-	camNode := FindNode(camName)
-
-	if camNode == nil {
-		return stats, ErrNoCamera
-	}
-
-	camera, ok := camNode.(Camera)
-
-	if !ok {
-		return stats, ErrNoCamera
+	// 1. Find cameras
+	if err := findViews(); err != nil {
+		return stats, err
 	}
 
 	framescramble = make([]pixelscramble, framebuffer.Width*framebuffer.Height)
@@ -183,24 +265,27 @@ func Render(maxIter int, exit chan bool) (RenderStats, error) {
 
 	for iter := 0; (iter < globals.MaxIter || globals.MaxIter == 0) && !finish; iter++ {
 
-		// Spawn one goroutine per CPU (ish)
-		workqueue := make(chan workitem)
-		var wg sync.WaitGroup
+		// Each view shares the scene and the pixel scrambles so the noise matches between eyes.
+		for _, v := range views {
+			// Spawn one goroutine per CPU (ish)
+			workqueue := make(chan workitem)
+			var wg sync.WaitGroup
 
-		for i := 0; i < globals.MaxGoRoutines && i < 10; i++ {
-			wg.Add(1)
-			go render(iter+1, camera, framebuffer, workqueue, &wg)
-		}
+			for i := 0; i < globals.MaxGoRoutines && i < 10; i++ {
+				wg.Add(1)
+				go render(iter+1, v.camera, v.framebuffer, workqueue, &wg)
+			}
 
-		// Parcel out frame tiles to the work queues.
-		for j := 0; j < globals.YRes; j += 32 {
-			for i := 0; i < globals.XRes; i += 32 {
-				workqueue <- workitem{i, j, 32, 32}
+			// Parcel out frame tiles to the work queues.
+			for j := 0; j < globals.YRes; j += 32 {
+				for i := 0; i < globals.XRes; i += 32 {
+					workqueue <- workitem{i, j, 32, 32}
+				}
 			}
-		}
 
-		close(workqueue)
-		wg.Wait()
+			close(workqueue)
+			wg.Wait()
+		}
 
 		log.Printf("Iter %v", iter)
 
@@ -48,6 +48,20 @@ type Shader interface {
 	EvalEmission(sc *ShaderContext, omegaO m.Vec3) colour.RGB
 }
 
+// OpacityShader is implemented by shaders which may be partially transparent, e.g. cutout
+// leaves.  Opacity is evaluated while tracing so only the surface parameters, ElemID, Geom,
+// position and normals are set up in sc (see Candidate).
+type OpacityShader interface {
+	Shader
+
+	// HasOpacity returns true if the shader may be anything other than fully opaque.  Geoms
+	// call this before rendering so opaque shaders cost nothing during traversal.
+	HasOpacity() bool
+
+	// EvalOpacity returns the opacity at the surface point, 1 is opaque.
+	EvalOpacity(sc *ShaderContext) colour.RGB
+}
+
 // ShaderContext encapsulates all of the data needed for evaluating shaders.
 // These should only ever be created with NewShaderContext.
 type ShaderContext struct {
@@ -93,6 +107,7 @@ type ShaderContext struct {
 
 	OutRGB      colour.RGB
 	OutSpectrum colour.Spectrum
+	OutAlpha    float32 // Coverage, 1 unless the shader changes it.  OutRGB is premultiplied.
 
 	task *RenderTask
 	next *ShaderContext // Pool link
@@ -275,20 +290,14 @@ func (sc *ShaderContext) EvaluateLightSamples(bsdf BSDF) colour.RGB {
 
 			if !TraceProbe(ray, chsc) {
 
-				rho := bsdf.Eval(ls.Ld)
-
-				//fmt.Printf("%v %v %v : \n", rho, sc.Liu, sc.Weight)
-
-				rho.Mul(ls.Liu)
+				rgb := bsdfRGB(bsdf, ls.Ld, ls.Liu)
 
 				p_hat := float32(nBSDFSamples) * float32(bsdf.PDF(ls.Ld)) / float32(totalSamples)
 
 				p_hat += float32(nLightSamples) * ls.Pdf / float32(totalSamples)
 
-				rho.Scale(1.0 / p_hat)
-
-				//fmt.Printf("%v\n\n", rho)
-				rgb := rho.ToRGB()
+				rgb.Scale(1.0 / p_hat)
+				rgb.Mul(ray.Transmission)
 
 				for k := range rgb {
 					if rgb[k] < 0 {
@@ -316,17 +325,14 @@ func (sc *ShaderContext) EvaluateLightSamples(bsdf BSDF) colour.RGB {
 
 			if !TraceProbe(ray, chsc) {
 
-				rho := bsdf.Eval(bs.Ld)
-
-				rho.Mul(bs.Liu)
+				rgb := bsdfRGB(bsdf, bs.Ld, bs.Liu)
 
 				p_hat := float32(nBSDFSamples) * float32(bs.Pdf) / float32(totalSamples)
 
 				p_hat += float32(nLightSamples) * bs.PdfLight / float32(totalSamples)
 
-				rho.Scale(1.0 / p_hat)
-
-				rgb := rho.ToRGB()
+				rgb.Scale(1.0 / p_hat)
+				rgb.Mul(ray.Transmission)
 
 				//fmt.Printf("%v %v %v %v %v %v %v\n", sc.X, sc.Y, totalSamples, bs.Pdf, p_hat, rho, rgb)
 
@@ -379,15 +385,9 @@ func (sc *ShaderContext) EvaluateLightSamples(bsdf BSDF) colour.RGB {
 
 			if !TraceProbe(ray, chsc) {
 
-				rho := bsdf.Eval(ls.Ld)
-
-				//fmt.Printf("%v %v %v : \n", rho, sc.Liu, sc.Weight)
-
-				rho.Mul(ls.Liu)
-				rho.Scale(1.0 / ls.Pdf)
-
-				//fmt.Printf("%v\n\n", rho)
-				rgb := rho.ToRGB()
+				rgb := bsdfRGB(bsdf, ls.Ld, ls.Liu)
+				rgb.Scale(1.0 / ls.Pdf)
+				rgb.Mul(ray.Transmission)
 
 				col.Add(rgb)
 
@@ -14,12 +14,60 @@ import (
 type RenderStats struct {
 	Duration                 time.Duration
 	RayCount, ShadowRayCount uint64
+	Texture                  TextureStats
 	start                    time.Time
+	textureStart             TextureStats
+}
+
+// TextureStats are the texture cache stats.  Lookups counts texture samples and Misses the
+// samples which had to load tiles.
+type TextureStats struct {
+	Lookups, Misses, Evictions uint64
+	Bytes, PeakBytes           int64
+}
+
+var textureStats func() TextureStats
+
+// textureLookups counts the texture lookups of finished RenderTasks and of lookups made without
+// a task, accessed atomically.
+var textureLookups uint64
+
+// SetTextureStats is called by the texture cache to register a function returning its stats.
+func SetTextureStats(f func() TextureStats) {
+	textureStats = f
+}
+
+// TextureLookups returns the number of texture lookups counted with CountTextureLookup.  Lookups
+// by a RenderTask are included once the task has finished.
+func TextureLookups() uint64 {
+	return atomic.LoadUint64(&textureLookups)
+}
+
+// CountTextureLookup counts a texture lookup for the stats.  Lookups are counted by the
+// RenderTask and added to the total when it finishes, so goroutines don't share a counter.
+func (sc *ShaderContext) CountTextureLookup() {
+	if sc.task != nil {
+		sc.task.textureLookups++
+		return
+	}
+
+	atomic.AddUint64(&textureLookups, 1)
+}
+
+// addTaskStats adds the counts of a finished task to the totals.
+func addTaskStats(task *RenderTask) {
+	atomic.AddUint64(&textureLookups, task.textureLookups)
+	task.textureLookups = 0
 }
 
 // String returns string representation of stats.
 func (s RenderStats) String() string {
-	return fmt.Sprintf("%v	%v	%v/%v", s.Duration, float64(s.RayCount)/(1000000.0*s.Duration.Seconds()), s.RayCount, s.ShadowRayCount)
+	return fmt.Sprintf("%v	%v	%v/%v	%v", s.Duration, float64(s.RayCount)/(1000000.0*s.Duration.Seconds()), s.RayCount, s.ShadowRayCount, s.Texture)
+}
+
+// String returns string representation of texture stats.
+func (s TextureStats) String() string {
+	return fmt.Sprintf("%v/%v/%v	%vMB", s.Lookups, s.Misses, s.Evictions, s.PeakBytes>>20)
 }
 
 // incRayCount atomically increases the ray count.
@@ -37,9 +85,25 @@ func (s *RenderStats) begin() {
 	s.RayCount = 0
 	s.ShadowRayCount = 0
 	s.start = time.Now()
+
+	if textureStats != nil {
+		s.textureStart = textureStats()
+	}
 }
 
 // end stops stat collection
 func (s *RenderStats) end() {
 	s.Duration = time.Since(s.start)
+
+	if textureStats != nil {
+		t := textureStats()
+
+		s.Texture = TextureStats{
+			Lookups:   t.Lookups - s.textureStart.Lookups,
+			Misses:    t.Misses - s.textureStart.Misses,
+			Evictions: t.Evictions - s.textureStart.Evictions,
+			Bytes:     t.Bytes,
+			PeakBytes: t.PeakBytes,
+		}
+	}
 }
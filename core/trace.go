@@ -35,7 +35,9 @@ func TraceProbe(ray *Ray, sg *ShaderContext) bool {
 }
 
 // Trace intersects ray with the scene and evaluates the shader at the first intersection. The
-// result is returned in the samp struct.
+// result is returned in the samp struct.  Surfaces with opacity have already been resolved
+// (see AnyHit) so the sample alpha is 1 if anything was hit, unless the shader sets OutAlpha
+// (e.g. holdouts).
 // Returns true if any intersection or false for none.
 func Trace(ray *Ray, samp *TraceSample) bool {
 
@@ -68,10 +70,15 @@ func Trace(ray *Ray, samp *TraceSample) bool {
 
 		sg.ApplyTransform()
 
+		sg.Ns = sg.N
+		sg.OutAlpha = 1
+
 		sg.Shader.Eval(sg)
 
 		if samp != nil {
 			samp.Colour = sg.OutRGB
+			samp.Opacity = colour.RGB{sg.OutAlpha, sg.OutAlpha, sg.OutAlpha}
+			samp.Alpha = sg.OutAlpha
 			samp.Point = sg.P
 			samp.ElemID = sg.ElemID
 			samp.Geom = sg.Geom
@@ -0,0 +1,246 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package exr implements the OpenEXR image format.
+
+Scanline and tiled (single level) images are supported with half and float channels and none,
+ZIP, ZIPS and PIZ compression.  The reader also reads RLE and PXR24 compression, uint channels,
+multi-part files and the full resolution level of mip-mapped files.  Layers are channels named
+layer.channel, e.g. diffuse.R, as usual for EXR.  The data window is given by X,Y,Width,Height of the image.Spec and the display
+window by FullX,FullY,FullWidth,FullHeight.  ExtraAttribs are written as header attributes, the
+"compression" attribute is one of "none", "zip", "zips" or "piz" (default "zip").
+*/
+package exr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+	"sort"
+)
+
+var errCorrupt = errors.New("EXR: corrupt data")
+
+const (
+	magic = 20000630
+
+	versionNumber = 2
+	flagTiled     = 0x200
+	flagLongNames = 0x400
+	flagNonImage  = 0x800
+	flagMultiPart = 0x1000
+
+	// maxShortName is the longest attribute or channel name without the long names flag.
+	maxShortName = 31
+)
+
+// Pixel types.
+const (
+	pixelUint  = 0
+	pixelHalf  = 1
+	pixelFloat = 2
+)
+
+// compression is the EXR compression method.
+type compression byte
+
+// Compression methods.
+const (
+	compressNone compression = iota
+	compressRLE
+	compressZIPS
+	compressZIP
+	compressPIZ
+	compressPXR24
+)
+
+var compressionNames = map[string]compression{
+	"none": compressNone,
+	"zips": compressZIPS,
+	"zip":  compressZIP,
+	"piz":  compressPIZ,
+}
+
+// String returns the name of the compression.
+func (c compression) String() string {
+	switch c {
+	case compressRLE:
+		return "rle"
+	case compressPXR24:
+		return "pxr24"
+	}
+
+	for name, v := range compressionNames {
+		if v == c {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("compression(%v)", byte(c))
+}
+
+// linesPerBlock returns the number of scanlines in each chunk of a scanline image.
+func (c compression) linesPerBlock() int {
+	switch c {
+	case compressZIP:
+		return 16
+	case compressPIZ:
+		return 32
+	case compressPXR24:
+		return 16
+	}
+
+	return 1
+}
+
+// pixelSize returns the size in bytes of a pixel type.
+func pixelSize(pixelType int32) int {
+	if pixelType == pixelHalf {
+		return 2
+	}
+
+	return 4
+}
+
+// channel is an entry of the channel list.
+type channel struct {
+	name      string
+	pixelType int32
+	index     int // Position in the interleaved pixels of the caller
+}
+
+// attribute is a header attribute.
+type attribute struct {
+	name, ty string
+	value    []byte
+}
+
+type attribsByName []attribute
+
+func (a attribsByName) Len() int           { return len(a) }
+func (a attribsByName) Less(i, j int) bool { return a[i].name < a[j].name }
+func (a attribsByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type channelsByName []channel
+
+func (c channelsByName) Len() int           { return len(c) }
+func (c channelsByName) Less(i, j int) bool { return c[i].name < c[j].name }
+func (c channelsByName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// reservedAttribs are the attributes which are always set from the image.Spec.
+var reservedAttribs = map[string]bool{
+	"channels":      true,
+	"compression":   true,
+	"dataWindow":    true,
+	"displayWindow": true,
+	"lineOrder":     true,
+	"tiles":         true,
+}
+
+// box2i encodes a box2i attribute from the min and max corners.
+func box2i(xMin, yMin, xMax, yMax int) []byte {
+	return le(int32(xMin), int32(yMin), int32(xMax), int32(yMax))
+}
+
+// le encodes the values little-endian.
+func le(values ...interface{}) []byte {
+	var b bytes.Buffer
+
+	for _, v := range values {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+
+	return b.Bytes()
+}
+
+// encodeAttrib returns the EXR type and value of an ExtraAttribs value.
+func encodeAttrib(name string, v interface{}) (attribute, error) {
+	switch t := v.(type) {
+	case string:
+		return attribute{name, "string", []byte(t)}, nil
+	case []string:
+		var b []byte
+
+		for _, s := range t {
+			b = append(b, le(int32(len(s)))...)
+			b = append(b, s...)
+		}
+
+		return attribute{name, "stringvector", b}, nil
+	case int:
+		return attribute{name, "int", le(int32(t))}, nil
+	case float32:
+		return attribute{name, "float", le(t)}, nil
+	case float64:
+		return attribute{name, "double", le(t)}, nil
+	case m.Vec2:
+		return attribute{name, "v2f", le(t)}, nil
+	case m.Vec3:
+		return attribute{name, "v3f", le(t)}, nil
+	case m.Matrix4:
+		// Matrix4 is column major with column vectors which is the same layout as Imath's
+		// row major matrices with row vectors.
+		return attribute{name, "m44f", le(t)}, nil
+	}
+
+	return attribute{}, fmt.Errorf("EXR: attribute %v has unsupported type %T", name, v)
+}
+
+// writeHeader writes the magic number, version and the attributes sorted by name.
+func writeHeader(b *bytes.Buffer, attribs []attribute, version int32) {
+	sort.Sort(attribsByName(attribs))
+
+	b.Write(le(int32(magic), version))
+
+	for _, a := range attribs {
+		b.WriteString(a.name)
+		b.WriteByte(0)
+		b.WriteString(a.ty)
+		b.WriteByte(0)
+		b.Write(le(int32(len(a.value))))
+		b.Write(a.value)
+	}
+
+	b.WriteByte(0)
+}
+
+// encodeChannels encodes the channel list attribute, the channels are sorted by name.
+func encodeChannels(channels []channel) []byte {
+	var b bytes.Buffer
+
+	for _, c := range channels {
+		b.WriteString(c.name)
+		b.WriteByte(0)
+		b.Write(le(c.pixelType, uint8(0), [3]uint8{}, int32(1), int32(1)))
+	}
+
+	b.WriteByte(0)
+
+	return b.Bytes()
+}
+
+// putPixel appends the pixel value v in the given type.
+func putPixel(b []byte, pixelType int32, v float32) []byte {
+	switch pixelType {
+	case pixelHalf:
+		h := m.Float32ToFloat16(v)
+		return append(b, byte(h), byte(h>>8))
+	case pixelUint:
+		u := uint32(0)
+
+		if v > 0 {
+			u = uint32(v)
+		}
+
+		return append(b, byte(u), byte(u>>8), byte(u>>16), byte(u>>24))
+	}
+
+	u := math.Float32bits(v)
+
+	return append(b, byte(u), byte(u>>8), byte(u>>16), byte(u>>24))
+}
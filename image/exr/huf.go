@@ -0,0 +1,492 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"container/heap"
+	"encoding/binary"
+)
+
+// The Huffman coder of the PIZ compression.  Codes are stored as length | code<<6.
+const (
+	hufEncBits = 16
+	hufEncSize = 1<<hufEncBits + 1
+
+	shortZeroCodeRun = 59
+	longZeroCodeRun  = 63
+	shortestLongRun  = 2 + longZeroCodeRun - shortZeroCodeRun
+	longestLongRun   = 255 + shortestLongRun
+
+	maxCodeLength = 58
+)
+
+func hufLength(code uint64) int { return int(code & 63) }
+
+func hufCode(code uint64) uint64 { return code >> 6 }
+
+// bitWriter writes bits most significant first.
+type bitWriter struct {
+	c   uint64
+	lc  int
+	out []byte
+}
+
+func (w *bitWriter) bits(n int, bits uint64) {
+	w.c = w.c<<uint(n) | bits
+	w.lc += n
+
+	for w.lc >= 8 {
+		w.lc -= 8
+		w.out = append(w.out, byte(w.c>>uint(w.lc)))
+	}
+}
+
+func (w *bitWriter) code(code uint64) {
+	w.bits(hufLength(code), hufCode(code))
+}
+
+// flush writes the remaining bits padded with zeros.
+func (w *bitWriter) flush() {
+	if w.lc > 0 {
+		w.out = append(w.out, byte(w.c<<uint(8-w.lc)))
+		w.lc = 0
+	}
+}
+
+// freqHeap is a min-heap of symbols ordered by frequency.
+type freqHeap struct {
+	frq []uint64
+	sym []int
+}
+
+func (h *freqHeap) Len() int           { return len(h.sym) }
+func (h *freqHeap) Less(i, j int) bool { return h.frq[h.sym[i]] < h.frq[h.sym[j]] }
+func (h *freqHeap) Swap(i, j int)      { h.sym[i], h.sym[j] = h.sym[j], h.sym[i] }
+func (h *freqHeap) Push(x interface{}) { h.sym = append(h.sym, x.(int)) }
+func (h *freqHeap) Pop() (x interface{}) {
+	x, h.sym = h.sym[len(h.sym)-1], h.sym[:len(h.sym)-1]
+	return
+}
+
+// hufCanonicalCodeTable replaces the code lengths in hcode with the canonical codes.
+func hufCanonicalCodeTable(hcode []uint64) {
+	var n [maxCodeLength + 1]uint64
+
+	for _, l := range hcode {
+		n[l]++
+	}
+
+	// The numerically lowest code of each length, longer codes are lower.
+	c := uint64(0)
+
+	for i := maxCodeLength; i > 0; i-- {
+		nc := (c + n[i]) >> 1
+		n[i] = c
+		c = nc
+	}
+
+	for i, l := range hcode {
+		if l > 0 {
+			hcode[i] = l | n[l]<<6
+			n[l]++
+		}
+	}
+}
+
+// hufBuildEncTable replaces the frequencies in frq with the codes and returns the range of
+// symbols used.  A pseudo symbol iM is added for run lengths.
+func hufBuildEncTable(frq []uint64) (im, iM int) {
+	for frq[im] == 0 {
+		im++
+	}
+
+	hlink := make([]int, hufEncSize)
+	h := &freqHeap{frq: frq}
+
+	for i := im; i < hufEncSize; i++ {
+		hlink[i] = i
+
+		if frq[i] != 0 {
+			h.sym = append(h.sym, i)
+			iM = i
+		}
+	}
+
+	iM++
+	frq[iM] = 1
+	h.sym = append(h.sym, iM)
+
+	heap.Init(h)
+
+	// Repeatedly merge the two least frequent nodes, each symbol in the merged lists gets a bit
+	// longer.  Lists are linked through hlink, ending where hlink[j] == j.
+	scode := make([]uint64, hufEncSize)
+
+	for h.Len() > 1 {
+		mm := heap.Pop(h).(int)
+		m := heap.Pop(h).(int)
+
+		frq[m] += frq[mm]
+		heap.Push(h, m)
+
+		for j := m; ; j = hlink[j] {
+			scode[j]++
+
+			if hlink[j] == j {
+				hlink[j] = mm
+				break
+			}
+		}
+
+		for j := mm; ; j = hlink[j] {
+			scode[j]++
+
+			if hlink[j] == j {
+				break
+			}
+		}
+	}
+
+	hufCanonicalCodeTable(scode)
+	copy(frq, scode)
+
+	return
+}
+
+// hufPackEncTable writes the code lengths from im to iM with runs of zeros compressed.
+func hufPackEncTable(hcode []uint64, im, iM int, w *bitWriter) {
+	for ; im <= iM; im++ {
+		l := hufLength(hcode[im])
+
+		if l == 0 {
+			zerun := 1
+
+			for im < iM && zerun < longestLongRun {
+				if hufLength(hcode[im+1]) > 0 {
+					break
+				}
+
+				im++
+				zerun++
+			}
+
+			if zerun >= 2 {
+				if zerun >= shortestLongRun {
+					w.bits(6, longZeroCodeRun)
+					w.bits(8, uint64(zerun-shortestLongRun))
+				} else {
+					w.bits(6, uint64(shortZeroCodeRun+zerun-2))
+				}
+
+				continue
+			}
+		}
+
+		w.bits(6, uint64(l))
+	}
+
+	w.flush()
+}
+
+// hufSendCode writes runCount+1 copies of the symbol, as a run if shorter.
+func hufSendCode(sCode uint64, runCount int, runCode uint64, w *bitWriter) {
+	if hufLength(sCode)+hufLength(runCode)+8 < hufLength(sCode)*runCount {
+		w.code(sCode)
+		w.code(runCode)
+		w.bits(8, uint64(runCount))
+	} else {
+		for ; runCount >= 0; runCount-- {
+			w.code(sCode)
+		}
+	}
+}
+
+// hufEncode writes the data and returns the number of bits.
+func hufEncode(hcode []uint64, in []uint16, rlc int, w *bitWriter) int {
+	s := in[0]
+	cs := 0
+
+	for _, v := range in[1:] {
+		if s == v && cs < 255 {
+			cs++
+		} else {
+			hufSendCode(hcode[s], cs, hcode[rlc], w)
+			cs = 0
+		}
+
+		s = v
+	}
+
+	hufSendCode(hcode[s], cs, hcode[rlc], w)
+
+	nBits := len(w.out)*8 + w.lc
+	w.flush()
+
+	return nBits
+}
+
+// hufCompress Huffman encodes raw.
+func hufCompress(raw []uint16) []byte {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	frq := make([]uint64, hufEncSize)
+
+	for _, v := range raw {
+		frq[v]++
+	}
+
+	im, iM := hufBuildEncTable(frq)
+
+	var table, data bitWriter
+
+	hufPackEncTable(frq, im, iM, &table)
+	nBits := hufEncode(frq, raw, iM, &data)
+
+	out := make([]byte, 20, 20+len(table.out)+len(data.out))
+
+	binary.LittleEndian.PutUint32(out[0:], uint32(im))
+	binary.LittleEndian.PutUint32(out[4:], uint32(iM))
+	binary.LittleEndian.PutUint32(out[8:], uint32(len(table.out)))
+	binary.LittleEndian.PutUint32(out[12:], uint32(nBits))
+
+	out = append(out, table.out...)
+
+	return append(out, data.out...)
+}
+
+// hufDecBits is the size of the lookup table for short codes.
+const hufDecBits = 14
+
+// hufDecoder decodes the canonical code, codes up to hufDecBits long are found in the table,
+// longer codes by searching each length.
+type hufDecoder struct {
+	table [1 << hufDecBits]uint32 // symbol<<8 | length
+	first [maxCodeLength + 1]uint64
+	syms  [maxCodeLength + 1][]int
+}
+
+// newHufDecoder builds the decoder from the canonical codes.
+func newHufDecoder(hcode []uint64, im, iM int) (*hufDecoder, error) {
+	d := &hufDecoder{}
+
+	for i := range d.first {
+		d.first[i] = 1 << 63
+	}
+
+	for i := im; i <= iM; i++ {
+		l := hufLength(hcode[i])
+		c := hufCode(hcode[i])
+
+		if l == 0 {
+			continue
+		}
+
+		if c>>uint(l) != 0 {
+			return nil, errCorrupt
+		}
+
+		if len(d.syms[l]) == 0 {
+			d.first[l] = c
+		}
+
+		d.syms[l] = append(d.syms[l], i)
+
+		if l <= hufDecBits {
+			shift := uint(hufDecBits - l)
+
+			for j := c << shift; j < (c+1)<<shift; j++ {
+				d.table[j] = uint32(i)<<8 | uint32(l)
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// hufUnpackEncTable reads the code lengths from im to iM and returns the canonical codes and
+// the number of bytes read.
+func hufUnpackEncTable(in []byte, im, iM int) ([]uint64, int, error) {
+	hcode := make([]uint64, hufEncSize)
+
+	var c uint64
+	lc, p := 0, 0
+
+	getBits := func(n int) (uint64, error) {
+		for lc < n {
+			if p >= len(in) {
+				return 0, errCorrupt
+			}
+
+			c = c<<8 | uint64(in[p])
+			p++
+			lc += 8
+		}
+
+		lc -= n
+
+		return (c >> uint(lc)) & (1<<uint(n) - 1), nil
+	}
+
+	for ; im <= iM; im++ {
+		l, err := getBits(6)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		hcode[im] = l
+
+		zerun := 0
+
+		if l == longZeroCodeRun {
+			n, err := getBits(8)
+
+			if err != nil {
+				return nil, 0, err
+			}
+
+			zerun = int(n) + shortestLongRun
+		} else if l >= shortZeroCodeRun {
+			zerun = int(l) - shortZeroCodeRun + 2
+		}
+
+		if zerun > 0 {
+			if im+zerun > iM+1 {
+				return nil, 0, errCorrupt
+			}
+
+			for k := 0; k < zerun; k++ {
+				hcode[im+k] = 0
+			}
+
+			im += zerun - 1
+		}
+	}
+
+	hufCanonicalCodeTable(hcode)
+
+	return hcode, p, nil
+}
+
+// hufUncompress decodes n values from the Huffman encoded data.
+func hufUncompress(data []byte, n int) ([]uint16, error) {
+	if len(data) == 0 {
+		if n != 0 {
+			return nil, errCorrupt
+		}
+
+		return nil, nil
+	}
+
+	if len(data) < 20 {
+		return nil, errCorrupt
+	}
+
+	im := int(binary.LittleEndian.Uint32(data[0:]))
+	iM := int(binary.LittleEndian.Uint32(data[4:]))
+	nBits := int(binary.LittleEndian.Uint32(data[12:]))
+
+	if im < 0 || im >= hufEncSize || iM < im || iM >= hufEncSize {
+		return nil, errCorrupt
+	}
+
+	hcode, tableLength, err := hufUnpackEncTable(data[20:], im, iM)
+
+	if err != nil {
+		return nil, err
+	}
+
+	in := data[20+tableLength:]
+
+	if nBits > 8*len(in) {
+		return nil, errCorrupt
+	}
+
+	d, err := newHufDecoder(hcode, im, iM)
+
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]uint16, 0, n)
+
+	var c uint64
+	lc, p, consumed := 0, 0, 0
+
+	for consumed < nBits {
+		for lc <= 56 && p < len(in) {
+			c = c<<8 | uint64(in[p])
+			p++
+			lc += 8
+		}
+
+		sym, l := -1, 0
+
+		// Short codes from the table, padding with zeros at the end of the data.
+		var peek uint64
+
+		if lc >= hufDecBits {
+			peek = c >> uint(lc-hufDecBits)
+		} else {
+			peek = c << uint(hufDecBits-lc)
+		}
+
+		if e := d.table[peek&(1<<hufDecBits-1)]; e&0xff != 0 {
+			sym, l = int(e>>8), int(e&0xff)
+		} else {
+			for k := hufDecBits + 1; k <= maxCodeLength && k <= lc; k++ {
+				v := (c >> uint(lc-k)) & (1<<uint(k) - 1)
+
+				if v >= d.first[k] && v-d.first[k] < uint64(len(d.syms[k])) {
+					sym, l = d.syms[k][v-d.first[k]], k
+					break
+				}
+			}
+		}
+
+		if sym < 0 || l > lc || consumed+l > nBits {
+			return nil, errCorrupt
+		}
+
+		lc -= l
+		consumed += l
+
+		if sym != iM {
+			if len(out) >= n {
+				return nil, errCorrupt
+			}
+
+			out = append(out, uint16(sym))
+			continue
+		}
+
+		// Run of the previous value.
+		if lc < 8 || consumed+8 > nBits || len(out) == 0 {
+			return nil, errCorrupt
+		}
+
+		lc -= 8
+		consumed += 8
+		cs := int(c>>uint(lc)) & 0xff
+
+		if len(out)+cs > n {
+			return nil, errCorrupt
+		}
+
+		s := out[len(out)-1]
+
+		for ; cs > 0; cs-- {
+			out = append(out, s)
+		}
+	}
+
+	if len(out) != n {
+		return nil, errCorrupt
+	}
+
+	return out, nil
+}
@@ -0,0 +1,378 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"encoding/binary"
+)
+
+const (
+	usRange    = 1 << 16
+	bitmapSize = usRange >> 3
+)
+
+// Constants of the 16 bit wavelet.
+const (
+	aOffset = 1 << 15
+	mOffset = 1 << 15
+	modMask = 1<<16 - 1
+)
+
+// pizChannel describes a channel of a PIZ block, size is the number of 16 bit words per value.
+type pizChannel struct {
+	nx, ny, size int
+}
+
+// wenc14 is the 14 bit wavelet encoding of a,b into average and difference.
+func wenc14(a, b uint16) (l, h uint16) {
+	as, bs := int(int16(a)), int(int16(b))
+
+	return uint16((as + bs) >> 1), uint16(as - bs)
+}
+
+// wenc16 is the 16 bit wavelet encoding using modular arithmetic.
+func wenc16(a, b uint16) (l, h uint16) {
+	ao := (int(a) + aOffset) & modMask
+	m := (ao + int(b)) >> 1
+	d := ao - int(b)
+
+	if d < 0 {
+		m = (m + mOffset) & modMask
+	}
+
+	return uint16(m), uint16(d & modMask)
+}
+
+// wav2Encode applies the 2D Haar wavelet transform to the nx by ny values at buf[in] with
+// strides ox and oy.  mx is the largest value.
+func wav2Encode(buf []uint16, in, nx, ox, ny, oy int, mx uint16) {
+	enc := wenc16
+
+	if mx < 1<<14 {
+		enc = wenc14
+	}
+
+	n := nx
+
+	if ny < n {
+		n = ny
+	}
+
+	for p, p2 := 1, 2; p2 <= n; p, p2 = p2, p2<<1 {
+		oy1, oy2 := oy*p, oy*p2
+		ox1, ox2 := ox*p, ox*p2
+
+		py := in
+		ey := in + oy*(ny-p2)
+
+		for ; py <= ey; py += oy2 {
+			px := py
+			ex := py + ox*(nx-p2)
+
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				p10 := px + oy1
+				p11 := p10 + ox1
+
+				i00, i01 := enc(buf[px], buf[p01])
+				i10, i11 := enc(buf[p10], buf[p11])
+				buf[px], buf[p10] = enc(i00, i10)
+				buf[p01], buf[p11] = enc(i01, i11)
+			}
+
+			// Odd column.
+			if nx&p != 0 {
+				p10 := px + oy1
+				buf[px], buf[p10] = enc(buf[px], buf[p10])
+			}
+		}
+
+		// Odd line.
+		if ny&p != 0 {
+			px := py
+			ex := py + ox*(nx-p2)
+
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				buf[px], buf[p01] = enc(buf[px], buf[p01])
+			}
+		}
+	}
+}
+
+// pizCompress compresses a block of scanlines.  The values are mapped to a dense range, wavelet
+// transformed per channel and then Huffman encoded.
+func pizCompress(data []byte, channels []pizChannel) []byte {
+	tmp := make([]uint16, len(data)/2)
+
+	// Gather the values of each channel together.
+	start := make([]int, len(channels))
+	end := make([]int, len(channels))
+	n := 0
+
+	for i, c := range channels {
+		start[i] = n
+		end[i] = n
+		n += c.nx * c.ny * c.size
+	}
+
+	ny := 0
+
+	if len(channels) > 0 {
+		ny = channels[0].ny
+	}
+
+	p := 0
+
+	for y := 0; y < ny; y++ {
+		for i, c := range channels {
+			for k := 0; k < c.nx*c.size; k++ {
+				tmp[end[i]] = binary.LittleEndian.Uint16(data[p:])
+				end[i]++
+				p += 2
+			}
+		}
+	}
+
+	// Bitmap of the values used, zero is always assumed.
+	var bitmap [bitmapSize]byte
+
+	for _, v := range tmp {
+		bitmap[v>>3] |= 1 << (v & 7)
+	}
+
+	bitmap[0] &^= 1
+
+	minNonZero, maxNonZero := bitmapSize-1, 0
+
+	for i, b := range bitmap {
+		if b != 0 {
+			if i < minNonZero {
+				minNonZero = i
+			}
+
+			if i > maxNonZero {
+				maxNonZero = i
+			}
+		}
+	}
+
+	// Forward LUT.
+	lut := make([]uint16, usRange)
+	k := 0
+
+	for i := 0; i < usRange; i++ {
+		if i == 0 || bitmap[i>>3]&(1<<uint(i&7)) != 0 {
+			lut[i] = uint16(k)
+			k++
+		}
+	}
+
+	maxValue := uint16(k - 1)
+
+	for i, v := range tmp {
+		tmp[i] = lut[v]
+	}
+
+	out := le(uint16(minNonZero), uint16(maxNonZero))
+
+	if minNonZero <= maxNonZero {
+		out = append(out, bitmap[minNonZero:maxNonZero+1]...)
+	}
+
+	for i, c := range channels {
+		for j := 0; j < c.size; j++ {
+			wav2Encode(tmp, start[i]+j, c.nx, c.size, c.ny, c.nx*c.size, maxValue)
+		}
+	}
+
+	huf := hufCompress(tmp)
+
+	out = append(out, le(int32(len(huf)))...)
+
+	return append(out, huf...)
+}
+
+// wdec14 inverts wenc14.
+func wdec14(l, h uint16) (a, b uint16) {
+	ls, hi := int(int16(l)), int(int16(h))
+	ai := ls + (hi & 1) + (hi >> 1)
+
+	return uint16(ai), uint16(ai - hi)
+}
+
+// wdec16 inverts wenc16.
+func wdec16(l, h uint16) (a, b uint16) {
+	m, d := int(l), int(h)
+	bb := (m - (d >> 1)) & modMask
+	aa := (d + bb - aOffset) & modMask
+
+	return uint16(aa), uint16(bb)
+}
+
+// wav2Decode inverts wav2Encode.
+func wav2Decode(buf []uint16, in, nx, ox, ny, oy int, mx uint16) {
+	dec := wdec16
+
+	if mx < 1<<14 {
+		dec = wdec14
+	}
+
+	n := nx
+
+	if ny < n {
+		n = ny
+	}
+
+	// Start from the coarsest level.
+	p := 1
+
+	for p <= n {
+		p <<= 1
+	}
+
+	p >>= 1
+	p2 := p
+	p >>= 1
+
+	for ; p >= 1; p2, p = p, p>>1 {
+		oy1, oy2 := oy*p, oy*p2
+		ox1, ox2 := ox*p, ox*p2
+
+		py := in
+		ey := in + oy*(ny-p2)
+
+		for ; py <= ey; py += oy2 {
+			px := py
+			ex := py + ox*(nx-p2)
+
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				p10 := px + oy1
+				p11 := p10 + ox1
+
+				i00, i10 := dec(buf[px], buf[p10])
+				i01, i11 := dec(buf[p01], buf[p11])
+				buf[px], buf[p01] = dec(i00, i01)
+				buf[p10], buf[p11] = dec(i10, i11)
+			}
+
+			// Odd column.
+			if nx&p != 0 {
+				p10 := px + oy1
+				buf[px], buf[p10] = dec(buf[px], buf[p10])
+			}
+		}
+
+		// Odd line.
+		if ny&p != 0 {
+			px := py
+			ex := py + ox*(nx-p2)
+
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				buf[px], buf[p01] = dec(buf[px], buf[p01])
+			}
+		}
+	}
+}
+
+// pizUncompress decodes a PIZ block into n bytes.
+func pizUncompress(data []byte, channels []pizChannel, n int) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errCorrupt
+	}
+
+	minNonZero := int(binary.LittleEndian.Uint16(data[0:]))
+	maxNonZero := int(binary.LittleEndian.Uint16(data[2:]))
+	data = data[4:]
+
+	if maxNonZero >= bitmapSize {
+		return nil, errCorrupt
+	}
+
+	var bitmap [bitmapSize]byte
+
+	if minNonZero <= maxNonZero {
+		if len(data) < maxNonZero-minNonZero+1 {
+			return nil, errCorrupt
+		}
+
+		copy(bitmap[minNonZero:maxNonZero+1], data)
+		data = data[maxNonZero-minNonZero+1:]
+	}
+
+	// Reverse LUT.
+	lut := make([]uint16, usRange)
+	k := 0
+
+	for i := 0; i < usRange; i++ {
+		if i == 0 || bitmap[i>>3]&(1<<uint(i&7)) != 0 {
+			lut[k] = uint16(i)
+			k++
+		}
+	}
+
+	maxValue := uint16(k - 1)
+
+	if len(data) < 4 {
+		return nil, errCorrupt
+	}
+
+	length := int(int32(binary.LittleEndian.Uint32(data)))
+	data = data[4:]
+
+	if length < 0 || length > len(data) {
+		return nil, errCorrupt
+	}
+
+	tmp, err := hufUncompress(data[:length], n/2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	start := make([]int, len(channels))
+	next := 0
+
+	for i, c := range channels {
+		start[i] = next
+		next += c.nx * c.ny * c.size
+	}
+
+	if next != len(tmp) {
+		return nil, errCorrupt
+	}
+
+	for i, c := range channels {
+		for j := 0; j < c.size; j++ {
+			wav2Decode(tmp, start[i]+j, c.nx, c.size, c.ny, c.nx*c.size, maxValue)
+		}
+	}
+
+	for i, v := range tmp {
+		tmp[i] = lut[v]
+	}
+
+	// Scanlines of each channel in turn.
+	out := make([]byte, 0, n)
+	ny := 0
+
+	if len(channels) > 0 {
+		ny = channels[0].ny
+	}
+
+	for y := 0; y < ny; y++ {
+		for i, c := range channels {
+			for k := 0; k < c.nx*c.size; k++ {
+				v := tmp[start[i]]
+				out = append(out, byte(v), byte(v>>8))
+				start[i]++
+			}
+		}
+	}
+
+	return out, nil
+}
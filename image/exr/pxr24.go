@@ -0,0 +1,74 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+)
+
+// pxr24Uncompress decodes a PXR24 block of nx by ny pixels.  Each scanline of each channel is
+// stored as byte planes of the differences between values, floats are truncated to 24 bits.
+func pxr24Uncompress(data []byte, channels []channel, nx, ny int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.ReadAll(zr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+
+	p := 0
+
+	for y := 0; y < ny; y++ {
+		for _, c := range channels {
+			planes := 4
+
+			switch c.pixelType {
+			case pixelHalf:
+				planes = 2
+			case pixelFloat:
+				planes = 3
+			}
+
+			if p+planes*nx > len(tmp) {
+				return nil, errCorrupt
+			}
+
+			pixel := uint32(0)
+
+			for x := 0; x < nx; x++ {
+				diff := uint32(0)
+
+				for k := 0; k < planes; k++ {
+					diff = diff<<8 | uint32(tmp[p+k*nx+x])
+				}
+
+				switch c.pixelType {
+				case pixelHalf:
+					pixel += diff
+					out = append(out, byte(pixel), byte(pixel>>8))
+				case pixelFloat:
+					pixel += diff << 8
+					out = append(out, byte(pixel), byte(pixel>>8), byte(pixel>>16), byte(pixel>>24))
+				default:
+					pixel += diff
+					out = append(out, byte(pixel), byte(pixel>>8), byte(pixel>>16), byte(pixel>>24))
+				}
+			}
+
+			p += planes * nx
+		}
+	}
+
+	return out, nil
+}
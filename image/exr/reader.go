@@ -0,0 +1,748 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jamiec7919/vermeer/image"
+	m "github.com/jamiec7919/vermeer/math"
+	"io"
+	"math"
+	"os"
+)
+
+// Reader implements an image reader for OpenEXR files.  Multi-part files hold several images,
+// the first part is read unless another is chosen with SetPart.
+type Reader struct {
+	file      *os.File
+	multiPart bool
+	parts     []*part
+	part      *part
+}
+
+// part is one image of the file.
+type part struct {
+	spec        image.Spec
+	channels    []channel
+	compression compression
+	tiled       bool
+	offsets     []uint64 // Chunks of the full resolution level
+	chunkCount  int
+}
+
+func init() {
+	image.RegisterReader(func(filename string) (image.Reader, error) {
+		r, err := Open(filename)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return r, nil
+	})
+}
+
+// Open attempts to open the given filename and returns an instance of the reader or nil and an
+// error.
+func Open(filename string) (*Reader, error) {
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{file: file}
+
+	if err := r.readHeaders(bufio.NewReader(file)); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// NumParts returns the number of images in the file.
+func (r *Reader) NumParts() int { return len(r.parts) }
+
+// SetPart selects the image read by the other methods.
+func (r *Reader) SetPart(n int) error {
+	if n < 0 || n >= len(r.parts) {
+		return fmt.Errorf("EXR: no part %v", n)
+	}
+
+	r.part = r.parts[n]
+
+	return nil
+}
+
+// readHeaders reads the headers and the offset tables of the parts.
+func (r *Reader) readHeaders(in *bufio.Reader) error {
+	var header [2]int32
+
+	if err := binary.Read(in, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+
+	if header[0] != magic {
+		return errors.New("EXR: not an OpenEXR file")
+	}
+
+	version := header[1]
+
+	if version&0xff != versionNumber {
+		return fmt.Errorf("EXR: unsupported version %v", version&0xff)
+	}
+
+	if version&flagNonImage != 0 {
+		return errors.New("EXR: deep images are unsupported")
+	}
+
+	r.multiPart = version&flagMultiPart != 0
+
+	for {
+		attribs, err := readAttribs(in)
+
+		if err != nil {
+			return err
+		}
+
+		// Multi-part headers end with an empty header.
+		if len(attribs) == 0 && r.multiPart {
+			break
+		}
+
+		p, err := newPart(attribs, version&flagTiled != 0)
+
+		if err != nil {
+			return err
+		}
+
+		r.parts = append(r.parts, p)
+
+		if !r.multiPart {
+			break
+		}
+	}
+
+	if len(r.parts) == 0 {
+		return errors.New("EXR: no images")
+	}
+
+	// Only the offsets of the first level are kept, levels are in order of decreasing size.
+	for _, p := range r.parts {
+		offsets := make([]uint64, p.chunkCount)
+
+		if err := binary.Read(in, binary.LittleEndian, offsets); err != nil {
+			return err
+		}
+
+		p.offsets = offsets[:p.level0Chunks()]
+	}
+
+	r.part = r.parts[0]
+
+	return nil
+}
+
+// readAttribs reads the attributes of a header up to the terminating null.
+func readAttribs(in *bufio.Reader) (map[string]attribute, error) {
+	attribs := map[string]attribute{}
+
+	for {
+		name, err := in.ReadString(0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		name = name[:len(name)-1]
+
+		if name == "" {
+			return attribs, nil
+		}
+
+		ty, err := in.ReadString(0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var size int32
+
+		if err := binary.Read(in, binary.LittleEndian, &size); err != nil {
+			return nil, err
+		}
+
+		if size < 0 {
+			return nil, errCorrupt
+		}
+
+		value := make([]byte, size)
+
+		if _, err := io.ReadFull(in, value); err != nil {
+			return nil, err
+		}
+
+		attribs[name] = attribute{name, ty[:len(ty)-1], value}
+	}
+}
+
+// newPart builds the spec of an image from its header attributes.
+func newPart(attribs map[string]attribute, tiled bool) (*part, error) {
+	p := &part{}
+
+	if t, ok := attribs["type"]; ok {
+		switch string(t.value) {
+		case "scanlineimage":
+			tiled = false
+		case "tiledimage":
+			tiled = true
+		default:
+			return nil, fmt.Errorf("EXR: unsupported part type %v", string(t.value))
+		}
+	}
+
+	for _, name := range []string{"channels", "compression", "dataWindow", "displayWindow"} {
+		if _, ok := attribs[name]; !ok {
+			return nil, fmt.Errorf("EXR: missing %v attribute", name)
+		}
+	}
+
+	channels, err := decodeChannels(attribs["channels"].value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attribs["compression"].value) != 1 {
+		return nil, errCorrupt
+	}
+
+	p.channels = channels
+	p.compression = compression(attribs["compression"].value[0])
+	p.tiled = tiled
+
+	switch p.compression {
+	case compressNone, compressRLE, compressZIPS, compressZIP, compressPIZ, compressPXR24:
+	default:
+		return nil, fmt.Errorf("EXR: unsupported compression %v", p.compression)
+	}
+
+	var data, display [4]int32
+
+	if err := decodeValue(attribs["dataWindow"].value, &data); err != nil {
+		return nil, err
+	}
+
+	if err := decodeValue(attribs["displayWindow"].value, &display); err != nil {
+		return nil, err
+	}
+
+	spec := &p.spec
+	spec.X, spec.Y = int(data[0]), int(data[1])
+	spec.Width, spec.Height = int(data[2]-data[0])+1, int(data[3]-data[1])+1
+	spec.FullX, spec.FullY = int(display[0]), int(display[1])
+	spec.FullWidth, spec.FullHeight = int(display[2]-display[0])+1, int(display[3]-display[1])+1
+	spec.Depth, spec.FullDepth = 1, 1
+	spec.NChannels = len(channels)
+	spec.AlphaChannel = -1
+	spec.ZChannel = -1
+
+	if spec.Width <= 0 || spec.Height <= 0 {
+		return nil, errCorrupt
+	}
+
+	for i, c := range channels {
+		spec.ChannelNames = append(spec.ChannelNames, c.name)
+
+		switch c.pixelType {
+		case pixelHalf:
+			spec.Format = append(spec.Format, image.TypeDesc{BaseType: image.HALF})
+		case pixelFloat:
+			spec.Format = append(spec.Format, image.TypeDesc{BaseType: image.FLOAT})
+		default:
+			spec.Format = append(spec.Format, image.TypeDesc{BaseType: image.UINT})
+		}
+
+		switch c.name {
+		case "A":
+			spec.AlphaChannel = i
+		case "Z":
+			spec.ZChannel = i
+		}
+	}
+
+	if tiled {
+		t, ok := attribs["tiles"]
+
+		if !ok {
+			return nil, errors.New("EXR: missing tiles attribute")
+		}
+
+		var desc struct {
+			XSize, YSize uint32
+			Mode         uint8
+		}
+
+		if err := decodeValue(t.value, &desc); err != nil {
+			return nil, err
+		}
+
+		if desc.XSize == 0 || desc.YSize == 0 {
+			return nil, errCorrupt
+		}
+
+		spec.TileWidth, spec.TileHeight, spec.TileDepth = int(desc.XSize), int(desc.YSize), 1
+	}
+
+	p.chunkCount = p.level0Chunks()
+
+	if c, ok := attribs["chunkCount"]; ok {
+		var n int32
+
+		if err := decodeValue(c.value, &n); err != nil {
+			return nil, err
+		}
+
+		if int(n) < p.chunkCount {
+			return nil, errCorrupt
+		}
+
+		p.chunkCount = int(n)
+	}
+
+	spec.ExtraAttribs = map[string]interface{}{"compression": p.compression.String()}
+
+	for name, a := range attribs {
+		switch name {
+		case "channels", "compression", "dataWindow", "displayWindow", "tiles", "chunkCount":
+			continue
+		}
+
+		spec.ExtraAttribs[name] = decodeAttrib(a)
+	}
+
+	return p, nil
+}
+
+// level0Chunks returns the number of chunks of the full resolution image.
+func (p *part) level0Chunks() int {
+	if p.tiled {
+		return p.tilesX() * p.tilesY()
+	}
+
+	lines := p.compression.linesPerBlock()
+
+	return (p.spec.Height + lines - 1) / lines
+}
+
+func (p *part) tilesX() int { return (p.spec.Width + p.spec.TileWidth - 1) / p.spec.TileWidth }
+
+func (p *part) tilesY() int { return (p.spec.Height + p.spec.TileHeight - 1) / p.spec.TileHeight }
+
+// decodeChannels decodes the channel list attribute.
+func decodeChannels(value []byte) ([]channel, error) {
+	var channels []channel
+
+	for {
+		i := bytes.IndexByte(value, 0)
+
+		if i < 0 {
+			return nil, errCorrupt
+		}
+
+		if i == 0 {
+			return channels, nil
+		}
+
+		var desc struct {
+			PixelType            int32
+			PLinear              uint8
+			Reserved             [3]uint8
+			XSampling, YSampling int32
+		}
+
+		if err := decodeValue(value[i+1:], &desc); err != nil {
+			return nil, err
+		}
+
+		name := string(value[:i])
+
+		if desc.PixelType < pixelUint || desc.PixelType > pixelFloat {
+			return nil, fmt.Errorf("EXR: channel %v has unknown type", name)
+		}
+
+		if desc.XSampling != 1 || desc.YSampling != 1 {
+			return nil, fmt.Errorf("EXR: channel %v is subsampled, unsupported", name)
+		}
+
+		channels = append(channels, channel{name: name, pixelType: desc.PixelType, index: len(channels)})
+		value = value[i+1+16:]
+	}
+}
+
+// decodeValue decodes the little-endian value.
+func decodeValue(value []byte, v interface{}) error {
+	if binary.Size(v) > len(value) {
+		return errCorrupt
+	}
+
+	return binary.Read(bytes.NewReader(value), binary.LittleEndian, v)
+}
+
+// decodeAttrib returns the value of an attribute for ExtraAttribs, the inverse of
+// encodeAttrib.  Unknown types are returned as the raw bytes.
+func decodeAttrib(a attribute) interface{} {
+	var err error
+
+	switch a.ty {
+	case "string":
+		return string(a.value)
+	case "stringvector":
+		var s []string
+
+		for v := a.value; len(v) >= 4; {
+			n := int(binary.LittleEndian.Uint32(v))
+
+			if n < 0 || 4+n > len(v) {
+				break
+			}
+
+			s = append(s, string(v[4:4+n]))
+			v = v[4+n:]
+		}
+
+		return s
+	case "int":
+		var v int32
+
+		if err = decodeValue(a.value, &v); err == nil {
+			return int(v)
+		}
+	case "float":
+		var v float32
+
+		if err = decodeValue(a.value, &v); err == nil {
+			return v
+		}
+	case "double":
+		var v float64
+
+		if err = decodeValue(a.value, &v); err == nil {
+			return v
+		}
+	case "v2f":
+		var v m.Vec2
+
+		if err = decodeValue(a.value, &v); err == nil {
+			return v
+		}
+	case "v3f":
+		var v m.Vec3
+
+		if err = decodeValue(a.value, &v); err == nil {
+			return v
+		}
+	case "m44f":
+		var v m.Matrix4
+
+		if err = decodeValue(a.value, &v); err == nil {
+			return v
+		}
+	case "lineOrder":
+		if len(a.value) > 0 {
+			return int(a.value[0])
+		}
+	}
+
+	return a.value
+}
+
+// Spec returns the image spec of the selected part.
+func (r *Reader) Spec() (image.Spec, error) { return r.part.spec, nil }
+
+// Close closes the reader.
+func (r *Reader) Close() { r.file.Close() }
+
+// readChunk reads and decodes chunk i of the selected part and returns its position relative
+// to the data window, size and the interleaved float pixels.
+func (r *Reader) readChunk(i int) (x0, y0, nx, ny int, pix []float32, err error) {
+	p := r.part
+	spec := &p.spec
+
+	if i < 0 || i >= len(p.offsets) {
+		return 0, 0, 0, 0, nil, errCorrupt
+	}
+
+	offset := int64(p.offsets[i])
+
+	if r.multiPart {
+		offset += 4
+	}
+
+	var data []byte
+
+	if p.tiled {
+		var header [5]int32
+
+		if err = binary.Read(io.NewSectionReader(r.file, offset, 20), binary.LittleEndian, &header); err != nil {
+			return
+		}
+
+		if header[2] != 0 || header[3] != 0 {
+			return 0, 0, 0, 0, nil, errCorrupt
+		}
+
+		x0, y0 = int(header[0])*spec.TileWidth, int(header[1])*spec.TileHeight
+
+		if x0 >= spec.Width || y0 >= spec.Height || x0 < 0 || y0 < 0 {
+			return 0, 0, 0, 0, nil, errCorrupt
+		}
+
+		nx, ny = minInt(spec.TileWidth, spec.Width-x0), minInt(spec.TileHeight, spec.Height-y0)
+		data, err = readBytes(r.file, offset+20, header[4])
+	} else {
+		var header [2]int32
+
+		if err = binary.Read(io.NewSectionReader(r.file, offset, 8), binary.LittleEndian, &header); err != nil {
+			return
+		}
+
+		x0, y0 = 0, int(header[0])-spec.Y
+
+		if y0 < 0 || y0 >= spec.Height {
+			return 0, 0, 0, 0, nil, errCorrupt
+		}
+
+		nx, ny = spec.Width, minInt(p.compression.linesPerBlock(), spec.Height-y0)
+		data, err = readBytes(r.file, offset+8, header[1])
+	}
+
+	if err != nil {
+		return
+	}
+
+	pix, err = p.decode(data, nx, ny)
+
+	return
+}
+
+// readBytes reads n bytes at offset.
+func readBytes(file *os.File, offset int64, n int32) ([]byte, error) {
+	if n < 0 {
+		return nil, errCorrupt
+	}
+
+	data := make([]byte, n)
+
+	if _, err := file.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// decode uncompresses a block of nx by ny pixels and converts it to interleaved floats.
+func (p *part) decode(data []byte, nx, ny int) ([]float32, error) {
+	size := 0
+
+	for _, c := range p.channels {
+		size += pixelSize(c.pixelType) * nx * ny
+	}
+
+	// Blocks which don't compress are stored as they are.
+	if len(data) < size {
+		var err error
+
+		switch p.compression {
+		case compressRLE:
+			data, err = rleUncompress(data, size)
+		case compressZIPS, compressZIP:
+			data, err = zipUncompress(data, size)
+		case compressPIZ:
+			channels := make([]pizChannel, len(p.channels))
+
+			for i, c := range p.channels {
+				channels[i] = pizChannel{nx, ny, pixelSize(c.pixelType) / 2}
+			}
+
+			data, err = pizUncompress(data, channels, size)
+		case compressPXR24:
+			data, err = pxr24Uncompress(data, p.channels, nx, ny)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(data) != size {
+		return nil, errCorrupt
+	}
+
+	nc := len(p.channels)
+	pix := make([]float32, nx*ny*nc)
+	q := 0
+
+	for y := 0; y < ny; y++ {
+		for k, c := range p.channels {
+			for x := 0; x < nx; x++ {
+				v := &pix[(x+y*nx)*nc+k]
+
+				switch c.pixelType {
+				case pixelHalf:
+					*v = m.Float16ToFloat32(m.Float16(binary.LittleEndian.Uint16(data[q:])))
+					q += 2
+				case pixelFloat:
+					*v = math.Float32frombits(binary.LittleEndian.Uint32(data[q:]))
+					q += 4
+				default:
+					*v = float32(binary.LittleEndian.Uint32(data[q:]))
+					q += 4
+				}
+			}
+		}
+	}
+
+	return pix, nil
+}
+
+// checkBuf returns the float32 buffer if it holds at least n values.
+func checkBuf(ty image.TypeDesc, buf interface{}, n int) ([]float32, error) {
+	if ty.BaseType != image.FLOAT {
+		return nil, errors.New("EXR: only supports float32 pixels")
+	}
+
+	pbuf, ok := buf.([]float32)
+
+	if !ok {
+		return nil, errors.New("EXR: pixel buffer not float32")
+	}
+
+	if len(pbuf) < n {
+		return nil, errors.New("EXR: pixel buffer too small")
+	}
+
+	return pbuf, nil
+}
+
+// copyBlock copies the nx by ny block of pixels into buf of width w at x0,y0.
+func copyBlock(buf []float32, w, nc int, pix []float32, x0, y0, nx, ny int) {
+	for y := 0; y < ny; y++ {
+		copy(buf[(x0+(y0+y)*w)*nc:(x0+nx+(y0+y)*w)*nc], pix[y*nx*nc:(y+1)*nx*nc])
+	}
+}
+
+// ReadImage reads entire image into the given buf, translating into type ty (if possible).
+// The channels are interleaved in the order of the spec, rows are top first.
+func (r *Reader) ReadImage(ty image.TypeDesc, buf interface{}) error {
+	spec := &r.part.spec
+	pbuf, err := checkBuf(ty, buf, spec.Width*spec.Height*spec.NChannels)
+
+	if err != nil {
+		return err
+	}
+
+	for i := range r.part.offsets {
+		x0, y0, nx, ny, pix, err := r.readChunk(i)
+
+		if err != nil {
+			return err
+		}
+
+		copyBlock(pbuf, spec.Width, spec.NChannels, pix, x0, y0, nx, ny)
+	}
+
+	return nil
+}
+
+// ReadScanline reads the scanline y (in the coordinates of the data window) into buf.
+func (r *Reader) ReadScanline(y, z int, ty image.TypeDesc, buf interface{}) error {
+	p := r.part
+	spec := &p.spec
+	pbuf, err := checkBuf(ty, buf, spec.Width*spec.NChannels)
+
+	if err != nil {
+		return err
+	}
+
+	y -= spec.Y
+
+	if y < 0 || y >= spec.Height {
+		return fmt.Errorf("EXR: scanline %v outside image", y+spec.Y)
+	}
+
+	var chunks []int
+
+	if p.tiled {
+		j := y / spec.TileHeight
+
+		for i := 0; i < p.tilesX(); i++ {
+			chunks = append(chunks, i+j*p.tilesX())
+		}
+	} else {
+		chunks = append(chunks, y/p.compression.linesPerBlock())
+	}
+
+	for _, i := range chunks {
+		x0, y0, nx, _, pix, err := r.readChunk(i)
+
+		if err != nil {
+			return err
+		}
+
+		copyBlock(pbuf, spec.Width, spec.NChannels, pix[(y-y0)*nx*spec.NChannels:], x0, 0, nx, 1)
+	}
+
+	return nil
+}
+
+// ReadTile reads the tile with its top left pixel at x,y (in the coordinates of the data
+// window) into buf.  buf holds a whole tile, pixels outside the image are left unchanged.
+func (r *Reader) ReadTile(x, y, z int, ty image.TypeDesc, buf interface{}) error {
+	p := r.part
+	spec := &p.spec
+
+	if !p.tiled {
+		return errors.New("EXR: image isn't tiled")
+	}
+
+	pbuf, err := checkBuf(ty, buf, spec.TileWidth*spec.TileHeight*spec.NChannels)
+
+	if err != nil {
+		return err
+	}
+
+	x -= spec.X
+	y -= spec.Y
+
+	if x < 0 || y < 0 || x >= spec.Width || y >= spec.Height || x%spec.TileWidth != 0 || y%spec.TileHeight != 0 {
+		return fmt.Errorf("EXR: no tile at %v,%v", x+spec.X, y+spec.Y)
+	}
+
+	_, _, nx, ny, pix, err := r.readChunk(x/spec.TileWidth + (y/spec.TileHeight)*p.tilesX())
+
+	if err != nil {
+		return err
+	}
+
+	copyBlock(pbuf, spec.TileWidth, spec.NChannels, pix, 0, 0, nx, ny)
+
+	return nil
+}
+
+// Supports returns true if the reader supports the given feature.
+func (r *Reader) Supports(tag string) bool {
+	switch tag {
+	case "tiles", "origin", "displaywindow", "channelformats", "arbitrary_metadata", "multiimage":
+		return true
+	}
+
+	return false
+}
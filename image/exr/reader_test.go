@@ -0,0 +1,270 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"github.com/jamiec7919/vermeer/image"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fixtureUint is the value of uint channel c at x,y, uint_value() in mkfixtures.py.
+func fixtureUint(x, y, c int) float32 {
+	return float32((x + 100) + 1000*(y+100) + 1000000*c)
+}
+
+// checkPixels compares the pixels of the nx by ny block at x0,y0 (in the coordinates of the
+// data window) with the fixture values.
+func checkPixels(t *testing.T, name string, spec image.Spec, buf []float32, x0, y0, nx, ny int) {
+	for y := 0; y < ny; y++ {
+		for x := 0; x < nx; x++ {
+			for c := 0; c < spec.NChannels; c++ {
+				px, py := spec.X+x0+x, spec.Y+y0+y
+				expected := fixtureValue(px, py, c)
+
+				if spec.Format[c].BaseType == image.UINT {
+					expected = fixtureUint(px, py, c)
+				}
+
+				if v := buf[(x+y*nx)*spec.NChannels+c]; v != expected {
+					t.Errorf("%v: %v at %v,%v = %v, expected %v", name, spec.ChannelNames[c], px, py, v, expected)
+					return
+				}
+			}
+		}
+	}
+}
+
+// checkRead reads the image, each scanline and each tile of r and compares them with the
+// fixture values.
+func checkRead(t *testing.T, name string, r *Reader) {
+	spec, _ := r.Spec()
+	nc := spec.NChannels
+	buf := make([]float32, spec.Width*spec.Height*nc)
+
+	if err := r.ReadImage(image.TypeDesc{BaseType: image.FLOAT}, buf); err != nil {
+		t.Fatalf("%v: %v", name, err)
+	}
+
+	checkPixels(t, name, spec, buf, 0, 0, spec.Width, spec.Height)
+
+	line := make([]float32, spec.Width*nc)
+
+	for y := 0; y < spec.Height; y++ {
+		if err := r.ReadScanline(spec.Y+y, 0, image.TypeDesc{BaseType: image.FLOAT}, line); err != nil {
+			t.Fatalf("%v: scanline %v: %v", name, y, err)
+		}
+
+		checkPixels(t, name, spec, line, 0, y, spec.Width, 1)
+	}
+
+	if spec.TileWidth == 0 {
+		return
+	}
+
+	tile := make([]float32, spec.TileWidth*spec.TileHeight*nc)
+
+	for y := 0; y < spec.Height; y += spec.TileHeight {
+		for x := 0; x < spec.Width; x += spec.TileWidth {
+			if err := r.ReadTile(spec.X+x, spec.Y+y, 0, image.TypeDesc{BaseType: image.FLOAT}, tile); err != nil {
+				t.Fatalf("%v: tile %v,%v: %v", name, x, y, err)
+			}
+
+			// Only the pixels inside the image are set in edge tiles.
+			nx, ny := minInt(spec.TileWidth, spec.Width-x), minInt(spec.TileHeight, spec.Height-y)
+
+			for j := 0; j < ny; j++ {
+				checkPixels(t, name, spec, tile[j*spec.TileWidth*nc:], x, y+j, nx, 1)
+			}
+		}
+	}
+}
+
+// The fixtures are written by testdata/mkfixtures.py.
+var fixtures = []struct {
+	file                string
+	compression         string
+	channels            []string
+	x, y, width, height int
+	tileWidth           int
+}{
+	{"zips.exr", "zips", []string{"B", "G", "R"}, -3, 2, 18, 9, 0},
+	{"zip.exr", "zip", []string{"A", "B", "G", "R"}, 0, 0, 23, 37, 0},
+	{"piz.exr", "piz", []string{"B", "G", "R", "Z", "id"}, 5, -4, 64, 56, 0},
+	{"tiled.exr", "zip", []string{"B", "G", "R"}, 2, 3, 19, 13, 8},
+	{"rle.exr", "rle", []string{"B", "G", "R", "id"}, 0, 0, 21, 13, 0},
+	{"pxr24.exr", "pxr24", []string{"A", "B", "G", "R", "id"}, 0, 0, 17, 21, 0},
+	// Mip-mapped, the smaller levels are stored first and must be skipped.
+	{"mipmap.exr", "none", []string{"B", "G", "R"}, 0, 0, 20, 11, 8},
+}
+
+func TestReadFixtures(t *testing.T) {
+	for _, f := range fixtures {
+		r, err := Open(filepath.Join("testdata", f.file))
+
+		if err != nil {
+			t.Errorf("%v: %v", f.file, err)
+			continue
+		}
+
+		spec, _ := r.Spec()
+
+		if spec.ExtraAttribs["compression"] != f.compression {
+			t.Errorf("%v: compression %v", f.file, spec.ExtraAttribs["compression"])
+		}
+
+		if !reflect.DeepEqual(spec.ChannelNames, f.channels) {
+			t.Errorf("%v: channels %v", f.file, spec.ChannelNames)
+		}
+
+		if spec.X != f.x || spec.Y != f.y || spec.Width != f.width || spec.Height != f.height || spec.TileWidth != f.tileWidth {
+			t.Errorf("%v: data window %v,%v %vx%v tiles %v", f.file, spec.X, spec.Y, spec.Width, spec.Height, spec.TileWidth)
+			r.Close()
+			continue
+		}
+
+		checkRead(t, f.file, r)
+		r.Close()
+	}
+}
+
+func TestReadMultiPart(t *testing.T) {
+	r, err := Open(filepath.Join("testdata", "multipart.exr"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	if r.NumParts() != 2 {
+		t.Fatalf("%v parts, expected 2", r.NumParts())
+	}
+
+	tests := []struct {
+		name                string
+		channels            []string
+		x, y, width, height int
+		tileWidth           int
+	}{
+		{"beauty", []string{"B", "G", "R"}, 0, 0, 16, 10, 0},
+		// Mip-mapped, chunkCount includes the smaller levels.
+		{"depth", []string{"Z", "id"}, -2, -2, 16, 13, 8},
+	}
+
+	// Parts are read in reverse to check that switching back works.
+	for i := len(tests) - 1; i >= 0; i-- {
+		test := tests[i]
+
+		if err := r.SetPart(i); err != nil {
+			t.Fatal(err)
+		}
+
+		spec, _ := r.Spec()
+
+		if spec.ExtraAttribs["name"] != test.name || !reflect.DeepEqual(spec.ChannelNames, test.channels) {
+			t.Errorf("part %v: name %v, channels %v", i, spec.ExtraAttribs["name"], spec.ChannelNames)
+		}
+
+		if spec.X != test.x || spec.Y != test.y || spec.Width != test.width || spec.Height != test.height || spec.TileWidth != test.tileWidth {
+			t.Errorf("part %v: data window %v,%v %vx%v tiles %v", i, spec.X, spec.Y, spec.Width, spec.Height, spec.TileWidth)
+			continue
+		}
+
+		checkRead(t, test.name, r)
+	}
+
+	r.SetPart(1)
+
+	if spec, _ := r.Spec(); spec.Format[1].BaseType != image.UINT {
+		t.Errorf("id channel is %v, expected UINT", spec.Format[1])
+	}
+
+	if err := r.SetPart(2); err == nil {
+		t.Errorf("SetPart(2) succeeded")
+	}
+}
+
+// TestReadOpenEXR reads python.exr from the CPython test data which was written by OpenEXR,
+// 16x16 uncompressed half ABGR.
+func TestReadOpenEXR(t *testing.T) {
+	r, err := Open(filepath.Join("testdata", "python.exr"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	spec, _ := r.Spec()
+
+	if spec.Width != 16 || spec.Height != 16 || spec.NChannels != 4 || spec.AlphaChannel != 0 {
+		t.Fatalf("spec %vx%v, %v channels, alpha %v", spec.Width, spec.Height, spec.NChannels, spec.AlphaChannel)
+	}
+
+	for _, f := range spec.Format {
+		if f.BaseType != image.HALF {
+			t.Errorf("format %v", spec.Format)
+			break
+		}
+	}
+
+	buf := make([]float32, 16*16*4)
+
+	if err := r.ReadImage(image.TypeDesc{BaseType: image.FLOAT}, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// A, B, G, R values from the file, rows are top first.
+	tests := []struct {
+		x, y int
+		v    [4]float32
+	}{
+		{0, 0, [4]float32{0, 0, 0, 0}},
+		{15, 15, [4]float32{0, 0, 0, 0}},
+		{4, 3, [4]float32{0.63916015625, 0.67822265625, 0.490234375, 0.2626953125}},
+		{8, 8, [4]float32{1, 0.341064453125, 0.89013671875, 1}},
+		{6, 10, [4]float32{1, 0.34521484375, 0.89013671875, 1}},
+		{11, 12, [4]float32{0.2548828125, 0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		var v [4]float32
+
+		copy(v[:], buf[(test.x+test.y*16)*4:])
+
+		if v != test.v {
+			t.Errorf("%v,%v = %v, expected %v", test.x, test.y, v, test.v)
+		}
+	}
+}
+
+func TestReadCorruptHeader(t *testing.T) {
+	window := make([]byte, 16)
+
+	tests := []struct {
+		name        string
+		compression []byte
+		dataWindow  []byte
+	}{
+		{"empty compression", []byte{}, window},
+		{"long compression", []byte{3, 0}, window},
+		{"short dataWindow", []byte{byte(compressZIP)}, window[:8]},
+	}
+
+	for _, test := range tests {
+		attribs := map[string]attribute{
+			"channels":      {"channels", "chlist", []byte{0}},
+			"compression":   {"compression", "compression", test.compression},
+			"dataWindow":    {"dataWindow", "box2i", test.dataWindow},
+			"displayWindow": {"displayWindow", "box2i", window},
+		}
+
+		if _, err := newPart(attribs, false); err != errCorrupt {
+			t.Errorf("%v: got error %v, expected %v", test.name, err, errCorrupt)
+		}
+	}
+}
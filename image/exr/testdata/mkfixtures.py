@@ -0,0 +1,621 @@
+#!/usr/bin/env python3
+# Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+# Use of this source code is governed by a BSD-style
+# license that can be found in the LICENSE file.
+
+"""Writes the OpenEXR test fixtures read by reader_test.go.
+
+The files are written from the OpenEXR file layout and the compressors of the reference
+library (ImfZip, ImfRle, ImfPxr24Compressor, ImfPizCompressor, ImfWav and ImfHuf), using only
+the Python standard library so they don't share code with the Go package.  python.exr is
+written by OpenEXR itself and comes from the CPython test data (Lib/test/imghdrdata, PSF
+license).
+
+Pixel values are given by value() and uint_value() of the absolute pixel coordinates, the Go
+tests use the same formulas.  Run from this directory: python3 mkfixtures.py
+"""
+
+import heapq
+import struct
+import zlib
+
+NONE, RLE, ZIPS, ZIP, PIZ, PXR24 = 0, 1, 2, 3, 4, 5
+UINT, HALF, FLOAT = 0, 1, 2
+
+LINES_PER_BLOCK = {NONE: 1, RLE: 1, ZIPS: 1, ZIP: 16, PIZ: 32, PXR24: 16}
+PIXEL_SIZE = {UINT: 4, HALF: 2, FLOAT: 4}
+
+
+def value(x, y, c, level=0):
+    """Value of channel c at x,y, multiples of 1/16 so exact in half and 24 bit floats."""
+    return ((x * 3 + y * 5 + c * 7) % 64) / 16.0 - 1 + level * 4
+
+
+def uint_value(x, y, c):
+    return (x + 100) + 1000 * (y + 100) + 1000000 * c
+
+
+def pack_pixel(ty, x, y, c, level=0):
+    if ty == UINT:
+        return struct.pack('<I', uint_value(x, y, c))
+    if ty == HALF:
+        return struct.pack('<e', value(x, y, c, level))
+    return struct.pack('<f', value(x, y, c, level))
+
+
+def block_data(channels, x0, y0, nx, ny, level=0):
+    """Uncompressed block: each scanline holds each channel in turn."""
+    b = bytearray()
+
+    for y in range(y0, y0 + ny):
+        for c, (_, ty) in enumerate(channels):
+            for x in range(x0, x0 + nx):
+                b += pack_pixel(ty, x, y, c, level)
+
+    return bytes(b)
+
+
+# ZIP and RLE
+
+
+def predict(data):
+    """Interleaves the bytes and applies the delta predictor (ImfZip.cpp)."""
+    n = len(data)
+    tmp = bytearray(data[0::2] + data[1::2])
+
+    for i in range(n - 1, 0, -1):
+        tmp[i] = (tmp[i] - tmp[i - 1] + 128 + 256) & 0xff
+
+    return bytes(tmp)
+
+
+def zip_compress(data, channels, nx, ny):
+    return zlib.compress(predict(data))
+
+
+def rle_compress(data, channels, nx, ny):
+    """ImfRle.cpp rleCompress."""
+    data = predict(data)
+    out = bytearray()
+    n = len(data)
+    start = 0
+    end = 1
+
+    while start < n:
+        while end < n and data[start] == data[end] and end - start - 1 < 127:
+            end += 1
+
+        if end - start >= 3:
+            out.append(end - start - 1)
+            out.append(data[start])
+            start = end
+        else:
+            while end < n and ((end + 1 >= n or data[end] != data[end + 1]) or
+                               (end + 2 >= n or data[end + 1] != data[end + 2])) and end - start < 127:
+                end += 1
+
+            out.append((start - end) & 0xff)
+            out += data[start:end]
+            start = end
+
+        end += 1
+
+    return bytes(out)
+
+
+# PXR24
+
+
+def float24(bits):
+    """ImfPxr24Compressor.cpp floatToFloat24 on the bits of a float."""
+    s = bits & 0x80000000
+    e = bits & 0x7f800000
+    m = bits & 0x007fffff
+
+    if e == 0x7f800000:
+        if m:
+            m >>= 8
+            i = (e >> 8) | m | (m == 0)
+        else:
+            i = e >> 8
+    else:
+        i = ((e | m) + (m & 0x80)) >> 8
+
+        if i >= 0x7f8000:
+            i = (e | m) >> 8
+
+    return (s >> 8) | i
+
+
+def pxr24_compress(data, channels, nx, ny):
+    out = bytearray()
+    p = 0
+
+    for y in range(ny):
+        for _, ty in channels:
+            planes = {UINT: 4, HALF: 2, FLOAT: 3}[ty]
+            rows = [bytearray() for _ in range(planes)]
+            previous = 0
+
+            for x in range(nx):
+                if ty == HALF:
+                    pixel = struct.unpack_from('<H', data, p)[0]
+                    p += 2
+                else:
+                    pixel = struct.unpack_from('<I', data, p)[0]
+                    p += 4
+
+                    if ty == FLOAT:
+                        pixel = float24(pixel)
+
+                diff = (pixel - previous) & 0xffffffff
+                previous = pixel
+
+                for k in range(planes):
+                    rows[k].append((diff >> (8 * (planes - 1 - k))) & 0xff)
+
+            for r in rows:
+                out += r
+
+    return zlib.compress(bytes(out))
+
+
+# PIZ
+
+HUF_ENCSIZE = (1 << 16) + 1
+SHORT_ZEROCODE_RUN = 59
+LONG_ZEROCODE_RUN = 63
+SHORTEST_LONG_RUN = 2 + LONG_ZEROCODE_RUN - SHORT_ZEROCODE_RUN
+LONGEST_LONG_RUN = 255 + SHORTEST_LONG_RUN
+
+
+class BitWriter:
+    def __init__(self):
+        self.out = bytearray()
+        self.c = 0
+        self.lc = 0
+
+    def bits(self, n, bits):
+        self.c = (self.c << n) | bits
+        self.lc += n
+
+        while self.lc >= 8:
+            self.lc -= 8
+            self.out.append((self.c >> self.lc) & 0xff)
+
+        self.c &= (1 << self.lc) - 1
+
+    def code(self, code):
+        self.bits(code & 63, code >> 6)
+
+    def flush(self):
+        if self.lc > 0:
+            self.out.append((self.c << (8 - self.lc)) & 0xff)
+
+
+def huf_code_lengths(freq):
+    """Huffman code lengths of the symbols with non-zero frequency."""
+    heap = [(f, s, [s]) for s, f in freq.items()]
+    heapq.heapify(heap)
+    length = {s: 0 for s in freq}
+
+    while len(heap) > 1:
+        f1, s1, l1 = heapq.heappop(heap)
+        f2, s2, l2 = heapq.heappop(heap)
+
+        for s in l1 + l2:
+            length[s] += 1
+
+        heapq.heappush(heap, (f1 + f2, min(s1, s2), l1 + l2))
+
+    return length
+
+
+def huf_canonical(length):
+    """ImfHuf.cpp hufCanonicalCodeTable, returns code << 6 | length."""
+    n = [0] * 59
+
+    for l in length.values():
+        n[l] += 1
+
+    c = 0
+
+    for i in range(58, 0, -1):
+        nc = (c + n[i]) >> 1
+        n[i] = c
+        c = nc
+
+    hcode = {}
+
+    for s in sorted(length):
+        l = length[s]
+        hcode[s] = l | (n[l] << 6)
+        n[l] += 1
+
+    return hcode
+
+
+def huf_compress(raw):
+    freq = {}
+
+    for v in raw:
+        freq[v] = freq.get(v, 0) + 1
+
+    im = min(freq)
+    rlc = max(freq) + 1
+    freq[rlc] = 1
+    hcode = huf_canonical(huf_code_lengths(freq))
+
+    # Code length table, with runs of unused symbols.
+    table = BitWriter()
+    s = im
+
+    while s <= rlc:
+        l = hcode.get(s, 0) & 63
+
+        if l == 0:
+            zerun = 1
+
+            while s < rlc and zerun < LONGEST_LONG_RUN and (hcode.get(s + 1, 0) & 63) == 0:
+                s += 1
+                zerun += 1
+
+            if zerun >= SHORTEST_LONG_RUN:
+                table.bits(6, LONG_ZEROCODE_RUN)
+                table.bits(8, zerun - SHORTEST_LONG_RUN)
+                s += 1
+                continue
+            elif zerun >= 2:
+                table.bits(6, SHORT_ZEROCODE_RUN + zerun - 2)
+                s += 1
+                continue
+
+        table.bits(6, l)
+        s += 1
+
+    table.flush()
+
+    # Data with runs of repeated symbols.
+    w = BitWriter()
+
+    def send(code, run):
+        if (code & 63) + (hcode[rlc] & 63) + 8 < (code & 63) * run:
+            w.code(code)
+            w.code(hcode[rlc])
+            w.bits(8, run)
+        else:
+            for _ in range(run + 1):
+                w.code(code)
+
+    s = raw[0]
+    run = 0
+
+    for v in raw[1:]:
+        if v == s and run < 255:
+            run += 1
+        else:
+            send(hcode[s], run)
+            run = 0
+
+        s = v
+
+    send(hcode[s], run)
+    nbits = len(w.out) * 8 + w.lc
+    w.flush()
+
+    return struct.pack('<5I', im, rlc, len(table.out), nbits, 0) + bytes(table.out) + bytes(w.out)
+
+
+def wenc14(a, b):
+    a = a - 0x10000 if a >= 0x8000 else a
+    b = b - 0x10000 if b >= 0x8000 else b
+
+    return ((a + b) >> 1) & 0xffff, (a - b) & 0xffff
+
+
+def wenc16(a, b):
+    ao = (a + 0x8000) & 0xffff
+    m = (ao + b) >> 1
+    d = ao - b
+
+    if d < 0:
+        m = (m + 0x8000) & 0xffff
+
+    return m, d & 0xffff
+
+
+def wav2_encode(buf, start, nx, ox, ny, oy, mx):
+    """ImfWav.cpp wav2Encode on buf from index start."""
+    enc = wenc14 if mx < (1 << 14) else wenc16
+    n = min(nx, ny)
+    p = 1
+    p2 = 2
+
+    while p2 <= n:
+        py = start
+        ey = start + oy * (ny - p2)
+        oy1, oy2, ox1, ox2 = oy * p, oy * p2, ox * p, ox * p2
+
+        while py <= ey:
+            px = py
+            ex = py + ox * (nx - p2)
+
+            while px <= ex:
+                p01 = px + ox1
+                p10 = px + oy1
+                p11 = p10 + ox1
+                i00, i01 = enc(buf[px], buf[p01])
+                i10, i11 = enc(buf[p10], buf[p11])
+                buf[px], buf[p10] = enc(i00, i10)
+                buf[p01], buf[p11] = enc(i01, i11)
+                px += ox2
+
+            if nx & p:
+                p10 = px + oy1
+                i00, buf[p10] = enc(buf[px], buf[p10])
+                buf[px] = i00
+
+            py += oy2
+
+        if ny & p:
+            px = py
+            ex = py + ox * (nx - p2)
+
+            while px <= ex:
+                p01 = px + ox1
+                i00, buf[p01] = enc(buf[px], buf[p01])
+                buf[px] = i00
+                px += ox2
+
+        p = p2
+        p2 <<= 1
+
+
+def piz_compress(data, channels, nx, ny):
+    words = struct.unpack('<%dH' % (len(data) // 2), data)
+
+    # Scanlines of each channel are gathered into planes of 16 bit words.
+    sizes = [PIXEL_SIZE[ty] // 2 for _, ty in channels]
+    planes = [[] for _ in channels]
+    p = 0
+
+    for y in range(ny):
+        for i, size in enumerate(sizes):
+            planes[i] += words[p:p + nx * size]
+            p += nx * size
+
+    tmp = [w for plane in planes for w in plane]
+
+    bitmap = bytearray(8192)
+
+    for w in tmp:
+        bitmap[w >> 3] |= 1 << (w & 7)
+
+    bitmap[0] &= ~1 & 0xff
+    nonzero = [i for i, b in enumerate(bitmap) if b]
+    min_nz, max_nz = (nonzero[0], nonzero[-1]) if nonzero else (8191, 0)
+
+    lut = [0] * 65536
+    k = 0
+
+    for i in range(65536):
+        if i == 0 or bitmap[i >> 3] & (1 << (i & 7)):
+            lut[i] = k
+            k += 1
+
+    max_value = k - 1
+    tmp = [lut[w] for w in tmp]
+
+    start = 0
+
+    for size in sizes:
+        for j in range(size):
+            wav2_encode(tmp, start + j, nx, size, ny, nx * size, max_value)
+
+        start += nx * ny * size
+
+    out = struct.pack('<HH', min_nz, max_nz)
+
+    if min_nz <= max_nz:
+        out += bytes(bitmap[min_nz:max_nz + 1])
+
+    huf = huf_compress(tmp)
+
+    return out + struct.pack('<i', len(huf)) + huf
+
+
+COMPRESSORS = {NONE: None, RLE: rle_compress, ZIPS: zip_compress, ZIP: zip_compress,
+               PIZ: piz_compress, PXR24: pxr24_compress}
+
+
+def compress(compression, data, channels, nx, ny):
+    if COMPRESSORS[compression] is None:
+        return data
+
+    out = COMPRESSORS[compression](data, channels, nx, ny)
+    # The fixtures are meant to exercise the decompressors.
+    assert len(out) < len(data), 'block does not compress'
+
+    return out
+
+
+# Headers
+
+
+def attrib(name, ty, value):
+    return name.encode() + b'\0' + ty.encode() + b'\0' + struct.pack('<i', len(value)) + value
+
+
+def header(part):
+    channels = b''.join(name.encode() + b'\0' + struct.pack('<iBBBBii', ty, 0, 0, 0, 0, 1, 1)
+                        for name, ty in part['channels']) + b'\0'
+    x0, y0, x1, y1 = part['window']
+    h = attrib('channels', 'chlist', channels)
+    h += attrib('compression', 'compression', bytes([part['compression']]))
+    h += attrib('dataWindow', 'box2i', struct.pack('<4i', x0, y0, x1, y1))
+    h += attrib('displayWindow', 'box2i', struct.pack('<4i', *part.get('display', (x0, y0, x1, y1))))
+    h += attrib('lineOrder', 'lineOrder', bytes([part.get('lineOrder', 0)]))
+    h += attrib('pixelAspectRatio', 'float', struct.pack('<f', 1))
+    h += attrib('screenWindowCenter', 'v2f', struct.pack('<2f', 0, 0))
+    h += attrib('screenWindowWidth', 'float', struct.pack('<f', 1))
+
+    if 'tiles' in part:
+        tw, th, mode = part['tiles']
+        h += attrib('tiles', 'tiledesc', struct.pack('<IIB', tw, th, mode))
+
+    for name, ty, value in part.get('extra', []):
+        h += attrib(name, ty, value)
+
+    return h
+
+
+def level_sizes(w, h, mode):
+    """Sizes of the levels, one level or mip-mapped with rounding down."""
+    if mode == 0:
+        return [(w, h)]
+
+    levels = []
+    l = 0
+
+    while True:
+        levels.append((max(w >> l, 1), max(h >> l, 1)))
+
+        if max(w, h) >> l <= 1:
+            return levels
+
+        l += 1
+
+
+def chunks(part):
+    """Chunk headers and data of a part in offset table order."""
+    x0, y0, x1, y1 = part['window']
+    w, h = x1 - x0 + 1, y1 - y0 + 1
+    comp = part['compression']
+    channels = part['channels']
+    out = []
+
+    if 'tiles' in part:
+        tw, th, mode = part['tiles']
+
+        for l, (lw, lh) in enumerate(level_sizes(w, h, mode & 15)):
+            for ty in range((lh + th - 1) // th):
+                for tx in range((lw + tw - 1) // tw):
+                    nx, ny = min(tw, lw - tx * tw), min(th, lh - ty * th)
+                    data = block_data(channels, x0 + tx * tw, y0 + ty * th, nx, ny, l)
+                    data = compress(comp, data, channels, nx, ny)
+                    out.append(struct.pack('<5i', tx, ty, l, l, len(data)) + data)
+    else:
+        lines = LINES_PER_BLOCK[comp]
+
+        for y in range(y0, y1 + 1, lines):
+            ny = min(lines, y1 + 1 - y)
+            data = compress(comp, block_data(channels, x0, y, w, ny), channels, w, ny)
+            out.append(struct.pack('<2i', y, len(data)) + data)
+
+    return out
+
+
+def write(filename, parts, reverse=False):
+    """Writes the parts, with the chunks stored in reverse order if reverse is set."""
+    multipart = len(parts) > 1
+    version = 2
+
+    if multipart:
+        version |= 0x1000
+    elif 'tiles' in parts[0]:
+        version |= 0x200
+
+    head = struct.pack('<2i', 20000630, version)
+    part_chunks = [chunks(p) for p in parts]
+
+    for i, p in enumerate(parts):
+        if multipart:
+            ty = b'tiledimage' if 'tiles' in p else b'scanlineimage'
+            p.setdefault('extra', []).extend([
+                ('name', 'string', p['name'].encode()),
+                ('type', 'string', ty),
+                ('chunkCount', 'int', struct.pack('<i', len(part_chunks[i]))),
+            ])
+
+        head += header(p) + b'\0'
+
+    if multipart:
+        head += b'\0'
+
+    # Chunks are (part, data) in offset table order, multi-part chunks start with the part.
+    table = [(i, struct.pack('<i', i) + c if multipart else c)
+             for i, cs in enumerate(part_chunks) for c in cs]
+    order = list(range(len(table)))
+
+    if reverse:
+        order.reverse()
+
+    offsets = [0] * len(table)
+    body = b''
+
+    for k in order:
+        offsets[k] = len(head) + 8 * len(table) + len(body)
+        body += table[k][1]
+
+    with open(filename, 'wb') as f:
+        f.write(head + struct.pack('<%dQ' % len(offsets), *offsets) + body)
+
+
+FIXTURES = {
+    'rle.exr': [{
+        'channels': [('B', HALF), ('G', HALF), ('R', HALF), ('id', UINT)],
+        'compression': RLE,
+        'window': (0, 0, 20, 12),
+    }],
+    'zips.exr': [{
+        'channels': [('B', FLOAT), ('G', FLOAT), ('R', FLOAT)],
+        'compression': ZIPS,
+        'window': (-3, 2, 14, 10),
+        'display': (0, 0, 15, 15),
+        'lineOrder': 1,
+    }],
+    'zip.exr': [{
+        'channels': [('A', HALF), ('B', HALF), ('G', HALF), ('R', HALF)],
+        'compression': ZIP,
+        'window': (0, 0, 22, 36),
+    }],
+    'piz.exr': [{
+        'channels': [('B', HALF), ('G', HALF), ('R', HALF), ('Z', FLOAT), ('id', UINT)],
+        'compression': PIZ,
+        'window': (5, -4, 68, 51),
+    }],
+    'pxr24.exr': [{
+        'channels': [('A', HALF), ('B', FLOAT), ('G', FLOAT), ('R', FLOAT), ('id', UINT)],
+        'compression': PXR24,
+        'window': (0, 0, 16, 20),
+    }],
+    'tiled.exr': [{
+        'channels': [('B', HALF), ('G', HALF), ('R', HALF)],
+        'compression': ZIP,
+        'window': (2, 3, 20, 15),
+        'tiles': (8, 8, 0),
+    }],
+    'mipmap.exr': [{
+        'channels': [('B', FLOAT), ('G', FLOAT), ('R', FLOAT)],
+        'compression': NONE,
+        'window': (0, 0, 19, 10),
+        'tiles': (8, 8, 1),
+    }],
+    'multipart.exr': [{
+        'name': 'beauty',
+        'channels': [('B', HALF), ('G', HALF), ('R', HALF)],
+        'compression': ZIP,
+        'window': (0, 0, 15, 9),
+    }, {
+        'name': 'depth',
+        'channels': [('Z', FLOAT), ('id', UINT)],
+        'compression': NONE,
+        'window': (-2, -2, 13, 10),
+        'tiles': (8, 8, 1),
+    }],
+}
+
+if __name__ == '__main__':
+    for name, parts in sorted(FIXTURES.items()):
+        # Chunks are stored in reverse so the readers must follow the offset tables.
+        write(name, parts, reverse=name in ('zips.exr', 'mipmap.exr', 'multipart.exr'))
@@ -0,0 +1,368 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jamiec7919/vermeer/image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Writer implements the OpenEXR writer.
+type Writer struct {
+	file        *os.File
+	spec        image.Spec
+	channels    []channel // Sorted by name
+	compression compression
+	attribs     []attribute
+	version     int32
+}
+
+func init() {
+	image.RegisterWriter(func(filename string) (image.Writer, error) {
+		ext := strings.ToLower(filepath.Ext(filename))
+
+		if ext == ".exr" {
+			return &Writer{}, nil
+		}
+
+		return nil, image.ErrNoWriter
+	})
+}
+
+// defaultChannelNames are the channel names used if spec.ChannelNames isn't given.
+var defaultChannelNames = []string{"R", "G", "B", "A"}
+
+// Open opens file filename, fills in spec and returns nil on success.
+func (w *Writer) Open(filename string, spec *image.Spec) error {
+	return w.OpenMode(filename, spec, "")
+}
+
+// OpenMode opens file filename, fills in spec and returns nil on success.
+func (w *Writer) OpenMode(filename string, spec *image.Spec, mode string) error {
+	if spec.Width <= 0 || spec.Height <= 0 {
+		return errors.New("EXR: image has no pixels")
+	}
+
+	if spec.NChannels == 0 {
+		spec.NChannels = len(spec.ChannelNames)
+	}
+
+	if spec.NChannels == 0 {
+		spec.NChannels = 3
+	}
+
+	if spec.ChannelNames == nil {
+		if spec.NChannels > len(defaultChannelNames) {
+			return errors.New("EXR: need channel names for more than 4 channels")
+		}
+
+		spec.ChannelNames = defaultChannelNames[:spec.NChannels]
+	}
+
+	if len(spec.ChannelNames) != spec.NChannels {
+		return errors.New("EXR: number of channel names doesn't match NChannels")
+	}
+
+	if len(spec.Format) > 1 && len(spec.Format) != spec.NChannels {
+		return errors.New("EXR: number of channel formats doesn't match NChannels")
+	}
+
+	if err := w.initHeader(spec); err != nil {
+		return err
+	}
+
+	file, err := os.Create(filename)
+
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+	w.spec = *spec
+
+	return nil
+}
+
+// initHeader builds the channel list and header attributes from spec.
+func (w *Writer) initHeader(spec *image.Spec) error {
+	w.channels = nil
+	w.version = versionNumber
+
+	names := map[string]bool{}
+
+	for i, name := range spec.ChannelNames {
+		if names[name] {
+			return fmt.Errorf("EXR: duplicate channel %v", name)
+		}
+
+		names[name] = true
+
+		ty := image.TypeDesc{BaseType: image.FLOAT}
+
+		if len(spec.Format) == 1 {
+			ty = spec.Format[0]
+		} else if len(spec.Format) > 1 {
+			ty = spec.Format[i]
+		}
+
+		c := channel{name: name, index: i}
+
+		switch ty.BaseType {
+		case image.FLOAT:
+			c.pixelType = pixelFloat
+		case image.HALF:
+			c.pixelType = pixelHalf
+		default:
+			return fmt.Errorf("EXR: channel %v must be HALF or FLOAT", name)
+		}
+
+		if len(name) > maxShortName {
+			w.version |= flagLongNames
+		}
+
+		w.channels = append(w.channels, c)
+	}
+
+	sort.Sort(channelsByName(w.channels))
+
+	w.compression = compressZIP
+
+	if c, present := spec.ExtraAttribs["compression"]; present {
+		name, _ := c.(string)
+		compression, ok := compressionNames[strings.ToLower(name)]
+
+		if !ok {
+			return fmt.Errorf("EXR: unknown compression %v", c)
+		}
+
+		w.compression = compression
+	}
+
+	// Display window defaults to the data window.
+	fullX, fullY, fullW, fullH := spec.FullX, spec.FullY, spec.FullWidth, spec.FullHeight
+
+	if fullW <= 0 || fullH <= 0 {
+		fullX, fullY, fullW, fullH = spec.X, spec.Y, spec.Width, spec.Height
+	}
+
+	attribs := map[string]attribute{
+		"channels":           {"channels", "chlist", encodeChannels(w.channels)},
+		"compression":        {"compression", "compression", []byte{byte(w.compression)}},
+		"dataWindow":         {"dataWindow", "box2i", box2i(spec.X, spec.Y, spec.X+spec.Width-1, spec.Y+spec.Height-1)},
+		"displayWindow":      {"displayWindow", "box2i", box2i(fullX, fullY, fullX+fullW-1, fullY+fullH-1)},
+		"lineOrder":          {"lineOrder", "lineOrder", []byte{0}}, // Increasing Y
+		"pixelAspectRatio":   {"pixelAspectRatio", "float", le(float32(1))},
+		"screenWindowCenter": {"screenWindowCenter", "v2f", le(float32(0), float32(0))},
+		"screenWindowWidth":  {"screenWindowWidth", "float", le(float32(1))},
+	}
+
+	if spec.TileWidth > 0 && spec.TileHeight > 0 {
+		// Single level, round down.
+		attribs["tiles"] = attribute{"tiles", "tiledesc", le(uint32(spec.TileWidth), uint32(spec.TileHeight), uint8(0))}
+		w.version |= flagTiled
+	}
+
+	for name, v := range spec.ExtraAttribs {
+		if reservedAttribs[name] {
+			continue
+		}
+
+		a, err := encodeAttrib(name, v)
+
+		if err != nil {
+			return err
+		}
+
+		attribs[name] = a
+	}
+
+	w.attribs = nil
+
+	for _, a := range attribs {
+		if len(a.name) > maxShortName || len(a.ty) > maxShortName {
+			w.version |= flagLongNames
+		}
+
+		w.attribs = append(w.attribs, a)
+	}
+
+	return nil
+}
+
+// Close closes the writer.
+func (w *Writer) Close() {
+	w.file.Close()
+}
+
+// pack returns the uncompressed pixel data of the nx by ny block at x0,y0, for each scanline
+// the values of each channel in turn.
+func (w *Writer) pack(buf []float32, x0, y0, nx, ny int) []byte {
+	var b []byte
+
+	for y := y0; y < y0+ny; y++ {
+		for _, c := range w.channels {
+			for x := x0; x < x0+nx; x++ {
+				b = putPixel(b, c.pixelType, buf[(x+y*w.spec.Width)*w.spec.NChannels+c.index])
+			}
+		}
+	}
+
+	return b
+}
+
+// compress returns the compressed block of nx by ny pixels, or data itself if compression
+// doesn't make it smaller.
+func (w *Writer) compress(data []byte, nx, ny int) ([]byte, error) {
+	var out []byte
+
+	switch w.compression {
+	case compressZIP, compressZIPS:
+		var err error
+
+		if out, err = zipCompress(data); err != nil {
+			return nil, err
+		}
+	case compressPIZ:
+		channels := make([]pizChannel, len(w.channels))
+
+		for i, c := range w.channels {
+			channels[i] = pizChannel{nx, ny, pixelSize(c.pixelType) / 2}
+		}
+
+		out = pizCompress(data, channels)
+	default:
+		return data, nil
+	}
+
+	if len(out) >= len(data) {
+		return data, nil
+	}
+
+	return out, nil
+}
+
+// WriteImage writes the image in buf described by ty.  buf holds the interleaved channels
+// starting with the top scanline.
+func (w *Writer) WriteImage(ty image.TypeDesc, buf interface{}) error {
+	if ty.BaseType != image.FLOAT {
+		return errors.New("EXR: only supports float32 pixels")
+	}
+
+	pbuf, ok := buf.([]float32)
+
+	if !ok {
+		return errors.New("EXR: pixel buffer not float32")
+	}
+
+	if len(pbuf) < w.spec.Width*w.spec.Height*w.spec.NChannels {
+		return errors.New("EXR: pixel buffer too small")
+	}
+
+	var chunks [][]byte
+
+	if w.version&flagTiled != 0 {
+		tw, th := w.spec.TileWidth, w.spec.TileHeight
+
+		for j := 0; j*th < w.spec.Height; j++ {
+			for i := 0; i*tw < w.spec.Width; i++ {
+				x0, y0 := i*tw, j*th
+				nx, ny := minInt(tw, w.spec.Width-x0), minInt(th, w.spec.Height-y0)
+
+				data, err := w.compress(w.pack(pbuf, x0, y0, nx, ny), nx, ny)
+
+				if err != nil {
+					return err
+				}
+
+				chunk := le(int32(i), int32(j), int32(0), int32(0), int32(len(data)))
+				chunks = append(chunks, append(chunk, data...))
+			}
+		}
+	} else {
+		lines := w.compression.linesPerBlock()
+
+		for y0 := 0; y0 < w.spec.Height; y0 += lines {
+			ny := minInt(lines, w.spec.Height-y0)
+
+			data, err := w.compress(w.pack(pbuf, 0, y0, w.spec.Width, ny), w.spec.Width, ny)
+
+			if err != nil {
+				return err
+			}
+
+			chunk := le(int32(w.spec.Y+y0), int32(len(data)))
+			chunks = append(chunks, append(chunk, data...))
+		}
+	}
+
+	var b bytes.Buffer
+
+	writeHeader(&b, w.attribs, w.version)
+
+	// Offset table of the chunks.
+	offset := uint64(b.Len() + 8*len(chunks))
+
+	for _, chunk := range chunks {
+		b.Write(le(offset))
+		offset += uint64(len(chunk))
+	}
+
+	for _, chunk := range chunks {
+		b.Write(chunk)
+	}
+
+	_, err := b.WriteTo(w.file)
+
+	return err
+}
+
+// WriteImageStride writes the image in buf described by ty with strides as given.
+func (w *Writer) WriteImageStride(ty image.TypeDesc, buf interface{}, xstride, ystride, zstride int) error {
+	return errors.New("EXR WriteImageStride: unsupported")
+}
+
+// WriteScanline writes the scanline in buf described by ty at position y,z.
+func (w *Writer) WriteScanline(y, z int, ty image.TypeDesc, buf interface{}) error {
+	return errors.New("EXR WriteScanline: unsupported")
+}
+
+// WriteScanlineStride writes the scanline in buf described by ty with strides as given.
+func (w *Writer) WriteScanlineStride(y, z int, ty image.TypeDesc, buf interface{}, xstride, ystride, zstride int) error {
+	return errors.New("EXR WriteScanlineStride: unsupported")
+}
+
+// WriteTile writes the image tile in buf described by ty at position x,y,z.
+func (w *Writer) WriteTile(x, y, z int, ty image.TypeDesc, buf interface{}) error {
+	return errors.New("EXR WriteTile: unsupported")
+}
+
+// WriteTileStride writes the image tile in buf described by ty with strides as given.
+func (w *Writer) WriteTileStride(x, y, z int, ty image.TypeDesc, buf interface{}, xstride, ystride, zstride int) error {
+	return errors.New("EXR WriteTileStride: unsupported")
+}
+
+// Supports returns true if the feature in tag is supported.
+func (w *Writer) Supports(tag string) bool {
+	switch tag {
+	case "tiles", "origin", "displaywindow", "channelformats", "arbitrary_metadata":
+		return true
+	}
+
+	return false
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,483 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/jamiec7919/vermeer/image"
+	m "github.com/jamiec7919/vermeer/math"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// fixtureValue is the value of channel c at x,y of the test images, the same as value() in
+// testdata/mkfixtures.py.  The values are multiples of 1/16 so are exact in halfs.
+func fixtureValue(x, y, c int) float32 {
+	return float32(((x*3+y*5+c*7)%64+64)%64)/16 - 1
+}
+
+// testFile is a written EXR file split into header attributes and chunks.
+type testFile struct {
+	version int32
+	attribs map[string]attribute
+	chunks  [][]byte
+}
+
+// parseTestFile splits the file into header attributes and nChunks chunks, checking the offset
+// table.
+func parseTestFile(data []byte, nChunks int) (*testFile, error) {
+	f := &testFile{attribs: map[string]attribute{}}
+
+	if len(data) < 8 || binary.LittleEndian.Uint32(data) != magic {
+		return nil, errCorrupt
+	}
+
+	f.version = int32(binary.LittleEndian.Uint32(data[4:]))
+	p := 8
+
+	// Attributes are name, type, size and value, ended by an empty name.
+	cstring := func() string {
+		i := bytes.IndexByte(data[p:], 0)
+
+		if i < 0 {
+			return ""
+		}
+
+		s := string(data[p : p+i])
+		p += i + 1
+
+		return s
+	}
+
+	for p < len(data) && data[p] != 0 {
+		a := attribute{name: cstring()}
+		a.ty = cstring()
+
+		if p+4 > len(data) {
+			return nil, errCorrupt
+		}
+
+		size := int(binary.LittleEndian.Uint32(data[p:]))
+		p += 4
+
+		if p+size > len(data) {
+			return nil, errCorrupt
+		}
+
+		a.value = data[p : p+size]
+		p += size
+		f.attribs[a.name] = a
+	}
+
+	p++
+
+	// The chunks follow the offset table in order.
+	next := p + 8*nChunks
+
+	for i := 0; i < nChunks; i++ {
+		offset := int(binary.LittleEndian.Uint64(data[p+8*i:]))
+
+		if offset != next {
+			return nil, errCorrupt
+		}
+
+		header := 8
+
+		if f.version&flagTiled != 0 {
+			header = 20
+		}
+
+		if offset+header > len(data) {
+			return nil, errCorrupt
+		}
+
+		size := int(binary.LittleEndian.Uint32(data[offset+header-4:]))
+		next = offset + header + size
+
+		if next > len(data) {
+			return nil, errCorrupt
+		}
+
+		f.chunks = append(f.chunks, data[offset:next])
+	}
+
+	if next != len(data) {
+		return nil, errCorrupt
+	}
+
+	return f, nil
+}
+
+func TestWriter(t *testing.T) {
+	half := image.TypeDesc{BaseType: image.HALF}
+	float := image.TypeDesc{BaseType: image.FLOAT}
+
+	formats := map[string][]image.TypeDesc{
+		"half":  {half},
+		"float": {float},
+		"mixed": {half, half, half, float, half},
+	}
+
+	// Channels are given out of order, files hold them sorted by name.
+	names := []string{"R", "G", "B", "Z", "A"}
+	sorted := []string{"A", "B", "G", "R", "Z"}
+
+	dir := t.TempDir()
+
+	for _, compression := range []string{"none", "zip", "zips", "piz"} {
+		for formatName, format := range formats {
+			for _, tiled := range []bool{false, true} {
+				name := compression + "-" + formatName
+
+				spec := image.Spec{
+					X: -3, Y: 7, Width: 100, Height: 37,
+					FullWidth: 128, FullHeight: 48,
+					Format:       format,
+					ChannelNames: names,
+					ExtraAttribs: map[string]interface{}{
+						"compression": compression,
+						"owner":       "vermeer",
+						"frame":       12,
+					},
+				}
+
+				if tiled {
+					name += "-tiled"
+					spec.TileWidth, spec.TileHeight = 64, 32
+				}
+
+				// Write with the channels in spec order, the fixture values are by sorted order.
+				buf := make([]float32, spec.Width*spec.Height*len(names))
+
+				for y := 0; y < spec.Height; y++ {
+					for x := 0; x < spec.Width; x++ {
+						for i, n := range names {
+							c := sort.SearchStrings(sorted, n)
+							buf[(x+y*spec.Width)*len(names)+i] = fixtureValue(spec.X+x, spec.Y+y, c)
+						}
+					}
+				}
+
+				filename := filepath.Join(dir, name+".exr")
+
+				w := &Writer{}
+
+				if err := w.Open(filename, &spec); err != nil {
+					t.Fatalf("%v: %v", name, err)
+				}
+
+				err := w.WriteImage(image.TypeDesc{BaseType: image.FLOAT}, buf)
+				w.Close()
+
+				if err != nil {
+					t.Fatalf("%v: %v", name, err)
+				}
+
+				data, err := ioutil.ReadFile(filename)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// The pixel types of the sorted channels.
+				var types []int32
+
+				for _, n := range sorted {
+					ty := format[0]
+
+					for i := range names {
+						if names[i] == n && len(format) > 1 {
+							ty = format[i]
+						}
+					}
+
+					if ty.BaseType == image.HALF {
+						types = append(types, pixelHalf)
+					} else {
+						types = append(types, pixelFloat)
+					}
+				}
+
+				checkFile(t, name, data, spec, sorted, types)
+			}
+		}
+	}
+}
+
+// checkFile checks the header and pixels of the file written from spec, the sorted channel
+// names have the given pixel types.  Compressed chunks are checked if they are ZIP or ZIPS.
+func checkFile(t *testing.T, name string, data []byte, spec image.Spec, names []string, types []int32) {
+	method := compressionNames[spec.ExtraAttribs["compression"].(string)]
+
+	// Scanlines per chunk from the OpenEXR spec.
+	lines := map[compression]int{compressNone: 1, compressZIPS: 1, compressZIP: 16, compressPIZ: 32}
+	bw, bh := spec.Width, lines[method]
+
+	if spec.TileWidth > 0 {
+		bw, bh = spec.TileWidth, spec.TileHeight
+	}
+
+	nbx, nby := (spec.Width+bw-1)/bw, (spec.Height+bh-1)/bh
+
+	f, err := parseTestFile(data, nbx*nby)
+
+	if err != nil {
+		t.Errorf("%v: %v", name, err)
+		return
+	}
+
+	if tiled := f.version&flagTiled != 0; tiled != (spec.TileWidth > 0) {
+		t.Errorf("%v: version %x", name, f.version)
+	}
+
+	var channels []channel
+
+	for i := range names {
+		channels = append(channels, channel{name: names[i], pixelType: types[i]})
+	}
+
+	attribs := []attribute{
+		{"channels", "chlist", encodeChannels(channels)},
+		{"compression", "compression", []byte{byte(method)}},
+		{"dataWindow", "box2i", box2i(-3, 7, 96, 43)},
+		{"displayWindow", "box2i", box2i(0, 0, 127, 47)},
+		{"lineOrder", "lineOrder", []byte{0}},
+		{"owner", "string", []byte("vermeer")},
+		{"frame", "int", le(int32(12))},
+	}
+
+	if spec.TileWidth > 0 {
+		attribs = append(attribs, attribute{"tiles", "tiledesc", le(uint32(64), uint32(32), uint8(0))})
+	}
+
+	for _, a := range attribs {
+		if !reflect.DeepEqual(f.attribs[a.name], a) {
+			t.Errorf("%v: attribute %v = %v, expected %v", name, a.name, f.attribs[a.name], a)
+		}
+	}
+
+	compressed := 0
+
+	for i, chunk := range f.chunks {
+		bx, by := i%nbx, i/nbx
+		x0, y0 := bx*bw, by*bh
+		nx, ny := minInt(bw, spec.Width-x0), minInt(bh, spec.Height-y0)
+
+		// Chunk headers are the first scanline y or the tile coordinates and level.
+		header := le(int32(spec.Y + y0))
+
+		if spec.TileWidth > 0 {
+			header = le(int32(bx), int32(by), int32(0), int32(0))
+		}
+
+		if !bytes.HasPrefix(chunk, header) {
+			t.Errorf("%v: chunk %v header %v, expected %v", name, i, chunk[:len(header)], header)
+			return
+		}
+
+		block := chunk[len(header)+4:]
+		size := 0
+
+		for _, ty := range types {
+			size += nx * ny * pixelSize(ty)
+		}
+
+		if len(block) > size {
+			t.Errorf("%v: chunk %v is %v bytes, uncompressed %v", name, i, len(block), size)
+			return
+		}
+
+		if len(block) < size {
+			compressed++
+
+			if method == compressPIZ {
+				continue
+			}
+
+			if block, err = zipUncompress(block, size); err != nil {
+				t.Errorf("%v: chunk %v: %v", name, i, err)
+				return
+			}
+		}
+
+		// For each scanline the values of each channel in turn.
+		for y := 0; y < ny; y++ {
+			for c, ty := range types {
+				for x := 0; x < nx; x++ {
+					var v float32
+
+					if ty == pixelHalf {
+						v = m.Float16ToFloat32(m.Float16(binary.LittleEndian.Uint16(block)))
+					} else {
+						v = math.Float32frombits(binary.LittleEndian.Uint32(block))
+					}
+
+					block = block[pixelSize(ty):]
+
+					px, py := spec.X+x0+x, spec.Y+y0+y
+
+					if expected := fixtureValue(px, py, c); v != expected {
+						t.Errorf("%v: %v at %v,%v = %v, expected %v", name, names[c], px, py, v, expected)
+						return
+					}
+				}
+			}
+		}
+	}
+
+	if method == compressNone && compressed > 0 {
+		t.Errorf("%v: %v chunks compressed", name, compressed)
+	}
+
+	if method != compressNone && compressed == 0 {
+		t.Errorf("%v: not compressed", name)
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	half := image.TypeDesc{BaseType: image.HALF}
+	float := image.TypeDesc{BaseType: image.FLOAT}
+
+	formats := map[string][]image.TypeDesc{
+		"half":  {half},
+		"float": {float},
+		"mixed": {half, half, half, float, half},
+	}
+
+	// Channels are given out of order, files hold them sorted by name.
+	names := []string{"R", "G", "B", "Z", "A"}
+	sorted := []string{"A", "B", "G", "R", "Z"}
+
+	attribs := map[string]interface{}{
+		"owner":        "vermeer",
+		"frame":        12,
+		"exposure":     float32(0.5),
+		"cameraOrigin": m.Vec3{1, 2, 3},
+		"multiView":    []string{"left", "right"},
+	}
+
+	dir := t.TempDir()
+
+	for _, compression := range []string{"none", "zip", "zips", "piz"} {
+		for formatName, format := range formats {
+			for _, tiled := range []bool{false, true} {
+				name := compression + "-" + formatName
+
+				spec := image.Spec{
+					X: -3, Y: 7, Width: 100, Height: 37,
+					FullWidth: 128, FullHeight: 48,
+					Format:       format,
+					ChannelNames: names,
+					ExtraAttribs: map[string]interface{}{"compression": compression},
+				}
+
+				if tiled {
+					name += "-tiled"
+					spec.TileWidth, spec.TileHeight = 64, 32
+				}
+
+				for k, v := range attribs {
+					spec.ExtraAttribs[k] = v
+				}
+
+				// Write with the channels in spec order, the fixture values are by sorted order.
+				buf := make([]float32, spec.Width*spec.Height*len(names))
+
+				for y := 0; y < spec.Height; y++ {
+					for x := 0; x < spec.Width; x++ {
+						for i, n := range names {
+							c := sort.SearchStrings(sorted, n)
+							buf[(x+y*spec.Width)*len(names)+i] = fixtureValue(spec.X+x, spec.Y+y, c)
+						}
+					}
+				}
+
+				filename := filepath.Join(dir, name+".exr")
+
+				w := &Writer{}
+
+				if err := w.Open(filename, &spec); err != nil {
+					t.Fatalf("%v: %v", name, err)
+				}
+
+				err := w.WriteImage(image.TypeDesc{BaseType: image.FLOAT}, buf)
+				w.Close()
+
+				if err != nil {
+					t.Fatalf("%v: %v", name, err)
+				}
+
+				checkRoundTrip(t, name, filename, spec, attribs)
+			}
+		}
+	}
+}
+
+// checkRoundTrip reads back the file written from spec.
+func checkRoundTrip(t *testing.T, name, filename string, spec image.Spec, attribs map[string]interface{}) {
+	r, err := Open(filename)
+
+	if err != nil {
+		t.Fatalf("%v: %v", name, err)
+	}
+
+	defer r.Close()
+
+	rspec, _ := r.Spec()
+
+	if rspec.ExtraAttribs["compression"] != "none" {
+		raw := 0
+
+		for _, f := range rspec.Format {
+			if f.BaseType == image.HALF {
+				raw += 2
+			} else {
+				raw += 4
+			}
+		}
+
+		if fi, err := os.Stat(filename); err != nil || fi.Size() >= int64(raw*rspec.Width*rspec.Height) {
+			t.Errorf("%v: not compressed", name)
+		}
+	}
+
+	if rspec.X != spec.X || rspec.Y != spec.Y || rspec.Width != spec.Width || rspec.Height != spec.Height {
+		t.Errorf("%v: data window %v,%v %vx%v", name, rspec.X, rspec.Y, rspec.Width, rspec.Height)
+	}
+
+	if rspec.FullX != 0 || rspec.FullY != 0 || rspec.FullWidth != spec.FullWidth || rspec.FullHeight != spec.FullHeight {
+		t.Errorf("%v: display window %v,%v %vx%v", name, rspec.FullX, rspec.FullY, rspec.FullWidth, rspec.FullHeight)
+	}
+
+	if rspec.TileWidth != spec.TileWidth || rspec.TileHeight != spec.TileHeight {
+		t.Errorf("%v: tiles %vx%v", name, rspec.TileWidth, rspec.TileHeight)
+	}
+
+	if !reflect.DeepEqual(rspec.ChannelNames, []string{"A", "B", "G", "R", "Z"}) {
+		t.Errorf("%v: channels %v", name, rspec.ChannelNames)
+	}
+
+	if rspec.AlphaChannel != 0 || rspec.ZChannel != 4 {
+		t.Errorf("%v: alpha %v, Z %v", name, rspec.AlphaChannel, rspec.ZChannel)
+	}
+
+	for k, v := range attribs {
+		if !reflect.DeepEqual(rspec.ExtraAttribs[k], v) {
+			t.Errorf("%v: attribute %v = %v, expected %v", name, k, rspec.ExtraAttribs[k], v)
+		}
+	}
+
+	if c := rspec.ExtraAttribs["compression"]; c != spec.ExtraAttribs["compression"] {
+		t.Errorf("%v: compression %v", name, c)
+	}
+
+	checkRead(t, name, r)
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+)
+
+// zipCompress compresses a block with zlib after separating the low and high bytes and taking
+// the differences between them.
+func zipCompress(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	tmp := make([]byte, len(data))
+
+	// Interleave: even bytes in the first half, odd bytes in the second.
+	t1, t2 := 0, (len(data)+1)/2
+
+	for i := 0; i < len(data); i += 2 {
+		tmp[t1] = data[i]
+		t1++
+
+		if i+1 < len(data) {
+			tmp[t2] = data[i+1]
+			t2++
+		}
+	}
+
+	// Predictor.
+	p := int(tmp[0])
+
+	for i := 1; i < len(tmp); i++ {
+		d := int(tmp[i]) - p + (128 + 256)
+		p = int(tmp[i])
+		tmp[i] = byte(d)
+	}
+
+	var b bytes.Buffer
+
+	zw := zlib.NewWriter(&b)
+
+	if _, err := zw.Write(tmp); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// zipUncompress inflates a ZIP or ZIPS block of n bytes.
+func zipUncompress(data []byte, n int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	tmp := make([]byte, n)
+
+	if _, err := io.ReadFull(zr, tmp); err != nil {
+		return nil, err
+	}
+
+	return unpredict(tmp), nil
+}
+
+// unpredict reverses the predictor and interleaving applied before ZIP and RLE compression.
+func unpredict(tmp []byte) []byte {
+	for i := 1; i < len(tmp); i++ {
+		tmp[i] = byte(int(tmp[i-1]) + int(tmp[i]) - 128)
+	}
+
+	out := make([]byte, len(tmp))
+	t1, t2 := 0, (len(tmp)+1)/2
+
+	for i := 0; i < len(out); i += 2 {
+		out[i] = tmp[t1]
+		t1++
+
+		if i+1 < len(out) {
+			out[i+1] = tmp[t2]
+			t2++
+		}
+	}
+
+	return out
+}
+
+// rleUncompress expands a run length encoded block of n bytes.  Negative counts are followed by
+// that many literal bytes, otherwise the next byte is repeated count+1 times.
+func rleUncompress(data []byte, n int) ([]byte, error) {
+	tmp := make([]byte, 0, n)
+
+	for p := 0; p < len(data); {
+		count := int(int8(data[p]))
+		p++
+
+		if count < 0 {
+			if p-count > len(data) || len(tmp)-count > n {
+				return nil, errCorrupt
+			}
+
+			tmp = append(tmp, data[p:p-count]...)
+			p -= count
+		} else {
+			if p >= len(data) || len(tmp)+count+1 > n {
+				return nil, errCorrupt
+			}
+
+			for i := 0; i <= count; i++ {
+				tmp = append(tmp, data[p])
+			}
+
+			p++
+		}
+	}
+
+	if len(tmp) != n {
+		return nil, errCorrupt
+	}
+
+	return unpredict(tmp), nil
+}
@@ -16,10 +16,9 @@ const minEncodingLen = 8
 const maxEncodingLen = 0x7fff
 const minRunLen = 4
 
-func convertComponent(expo uint, val byte) float32 {
-	v := float32(val) / 256.0
-	d := 1 << expo // float32(math.Pow(2.0, float64(expo)))
-	return v * float32(d)
+func convertComponent(expo int, val byte) float32 {
+	v := (float64(val) + 0.5) / 256.0
+	return float32(math.Ldexp(v, expo))
 }
 
 func convertRGBToRGBE(r, g, b float32) (or, og, ob, oe byte) {
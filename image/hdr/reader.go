@@ -14,9 +14,10 @@ import (
 
 // Reader implements an image reader for Radiance HDR format.
 type Reader struct {
-	file   *os.File
-	reader *bufio.Reader
-	spec   image.Spec
+	file     *os.File
+	reader   *bufio.Reader
+	spec     image.Spec
+	bottomUp bool // +Y, first scanline is the bottom of the image
 }
 
 func init() {
@@ -83,6 +84,8 @@ func (h *Reader) readHeaders() error {
 
 	// _,err := fmt.Fscanf...    include newline
 
+	h.bottomUp = ys == "+Y"
+
 	h.spec.Height = height
 	h.spec.Width = width
 	h.spec.X = 0 //xs
@@ -91,9 +94,11 @@ func (h *Reader) readHeaders() error {
 	h.spec.FullWidth = width
 	h.spec.FullX = 0 //xs
 	h.spec.FullY = 0 //ys
-	h.spec.NChannels = 4
-	h.spec.Format = []image.TypeDesc{image.TypeDesc{}, image.TypeDesc{}, image.TypeDesc{}, image.TypeDesc{}}
-	h.spec.ChannelNames = []string{"R", "G", "B", "E"}
+	// ReadImage converts the RGBE pixels to float RGB.
+	h.spec.NChannels = 3
+	float := image.TypeDesc{BaseType: image.FLOAT}
+	h.spec.Format = []image.TypeDesc{float, float, float}
+	h.spec.ChannelNames = []string{"R", "G", "B"}
 	h.spec.AlphaChannel = -1
 	h.spec.ZChannel = -1
 
@@ -121,25 +126,39 @@ func (h *Reader) ReadImage(ty image.TypeDesc, buf interface{}) error {
 	scanline := make([]byte, h.spec.Width*4)
 
 	for j := 0; j < h.spec.Height; j++ {
-		if err := readScanline(h.reader, scanline[j*h.spec.Width*4:j*h.spec.Width*4+h.spec.Width*4]); err != nil {
+		if err := readScanline(h.reader, scanline); err != nil {
 			return err
 		}
 
+		// Rows are returned top first.
+		y := j
+
+		if h.bottomUp {
+			y = h.spec.Height - 1 - j
+		}
+
 		for i := 0; i < h.spec.Width; i++ {
 			or := scanline[(i*4)+0]
 			og := scanline[(i*4)+1]
 			ob := scanline[(i*4)+2]
 			oe := scanline[(i*4)+3]
 
-			expo := uint(oe) - 128
+			if oe == 0 {
+				pbuf[y*h.spec.Width*3+(i*3)+0] = 0
+				pbuf[y*h.spec.Width*3+(i*3)+1] = 0
+				pbuf[y*h.spec.Width*3+(i*3)+2] = 0
+				continue
+			}
+
+			expo := int(oe) - colourExcess
 
 			r := convertComponent(expo, or)
 			g := convertComponent(expo, og)
 			b := convertComponent(expo, ob)
 
-			pbuf[j*h.spec.Width*3+(i*3)+0] = r
-			pbuf[j*h.spec.Width*3+(i*3)+1] = g
-			pbuf[j*h.spec.Width*3+(i*3)+2] = b
+			pbuf[y*h.spec.Width*3+(i*3)+0] = r
+			pbuf[y*h.spec.Width*3+(i*3)+1] = g
+			pbuf[y*h.spec.Width*3+(i*3)+2] = b
 		}
 	}
 
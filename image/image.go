@@ -26,6 +26,8 @@ type Aggregate int
 const (
 	UINT8 BaseType = iota
 	FLOAT
+	HALF
+	UINT
 )
 
 // Enum for Aggregate.
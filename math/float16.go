@@ -36,9 +36,9 @@ func Float32ToFloat16(xe float32) Float16 {
 		return Float16(0xFE00) // NaN, only 1st mantissa bit set
 
 	} else { // Normalized number
-		hs := Float16(xs >> 16)                // Sign bit
-		hes := (uint32(xexp >> 23)) - 127 + 15 // Exponent unbias the single, then bias the halfp
-		if hes >= 0x1F {                       // Overflow
+		hs := Float16(xs >> 16)         // Sign bit
+		hes := int(xexp>>23) - 127 + 15 // Exponent unbias the single, then bias the halfp
+		if hes >= 0x1F {                // Overflow
 			return Float16((xs >> 16) | 0x7C00) // Signed Inf
 		} else if hes <= 0 { // Underflow
 
@@ -47,9 +47,9 @@ func Float32ToFloat16(xe float32) Float16 {
 			if (14 - hes) > 24 { // Mantissa shifted all the way off & no rounding possibility
 				hm = Float16(0) // Set mantissa to zero
 			} else {
-				xm |= 0x00800000                    // Add the hidden leading bit
-				hm = Float16(xm >> (14 - hes))      // Mantissa
-				if (xm>>(13-hes))&0x00000001 != 0 { // Check for rounding
+				xm |= 0x00800000                        // Add the hidden leading bit
+				hm = Float16(xm >> uint(14-hes))        // Mantissa
+				if (xm>>uint(13-hes))&0x00000001 != 0 { // Check for rounding
 					hm += Float16(1) // Round, might overflow into exp bit, but this is OK
 				}
 			}
@@ -46,7 +46,7 @@ var typeVec3Array = reflect.TypeOf(param.Vec3Array{})
 var typeFloat32Array = reflect.TypeOf(param.Float32Array{})
 var typeMatrixArray = reflect.TypeOf(param.MatrixArray{})
 
-var keywords = []string{"int", "float", "vec2", "vec3", "point", "rgb", "rgbtex", "matrix"}
+var keywords = []string{"int", "float", "vec2", "vec3", "point", "rgb", "rgbtex", "map", "expr", "matrix"}
 
 func isInKeywords(v string) bool {
 	for _, k := range keywords {
@@ -267,6 +267,47 @@ func (p *parser) rgbtex(field reflect.Value) error {
 	return nil
 }
 
+func (p *parser) mapref(field reflect.Value) error {
+
+	var sym SymType
+
+	if t := p.lex.Lex(&sym); t != TokToken && sym.str != "map" {
+		return errors.New("Expected field type.")
+	}
+
+	if t := p.lex.Lex(&sym); t != TokString {
+		return errors.New("Expected map node name.")
+	}
+
+	field.Set(reflect.ValueOf(&maps.NodeRef{Name: sym.str}))
+
+	return nil
+}
+
+func (p *parser) expr(field reflect.Value) error {
+
+	var sym SymType
+
+	if t := p.lex.Lex(&sym); t != TokToken && sym.str != "expr" {
+		return errors.New("Expected field type.")
+	}
+
+	if t := p.lex.Lex(&sym); t != TokString {
+		return errors.New("Expected expression string.")
+	}
+
+	v, err := maps.CreateExpr(sym.str)
+
+	if err != nil {
+		p.errorf("%v", err)
+		return err
+	}
+
+	field.Set(reflect.ValueOf(v))
+
+	return nil
+}
+
 func (p *parser) vec3(field reflect.Value) error {
 
 	var sym SymType
@@ -644,6 +685,10 @@ func (p *parser) parseParam(field reflect.Value, skip bool) error {
 				p.floatmap(field)
 			case "rgbtex":
 				p.rgbtex(field)
+			case "map":
+				p.mapref(field)
+			case "expr":
+				p.expr(field)
 			}
 		}
 	default:
@@ -0,0 +1,402 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package texture
+
+import (
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Tile size in texels, must be a power of two.
+const (
+	tileShift = 6
+	tileSize  = 1 << tileShift
+	tileMask  = tileSize - 1
+)
+
+// tile is a square of texels from one mip level.  Tiles along the right and top edges of a level
+// are clipped to the level size.
+type tile struct {
+	lastUsed uint64       // Cache clock at last use, accessed atomically.
+	data     atomic.Value // []byte, nil if not resident
+	size     int
+}
+
+type level struct {
+	w, h           int
+	tilesX, tilesY int
+	tiles          []tile
+}
+
+// tileSource is used by the cache to (re)load tiles.  loadTiles must store the given tile with
+// storeTile.
+type tileSource interface {
+	loadTiles(tex *Texture, l, tx, ty int) error
+}
+
+// tileCache tracks the resident tiles of all textures.  Tiles are evicted least recently used
+// first when the memory used exceeds core.TextureCacheSize.
+//
+// Recency is measured with a clock which ticks on every tile load, so cache hits only have to
+// update the tile's stamp (if it changed) without taking any locks.
+type tileCache struct {
+	clock     uint64 // accessed atomically
+	misses    uint64 // accessed atomically
+	evictions uint64
+
+	mutex     sync.Mutex
+	resident  []*tile
+	bytes     int64
+	peakBytes int64
+}
+
+var cache tileCache
+
+func init() {
+	core.SetTextureStats(Stats)
+}
+
+// Stats returns the texture cache statistics.  Lookups are counted by core, those of a render
+// task are included once it has finished.
+func Stats() core.TextureStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	return core.TextureStats{
+		Lookups:   core.TextureLookups(),
+		Misses:    atomic.LoadUint64(&cache.misses),
+		Evictions: cache.evictions,
+		Bytes:     cache.bytes,
+		PeakBytes: cache.peakBytes,
+	}
+}
+
+// add records a newly resident tile.
+func (c *tileCache) add(t *tile) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.resident = append(c.resident, t)
+	c.bytes += int64(t.size)
+
+	if c.bytes > c.peakBytes {
+		c.peakBytes = c.bytes
+	}
+}
+
+// reserve counts n bytes of temporary memory (e.g. a decoded image) against the limit, evicting
+// tiles to make room.  It must be returned with release.
+func (c *tileCache) reserve(n int64) {
+	c.mutex.Lock()
+	c.bytes += n
+
+	if c.bytes > c.peakBytes {
+		c.peakBytes = c.bytes
+	}
+
+	c.mutex.Unlock()
+
+	c.evict()
+}
+
+// release returns memory counted by reserve.
+func (c *tileCache) release(n int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.bytes -= n
+}
+
+type tileAge struct {
+	t        *tile
+	lastUsed uint64
+}
+
+type byAge []tileAge
+
+func (a byAge) Len() int           { return len(a) }
+func (a byAge) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byAge) Less(i, j int) bool { return a[i].lastUsed < a[j].lastUsed }
+
+// evict releases the least recently used tiles until the cache is 1/8 below the limit, so
+// evictions happen in batches.  Tiles used since the last load are never evicted.
+func (c *tileCache) evict() {
+	limit := core.TextureCacheSize()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if limit <= 0 || c.bytes <= limit {
+		return
+	}
+
+	// Snapshot the stamps as they may change while sorting.
+	ages := make([]tileAge, len(c.resident))
+
+	for i, t := range c.resident {
+		ages[i] = tileAge{t, atomic.LoadUint64(&t.lastUsed)}
+	}
+
+	sort.Sort(byAge(ages))
+
+	target := limit - limit/8
+	clock := atomic.LoadUint64(&c.clock)
+
+	n := 0
+
+	for ; n < len(ages) && c.bytes > target; n++ {
+		if ages[n].lastUsed >= clock {
+			break
+		}
+
+		ages[n].t.data.Store([]byte(nil))
+		c.bytes -= int64(ages[n].t.size)
+		c.evictions++
+	}
+
+	c.resident = make([]*tile, 0, len(ages)-n)
+
+	for _, age := range ages[n:] {
+		c.resident = append(c.resident, age.t)
+	}
+}
+
+// initLevels sets up the (empty) tiles for each mip level.
+func (tex *Texture) initLevels() {
+	w, h := tex.w, tex.h
+
+	tex.levels = make([]level, numLevels(w, h))
+
+	for l := range tex.levels {
+		lvl := &tex.levels[l]
+
+		lvl.w, lvl.h = w, h
+		lvl.tilesX = (w + tileSize - 1) >> tileShift
+		lvl.tilesY = (h + tileSize - 1) >> tileShift
+		lvl.tiles = make([]tile, lvl.tilesX*lvl.tilesY)
+
+		w = maxi(1, (w+1)/2)
+		h = maxi(1, (h+1)/2)
+	}
+}
+
+// pin builds the mip levels from the 8-bit tex.data and keeps all the tiles in memory.
+func (tex *Texture) pin() {
+	data := make([]float32, len(tex.data))
+
+	for i := range data {
+		data[i] = float32(tex.data[i]) / 255.0
+	}
+
+	tex.fmt = formatUint8
+	tex.initLevels()
+	tex.fillLevels(0, stdfilter(tex.w, tex.h, data, tex.components))
+
+	tex.data = nil
+}
+
+// fillLevels encodes levels l and coarser from the mipmap and fills any missing tiles.
+func (tex *Texture) fillLevels(l int, mip mipmap) {
+	for k := l; k < len(tex.levels); k++ {
+		tex.fillLevel(k, encode(tex.fmt, mip.mipmap[k].mipmap))
+	}
+}
+
+// fillLevel copies any missing tiles of level l out of data, which holds the whole level in
+// the texture's format.
+func (tex *Texture) fillLevel(l int, data []byte) {
+	lvl := &tex.levels[l]
+	c := tex.components * formatSize(tex.fmt)
+	clock := atomic.LoadUint64(&cache.clock)
+
+	for ty := 0; ty < lvl.tilesY; ty++ {
+		for tx := 0; tx < lvl.tilesX; tx++ {
+			t := &lvl.tiles[ty*lvl.tilesX+tx]
+
+			if d, _ := t.data.Load().([]byte); d != nil {
+				continue
+			}
+
+			tex.storeTile(t, cutTile(lvl, data, c, tx, ty), clock)
+		}
+	}
+}
+
+// cutTile copies tile tx,ty out of data, which holds the whole level with c bytes per texel.
+func cutTile(lvl *level, data []byte, c, tx, ty int) []byte {
+	x0, y0 := tx<<tileShift, ty<<tileShift
+	tw, th := mini(tileSize, lvl.w-x0), mini(tileSize, lvl.h-y0)
+
+	d := make([]byte, tw*th*c)
+
+	for y := 0; y < th; y++ {
+		copy(d[y*tw*c:(y+1)*tw*c], data[((y0+y)*lvl.w+x0)*c:])
+	}
+
+	return d
+}
+
+// storeTile makes d the resident data of tile t.
+func (tex *Texture) storeTile(t *tile, d []byte, clock uint64) {
+	atomic.StoreUint64(&t.lastUsed, clock)
+	t.size = len(d)
+	t.data.Store(d)
+
+	if tex.src != nil {
+		cache.add(t)
+	}
+}
+
+// tile returns the data for tile tx,ty of level l, loading it if it isn't resident.
+func (tex *Texture) tile(l, tx, ty int) []byte {
+	lvl := &tex.levels[l]
+	t := &lvl.tiles[ty*lvl.tilesX+tx]
+
+	if d, _ := t.data.Load().([]byte); d != nil {
+		if clock := atomic.LoadUint64(&cache.clock); atomic.LoadUint64(&t.lastUsed) != clock {
+			atomic.StoreUint64(&t.lastUsed, clock)
+		}
+
+		return d
+	}
+
+	return tex.load(l, tx, ty)
+}
+
+func (tex *Texture) load(l, tx, ty int) []byte {
+	tex.loadMutex.Lock()
+	defer tex.loadMutex.Unlock()
+
+	lvl := &tex.levels[l]
+	t := &lvl.tiles[ty*lvl.tilesX+tx]
+
+	// May have been loaded while waiting for the lock.
+	if d, _ := t.data.Load().([]byte); d != nil {
+		return d
+	}
+
+	atomic.AddUint64(&cache.misses, 1)
+	atomic.AddUint64(&cache.clock, 1)
+
+	if err := tex.src.loadTiles(tex, l, tx, ty); err != nil {
+		log.Printf("texture: \"%v\": %v", tex.url, err)
+	}
+
+	d, _ := t.data.Load().([]byte)
+
+	cache.evict()
+
+	if d == nil {
+		// Failed to load, sample as black.
+		tw, th := mini(tileSize, lvl.w-(tx<<tileShift)), mini(tileSize, lvl.h-(ty<<tileShift))
+		d = make([]byte, tw*th*tex.components*formatSize(tex.fmt))
+	}
+
+	return d
+}
+
+// texel returns the texel x,y of level l.
+func (tex *Texture) texel(l, x, y int) (c [3]float32) {
+	lvl := &tex.levels[l]
+	tx, ty := x>>tileShift, y>>tileShift
+	tw := mini(tileSize, lvl.w-(tx<<tileShift))
+
+	d := tex.tile(l, tx, ty)
+	i := ((y&tileMask)*tw + (x & tileMask)) * tex.components
+
+	for k := range c {
+		c[k] = decode(tex.fmt, d, i+k)
+	}
+
+	return
+}
+
+// MaxLevelOfDetail returns the index of the coarsest mip level.
+func (tex *Texture) MaxLevelOfDetail() int {
+	return len(tex.levels) - 1
+}
+
+// wrappedTexel returns the texel x,y of level l with the coordinates wrapped.
+func (tex *Texture) wrappedTexel(l, x, y, wrap int) (c [3]float32) {
+	lvl := &tex.levels[l]
+
+	x, okx := wrapIndex(x, lvl.w, wrap)
+	y, oky := wrapIndex(y, lvl.h, wrap)
+
+	if !okx || !oky {
+		return
+	}
+
+	return tex.texel(l, x, y)
+}
+
+// BilinearSample samples level l, coordinates outside [0,1) are handled by the wrap mode.
+func (tex *Texture) BilinearSample(l int, s, t float32, wrap int) (c [3]float32) {
+	lvl := &tex.levels[l]
+
+	fs := s * float32(lvl.w)
+	ft := t * float32(lvl.h)
+
+	x0 := int(m.Floor(fs))
+	x1 := int(m.Ceil(fs))
+	dx := fs - m.Floor(fs)
+	y0 := int(m.Floor(ft))
+	y1 := int(m.Ceil(ft))
+	dy := ft - m.Floor(ft)
+
+	c00 := tex.wrappedTexel(l, x0, y0, wrap)
+	c10 := tex.wrappedTexel(l, x1, y0, wrap)
+	c01 := tex.wrappedTexel(l, x0, y1, wrap)
+	c11 := tex.wrappedTexel(l, x1, y1, wrap)
+
+	for k := range c {
+		c0 := (1-dx)*c00[k] + dx*c10[k]
+		c1 := (1-dx)*c01[k] + dx*c11[k]
+
+		c[k] = (1-dy)*c0 + dy*c1
+	}
+
+	return
+}
+
+// TrilinearSample samples between the two mip levels either side of lod.
+func (tex *Texture) TrilinearSample(s, t, lod float32, wrap int) (c [3]float32) {
+	l0 := int(m.Ceil(lod))
+	l1 := int(m.Floor(lod))
+	dl := lod - m.Floor(lod)
+
+	if l0 < 0 {
+		l0 = 0
+	}
+
+	if l0 > len(tex.levels)-1 {
+		l0 = len(tex.levels) - 1
+	}
+
+	if l1 < 0 {
+		l1 = 0
+	}
+
+	if l1 > len(tex.levels)-1 {
+		l1 = len(tex.levels) - 1
+	}
+
+	if l1 == l0 {
+		return tex.BilinearSample(l0, s, t, wrap)
+	}
+
+	c0 := tex.BilinearSample(l0, s, t, wrap)
+	c1 := tex.BilinearSample(l1, s, t, wrap)
+
+	for k := range c {
+		c[k] = dl*c0[k] + (1-dl)*c1[k]
+	}
+
+	return
+}
@@ -0,0 +1,168 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package texture
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/core"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePNG writes a w by h PNG where the pixel x,y from the top has red x%251 and green y%251.
+func writePNG(t *testing.T, filename string, w, h int) {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x % 251), uint8(y % 251), 0, 255})
+		}
+	}
+
+	f, err := os.Create(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	const w, h = 1024, 1024 // 3MB of tiles in the finest level
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "eviction.png")
+	writePNG(t, filename, w, h)
+
+	core.Init(nil)
+	core.AddNode(&core.Globals{TextureCacheSize: 1, TextureCacheDir: filepath.Join(dir, "cache")})
+
+	opts := Options{Filter: FilterTrilinear}
+
+	// Samples texel x,y (from the bottom) of the finest level, the derivatives are tiny so
+	// only one tile is read.
+	sample := func(x, y int) {
+		sc := &core.ShaderContext{
+			U: float32(x) / w, V: float32(y) / h,
+			Image: &core.Image{PixelDelta: [2]float32{1, 1}},
+		}
+		sc.Dduvdx[0], sc.Dduvdy[1] = 1e-6, 1e-6
+
+		c := Sample(filename, opts, sc)
+		expected := [3]float32{float32(x%251) / 255, float32((h-1-y)%251) / 255, 0}
+
+		if c != expected {
+			t.Errorf("texel %v,%v = %v, expected %v", x, y, c, expected)
+		}
+	}
+
+	start := Stats()
+
+	// Each tile is sampled twice, the second is a hit.
+	for ty := 0; ty < h/tileSize; ty++ {
+		for tx := 0; tx < w/tileSize; tx++ {
+			sample(tx*tileSize+5, ty*tileSize+7)
+			sample(tx*tileSize+9, ty*tileSize+3)
+		}
+	}
+
+	stats := Stats()
+	tiles := uint64(w / tileSize * h / tileSize)
+
+	if n := stats.Lookups - start.Lookups; n != 2*tiles {
+		t.Errorf("%v lookups, expected %v", n, 2*tiles)
+	}
+
+	if n := stats.Misses - start.Misses; n != tiles {
+		t.Errorf("%v misses, expected %v", n, tiles)
+	}
+
+	if stats.Evictions == start.Evictions {
+		t.Errorf("no evictions")
+	}
+
+	if stats.Bytes > core.TextureCacheSize() {
+		t.Errorf("%v bytes resident, limit %v", stats.Bytes, core.TextureCacheSize())
+	}
+
+	// The first tile has been evicted and is read back from the converted file.
+	sample(1, 2)
+
+	if n := Stats().Misses - stats.Misses; n != 1 {
+		t.Errorf("%v misses reloading, expected 1", n)
+	}
+
+	converted, _ := filepath.Glob(filepath.Join(dir, "cache", "*"+TiledExt))
+
+	if len(converted) != 1 {
+		t.Fatalf("converted files %v", converted)
+	}
+
+	// A later render uses the converted file.
+	tex, err := LoadTexture(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if src, err := openConverted(tex, convertedFilename(tex)); err != nil || src.filename != converted[0] {
+		t.Errorf("converted file not reused: %v", err)
+	}
+}
+
+func TestFileCacheLimit(t *testing.T) {
+	dir := t.TempDir()
+
+	var names []string
+
+	for i := 0; i < maxOpenFiles+8; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("%v.vtx", i))
+
+		if err := ioutil.WriteFile(name, []byte{byte(i)}, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, name)
+	}
+
+	// The first file stays in use while the others are opened.
+	first, err := openFiles.acquire(names[0])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range names[1:] {
+		f, err := openFiles.acquire(name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		openFiles.release(f)
+
+		if n := openFiles.numOpen(); n > maxOpenFiles {
+			t.Fatalf("%v files open", n)
+		}
+	}
+
+	b := make([]byte, 1)
+
+	if _, err := first.ReadAt(b, 0); err != nil || b[0] != 0 {
+		t.Errorf("file in use was closed: %v", err)
+	}
+
+	openFiles.release(first)
+}
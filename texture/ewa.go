@@ -0,0 +1,133 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package texture
+
+import (
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// maxAnisotropy limits the eccentricity of the EWA ellipse, very thin ellipses are widened (which
+// blurs slightly) so the number of texels filtered is bounded.
+const maxAnisotropy = 8
+
+// ewaAlpha is the falloff of the Gaussian filter.
+const ewaAlpha = 2
+
+// ewa filters with an elliptically weighted average of the texels under the pixel footprint
+// (Heckbert 1989, as in PBRT).  The derivatives are in texture coordinates scaled to the pixel
+// footprint.
+func ewa(img *Texture, s, t float32, Dduvdx, Dduvdy m.Vec2, wrap int) (c [3]float32) {
+	// Work in level 0 texels so non-square textures choose the level correctly.
+	dst0 := m.Vec2{Dduvdx[0] * float32(img.w), Dduvdx[1] * float32(img.h)}
+	dst1 := m.Vec2{Dduvdy[0] * float32(img.w), Dduvdy[1] * float32(img.h)}
+
+	if m.Vec2Length(dst0) < m.Vec2Length(dst1) {
+		dst0, dst1 = dst1, dst0
+	}
+
+	majorLength := m.Vec2Length(dst0)
+	minorLength := m.Vec2Length(dst1)
+
+	if minorLength == 0 {
+		return img.BilinearSample(0, s, t, wrap)
+	}
+
+	if minorLength*maxAnisotropy < majorLength {
+		scale := majorLength / (minorLength * maxAnisotropy)
+		dst1 = m.Vec2Scale(scale, dst1)
+		minorLength *= scale
+	}
+
+	lod := m.Max(0, m.Log2(minorLength))
+
+	if lod >= float32(img.MaxLevelOfDetail()) {
+		return img.ewaLevel(img.MaxLevelOfDetail(), s, t, dst0, dst1, wrap)
+	}
+
+	l := int(m.Floor(lod))
+	dl := lod - float32(l)
+
+	c0 := img.ewaLevel(l, s, t, dst0, dst1, wrap)
+	c1 := img.ewaLevel(l+1, s, t, dst0, dst1, wrap)
+
+	for k := range c {
+		c[k] = (1-dl)*c0[k] + dl*c1[k]
+	}
+
+	return
+}
+
+// ewaLevel filters level l with the ellipse given by the axes dst0, dst1 in level 0 texels.
+func (tex *Texture) ewaLevel(l int, s, t float32, dst0, dst1 m.Vec2, wrap int) (c [3]float32) {
+	lvl := &tex.levels[l]
+
+	// Rescale to this level's texels.
+	rs := float32(lvl.w) / float32(tex.w)
+	rt := float32(lvl.h) / float32(tex.h)
+
+	s *= float32(lvl.w)
+	t *= float32(lvl.h)
+	dst0 = m.Vec2{dst0[0] * rs, dst0[1] * rt}
+	dst1 = m.Vec2{dst1[0] * rs, dst1[1] * rt}
+
+	// Implicit ellipse Au^2 + Buv + Cv^2 = 1, grown by a texel so it always covers one.
+	A := dst0[1]*dst0[1] + dst1[1]*dst1[1] + 1
+	B := -2 * (dst0[0]*dst0[1] + dst1[0]*dst1[1])
+	C := dst0[0]*dst0[0] + dst1[0]*dst1[0] + 1
+
+	invF := 1 / (A*C - B*B*0.25)
+	A *= invF
+	B *= invF
+	C *= invF
+
+	// Bounding box of the ellipse.
+	det := -B*B + 4*A*C
+	invDet := 1 / det
+	uSqrt := m.Sqrt(det * C)
+	vSqrt := m.Sqrt(A * det)
+
+	s0 := int(m.Ceil(s - 2*invDet*uSqrt))
+	s1 := int(m.Floor(s + 2*invDet*uSqrt))
+	t0 := int(m.Ceil(t - 2*invDet*vSqrt))
+	t1 := int(m.Floor(t + 2*invDet*vSqrt))
+
+	var sum [3]float32
+	var sumWeight float32
+
+	falloff := m.Exp(-ewaAlpha)
+
+	for it := t0; it <= t1; it++ {
+		tt := float32(it) - t
+
+		for is := s0; is <= s1; is++ {
+			ss := float32(is) - s
+
+			r2 := A*ss*ss + B*ss*tt + C*tt*tt
+
+			if r2 >= 1 {
+				continue
+			}
+
+			weight := m.Exp(-ewaAlpha*r2) - falloff
+			texel := tex.wrappedTexel(l, is, it, wrap)
+
+			for k := range sum {
+				sum[k] += texel[k] * weight
+			}
+
+			sumWeight += weight
+		}
+	}
+
+	if sumWeight == 0 {
+		return tex.BilinearSample(l, s/float32(lvl.w), t/float32(lvl.h), wrap)
+	}
+
+	for k := range c {
+		c[k] = sum[k] / sumWeight
+	}
+
+	return
+}
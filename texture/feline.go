@@ -21,24 +21,14 @@ C= Cnn/F
 
 // SampleFeline returns the filtered RGB value for the given texture file.  Accepts
 // normalized texture coordinates in sc.U & sc.V and texture derivatives Dduvdx&dy.
-// WRL-99-1
 func SampleFeline(filename string, sc *core.ShaderContext) (c [3]float32) {
-	textures := texStore.Load().(TexStore)
-	img := textures[filename]
-
-	if img == nil {
-		img2, err := cacheMiss(filename)
-
-		if err != nil {
-			return
-		}
-
-		img = img2
-	}
-
-	Dduvdx := m.Vec2Scale(sc.Image.PixelDelta[0], sc.Dduvdx)
-	Dduvdy := m.Vec2Scale(sc.Image.PixelDelta[1], sc.Dduvdy)
+	return Sample(filename, Options{}, sc)
+}
 
+// feline filters with the Feline anisotropic filter, the texture derivatives are scaled to the
+// pixel footprint.
+// WRL-99-1
+func feline(img *Texture, s, t float32, Dduvdx, Dduvdy m.Vec2, wrap int) (c [3]float32) {
 	// NOTE: we need image coordinates for Feline to work.
 	// TODO: tidy this all up, the mip mapping and texture structures are
 	// a complete mess.
@@ -91,8 +81,8 @@ func SampleFeline(filename string, sc *core.ShaderContext) (c [3]float32) {
 
 	levelOfDetail := m.Log2(minorRadius)
 
-	if levelOfDetail > float32(img.mipmap.MaxLevelOfDetail()) {
-		levelOfDetail = float32(img.mipmap.MaxLevelOfDetail())
+	if levelOfDetail > float32(img.MaxLevelOfDetail()) {
+		levelOfDetail = float32(img.MaxLevelOfDetail())
 		iProbes = 1
 	}
 
@@ -121,17 +111,17 @@ func SampleFeline(filename string, sc *core.ShaderContext) (c [3]float32) {
 	var accumWeight float32
 
 	for i := 0; i < nProbes; i++ {
-		u := float32(img.w)*sc.U + (n/2)*dU
-		v := float32(img.h)*sc.V + (n/2)*dV
+		u := float32(img.w)*s + (n/2)*dU
+		v := float32(img.h)*t + (n/2)*dV
 
 		//d := float32(n) / 2 * m.Sqrt(sqr(dU)+sqr(dV)) / majorRadius
 		d2 := (sqr(n) / 4) * (sqr(dU) + sqr(dV)) / sqr(majorRadius)
 		relativeWeight := m.Exp(-alpha * d2)
 
-		sample := img.mipmap.TrilinearSample(u/float32(img.w), v/float32(img.h), levelOfDetail)
+		sample := img.TrilinearSample(u/float32(img.w), v/float32(img.h), levelOfDetail, wrap)
 
 		for k := range accum {
-			accum[k] += (sample[k] / 255.0) * relativeWeight
+			accum[k] += sample[k] * relativeWeight
 
 		}
 
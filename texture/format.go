@@ -0,0 +1,84 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package texture
+
+import (
+	"encoding/binary"
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+)
+
+// Texel storage formats.  8 and 16 bit integer texels are normalized to [0,1], half and float
+// store the values unchanged so HDR images aren't clipped.
+const (
+	formatUint8 = iota
+	formatUint16
+	formatHalf
+	formatFloat
+	formatSRGB8 // 8-bit sRGB encoded, decoded to linear when sampled
+)
+
+// maxHalf is the largest finite half, larger values are clamped to it rather than becoming Inf.
+const maxHalf = 65504
+
+// srgbTable decodes formatSRGB8 texels.
+var srgbTable [256]float32
+
+func init() {
+	for i := range srgbTable {
+		srgbTable[i] = colour.SRGBToLinear(float32(i) / 255.0)
+	}
+}
+
+// formatSize returns the size in bytes of one component.
+func formatSize(format int) int {
+	switch format {
+	case formatUint16, formatHalf:
+		return 2
+	case formatFloat:
+		return 4
+	}
+
+	return 1
+}
+
+// encode converts the float components into the storage format.
+func encode(format int, data []float32) []byte {
+	out := make([]byte, len(data)*formatSize(format))
+
+	for i, v := range data {
+		switch format {
+		case formatUint8:
+			out[i] = byte(m.Clamp(v, 0, 1)*255 + 0.5)
+		case formatSRGB8:
+			out[i] = byte(colour.LinearToSRGB(m.Clamp(v, 0, 1))*255 + 0.5)
+		case formatUint16:
+			binary.LittleEndian.PutUint16(out[i*2:], uint16(m.Clamp(v, 0, 1)*65535+0.5))
+		case formatHalf:
+			binary.LittleEndian.PutUint16(out[i*2:], uint16(m.Float32ToFloat16(m.Clamp(v, -maxHalf, maxHalf))))
+		case formatFloat:
+			binary.LittleEndian.PutUint32(out[i*4:], math.Float32bits(v))
+		}
+	}
+
+	return out
+}
+
+// decode returns the i'th component of data.
+func decode(format int, data []byte, i int) float32 {
+	switch format {
+	case formatUint16:
+		return float32(binary.LittleEndian.Uint16(data[i*2:])) / 65535.0
+	case formatHalf:
+		return m.Float16ToFloat32(m.Float16(binary.LittleEndian.Uint16(data[i*2:])))
+	case formatFloat:
+		return math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	case formatSRGB8:
+		return srgbTable[data[i]]
+	}
+
+	return float32(data[i]) / 255.0
+}
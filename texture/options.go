@@ -0,0 +1,165 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package texture
+
+import (
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// Colour spaces of texture files.  Linear and Raw images are used unchanged, Raw is for
+// non-colour data such as normal or roughness maps.  sRGB images are decoded to linear when
+// loaded.
+const (
+	ColourSpaceLinear = iota
+	ColourSpaceSRGB
+	ColourSpaceRaw
+)
+
+// Wrap modes for coordinates outside [0,1).
+const (
+	WrapRepeat = iota
+	WrapClamp  // Repeat the edge texels
+	WrapMirror // Alternate tiles are mirrored
+	WrapBlack  // Black outside the image
+)
+
+// Texture filters.
+const (
+	FilterFeline = iota
+	FilterTrilinear
+	FilterEWA
+)
+
+// Options controls how a texture file is interpreted and sampled.  The zero value is a linear,
+// repeating texture with Feline filtering and no UV transform.
+type Options struct {
+	ColourSpace int
+	Wrap        int
+	Filter      int
+
+	// UV transform, applied as scale then rotate (in degrees) then offset.  A zero scale is
+	// treated as 1.
+	ScaleU, ScaleV   float32
+	OffsetU, OffsetV float32
+	Rotate           float32
+}
+
+// transform applies the UV transform to the coordinates uv and derivatives.
+func (opts *Options) transform(uv, Dduvdx, Dduvdy m.Vec2) (m.Vec2, m.Vec2, m.Vec2) {
+	su, sv := opts.ScaleU, opts.ScaleV
+
+	if su == 0 {
+		su = 1
+	}
+
+	if sv == 0 {
+		sv = 1
+	}
+
+	sin, cos := m.Sincos(opts.Rotate * m.Pi / 180)
+
+	xform := func(d m.Vec2) m.Vec2 {
+		u, v := d[0]*su, d[1]*sv
+		return m.Vec2{u*cos - v*sin, u*sin + v*cos}
+	}
+
+	uv = xform(uv)
+	uv[0] += opts.OffsetU
+	uv[1] += opts.OffsetV
+
+	return uv, xform(Dduvdx), xform(Dduvdy)
+}
+
+// Sample returns the filtered RGB value for the given texture file using the options.  Accepts
+// normalized texture coordinates in sc.U & sc.V and texture derivatives Dduvdx&dy.
+func Sample(filename string, opts Options, sc *core.ShaderContext) (c [3]float32) {
+	uv, Dduvdx, Dduvdy := opts.transform(m.Vec2{sc.U, sc.V}, sc.Dduvdx, sc.Dduvdy)
+
+	sc.CountTextureLookup()
+
+	img, s, t := lookup(filename, opts.ColourSpace, uv[0], uv[1])
+
+	if img == nil {
+		return
+	}
+
+	Dduvdx = m.Vec2Scale(sc.Image.PixelDelta[0], Dduvdx)
+	Dduvdy = m.Vec2Scale(sc.Image.PixelDelta[1], Dduvdy)
+
+	switch opts.Filter {
+	case FilterTrilinear:
+		return trilinear(img, s, t, Dduvdx, Dduvdy, opts.Wrap)
+	case FilterEWA:
+		return ewa(img, s, t, Dduvdx, Dduvdy, opts.Wrap)
+	}
+
+	return feline(img, s, t, Dduvdx, Dduvdy, opts.Wrap)
+}
+
+// cacheKey returns the texture store key, sRGB textures are stored separately as their texels
+// are converted when loaded.
+func cacheKey(filename string, colourSpace int) string {
+	if colourSpace == ColourSpaceSRGB {
+		return filename + "\x00srgb"
+	}
+
+	return filename
+}
+
+// setColourSpace sets up a freshly loaded texture for the colour space.  Must be called before
+// any tiles are loaded.  8-bit sRGB textures stay sRGB encoded in the cache to avoid banding in
+// the darks.
+func (tex *Texture) setColourSpace(colourSpace int) {
+	// Pinned textures (the fallback) are shared so mustn't be changed and tiled files are
+	// already converted.
+	if _, ok := tex.src.(imageSource); colourSpace != ColourSpaceSRGB || !ok {
+		return
+	}
+
+	tex.srgb = true
+
+	if tex.fmt == formatUint8 {
+		tex.fmt = formatSRGB8
+	}
+}
+
+// wrapIndex returns the texel index i wrapped into [0,n), ok is false if the texel is black.
+func wrapIndex(i, n, wrap int) (int, bool) {
+	switch wrap {
+	case WrapClamp:
+		if i < 0 {
+			return 0, true
+		}
+
+		if i >= n {
+			return n - 1, true
+		}
+
+		return i, true
+	case WrapMirror:
+		i %= 2 * n
+
+		if i < 0 {
+			i += 2 * n
+		}
+
+		if i >= n {
+			i = 2*n - 1 - i
+		}
+
+		return i, true
+	case WrapBlack:
+		return i, i >= 0 && i < n
+	}
+
+	i %= n
+
+	if i < 0 {
+		i += n
+	}
+
+	return i, true
+}
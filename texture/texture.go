@@ -5,28 +5,45 @@
 /*
 Package texture implements an efficient texture cache.
 
-At present it does virtually nothing other than thread-safe loading of texture files.  Doesn't
-release memory or attempt to use tiled caching.
+Textures are loaded lazily, only the header is read until the texture is sampled.  Each mip level
+is split into tiles which are held in a cache limited to Globals.TextureCacheSize megabytes, the
+least recently used tiles are released when the limit is exceeded and reloaded if needed again.
+Ordinary image files can't be read a tile at a time so the first miss decodes the image and
+converts it to a tiled file in Globals.TextureCacheDir, which is kept for later renders.
+
+Tiled files (TiledExt, made with MakeTiled or the mktex command) hold the mip levels ready
+filtered, misses read just the tile needed.  An up to date tiled file next to an image is used in
+its place.
 
 Textures are currently represented simply by a string which references into a hashmap.  Lookup sounds
 inefficient but has never shown up as significant on profiling.  Expected to change as many more
-textures are used in shaders. */
+textures are used in shaders.
+
+Filenames containing <UDIM> or <UVTILE> refer to tile sets, the tile is chosen from the texture
+coordinates and loaded on first use.  Missing tiles are black. */
 package texture
 
 import (
 	//"fmt"
 	"bytes"
+	"fmt"
 	"github.com/blezek/tga"
+	"github.com/jamiec7919/vermeer/colour"
 	"github.com/jamiec7919/vermeer/core"
+	vimage "github.com/jamiec7919/vermeer/image"
+	_ "github.com/jamiec7919/vermeer/image/exr" // Imported for effect
+	_ "github.com/jamiec7919/vermeer/image/hdr" // Imported for effect
 	m "github.com/jamiec7919/vermeer/math"
 	_ "golang.org/x/image/tiff" // Imported for effect
 	"image"
+	"image/color"
 	_ "image/jpeg" // Imported for effect
 	_ "image/png"  // Imported for effect
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 )
@@ -36,15 +53,21 @@ import (
 const embeddedTexture = "data/checker.png"
 
 // Texture represents a texture image.  (shouldn't be public)
+//
+// The mip levels are split into tiles which are held in the tile cache, src reloads them when
+// they have been evicted.  Textures without a src are pinned in memory.
 type Texture struct {
-	url    string
-	fmt    int
-	w, h   int
-	data   []byte
-	mipmap mipmap
+	url        string
+	fmt        int
+	w, h       int
+	components int
+	srgb       bool // Convert from sRGB when loading
+	data       []byte
+	levels     []level
+	src        tileSource
+	loadMutex  sync.Mutex
 }
 
-// TexStore is the type of the cache.
 type TexStore map[string]*Texture
 
 var texStore atomic.Value
@@ -66,9 +89,7 @@ func init() {
 		tmp.SetRGB(0, 1, 250, 150, 250)
 		tmp.SetRGB(1, 1, 2, 2, 2)
 
-		mipmap := stdfilter(tmp.w, tmp.h, tmp.data, 3)
-
-		tmp.mipmap = mipmap
+		tmp.pin()
 		testTexture = tmp
 
 	} else {
@@ -91,7 +112,35 @@ draw.Draw(m, m.Bounds(), src, b.Min, draw.Src)
 
 // LoadTexture returns a texture object or an error if can't be openend.  Takes a url for
 // future network texture server. (shouldn't be public)
+//
+// Only the image header is read, the tiles are loaded into the cache when first sampled.
 func LoadTexture(url string) (*Texture, error) {
+	if isTiled(url) {
+		tex, _, err := openTiled(url)
+
+		if err != nil {
+			return testTexture, err
+		}
+
+		return tex, nil
+	}
+
+	if isFloatImage(url) {
+		in, err := vimage.Open(url)
+
+		if err != nil {
+			return testTexture, err
+		}
+
+		spec, err := in.Spec()
+		in.Close()
+
+		if err != nil {
+			return testTexture, err
+		}
+
+		return newTexture(url, spec.Width, spec.Height, formatHalf), nil
+	}
 
 	file, err := os.Open(url)
 	if err != nil {
@@ -99,15 +148,167 @@ func LoadTexture(url string) (*Texture, error) {
 	}
 	defer file.Close()
 
-	return loadTexture(url, file)
+	if isTGA(url) {
+		// No DecodeConfig for TGA, have to decode the whole thing.
+		img, err := tga.Decode(file)
+
+		if err != nil {
+			return testTexture, err
+		}
+
+		return newTexture(url, img.Bounds().Dx(), img.Bounds().Dy(), formatUint8), nil
+	}
+
+	config, _, err := image.DecodeConfig(file)
+
+	if err != nil {
+		return testTexture, err
+	}
+
+	return newTexture(url, config.Width, config.Height, modelFormat(config.ColorModel)), nil
 }
 
-func loadTexture(url string, file io.Reader) (*Texture, error) {
+// newTexture returns an empty texture which loads from url.
+func newTexture(url string, w, h, format int) *Texture {
+	t := &Texture{url: url, w: w, h: h, fmt: format, components: 3, src: imageSource{}}
+	t.initLevels()
+
+	return t
+}
+
+func isTGA(url string) bool {
+	return filepath.Ext(url) == ".tga" || filepath.Ext(url) == ".TGA"
+}
+
+// isFloatImage returns true for the floating point formats read with the vermeer/image
+// package rather than Go's image package.
+func isFloatImage(url string) bool {
+	switch strings.ToLower(filepath.Ext(url)) {
+	case ".hdr", ".pic", ".exr":
+		return true
+	}
+
+	return false
+}
+
+// modelFormat returns the storage format for images with the given colour model, 16-bit
+// images are kept at 16 bits.
+func modelFormat(model color.Model) int {
+	switch model {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model, color.Alpha16Model:
+		return formatUint16
+	}
+
+	return formatUint8
+}
+
+// ReadImage reads the whole image into float RGB with the bottom row first.
+func ReadImage(url string) (w, h int, data []float32, err error) {
+	if isFloatImage(url) {
+		return readFloatImage(url)
+	}
+
+	file, err := os.Open(url)
+
+	if err != nil {
+		return
+	}
+
+	defer file.Close()
+
+	w, h, _, data, err = decodeImage(url, file)
+
+	return
+}
+
+// readFloatImage reads an image with the vermeer/image package.  Readers return RGB top row
+// first.
+func readFloatImage(url string) (w, h int, data []float32, err error) {
+	in, err := vimage.Open(url)
+
+	if err != nil {
+		return
+	}
+
+	defer in.Close()
+
+	spec, err := in.Spec()
+
+	if err != nil {
+		return
+	}
+
+	w, h = spec.Width, spec.Height
+	nc := spec.NChannels
+
+	if nc == 0 {
+		err = fmt.Errorf("%v: image has no channels", url)
+		return
+	}
+
+	buf := make([]float32, w*h*nc)
+
+	if err = in.ReadImage(vimage.TypeDesc{BaseType: vimage.FLOAT}, buf); err != nil {
+		return
+	}
+
+	rgb := rgbChannels(spec.ChannelNames)
+	data = make([]float32, w*h*3)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			src := buf[(x+y*w)*nc:]
+			dst := data[(x+(h-1-y)*w)*3:]
+
+			for k, c := range rgb {
+				dst[k] = src[c]
+			}
+		}
+	}
+
+	return
+}
 
-	var err error
+// rgbChannels returns the indices of the R, G and B channels of an image.  Greyscale images
+// (Y) use the one channel for all three, otherwise the first channels are used.
+func rgbChannels(names []string) [3]int {
+	find := func(name string) int {
+		for i, n := range names {
+			if n == name {
+				return i
+			}
+		}
+
+		return -1
+	}
+
+	if r, g, b := find("R"), find("G"), find("B"); r >= 0 && g >= 0 && b >= 0 {
+		return [3]int{r, g, b}
+	}
+
+	if y := find("Y"); y >= 0 {
+		return [3]int{y, y, y}
+	}
+
+	var rgb [3]int
+
+	for k := range rgb {
+		rgb[k] = k
+
+		if k >= len(names) {
+			rgb[k] = len(names) - 1
+		}
+	}
+
+	return rgb
+}
+
+// decodeImage decodes an image to float RGB with the bottom row first.  format is the storage
+// format which holds the image without loss.
+func decodeImage(url string, file io.Reader) (w, h, format int, data []float32, err error) {
 	var m image.Image
 
-	if filepath.Ext(url) == ".tga" || filepath.Ext(url) == ".TGA" {
+	if isTGA(url) {
 		// Decode the image.
 		m, err = tga.Decode(file)
 
@@ -117,56 +318,105 @@ func loadTexture(url string, file io.Reader) (*Texture, error) {
 	}
 
 	if err != nil {
-		return testTexture, err
+		return
 	}
 
-	t := CreateRGBTexture(m.Bounds().Max.X-m.Bounds().Min.X, m.Bounds().Max.Y-m.Bounds().Min.Y)
-	t.url = url
-
-	//		tex := AllocTexImage(m.Bounds().Max.X-m.Bounds().Min.X, m.Bounds().Max.Y-m.Bounds().Min.Y)
+	w = m.Bounds().Max.X - m.Bounds().Min.X
+	h = m.Bounds().Max.Y - m.Bounds().Min.Y
+	format = modelFormat(m.ColorModel())
+	data = make([]float32, w*h*3)
 
 	for j := m.Bounds().Min.Y; j < m.Bounds().Max.Y; j++ {
 		for i := m.Bounds().Min.X; i < m.Bounds().Max.X; i++ {
 			r, g, b, _ := m.At(i, j).RGBA()
-			t.SetRGB(i, m.Bounds().Max.Y-1-j, uint8(r>>8), uint8(g>>8), uint8(b>>8))
-			//t.SetRGB(i, m.Bounds().Max.Y-1-j, uint8(r), uint8(g), uint8(b))
-			//t.SetRGB(i, m.Bounds().Max.Y-1-j, uint8(r/alpha), uint8(g/alpha), uint8(b/alpha))
-			//				tex.Set(i, m.Bounds().Max.Y-1-j, byte(r), byte(g), byte(b))
+			x := i - m.Bounds().Min.X
+			y := m.Bounds().Max.Y - 1 - j
+
+			data[(x+(y*w))*3+0] = float32(r) / 65535.0
+			data[(x+(y*w))*3+1] = float32(g) / 65535.0
+			data[(x+(y*w))*3+2] = float32(b) / 65535.0
 		}
 	}
 
-	mipmap := stdfilter(t.w, t.h, t.data, 3)
+	return
+}
+
+// loadTexture decodes the whole image from file, the texture is pinned in memory as it can't
+// be reloaded.
+func loadTexture(url string, file io.Reader) (*Texture, error) {
+	w, h, format, data, err := decodeImage(url, file)
 
-	t.mipmap = mipmap
+	if err != nil {
+		return testTexture, err
+	}
 
-	/*
-		t.rset = make(map[int]*TexImage)
-		t.rset[t.genHash(log2(tex.w), log2(tex.h))] = tex
+	t := &Texture{url: url, w: w, h: h, fmt: format, components: 3}
+	t.initLevels()
+	t.fillLevels(0, stdfilter(w, h, data, t.components))
 
-		w := tex.w
+	return t, nil
+}
 
-		for w > 0 {
-			h := tex.h
+// imageSource loads tiles from an ordinary image file.  These can't be read a tile at a time so
+// on the first miss the whole image is decoded, filtered and converted to a tiled file in the
+// texture cache directory which then becomes the texture's source, later misses (and later
+// renders) read a single tile.  If the file can't be written only the requested tile is kept
+// and the image is decoded again on each miss.
+type imageSource struct{}
+
+func (imageSource) loadTiles(tex *Texture, l, tx, ty int) error {
+	converted := convertedFilename(tex)
+
+	if converted != "" {
+		if src, err := openConverted(tex, converted); err == nil {
+			// Called with tex.loadMutex held, which also guards tex.src.
+			tex.src = src
+			return src.loadTiles(tex, l, tx, ty)
+		}
+	}
 
-			texa := tex
+	// The decoded image and its mip levels are counted against the cache while converting.
+	work := int64(tex.w*tex.h*tex.components*4) * 7 / 3
 
-			for h > 0 {
-				tex2 := AllocTexImage(w, h)
-				tex2.Resample(texa)
-				t.rset[t.genHash(log2(w), log2(h))] = tex2
-				texa = tex2
-				/// log.Printf("Gen %v %v", log2(w), log2(h))
-				h /= 2
-			}
-			w /= 2
+	cache.reserve(work)
+	defer cache.release(work)
+
+	w, h, data, err := ReadImage(tex.url)
+
+	if err != nil {
+		return err
+	}
+
+	if w != tex.w || h != tex.h {
+		return fmt.Errorf("image size changed to %vx%v", w, h)
+	}
+
+	if tex.srgb {
+		for i := range data {
+			data[i] = colour.SRGBToLinear(m.Max(data[i], 0))
 		}
-	*/ /*
-		tex3 := AllocTexImage(tex2.w/2, tex2.h/2)
-		tex3.Resample(tex2)
-		return tex3.Texture(), nil
-	*/
+	}
 
-	return t, nil
+	mip := stdfilter(w, h, data, tex.components)
+
+	if converted != "" {
+		src, err := convertTiled(tex, mip, converted)
+
+		if err == nil {
+			tex.src = src
+			return src.loadTiles(tex, l, tx, ty)
+		}
+
+		log.Printf("texture: \"%v\": %v", tex.url, err)
+	}
+
+	lvl := &tex.levels[l]
+	d := cutTile(lvl, encode(tex.fmt, mip.mipmap[l].mipmap), tex.components*formatSize(tex.fmt), tx, ty)
+
+	// Stamped one tick old so it can be evicted by the next load if it isn't used again.
+	tex.storeTile(&lvl.tiles[ty*lvl.tilesX+tx], d, atomic.LoadUint64(&cache.clock)-1)
+
+	return nil
 }
 
 // SetRGB sets a pixel in a Texture object. (shouldn't be public)
@@ -176,27 +426,31 @@ func (tex *Texture) SetRGB(x, y int, r, g, b byte) {
 	tex.data[(x+(y*tex.w))*3+2] = b
 }
 
-// CreateRGBTexture creates an RGB texture of appropriate size.
+// CreateRGBTexture creates an RGB texture of appropriate size.  The texture can be sampled once
+// the pixels have been set and pinned.
 func CreateRGBTexture(w, h int) *Texture {
 	return &Texture{
-		w:    w,
-		h:    h,
-		data: make([]byte, w*h*3),
+		w:          w,
+		h:          h,
+		components: 3,
+		data:       make([]byte, w*h*3),
 	}
 }
 
-func cacheMiss(filename string) (*Texture, error) {
+func cacheMiss(filename string, colourSpace int) (*Texture, error) {
 	loadMutex.Lock()
 	defer loadMutex.Unlock()
 
+	key := cacheKey(filename, colourSpace)
+
 	// Load current version, make sure the previous locker hasn't loaded the
 	// same image we want.
 	textures := texStore.Load().(TexStore)
-	if img, present := textures[filename]; present {
+	if img, present := textures[key]; present {
 		return img, nil
 	}
 
-	tex, err := LoadTexture(filename)
+	tex, err := openTexture(filename, colourSpace)
 
 	if err != nil {
 		//loadMutex.Unlock()
@@ -209,103 +463,61 @@ func cacheMiss(filename string) (*Texture, error) {
 	for k, v := range textures {
 		texturesNew[k] = v
 	}
-	texturesNew[filename] = tex
+	texturesNew[key] = tex
 	texStore.Store(texturesNew)
 
 	return tex, nil
 
 }
 
-// SampleRGB samples an RGB value from the given file using the coords s,t and footprint ds,dt.
-func SampleRGB(filename string, sg *core.ShaderContext) (out [3]float32) {
+// lookup returns the texture for filename and the coordinates u,v within it.  For tile sets
+// this is the tile containing u,v, or nil if that tile is missing.
+func lookup(filename string, colourSpace int, u, v float32) (*Texture, float32, float32) {
+	if isTileSet(filename) {
+		return lookupTile(filename, colourSpace, u, v)
+	}
 
-	// This uses an atomic copy-on-write for the textures store
-	//ds = m.Max(1, 1/ds)
-	//dt = m.Max(1, 1/dt)
+	key := cacheKey(filename, colourSpace)
 
-	//fmt.Printf("%v %v\n", ds, dt)
-	//loadMutex.Lock()
 	textures := texStore.Load().(TexStore)
-	img := textures[filename]
+	img := textures[key]
 
 	if img == nil {
-		img2, err := cacheMiss(filename)
+		img2, err := cacheMiss(filename, colourSpace)
 
 		if err != nil {
-			return
+			return nil, u, v
 		}
 
 		img = img2
 	}
 
-	deltaTx := m.Vec2Scale(sg.Image.PixelDelta[0], sg.Dduvdx)
-	deltaTy := m.Vec2Scale(sg.Image.PixelDelta[1], sg.Dduvdy)
+	return img, u, v
+}
 
-	deltaTx[0] = deltaTx[0] * float32(img.w)
-	deltaTy[0] = deltaTy[0] * float32(img.w)
+// SampleRGB samples an RGB value from the given file with trilinear filtering.
+func SampleRGB(filename string, sg *core.ShaderContext) (out [3]float32) {
+	return Sample(filename, Options{Filter: FilterTrilinear}, sg)
+}
 
-	deltaTx[1] = deltaTx[1] * float32(img.h)
-	deltaTy[1] = deltaTy[1] * float32(img.h)
+// trilinear filters with the mip level chosen by the longest texture derivative (given in
+// texture coordinates, scaled to the pixel footprint).
+func trilinear(img *Texture, s, t float32, Dduvdx, Dduvdy m.Vec2, wrap int) (out [3]float32) {
+	deltaTx := m.Vec2{Dduvdx[0] * float32(img.w), Dduvdx[1] * float32(img.h)}
+	deltaTy := m.Vec2{Dduvdy[0] * float32(img.w), Dduvdy[1] * float32(img.h)}
 
-	ds := m.Max(m.Abs(deltaTx[0]), m.Abs(deltaTy[0]))
-	dt := m.Max(m.Abs(deltaTx[1]), m.Abs(deltaTy[1]))
+	ds := m.Vec2Length(deltaTx)
+	dt := m.Vec2Length(deltaTy)
 
-	ds = m.Vec2Length(deltaTx)
-	dt = m.Vec2Length(deltaTy)
-	//	loadMutex.Unlock()
-	/*
-		deltaTx := m.Vec2Scale(sg.Image.PixelDelta[0], sg.Dduvdx)
-		deltaTy := m.Vec2Scale(sg.Image.PixelDelta[1], sg.Dduvdy)
-	*/
 	lod := m.Log2(m.Max(ds, dt))
-	/*
-		l := int(lod)
-
-		if l > len(img.mipmap.mipmap)-1 {
-			l = len(img.mipmap.mipmap) - 1
-		}
-
-		if l < 0 {
-			l = 0
-		}
 
-		w := img.mipmap.mipmap[l].w
-		h := img.mipmap.mipmap[l].h
-		x := int(s * float32(w))
-		y := int(t * float32(h))
-
-		//fmt.Printf("%v %v %v %v\n", x, y, img.mipmap.mipmap[l].w, img.mipmap.mipmap[l].h)
-
-		x = x % w
-		y = y % h
-
-		//fmt.Printf("%v %v\n", x, y)
-		if x < 0 {
-			x += w
-		}
-		if y < 0 {
-			y += h
-		}
-
-		out[0] = float32(img.mipmap.mipmap[l].mipmap[(x+(y*img.mipmap.mipmap[l].w))*3+0]) / 255.0
-		out[1] = float32(img.mipmap.mipmap[l].mipmap[(x+(y*img.mipmap.mipmap[l].w))*3+1]) / 255.0
-		out[2] = float32(img.mipmap.mipmap[l].mipmap[(x+(y*img.mipmap.mipmap[l].w))*3+2]) / 255.0
-	*/
-	lod = lod
-
-	if lod > float32(img.mipmap.MaxLevelOfDetail()) {
-		lod = float32(img.mipmap.MaxLevelOfDetail())
+	if lod > float32(img.MaxLevelOfDetail()) {
+		lod = float32(img.MaxLevelOfDetail())
 	}
 
 	if lod < 0 {
 		lod = 0
 	}
 
-	out = img.mipmap.TrilinearSample(sg.U, sg.V, lod)
-	out[0] /= 255.0
-	out[1] /= 255.0
-	out[2] /= 255.0
-
-	//out[0] = lod * 10
-	return
+	return img.TrilinearSample(s, t, lod, wrap)
 }
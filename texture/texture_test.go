@@ -0,0 +1,79 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package texture
+
+import (
+	vimage "github.com/jamiec7919/vermeer/image"
+	"github.com/jamiec7919/vermeer/image/exr"
+	"path/filepath"
+	"testing"
+)
+
+// writeEXR writes a w by h EXR with the channels, channel k of pixel x,y is
+// x + 10*y + 100*k.  EXR files hold the channels sorted by name.
+func writeEXR(t *testing.T, filename string, w, h int, channels []string) {
+	spec := vimage.Spec{Width: w, Height: h, ChannelNames: channels}
+	buf := make([]float32, w*h*len(channels))
+
+	for i := range buf {
+		p, k := i/len(channels), i%len(channels)
+		buf[i] = float32(p%w + 10*(p/w) + 100*k)
+	}
+
+	out := &exr.Writer{}
+
+	if err := out.Open(filename, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	defer out.Close()
+
+	if err := out.WriteImage(vimage.TypeDesc{BaseType: vimage.FLOAT}, buf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadFloatImageChannels(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels []string
+		rgb      [3]int // Index in channels of R, G and B
+	}{
+		// Stored as A, B, G, R.
+		{"rgba", []string{"R", "G", "B", "A"}, [3]int{0, 1, 2}},
+		{"luminance", []string{"Y", "A"}, [3]int{0, 0, 0}},
+		{"other", []string{"Z"}, [3]int{0, 0, 0}},
+	}
+
+	const w, h = 5, 3
+
+	for _, test := range tests {
+		filename := filepath.Join(t.TempDir(), test.name+".exr")
+		writeEXR(t, filename, w, h, test.channels)
+
+		rw, rh, data, err := readFloatImage(filename)
+
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		if rw != w || rh != h || len(data) != w*h*3 {
+			t.Fatalf("%v: %vx%v with %v values", test.name, rw, rh, len(data))
+		}
+
+		// Textures are bottom row first.
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				for k, c := range test.rgb {
+					expected := float32(x + 10*(h-1-y) + 100*c)
+
+					if v := data[(x+y*w)*3+k]; v != expected {
+						t.Errorf("%v: channel %v at %v,%v = %v, expected %v", test.name, k, x, y, v, expected)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,484 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package texture
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// TiledExt is the extension of tiled texture files made by MakeTiled (and the mktex command).
+//
+// Tiled files hold every mip level already filtered and split into tiles in the storage format,
+// so tiles are read straight into the cache without decoding or filtering.  The layout (all
+// little-endian) is the tiledHeader, a tiledEntry for each tile of each level (finest level
+// first, tiles in rows from the bottom) and then the tile data.
+const TiledExt = ".vtx"
+
+const tiledVersion = 1
+
+var tiledMagic = [4]byte{'V', 'T', 'E', 'X'}
+
+var errNotTiled = errors.New("not a tiled texture")
+
+type tiledHeader struct {
+	Magic       [4]byte
+	Version     uint32
+	Width       uint32
+	Height      uint32
+	Components  uint32
+	Format      uint32
+	ColourSpace uint32
+	Levels      uint32
+	TileShift   uint32
+}
+
+type tiledEntry struct {
+	Offset uint64
+	Size   uint32
+}
+
+// maxOpenFiles is the number of tiled files kept open for loading tiles.
+const maxOpenFiles = 64
+
+// tiledSource reads tiles from a tiled file, one tile at a time.  The file is opened through
+// openFiles when a tile is needed.
+type tiledSource struct {
+	filename    string
+	colourSpace int
+	entries     [][]tiledEntry // For each level
+}
+
+// openFile is a file held open by fileCache.
+type openFile struct {
+	*os.File
+	users    int // Loads using the file, it can't be closed while in use
+	lastUsed uint64
+}
+
+// fileCache keeps up to maxOpenFiles tiled files open so tiles can be read without reopening
+// the file each time, the least recently used file not in use is closed to open another.
+type fileCache struct {
+	mutex sync.Mutex
+	files map[string]*openFile
+	clock uint64
+}
+
+var openFiles = fileCache{files: map[string]*openFile{}}
+
+// acquire returns the open file filename, it must be given back with release.
+func (c *fileCache) acquire(filename string) (*openFile, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.clock++
+
+	if f := c.files[filename]; f != nil {
+		f.users++
+		f.lastUsed = c.clock
+
+		return f, nil
+	}
+
+	if len(c.files) >= maxOpenFiles {
+		var lru *openFile
+		var name string
+
+		for n, f := range c.files {
+			if f.users == 0 && (lru == nil || f.lastUsed < lru.lastUsed) {
+				lru, name = f, n
+			}
+		}
+
+		// If every file is in use the limit is exceeded until they are released.
+		if lru != nil {
+			lru.Close()
+			delete(c.files, name)
+		}
+	}
+
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := &openFile{File: file, users: 1, lastUsed: c.clock}
+	c.files[filename] = f
+
+	return f, nil
+}
+
+// release gives back a file returned by acquire.
+func (c *fileCache) release(f *openFile) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	f.users--
+}
+
+// numOpen returns the number of open files.
+func (c *fileCache) numOpen() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.files)
+}
+
+func (src *tiledSource) loadTiles(tex *Texture, l, tx, ty int) error {
+	lvl := &tex.levels[l]
+	entry := src.entries[l][ty*lvl.tilesX+tx]
+
+	tw, th := mini(tileSize, lvl.w-(tx<<tileShift)), mini(tileSize, lvl.h-(ty<<tileShift))
+
+	if int(entry.Size) != tw*th*tex.components*formatSize(tex.fmt) {
+		return fmt.Errorf("level %v tile %v,%v has wrong size %v", l, tx, ty, entry.Size)
+	}
+
+	file, err := openFiles.acquire(src.filename)
+
+	if err != nil {
+		return err
+	}
+
+	defer openFiles.release(file)
+
+	d := make([]byte, entry.Size)
+
+	if _, err := file.ReadAt(d, int64(entry.Offset)); err != nil {
+		return err
+	}
+
+	tex.storeTile(&lvl.tiles[ty*lvl.tilesX+tx], d, atomic.LoadUint64(&cache.clock))
+
+	return nil
+}
+
+func isTiled(url string) bool {
+	return strings.ToLower(filepath.Ext(url)) == TiledExt
+}
+
+// TiledFilename returns the name of the tiled file for the image filename, TiledExt is added
+// after the image's own extension so e.g. foo.png and foo.tif don't share foo.png.vtx.
+func TiledFilename(filename string) string {
+	return filename + TiledExt
+}
+
+// openTiled reads the header and tile table of a tiled file, the file is opened again when
+// tiles are loaded.
+func openTiled(url string) (*Texture, int, error) {
+	file, err := os.Open(url)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+
+	var hdr tiledHeader
+
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, 0, err
+	}
+
+	if hdr.Magic != tiledMagic {
+		return nil, 0, errNotTiled
+	}
+
+	if hdr.Version != tiledVersion {
+		return nil, 0, fmt.Errorf("unsupported tiled texture version %v", hdr.Version)
+	}
+
+	if hdr.Components != 3 || hdr.TileShift != tileShift || hdr.Width == 0 || hdr.Height == 0 {
+		return nil, 0, fmt.Errorf("unsupported tiled texture layout (%v components, tile shift %v)", hdr.Components, hdr.TileShift)
+	}
+
+	switch hdr.Format {
+	case formatUint8, formatUint16, formatHalf, formatFloat, formatSRGB8:
+	default:
+		return nil, 0, fmt.Errorf("unknown tiled texture format %v", hdr.Format)
+	}
+
+	src := &tiledSource{filename: url, colourSpace: int(hdr.ColourSpace)}
+	tex := &Texture{url: url, w: int(hdr.Width), h: int(hdr.Height), fmt: int(hdr.Format), components: 3, src: src}
+	tex.initLevels()
+
+	if int(hdr.Levels) != len(tex.levels) {
+		return nil, 0, fmt.Errorf("tiled texture has %v levels, expected %v", hdr.Levels, len(tex.levels))
+	}
+
+	src.entries = make([][]tiledEntry, len(tex.levels))
+
+	for l := range tex.levels {
+		src.entries[l] = make([]tiledEntry, len(tex.levels[l].tiles))
+
+		if err := binary.Read(r, binary.LittleEndian, src.entries[l]); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return tex, src.colourSpace, nil
+}
+
+// openTexture returns the texture for filename in the given colour space.  If an up to date
+// tiled file made for the same colour space is next to the image it is used instead.
+func openTexture(filename string, colourSpace int) (*Texture, error) {
+	if !isTiled(filename) {
+		if tiled := TiledFilename(filename); isUpToDate(tiled, filename) {
+			tex, cs, err := openTiled(tiled)
+
+			switch {
+			case err != nil:
+				log.Printf("texture: \"%v\": %v", tiled, err)
+			case (cs == ColourSpaceSRGB) != (colourSpace == ColourSpaceSRGB):
+				log.Printf("texture: \"%v\" was made for a different colour space, using \"%v\"", tiled, filename)
+			default:
+				return tex, nil
+			}
+		}
+	}
+
+	tex, err := LoadTexture(filename)
+
+	if err == nil {
+		tex.setColourSpace(colourSpace)
+	}
+
+	return tex, err
+}
+
+// isUpToDate returns true if the tiled file exists and isn't older than the image (if the image
+// exists at all).
+func isUpToDate(tiled, filename string) bool {
+	ti, err := os.Stat(tiled)
+
+	if err != nil {
+		return false
+	}
+
+	fi, err := os.Stat(filename)
+
+	if err != nil {
+		return true
+	}
+
+	return !ti.ModTime().Before(fi.ModTime())
+}
+
+// MakeTiled converts the image file src into the tiled file dst.  The texels are converted from
+// the colour space (ColourSpaceLinear etc.), if half is true they are stored as half floats
+// otherwise in the smallest format which holds the image without loss.
+func MakeTiled(src, dst string, colourSpace int, half bool) error {
+	if isTiled(src) {
+		return fmt.Errorf("%v is already tiled", src)
+	}
+
+	tex, err := LoadTexture(src)
+
+	if err != nil {
+		return err
+	}
+
+	w, h, data, err := ReadImage(src)
+
+	if err != nil {
+		return err
+	}
+
+	format := tex.fmt
+
+	if colourSpace == ColourSpaceSRGB {
+		for i := range data {
+			data[i] = colour.SRGBToLinear(m.Max(data[i], 0))
+		}
+
+		if format == formatUint8 {
+			format = formatSRGB8
+		}
+	}
+
+	if half {
+		format = formatHalf
+	}
+
+	mip := stdfilter(w, h, data, tex.components)
+	hdr, entries := tiledLayout(tex, format, colourSpace)
+
+	file, err := os.Create(dst)
+
+	if err != nil {
+		return err
+	}
+
+	if err := writeTiled(file, hdr, entries, tex.levels, mip, format); err != nil {
+		file.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return file.Close()
+}
+
+// tiledLayout returns the header and tile table of a tiled file holding tex in the format.
+func tiledLayout(tex *Texture, format, colourSpace int) (tiledHeader, [][]tiledEntry) {
+	hdr := tiledHeader{
+		Magic:       tiledMagic,
+		Version:     tiledVersion,
+		Width:       uint32(tex.w),
+		Height:      uint32(tex.h),
+		Components:  uint32(tex.components),
+		Format:      uint32(format),
+		ColourSpace: uint32(colourSpace),
+		Levels:      uint32(len(tex.levels)),
+		TileShift:   tileShift,
+	}
+
+	// Build the table, tiles follow it in the same order.
+	c := tex.components * formatSize(format)
+	entries := make([][]tiledEntry, len(tex.levels))
+	offset := uint64(binary.Size(hdr))
+
+	for l := range tex.levels {
+		offset += uint64(len(tex.levels[l].tiles) * binary.Size(tiledEntry{}))
+	}
+
+	for l := range tex.levels {
+		lvl := &tex.levels[l]
+		entries[l] = make([]tiledEntry, len(lvl.tiles))
+
+		for ty := 0; ty < lvl.tilesY; ty++ {
+			for tx := 0; tx < lvl.tilesX; tx++ {
+				tw, th := mini(tileSize, lvl.w-(tx<<tileShift)), mini(tileSize, lvl.h-(ty<<tileShift))
+				size := uint32(tw * th * c)
+
+				entries[l][ty*lvl.tilesX+tx] = tiledEntry{offset, size}
+				offset += uint64(size)
+			}
+		}
+	}
+
+	return hdr, entries
+}
+
+// convertedFilename returns the name of the tiled file in the texture cache directory
+// converted from the image of tex, or "" if there isn't a cache directory.  The name depends on
+// the full path of the image and whether it is converted from sRGB.
+func convertedFilename(tex *Texture) string {
+	dir := core.TextureCacheDir()
+
+	if dir == "" {
+		return ""
+	}
+
+	path, err := filepath.Abs(tex.url)
+
+	if err != nil {
+		return ""
+	}
+
+	sum := sha1.Sum([]byte(fmt.Sprintf("%v\x00%v\x00%v", path, tex.srgb, tex.fmt)))
+
+	return filepath.Join(dir, fmt.Sprintf("%v-%x%v", filepath.Base(path), sum[:8], TiledExt))
+}
+
+// openConverted returns a source for the converted tiled file of tex if it is up to date and
+// matches the image.
+func openConverted(tex *Texture, filename string) (*tiledSource, error) {
+	if !isUpToDate(filename, tex.url) {
+		return nil, os.ErrNotExist
+	}
+
+	t, _, err := openTiled(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t.w != tex.w || t.h != tex.h || t.fmt != tex.fmt {
+		return nil, fmt.Errorf("%v doesn't match the image", filename)
+	}
+
+	return t.src.(*tiledSource), nil
+}
+
+// convertTiled writes the mip levels of tex to the tiled file filename and returns a source
+// reading it, so an image only has to be decoded once however often its tiles are evicted, and
+// later renders can use the file straight away.  The file is written under a temporary name
+// and renamed so other renders never see it partly written.
+func convertTiled(tex *Texture, mip mipmap, filename string) (*tiledSource, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return nil, err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, entries := tiledLayout(tex, tex.fmt, ColourSpaceLinear)
+	err = writeTiled(file, hdr, entries, tex.levels, mip, tex.fmt)
+
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return &tiledSource{filename: filename, entries: entries}, nil
+}
+
+func writeTiled(file *os.File, hdr tiledHeader, entries [][]tiledEntry, levels []level, mip mipmap, format int) error {
+	out := bufio.NewWriter(file)
+
+	if err := binary.Write(out, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+
+	for l := range entries {
+		if err := binary.Write(out, binary.LittleEndian, entries[l]); err != nil {
+			return err
+		}
+	}
+
+	c := int(hdr.Components) * formatSize(format)
+
+	for l := range levels {
+		lvl := &levels[l]
+		data := encode(format, mip.mipmap[l].mipmap)
+
+		for ty := 0; ty < lvl.tilesY; ty++ {
+			for tx := 0; tx < lvl.tilesX; tx++ {
+				if _, err := out.Write(cutTile(lvl, data, c, tx, ty)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return out.Flush()
+}
@@ -0,0 +1,148 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package texture
+
+import (
+	"fmt"
+	m "github.com/jamiec7919/vermeer/math"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Tokens which mark a filename as a tile set, one image per unit square of UV space.
+//
+// <UDIM> is replaced by 1001 + u + 10*v (so u is limited to [0,10)) and <UVTILE> by u<u+1>_v<v+1>
+// (Mari convention) where u,v are the integer parts of the texture coordinates.
+const (
+	udimToken   = "<UDIM>"
+	uvtileToken = "<UVTILE>"
+)
+
+// tileKey identifies the tile covering [u,u+1)x[v,v+1).
+type tileKey struct {
+	u, v int
+}
+
+// tileSet holds the tiles loaded so far, copy-on-write in the same way as the main TexStore.
+// Missing tiles are stored as nil so they are only looked for once.
+type tileSet struct {
+	pattern     string
+	colourSpace int
+	tiles       atomic.Value // map[tileKey]*Texture
+	mutex       sync.Mutex
+}
+
+type tileSetStore map[string]*tileSet
+
+var tileSets atomic.Value
+
+var tileSetMutex sync.Mutex
+
+func init() {
+	tileSets.Store(make(tileSetStore))
+}
+
+func isTileSet(filename string) bool {
+	return strings.Contains(filename, udimToken) || strings.Contains(filename, uvtileToken)
+}
+
+// tileFilename returns the filename of the given tile, or "" if the tile can't be named.
+func tileFilename(pattern string, key tileKey) string {
+	if strings.Contains(pattern, udimToken) {
+		if key.u < 0 || key.u > 9 || key.v < 0 {
+			return ""
+		}
+
+		return strings.Replace(pattern, udimToken, fmt.Sprintf("%d", 1001+key.u+10*key.v), -1)
+	}
+
+	return strings.Replace(pattern, uvtileToken, fmt.Sprintf("u%d_v%d", key.u+1, key.v+1), -1)
+}
+
+func findTileSet(pattern string, colourSpace int) *tileSet {
+	key := cacheKey(pattern, colourSpace)
+
+	if ts := tileSets.Load().(tileSetStore)[key]; ts != nil {
+		return ts
+	}
+
+	tileSetMutex.Lock()
+	defer tileSetMutex.Unlock()
+
+	sets := tileSets.Load().(tileSetStore)
+
+	if ts := sets[key]; ts != nil {
+		return ts
+	}
+
+	ts := &tileSet{pattern: pattern, colourSpace: colourSpace}
+	ts.tiles.Store(make(map[tileKey]*Texture))
+
+	setsNew := make(tileSetStore)
+
+	for k, v := range sets {
+		setsNew[k] = v
+	}
+
+	setsNew[key] = ts
+	tileSets.Store(setsNew)
+
+	return ts
+}
+
+// tile returns the tile for key, loading it if needed.  Returns nil if the tile doesn't exist or
+// can't be loaded.
+func (ts *tileSet) tile(key tileKey) *Texture {
+	if img, present := ts.tiles.Load().(map[tileKey]*Texture)[key]; present {
+		return img
+	}
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	tiles := ts.tiles.Load().(map[tileKey]*Texture)
+
+	if img, present := tiles[key]; present {
+		return img
+	}
+
+	var img *Texture
+
+	if filename := tileFilename(ts.pattern, key); filename != "" {
+		tex, err := openTexture(filename, ts.colourSpace)
+
+		if err == nil {
+			img = tex
+		} else if !os.IsNotExist(err) {
+			// Sparse tile sets are normal, only complain about broken tiles.
+			log.Printf("texture: tile \"%v\": %v", filename, err)
+		}
+	}
+
+	tilesNew := make(map[tileKey]*Texture)
+
+	for k, v := range tiles {
+		tilesNew[k] = v
+	}
+
+	tilesNew[key] = img
+	ts.tiles.Store(tilesNew)
+
+	return img
+}
+
+// lookupTile returns the tile of the tile set containing u,v and the coordinates within the
+// tile.  The derivatives don't change so filtering works as usual within each tile.
+func lookupTile(pattern string, colourSpace int, u, v float32) (*Texture, float32, float32) {
+	tu := m.Floor(u)
+	tv := m.Floor(v)
+
+	img := findTileSet(pattern, colourSpace).tile(tileKey{int(tu), int(tv)})
+
+	return img, u - tu, v - tv
+}